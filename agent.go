@@ -7,9 +7,11 @@ package holochain
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 // Unique user identifier in context of this holochain
@@ -21,8 +23,34 @@ const (
 	IPFS = iota
 )
 
+var keyTypeNames = map[KeytypeType]string{
+	IPFS: "IPFS",
+}
+
+// String returns the name of the key type as used in the DNA
+func (k KeytypeType) String() string {
+	name, ok := keyTypeNames[k]
+	if !ok {
+		name = fmt.Sprintf("unknown(%d)", int(k))
+	}
+	return name
+}
+
+// KeyTypeFromString returns the key type with the given name
+func KeyTypeFromString(name string) (keyType KeytypeType, err error) {
+	for k, n := range keyTypeNames {
+		if n == name {
+			keyType = k
+			return
+		}
+	}
+	err = fmt.Errorf("unknown key type: %s", name)
+	return
+}
+
 type Agent interface {
 	Name() AgentName
+	SetName(name AgentName)
 	KeyType() KeytypeType
 	GenKeys() error
 	PrivKey() ic.PrivKey
@@ -38,6 +66,10 @@ func (a *IPFSAgent) Name() AgentName {
 	return a.name
 }
 
+func (a *IPFSAgent) SetName(name AgentName) {
+	a.name = name
+}
+
 func (a *IPFSAgent) KeyType() KeytypeType {
 	return IPFS
 }
@@ -60,6 +92,55 @@ func (a *IPFSAgent) GenKeys() (err error) {
 	return
 }
 
+// AgentAddress returns the address other nodes use to reach the agent with the given
+// public key.  It's the peer ID derived from a hash of the key, so it's the same
+// for every node that computes it, and for the local agent it's the holochain's node ID.
+func AgentAddress(pubKey ic.PubKey) (id peer.ID, err error) {
+	id, err = peer.IDFromPublicKey(pubKey)
+	return
+}
+
+// agentKeyHash returns the B58 encoded address of the agent whose marshaled public key
+// (i.e. an AgentEntry's Key) is given.  If the key isn't raw bytes it's expected to be
+// base64 encoded, which is how the key appears in an AgentEntry marshaled to JSON.
+func agentKeyHash(key interface{}) (hash string, err error) {
+	var b []byte
+	switch k := key.(type) {
+	case []byte:
+		b = k
+	case string:
+		if b, err = base64.StdEncoding.DecodeString(k); err != nil {
+			return
+		}
+	default:
+		err = errors.New("expected key as bytes or base64 string")
+		return
+	}
+	var pk ic.PubKey
+	if pk, err = ic.UnmarshalPublicKey(b); err != nil {
+		return
+	}
+	var id peer.ID
+	if id, err = AgentAddress(pk); err != nil {
+		return
+	}
+	hash = peer.IDB58Encode(id)
+	return
+}
+
+// PubKeyType returns the key type of the given public key, which is the type an agent
+// using that key must declare.  IPFS agents generate Ed25519 keys, so that's the only
+// kind of key holochain supports.
+func PubKeyType(pubKey ic.PubKey) (keyType KeytypeType, err error) {
+	switch pubKey.(type) {
+	case *ic.Ed25519PublicKey:
+		keyType = IPFS
+	default:
+		err = fmt.Errorf("unsupported public key type: %T", pubKey)
+	}
+	return
+}
+
 // NewAgent creates an agent structure of the given type
 // Note: currently only IPFS agents are implemented
 func NewAgent(keyType KeytypeType, name AgentName) (agent Agent, err error) {
@@ -81,13 +162,13 @@ func NewAgent(keyType KeytypeType, name AgentName) (agent Agent, err error) {
 
 // SaveAgent saves out the keys and agent name to the given directory
 func SaveAgent(path string, agent Agent) (err error) {
-	writeFile(path, AgentFileName, []byte(agent.Name()))
-	if err != nil {
-		return
-	}
 	if fileExists(path + "/" + PrivKeyFileName) {
 		return errors.New("keys already exist")
 	}
+	err = writeFile(path, AgentFileName, []byte(agent.Name()))
+	if err != nil {
+		return
+	}
 	var k []byte
 	k, err = agent.PrivKey().Bytes()
 	if err != nil {
@@ -1,7 +1,10 @@
 package holochain
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -27,3 +30,53 @@ func TestAgent(t *testing.T) {
 
 	})
 }
+
+func TestAgentAddress(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should match the node id of the local agent", t, func() {
+		id, err := AgentAddress(h.Agent().PubKey())
+		So(err, ShouldBeNil)
+		So(peer.IDB58Encode(id), ShouldEqual, peer.IDB58Encode(h.id))
+	})
+
+	Convey("it should compute the address from a marshaled key", t, func() {
+		key, _ := ic.MarshalPublicKey(h.Agent().PubKey())
+		hash, err := agentKeyHash(key)
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, peer.IDB58Encode(h.id))
+		hash, err = agentKeyHash(base64.StdEncoding.EncodeToString(key))
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, peer.IDB58Encode(h.id))
+		_, err = agentKeyHash(1)
+		So(err.Error(), ShouldEqual, "expected key as bytes or base64 string")
+	})
+}
+
+func TestKeyTypeNames(t *testing.T) {
+	Convey("key types should convert to and from their names", t, func() {
+		So(KeytypeType(IPFS).String(), ShouldEqual, "IPFS")
+		So(KeytypeType(99).String(), ShouldEqual, "unknown(99)")
+		k, err := KeyTypeFromString("IPFS")
+		So(err, ShouldBeNil)
+		So(k, ShouldEqual, IPFS)
+		_, err = KeyTypeFromString("RSA")
+		So(err.Error(), ShouldEqual, "unknown key type: RSA")
+	})
+}
+
+func TestPubKeyType(t *testing.T) {
+	Convey("PubKeyType should derive the key type from the key itself", t, func() {
+		a, err := NewAgent(IPFS, "zippy")
+		So(err, ShouldBeNil)
+		k, err := PubKeyType(a.PubKey())
+		So(err, ShouldBeNil)
+		So(k, ShouldEqual, IPFS)
+
+		_, pub, err := ic.GenerateKeyPairWithReader(ic.RSA, 1024, rand.Reader)
+		So(err, ShouldBeNil)
+		_, err = PubKeyType(pub)
+		So(err.Error(), ShouldEqual, "unsupported public key type: *crypto.RsaPublicKey")
+	})
+}
@@ -9,12 +9,14 @@ package holochain
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	ma "github.com/multiformats/go-multiaddr"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 type BSReq struct {
@@ -28,57 +30,132 @@ type BSResp struct {
 	Remote string
 }
 
+// BootstrapServers is a list of bootstrap server host:port addresses.  For backwards
+// compatibility it can be decoded from a single string as well as from a list.
+type BootstrapServers []string
+
+// parseBootstrapServers builds a BootstrapServers list from a comma separated string
+func parseBootstrapServers(s string) (servers BootstrapServers) {
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			servers = append(servers, host)
+		}
+	}
+	return
+}
+
+// UnmarshalJSON decodes either a single server string or a list of servers (it's also
+// used when decoding yaml)
+func (b *BootstrapServers) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err == nil {
+		*b = parseBootstrapServers(s)
+		return
+	}
+	var l []string
+	if err = json.Unmarshal(data, &l); err == nil {
+		*b = l
+	}
+	return
+}
+
+// UnmarshalTOML decodes either a single server string or a list of servers
+func (b *BootstrapServers) UnmarshalTOML(data interface{}) (err error) {
+	switch t := data.(type) {
+	case string:
+		*b = parseBootstrapServers(t)
+	case []interface{}:
+		var l BootstrapServers
+		for _, v := range t {
+			s, ok := v.(string)
+			if !ok {
+				return errors.New("expected bootstrap server to be a string")
+			}
+			l = append(l, s)
+		}
+		*b = l
+	default:
+		err = errors.New("expected bootstrap server string or list")
+	}
+	return
+}
+
+// BSpost announces this node to all the bootstrap servers, returning the first error
+// encountered if any of them fail
 func (h *Holochain) BSpost() (err error) {
 	nodeID := peer.IDB58Encode(h.node.HashAddr)
 	req := BSReq{Version: 1, NodeID: nodeID, NodeAddr: h.node.NetAddr.String()}
-	host := h.config.BootstrapServer
 	id := h.DNAHash()
-	url := fmt.Sprintf("http://%s/%s/%s", host, id.String(), nodeID)
 	var b []byte
-	b, err = json.Marshal(req)
-	//var resp *http.Response
-	if err == nil {
-		_, err = http.Post(url, "application/json", bytes.NewBuffer(b))
+	if b, err = json.Marshal(req); err != nil {
+		return
+	}
+	for _, host := range h.config.BootstrapServer {
+		url := fmt.Sprintf("http://%s/%s/%s", host, id.String(), nodeID)
+		resp, e := http.Post(url, "application/json", bytes.NewBuffer(b))
+		if e == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				e = fmt.Errorf("status %s", resp.Status)
+			}
+		}
+		if e != nil && err == nil {
+			err = fmt.Errorf("bootstrap server %s: %v", host, e)
+		}
 	}
 	return
 }
 
+// BSget gets the list of nodes from the bootstrap servers, trying them in order until
+// one responds, and adds the nodes to the peerstore
 func (h *Holochain) BSget() (err error) {
-	host := h.config.BootstrapServer
-	if host == "" {
-		return
+	for _, host := range h.config.BootstrapServer {
+		if err = h.bsGet(host); err == nil {
+			return
+		}
+		h.dht.dlog.Logf("error getting nodes from bootstrap server %s: %v", host, err)
 	}
+	return
+}
+
+// bsGet gets the list of nodes from one bootstrap server
+func (h *Holochain) bsGet(host string) (err error) {
 	id := h.DNAHash()
 	url := fmt.Sprintf("http://%s/%s", host, id.String())
 	var resp *http.Response
 	resp, err = http.Get(url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %s", resp.Status)
+		return
+	}
+	var b []byte
+	b, err = ioutil.ReadAll(resp.Body)
 	if err == nil {
-		defer resp.Body.Close()
-		var b []byte
-		b, err = ioutil.ReadAll(resp.Body)
+		var nodes []BSResp
+		err = json.Unmarshal(b, &nodes)
 		if err == nil {
-			var nodes []BSResp
-			err = json.Unmarshal(b, &nodes)
-			if err == nil {
-				myNodeID := peer.IDB58Encode(h.node.HashAddr)
-				for _, r := range nodes {
-					var id peer.ID
-					var addr ma.Multiaddr
-					id, err = peer.IDB58Decode(r.Req.NodeID)
+			myNodeID := peer.IDB58Encode(h.node.HashAddr)
+			for _, r := range nodes {
+				var id peer.ID
+				var addr ma.Multiaddr
+				id, err = peer.IDB58Decode(r.Req.NodeID)
+				if err == nil {
+					addr, err = ma.NewMultiaddr(r.Req.NodeAddr)
 					if err == nil {
-						addr, err = ma.NewMultiaddr(r.Req.NodeAddr)
-						if err == nil {
-							if myNodeID != r.Req.NodeID {
-								h.dht.dlog.Logf("discovered peer: %s", r.Req.NodeID)
-								h.node.Host.Peerstore().AddAddr(id, addr, pstore.PermanentAddrTTL)
-								err = h.dht.UpdateGossiper(id, 0)
-
-							}
+						if myNodeID != r.Req.NodeID {
+							h.dht.dlog.Logf("discovered peer: %s", r.Req.NodeID)
+							h.node.Host.Peerstore().AddAddr(id, addr, pstore.PermanentAddrTTL)
+							err = h.dht.UpdateGossiper(id, 0)
 
 						}
-					}
 
+					}
 				}
+
 			}
 		}
 	}
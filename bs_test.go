@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBootstrapServersDecoding(t *testing.T) {
+	Convey("it should decode a single server or a list of servers", t, func() {
+		for _, format := range []string{"json", "yaml"} {
+			var c Config
+			err := Decode(strings.NewReader(`{"BootstrapServer":"a:1"}`), format, &c)
+			So(err, ShouldBeNil)
+			So(c.BootstrapServer, ShouldResemble, BootstrapServers{"a:1"})
+			err = Decode(strings.NewReader(`{"BootstrapServer":["a:1","b:2"]}`), format, &c)
+			So(err, ShouldBeNil)
+			So(c.BootstrapServer, ShouldResemble, BootstrapServers{"a:1", "b:2"})
+		}
+
+		var c Config
+		err := Decode(strings.NewReader(`BootstrapServer = "a:1"`), "toml", &c)
+		So(err, ShouldBeNil)
+		So(c.BootstrapServer, ShouldResemble, BootstrapServers{"a:1"})
+		err = Decode(strings.NewReader(`BootstrapServer = ["a:1", "b:2"]`), "toml", &c)
+		So(err, ShouldBeNil)
+		So(c.BootstrapServer, ShouldResemble, BootstrapServers{"a:1", "b:2"})
+	})
+
+	Convey("it should round trip through encoding", t, func() {
+		for _, format := range []string{"json", "yaml", "toml"} {
+			c := Config{BootstrapServer: BootstrapServers{"a:1", "b:2"}}
+			var b bytes.Buffer
+			err := Encode(&b, format, &c)
+			So(err, ShouldBeNil)
+			var c2 Config
+			err = Decode(&b, format, &c2)
+			So(err, ShouldBeNil)
+			So(c2.BootstrapServer, ShouldResemble, c.BootstrapServer)
+		}
+	})
+
+	Convey("it should split comma separated defaults", t, func() {
+		So(parseBootstrapServers("a:1, b:2,"), ShouldResemble, BootstrapServers{"a:1", "b:2"})
+		So(parseBootstrapServers(""), ShouldBeNil)
+	})
+}
+
+func TestBSFailover(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	var lk sync.Mutex
+	posted := make(map[string]bool)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	key, _, _ := ic.GenerateEd25519Key(rand.Reader)
+	peerID, _ := peer.IDFromPrivateKey(key)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		defer lk.Unlock()
+		if r.Method == "POST" {
+			posted["up"] = true
+			return
+		}
+		fmt.Fprintf(w, `[{"Req":{"Version":1,"NodeID":"%s","NodeAddr":"/ip4/127.0.0.1/tcp/1234"},"Remote":""}]`, peer.IDB58Encode(peerID))
+	}))
+	defer up.Close()
+
+	h.config.BootstrapServer = BootstrapServers{
+		strings.TrimPrefix(down.URL, "http://"),
+		strings.TrimPrefix(up.URL, "http://"),
+	}
+
+	Convey("BSget should fall back to the next server", t, func() {
+		err := h.BSget()
+		So(err, ShouldBeNil)
+		So(len(h.node.Host.Peerstore().Addrs(peerID)), ShouldEqual, 1)
+	})
+
+	Convey("BSpost should announce to all servers and report failures", t, func() {
+		err := h.BSpost()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "bootstrap server "+h.config.BootstrapServer[0])
+		So(posted["up"], ShouldBeTrue)
+	})
+}
@@ -22,67 +22,141 @@ type WalkerFn func(key *Hash, header *Header, entry Entry) error
 
 var ErrHashNotFound error = errors.New("hash not found")
 
+// ChainMemoryPath is the path which selects an in-memory chain store in NewChainFromFile
+const ChainMemoryPath = ":memory:"
+
 // Chain structure for providing in-memory access to chain data, entries headers and hashes
 type Chain struct {
-	Hashes   []Hash
-	Headers  []*Header
-	Entries  []Entry
-	TypeTops map[string]int // pointer to index of top of a given type
-	Hmap     map[string]int // map header hashes to index number
-	Emap     map[string]int // map entry hashes to index number
+	Hashes     []Hash
+	Headers    []*Header
+	Entries    []Entry
+	TypeTops   map[string]int // pointer to index of top of a given type
+	TypeCounts map[string]int // number of entries of a given type
+	Hmap       map[string]int // map header hashes to index number
+	Emap       map[string]int // map entry hashes to index number
 
 	//---
 
-	s *os.File // if this stream is not nil, new entries will get marshaled to it
+	s      *os.File     // if this stream is not nil, new entries will get marshaled to it
+	sc     *storeCipher // if not nil, entries marshaled to s are encrypted with it
+	legacy bool         // s has no chainFormatMagic so entries are always marshaled to it in full
 }
 
 // NewChain creates and empty chain
 func NewChain() (chain *Chain) {
 	c := Chain{
-		Headers:  make([]*Header, 0),
-		Entries:  make([]Entry, 0),
-		Hashes:   make([]Hash, 0),
-		TypeTops: make(map[string]int),
-		Hmap:     make(map[string]int),
-		Emap:     make(map[string]int),
+		Headers:    make([]*Header, 0),
+		Entries:    make([]Entry, 0),
+		Hashes:     make([]Hash, 0),
+		TypeTops:   make(map[string]int),
+		TypeCounts: make(map[string]int),
+		Hmap:       make(map[string]int),
+		Emap:       make(map[string]int),
 	}
 	chain = &c
 	return
 }
 
+// NewChainInMemory creates an empty chain that is never persisted to disk
+func NewChainInMemory() (chain *Chain) {
+	chain = NewChain()
+	return
+}
+
 // Creates a chain from a file, loading any data there, and setting it to be persisted to
 // if no file exists it will be created
+// if the path is empty or ChainMemoryPath an in-memory chain is returned instead
 func NewChainFromFile(h HashSpec, path string) (c *Chain, err error) {
+	return NewEncryptedChainFromFile(h, path, nil)
+}
+
+// NewEncryptedChainFromFile does the same as NewChainFromFile but encrypts the chain's
+// file with key (see StoreKey).  If key is nil the file is not encrypted.  Opening an
+// encrypted file with the wrong key returns ErrStoreKey, and opening an encrypted file
+// without a key, or a non-empty unencrypted one with a key, returns ErrStoreEncrypted
+// or ErrStoreNotEncrypted.
+func NewEncryptedChainFromFile(h HashSpec, path string, key []byte) (c *Chain, err error) {
+	if path == "" || path == ChainMemoryPath {
+		c = NewChainInMemory()
+		return
+	}
 	defer func() {
 		if err != nil {
 			Debugf("error loading chain :%s", err.Error())
 		}
 	}()
 	c = NewChain()
+	if key != nil {
+		if c.sc, err = newStoreCipher(key); err != nil {
+			return
+		}
+	}
 
 	var f *os.File
+	var empty bool
 	if fileExists(path) {
 		f, err = os.Open(path)
 		if err != nil {
 			return
 		}
 		var i int
+		r := &countingReader{r: f}
+		if empty, err = c.readSealedPreamble(f, r); err != nil {
+			f.Close()
+			return
+		}
+		if !empty && c.sc == nil {
+			if err = c.readFormatPreamble(f, r); err != nil {
+				f.Close()
+				return
+			}
+		}
+		good := r.n // offset of the end of the last complete pair
+		truncated := false
 		for {
 			var header *Header
 			var e Entry
-			header, e, err = readPair(f)
-			if err != nil && err.Error() == "EOF" {
+			header, e, err = c.readPair(r)
+			if err == io.EOF && r.n == good {
 				err = nil
 				break
 			}
+			if err == ErrStoreKey {
+				// a complete record that doesn't open isn't a partial write so fail
+				// rather than discarding it
+				f.Close()
+				return
+			}
+			if err != nil && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)) {
+				// a partial trailing pair is left behind if we were killed mid-write,
+				// so drop it rather than refusing to load the chain
+				Infof("Warning: discarding partial chain data in %s after offset %d: %v", path, good, err)
+				err = nil
+				truncated = true
+				break
+			}
+			if err == nil && e == nil && c.legacy {
+				err = fmt.Errorf("entry reference in a chain file without a format marker")
+			}
+			if err == nil {
+				err = c.addPair(header, e, i)
+			}
 			if err != nil {
-				Debugf("error reading pair:%s", err.Error())
+				// anything else is corruption of data that was completely written, and
+				// truncating it would throw away all the good pairs that follow it
+				f.Close()
+				err = fmt.Errorf("corrupt chain data in %s at offset %d: %w", path, good, err)
 				return
 			}
-			c.addPair(header, e, i)
 			i++
+			good = r.n
 		}
 		f.Close()
+		if truncated {
+			if err = os.Truncate(path, good); err != nil {
+				return
+			}
+		}
 		i--
 		// if we read anything then we have to calculate the final hash and add it
 		if i >= 0 {
@@ -115,11 +189,167 @@ func NewChainFromFile(h HashSpec, path string) (c *Chain, err error) {
 		if err != nil {
 			return
 		}
+		empty = true
+	}
+	if empty && c.sc != nil {
+		if err = c.writeSealedPreamble(f); err != nil {
+			f.Close()
+			return
+		}
+	} else if empty {
+		if _, err = f.Write([]byte(chainFormatMagic)); err != nil {
+			f.Close()
+			return
+		}
 	}
 	c.s = f
 	return
 }
 
+// chainFormatMagic starts an unencrypted chain file in the format in which a header whose
+// entry is already on the chain is followed by a reference to it rather than a copy.  Its
+// zero first byte followed by 'H' can't start a header, so files from before the format
+// existed are still recognized, and are then only ever appended to with complete entries.
+// Encrypted chain files always use the format.
+const chainFormatMagic = "\x00HCCHAINv2"
+
+// readFormatPreamble reads the start of a non-empty unencrypted chain file, marking the
+// chain as legacy and leaving the reader at the start of the file if it is missing
+func (c *Chain) readFormatPreamble(f *os.File, r *countingReader) (err error) {
+	magic := make([]byte, len(chainFormatMagic))
+	if _, err = io.ReadFull(r, magic); err == nil && string(magic) == chainFormatMagic {
+		return
+	}
+	c.legacy = true
+	_, err = f.Seek(0, io.SeekStart)
+	r.n = 0
+	return
+}
+
+// sealedChainMagic starts an encrypted chain file.  It is followed by a sealed copy of
+// itself so that the key can be checked even when the chain is empty.
+const sealedChainMagic = "HCSEALv1"
+
+// writeSealedPreamble starts an encrypted chain file
+func (c *Chain) writeSealedPreamble(w io.Writer) (err error) {
+	if _, err = w.Write([]byte(sealedChainMagic)); err != nil {
+		return
+	}
+	err = c.writeSealed(w, []byte(sealedChainMagic))
+	return
+}
+
+// readSealedPreamble reads the start of an encrypted chain file, checking that the file
+// is encrypted if and only if the chain has a key, and that the key is the right one.
+// For an unencrypted chain the reader is left at the start of the file, which is
+// reported as empty if it is.
+func (c *Chain) readSealedPreamble(f *os.File, r *countingReader) (empty bool, err error) {
+	magic := make([]byte, len(sealedChainMagic))
+	var n int
+	n, err = io.ReadFull(r, magic)
+	if n == 0 && err == io.EOF {
+		empty = true
+		err = nil
+		return
+	}
+	if err == nil && string(magic) == sealedChainMagic {
+		if c.sc == nil {
+			err = ErrStoreEncrypted
+			return
+		}
+		var check []byte
+		if check, err = c.readSealed(r); err != nil {
+			return
+		}
+		if string(check) != sealedChainMagic {
+			err = ErrStoreKey
+		}
+		return
+	}
+	if c.sc != nil {
+		err = ErrStoreNotEncrypted
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	r.n = 0
+	return
+}
+
+// writeSealed encrypts data with the chain's key and writes it with its length.  Records
+// aren't bound to their position as each header already links to the previous one.
+func (c *Chain) writeSealed(w io.Writer, data []byte) (err error) {
+	var sealed []byte
+	if sealed, err = c.sc.seal(data, nil); err != nil {
+		return
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint64(len(sealed)))
+	b.Write(sealed)
+	// a single write so that a record is only ever partially written if we are killed
+	_, err = w.Write(b.Bytes())
+	return
+}
+
+// readSealed reads a record written by writeSealed and decrypts it
+func (c *Chain) readSealed(r io.Reader) (data []byte, err error) {
+	var l uint64
+	if err = binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	sealed := make([]byte, l)
+	if _, err = io.ReadFull(r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	data, err = c.sc.open(sealed, nil)
+	return
+}
+
+// writePair writes a header and entry pair to the chain's file, encrypting it if the
+// chain has a key
+func (c *Chain) writePair(header *Header, entry Entry) (err error) {
+	if c.sc == nil {
+		err = writePair(c.s, header, entry)
+		return
+	}
+	var b bytes.Buffer
+	if err = writePair(&b, header, entry); err != nil {
+		return
+	}
+	err = c.writeSealed(c.s, b.Bytes())
+	return
+}
+
+// readPair reads a header and entry pair from the chain's file, decrypting it if the
+// chain has a key
+func (c *Chain) readPair(r io.Reader) (header *Header, entry Entry, err error) {
+	if c.sc == nil {
+		return readPair(r)
+	}
+	var data []byte
+	if data, err = c.readSealed(r); err != nil {
+		return
+	}
+	header, entry, err = readPair(bytes.NewReader(data))
+	return
+}
+
+// InMemory returns true if the chain is not being persisted to a file
+func (c *Chain) InMemory() bool {
+	return c.s == nil
+}
+
+// Close closes the chain's file stream if it is being persisted
+func (c *Chain) Close() (err error) {
+	if c.s != nil {
+		err = c.s.Close()
+		c.s = nil
+	}
+	return
+}
+
 // Top returns the latest header
 func (c *Chain) Top() (header *Header) {
 	l := len(c.Headers)
@@ -186,18 +416,31 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 		err = errors.New("entry indexes don't match can't create new entry")
 		return
 	}
-	var g GobEntry
-	g = *e.(*GobEntry)
+	// identical content is stored only once, so a header whose entry is already
+	// on the chain shares that copy and only a reference to it is persisted
+	var entry, stored Entry
+	if j, ok := c.Emap[header.EntryLink.String()]; ok {
+		entry = c.Entries[j]
+	} else {
+		var g GobEntry
+		g = *e.(*GobEntry)
+		entry = &g
+		stored = &g
+	}
 
 	c.Hashes = append(c.Hashes, hash)
 	c.Headers = append(c.Headers, header)
-	c.Entries = append(c.Entries, &g)
+	c.Entries = append(c.Entries, entry)
 	c.TypeTops[header.Type] = entryIdx
+	c.TypeCounts[header.Type]++
 	c.Emap[header.EntryLink.String()] = entryIdx
 	c.Hmap[hash.String()] = entryIdx
 
 	if c.s != nil {
-		err = writePair(c.s, header, &g)
+		if c.legacy {
+			stored = entry
+		}
+		err = c.writePair(header, stored)
 	}
 
 	return
@@ -237,15 +480,50 @@ func (c *Chain) GetEntryHeader(h Hash) (header *Header, err error) {
 	return
 }
 
+// Find returns the header and entry for a hash which may be either an entry hash or a header hash
+func (c *Chain) Find(h Hash) (header *Header, entry Entry, err error) {
+	k := h.String()
+	i, ok := c.Emap[k]
+	if !ok {
+		i, ok = c.Hmap[k]
+	}
+	if ok {
+		header = c.Headers[i]
+		entry = c.Entries[i]
+	} else {
+		err = ErrHashNotFound
+	}
+	return
+}
+
+// writePair writes a header and its entry, or if the entry is nil a reference to an
+// earlier copy of the header's entry
 func writePair(writer io.Writer, header *Header, entry Entry) (err error) {
 	err = MarshalHeader(writer, header)
 	if err != nil {
 		return
 	}
+	if entry == nil {
+		err = binary.Write(writer, binary.LittleEndian, uint64(0))
+		return
+	}
 	err = MarshalEntry(writer, entry)
 	return
 }
 
+// countingReader counts the bytes read through it so that the offset of the last complete
+// pair read from a chain file is known
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
 func readPair(reader io.Reader) (header *Header, entry Entry, err error) {
 	var hd Header
 	err = UnmarshalHeader(reader, &hd, 34)
@@ -266,8 +544,14 @@ func (c *Chain) MarshalChain(writer io.Writer) (err error) {
 		return err
 	}
 
+	written := make(map[string]bool)
 	for i, h := range c.Headers {
 		e := c.Entries[i]
+		k := h.EntryLink.String()
+		if written[k] {
+			e = nil
+		}
+		written[k] = true
 		err = writePair(writer, h, e)
 		if err != nil {
 			return
@@ -284,7 +568,16 @@ func (c *Chain) MarshalChain(writer io.Writer) (err error) {
 // This call assumes that Hashes array is one element behind the Headers and Entries
 // because for each pair (except the 0th) it adds the hash of the previous entry
 // thus it also means that you must add the last Hash after you have finished calling addPair
-func (c *Chain) addPair(header *Header, entry Entry, i int) {
+// A nil entry is a reference to an earlier pair with the same entry hash.
+func (c *Chain) addPair(header *Header, entry Entry, i int) (err error) {
+	if entry == nil {
+		j, ok := c.Emap[header.EntryLink.String()]
+		if !ok {
+			err = fmt.Errorf("%w: entry %v referenced before it was stored", ErrHashNotFound, header.EntryLink)
+			return
+		}
+		entry = c.Entries[j]
+	}
 	if i > 0 {
 		s := header.HeaderLink.String()
 		h, _ := NewHash(s)
@@ -294,7 +587,9 @@ func (c *Chain) addPair(header *Header, entry Entry, i int) {
 	c.Headers = append(c.Headers, header)
 	c.Entries = append(c.Entries, entry)
 	c.TypeTops[header.Type] = i
+	c.TypeCounts[header.Type]++
 	c.Emap[header.EntryLink.String()] = i
+	return
 }
 
 // UnmarshalChain unserializes a chain from a reader
@@ -317,7 +612,10 @@ func UnmarshalChain(reader io.Reader) (c *Chain, err error) {
 		if err != nil {
 			return
 		}
-		c.addPair(header, e, int(i))
+		err = c.addPair(header, e, int(i))
+		if err != nil {
+			return
+		}
 	}
 	// decode final hash
 	var h Hash
@@ -342,51 +640,354 @@ func (c *Chain) Walk(fn WalkerFn) (err error) {
 	return
 }
 
-// Validate traverses chain confirming the hashes
-// @TODO confirm that TypeLinks are also correct
-// @TODO confirm signatures
-func (c *Chain) Validate(h HashSpec) (err error) {
-	l := len(c.Headers)
-	for i := l - 1; i >= 0; i-- {
-		hd := c.Headers[i]
-		var hash Hash
-
-		// hash the header
-		hash, _, err = hd.Sum(h)
+// WalkType traverses the entries of a single type from the most recent to the first,
+// following the TypeLink of each header to the previous header of the same type
+func (c *Chain) WalkType(entryType string, fn WalkerFn) (err error) {
+	i, ok := c.TypeTops[entryType]
+	for ok {
+		err = fn(&c.Hashes[i], c.Headers[i], c.Entries[i])
 		if err != nil {
 			return
 		}
+		link := c.Headers[i].TypeLink
+		if link.IsNullHash() {
+			break
+		}
+		var j int
+		j, ok = c.Hmap[link.String()]
+		if !ok {
+			err = fmt.Errorf("type link not found at link %d", i)
+		}
+		i = j
+	}
+	return
+}
 
-		var nexth Hash
-		if i == l-1 {
-			nexth = c.Hashes[i]
-		} else {
-			nexth = c.Headers[i+1].HeaderLink
+// ValidateTypeLinks confirms that the TypeLink of each header points to the previous header
+// of the same type, or is the null hash for the first header of a type
+func (c *Chain) ValidateTypeLinks() (err error) {
+	err = firstError(c.typeLinkErrors(nil))
+	return
+}
+
+// typeLinkErrors returns the type link error of each header, nil where the link is correct,
+// calling step (if it's not nil) as each header is checked
+func (c *Chain) typeLinkErrors(step func()) (errs []error) {
+	errs = make([]error, len(c.Headers))
+	prev := make(map[string]Hash)
+	for i, hd := range c.Headers {
+		p, ok := prev[hd.Type]
+		if !ok {
+			p = NullHash()
 		}
+		if !bytes.Equal(hd.TypeLink.H, p.H) {
+			errs[i] = fmt.Errorf("type link mismatch at link %d", i)
+		}
+		prev[hd.Type] = c.Hashes[i]
+		stepped(step)
+	}
+	return
+}
 
-		if !bytes.Equal(hash.H, nexth.H) {
-			err = fmt.Errorf("header hash mismatch at link %d", i)
-			return
+// ValidateTimestamps confirms that the timestamp of each header is no earlier than that of
+// the header before it, which a clock rollback or a tampered chain would break.  Backdated
+// headers, whose timestamps the author chose, are held to the same order.
+func (c *Chain) ValidateTimestamps() (err error) {
+	err = firstError(c.timestampErrors(nil))
+	return
+}
+
+// timestampErrors returns the timestamp ordering error of each header, nil where the
+// timestamp is in order, calling step (if it's not nil) as each header is checked
+func (c *Chain) timestampErrors(step func()) (errs []error) {
+	errs = make([]error, len(c.Headers))
+	var last time.Time
+	for i, hd := range c.Headers {
+		if hd.Time.Before(last) {
+			errs[i] = fmt.Errorf("timestamp earlier than previous header at link %d", i)
 		}
+		last = hd.Time
+		stepped(step)
+	}
+	return
+}
+
+// stepped calls a validation pass's step function if it's not nil
+func stepped(step func()) {
+	if step != nil {
+		step()
+	}
+}
 
-		var b []byte
-		b, err = c.Entries[i].Marshal()
+// firstError returns the first non nil error of errs
+func firstError(errs []error) error {
+	for _, err := range errs {
 		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateStrict does the same checks as Validate and also confirms that the header
+// timestamps never go backwards
+func (c *Chain) ValidateStrict(h HashSpec) (err error) {
+	if err = c.Validate(h); err != nil {
+		return
+	}
+	err = c.ValidateTimestamps()
+	return
+}
+
+// Validate traverses chain confirming the hashes and the type links
+// @TODO confirm signatures
+func (c *Chain) Validate(h HashSpec) (err error) {
+	for i := len(c.Headers) - 1; i >= 0; i-- {
+		if err = c.validateHeader(h, i, true); err != nil {
 			return
 		}
-		err = hash.Sum(h, b)
-		if err != nil {
+	}
+	err = c.ValidateTypeLinks()
+	return
+}
+
+// ValidateFrom does the same checks as Validate but only from the header with hash start
+// to the top of the chain, trusting the headers before it, so that a chain extended from
+// a known good checkpoint needn't be revalidated from genesis.  The entry hashes and type
+// links are only checked if entriesToo is set.
+func (c *Chain) ValidateFrom(h HashSpec, start Hash, entriesToo bool) (err error) {
+	first, ok := c.Hmap[start.String()]
+	if !ok {
+		err = fmt.Errorf("%w: start header %v", ErrHashNotFound, start)
+		return
+	}
+	for i := len(c.Headers) - 1; i >= first; i-- {
+		if err = c.validateHeader(h, i, entriesToo); err != nil {
 			return
 		}
+	}
+	if !entriesToo {
+		return
+	}
+	prev := make(map[string]Hash)
+	for i := first; i < len(c.Headers); i++ {
+		hd := c.Headers[i]
+		p, ok := prev[hd.Type]
+		if ok {
+			if !bytes.Equal(hd.TypeLink.H, p.H) {
+				err = fmt.Errorf("type link mismatch at link %d", i)
+				return
+			}
+		} else if !hd.TypeLink.IsNullHash() {
+			// the first header of its type in the range must link back before start
+			j, ok := c.Hmap[hd.TypeLink.String()]
+			if !ok || j >= first || c.Headers[j].Type != hd.Type {
+				err = fmt.Errorf("type link mismatch at link %d", i)
+				return
+			}
+		}
+		prev[hd.Type] = c.Hashes[i]
+	}
+	return
+}
 
-		if !bytes.Equal(hash.H, hd.EntryLink.H) {
-			err = fmt.Errorf("entry hash mismatch at link %d", i)
+// validateHeader confirms that the hash of header i matches the link to it from the next
+// header (or the chain's hash of it for the top header), and if entriesToo is set that
+// its entry hashes to its EntryLink
+func (c *Chain) validateHeader(h HashSpec, i int, entriesToo bool) (err error) {
+	hd := c.Headers[i]
+	var hash Hash
+
+	// hash the header
+	hash, _, err = hd.Sum(h)
+	if err != nil {
+		return
+	}
+
+	var nexth Hash
+	if i == len(c.Headers)-1 {
+		nexth = c.Hashes[i]
+	} else {
+		nexth = c.Headers[i+1].HeaderLink
+	}
+
+	if !bytes.Equal(hash.H, nexth.H) {
+		err = fmt.Errorf("header hash mismatch at link %d", i)
+		return
+	}
+	if !entriesToo {
+		return
+	}
+
+	var b []byte
+	b, err = c.Entries[i].Marshal()
+	if err != nil {
+		return
+	}
+	err = hash.Sum(h, b)
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(hash.H, hd.EntryLink.H) {
+		err = fmt.Errorf("entry hash mismatch at link %d", i)
+	}
+	return
+}
+
+// VerifySignatures confirms that each header was signed by the agent key that was in effect
+// at that position in the chain. The genesis AgentEntry establishes the first key, and each
+// subsequent AgentEntry (which must itself be signed by the previous key) rotates to a new one.
+func (c *Chain) VerifySignatures() (err error) {
+	err = firstError(c.signatureErrors(nil))
+	return
+}
+
+// signatureErrors returns the signature verification error of each header, nil where the
+// signature is valid, calling step (if it's not nil) as each header is checked.  Once an
+// AgentEntry's key can't be read the following headers can't be verified.
+func (c *Chain) signatureErrors(step func()) (errs []error) {
+	errs = make([]error, len(c.Headers))
+	var key ic.PubKey
+	var err error
+
+	// the DNA entry precedes the first AgentEntry but is signed by its key
+	for i, hd := range c.Headers {
+		if hd.Type == AgentEntryType {
+			key, err = agentEntryKey(c.Entries[i])
+			if err != nil {
+				errs[0] = err
+				return
+			}
+			break
+		}
+	}
+
+	for i, hd := range c.Headers {
+		stepped(step)
+		if key == nil {
+			errs[i] = fmt.Errorf("no agent key to verify signature at link %d", i)
+			continue
+		}
+		valid, err := key.Verify(hd.EntryLink.H, hd.Sig.S)
+		if err != nil {
+			errs[i] = err
+		} else if !valid {
+			errs[i] = fmt.Errorf("invalid signature at link %d", i)
+		}
+		if hd.Type == AgentEntryType {
+			if key, err = agentEntryKey(c.Entries[i]); err != nil && errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return
+}
+
+// VerifyChainSlice checks that headers, received from a peer, are a valid continuation of
+// another agent's chain from the header with hash expectedPrev: that each header links to
+// the one before it, is signed by the agent's key, and (if entries are given) that it links
+// to its entry.  key is the agent's key in effect at expectedPrev, and is replaced by the
+// key of each AgentEntry in the slice, as a key rotation does.  entries must be empty or
+// match headers one to one, and may only have nil elements for entries that aren't
+// AgentEntries, which aren't checked.  Type links point outside the slice so aren't checked.
+//
+// N.B. header signatures only cover the entry hash (EntryLink), so what is authenticated is
+// that the agent signed each entry, not the rest of the header.  The header links are only
+// checked to be consistent with each other: whoever relays the slice could change the
+// times, types or links of its headers and re-link the headers after them without it failing.
+func VerifyChainSlice(spec HashSpec, key ic.PubKey, headers []Header, entries []Entry, expectedPrev Hash) (err error) {
+	if len(entries) != 0 && len(entries) != len(headers) {
+		err = fmt.Errorf("%d entries given for %d headers", len(entries), len(headers))
+		return
+	}
+	prev := expectedPrev
+	for i := range headers {
+		hd := &headers[i]
+		if !hd.HeaderLink.Equal(&prev) {
+			err = fmt.Errorf("header link mismatch at slice index %d", i)
+			return
+		}
+		var valid bool
+		if valid, err = key.Verify(hd.EntryLink.H, hd.Sig.S); err != nil {
+			return
+		}
+		if !valid {
+			err = fmt.Errorf("%w at slice index %d", ErrInvalidSignature, i)
 			return
 		}
+		var e Entry
+		if len(entries) != 0 {
+			e = entries[i]
+		}
+		if e != nil {
+			var eh Hash
+			if eh, err = e.Sum(spec); err != nil {
+				return
+			}
+			if !eh.Equal(&hd.EntryLink) {
+				err = fmt.Errorf("entry hash mismatch at slice index %d", i)
+				return
+			}
+		}
+		if hd.Type == AgentEntryType {
+			if e == nil {
+				err = fmt.Errorf("missing AgentEntry at slice index %d", i)
+				return
+			}
+			if key, err = agentEntryKey(e); err != nil {
+				return
+			}
+		}
+		if prev, _, err = hd.Sum(spec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// signingAgent returns the AgentEntry in effect when header i was signed: the header's own
+// entry if it is an AgentEntry (which a key rotation signs with the previous key), otherwise
+// the latest AgentEntry before it.  The DNA entry precedes the first AgentEntry but is
+// signed by its key.
+func (c *Chain) signingAgent(i int) (a AgentEntry, err error) {
+	j := -1
+	for k := i; k >= 0; k-- {
+		if c.Headers[k].Type == AgentEntryType {
+			j = k
+			break
+		}
+	}
+	if j < 0 {
+		for k := i + 1; k < len(c.Headers); k++ {
+			if c.Headers[k].Type == AgentEntryType {
+				j = k
+				break
+			}
+		}
+	}
+	if j < 0 {
+		err = errors.New("no AgentEntry in chain")
+		return
+	}
+	a, ok := c.Entries[j].Content().(AgentEntry)
+	if !ok {
+		err = errors.New("expected AgentEntry")
 	}
 	return
 }
 
+// agentEntryKey returns the public key of an AgentEntry
+func agentEntryKey(e Entry) (key ic.PubKey, err error) {
+	a, ok := e.Content().(AgentEntry)
+	if !ok {
+		err = errors.New("expected AgentEntry")
+		return
+	}
+	key, err = ic.UnmarshalPublicKey(a.Key)
+	return
+}
+
 // String converts a chain to a textual dump of the headers and entries
 func (c *Chain) String() string {
 	l := len(c.Headers)
@@ -407,3 +1008,8 @@ func (c *Chain) String() string {
 func (c *Chain) Length() int {
 	return len(c.Headers)
 }
+
+// CountType returns the number of entries of the given type in the chain
+func (c *Chain) CountType(entryType string) int {
+	return c.TypeCounts[entryType]
+}
@@ -2,9 +2,12 @@ package holochain
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -58,6 +61,226 @@ func TestNewChainFromFile(t *testing.T) {
 	})
 }
 
+func TestNewChainFromTruncatedFile(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+	var sizes []int64
+	for i := 0; i < 3; i++ {
+		e := GobEntry{C: fmt.Sprintf("some data%d", i)}
+		if _, err = c.AddEntry(h, now, "myData", &e, key); err != nil {
+			panic(err)
+		}
+		info, _ := os.Stat(path)
+		sizes = append(sizes, info.Size())
+	}
+	c.s.Close()
+	full, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	// cut inside the last pair: just into it, in its middle and just short of its end
+	offsets := []struct {
+		name   string
+		offset int64
+	}{
+		{"one byte into the last record", sizes[1] + 1},
+		{"mid-record", (sizes[1] + sizes[2]) / 2},
+		{"one byte short of the last record's end", sizes[2] - 1},
+	}
+	for _, o := range offsets {
+		if err = ioutil.WriteFile(path, full[:o.offset], 0600); err != nil {
+			panic(err)
+		}
+
+		Convey("it should recover to the last complete entry when cut "+o.name, t, func() {
+			c, err = NewChainFromFile(h, path)
+			So(err, ShouldBeNil)
+			So(c.Length(), ShouldEqual, 2)
+			So(c.Top().Type, ShouldEqual, "myData")
+			So(c.Entries[1].Content(), ShouldEqual, "some data1")
+			info, _ := os.Stat(path)
+			So(info.Size(), ShouldEqual, sizes[1])
+			So(c.Validate(h), ShouldBeNil)
+		})
+
+		Convey("it should continue to append after recovery when cut "+o.name, t, func() {
+			e := GobEntry{C: "more data"}
+			_, err := c.AddEntry(h, now, "myData", &e, key)
+			So(err, ShouldBeNil)
+			dump := c.String()
+			c.s.Close()
+			c, err = NewChainFromFile(h, path)
+			So(err, ShouldBeNil)
+			So(c.String(), ShouldEqual, dump)
+			c.s.Close()
+		})
+	}
+}
+
+func TestNewChainFromCorruptFile(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+	var sizes []int64
+	for i := 0; i < 3; i++ {
+		e := GobEntry{C: fmt.Sprintf("some data%d", i)}
+		if _, err = c.AddEntry(h, now, "myData", &e, key); err != nil {
+			panic(err)
+		}
+		info, _ := os.Stat(path)
+		sizes = append(sizes, info.Size())
+	}
+	c.s.Close()
+
+	// garble the gob encoding of the middle pair's entry
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	i := bytes.Index(b[sizes[0]:sizes[1]], []byte("string"))
+	if i < 0 {
+		panic("entry encoding not found")
+	}
+	for j := sizes[0] + int64(i); j < sizes[1]; j++ {
+		b[j] = 0xff
+	}
+	if err = ioutil.WriteFile(path, b, 0600); err != nil {
+		panic(err)
+	}
+
+	Convey("it should refuse to load a chain with a corrupt pair that isn't the last", t, func() {
+		_, err := NewChainFromFile(h, path)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, fmt.Sprintf("corrupt chain data in %s at offset %d", path, sizes[0]))
+		info, _ := os.Stat(path)
+		So(info.Size(), ShouldEqual, sizes[2])
+	})
+}
+
+func TestNewEncryptedChainFromFile(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+	storeKey := bytes.Repeat([]byte{1}, 32)
+
+	path := d + "/chain.dat"
+	c, err := NewEncryptedChainFromFile(h, path, storeKey)
+	if err != nil {
+		panic(err)
+	}
+	var sizes []int64
+	for i := 0; i < 3; i++ {
+		e := GobEntry{C: fmt.Sprintf("secret data%d", i)}
+		if _, err = c.AddEntry(h, now, "myData", &e, key); err != nil {
+			panic(err)
+		}
+		info, _ := os.Stat(path)
+		sizes = append(sizes, info.Size())
+	}
+	dump := c.String()
+	c.s.Close()
+
+	Convey("it should not write the chain data in the clear", t, func() {
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(b, []byte(sealedChainMagic)), ShouldBeTrue)
+		So(bytes.Contains(b, []byte("secret data")), ShouldBeFalse)
+		So(bytes.Contains(b, []byte("myData")), ShouldBeFalse)
+	})
+
+	Convey("it should load the chain data with the key", t, func() {
+		c, err := NewEncryptedChainFromFile(h, path, storeKey)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		So(c.Validate(h), ShouldBeNil)
+		c.s.Close()
+	})
+
+	Convey("it should fail cleanly with the wrong key or no key", t, func() {
+		_, err := NewEncryptedChainFromFile(h, path, bytes.Repeat([]byte{2}, 32))
+		So(err, ShouldEqual, ErrStoreKey)
+		_, err = NewChainFromFile(h, path)
+		So(err, ShouldEqual, ErrStoreEncrypted)
+		info, _ := os.Stat(path)
+		So(info.Size(), ShouldEqual, sizes[2])
+	})
+
+	Convey("it should check the key of an encrypted chain with no entries", t, func() {
+		p := d + "/empty.dat"
+		c, err := NewEncryptedChainFromFile(h, p, storeKey)
+		So(err, ShouldBeNil)
+		c.s.Close()
+		_, err = NewEncryptedChainFromFile(h, p, bytes.Repeat([]byte{2}, 32))
+		So(err, ShouldEqual, ErrStoreKey)
+	})
+
+	Convey("it should refuse to encrypt an unencrypted chain", t, func() {
+		p := d + "/plain.dat"
+		c, err := NewChainFromFile(h, p)
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "some data"}
+		_, err = c.AddEntry(h, now, "myData", &e, key)
+		So(err, ShouldBeNil)
+		c.s.Close()
+		_, err = NewEncryptedChainFromFile(h, p, storeKey)
+		So(err, ShouldEqual, ErrStoreNotEncrypted)
+	})
+
+	Convey("it should recover to the last complete entry", t, func() {
+		So(os.Truncate(path, sizes[2]-3), ShouldBeNil)
+		c, err := NewEncryptedChainFromFile(h, path, storeKey)
+		So(err, ShouldBeNil)
+		So(c.Length(), ShouldEqual, 2)
+		So(c.Entries[1].Content(), ShouldEqual, "secret data1")
+		info, _ := os.Stat(path)
+		So(info.Size(), ShouldEqual, sizes[1])
+		c.s.Close()
+	})
+}
+
+func TestNewChainInMemory(t *testing.T) {
+	h, key, now := chainTestSetup()
+	Convey("it should make an in-memory chain for empty or memory paths", t, func() {
+		c, err := NewChainFromFile(h, ChainMemoryPath)
+		So(err, ShouldBeNil)
+		So(c.InMemory(), ShouldBeTrue)
+		c, err = NewChainFromFile(h, "")
+		So(err, ShouldBeNil)
+		So(c.InMemory(), ShouldBeTrue)
+	})
+	Convey("it should add and walk entries like a file backed chain", t, func() {
+		c := NewChainInMemory()
+		e := GobEntry{C: "some data1"}
+		c.AddEntry(h, now, "myData1", &e, key)
+		e2 := GobEntry{C: "some other data2"}
+		c.AddEntry(h, now, "myData2", &e2, key)
+		So(c.Length(), ShouldEqual, 2)
+		So(c.Top().Type, ShouldEqual, "myData2")
+		var types []string
+		err := c.Walk(func(key *Hash, header *Header, entry Entry) error {
+			types = append(types, header.Type)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", types), ShouldEqual, "[myData2 myData1]")
+		So(c.Close(), ShouldBeNil)
+	})
+}
+
 func TestTop(t *testing.T) {
 	c := NewChain()
 	var hash *Hash
@@ -118,6 +341,8 @@ func TestAddEntry(t *testing.T) {
 		So(len(c.Headers), ShouldEqual, 1)
 		So(len(c.Entries), ShouldEqual, 1)
 		So(c.TypeTops["myData"], ShouldEqual, 0)
+		So(c.CountType("myData"), ShouldEqual, 1)
+		So(c.CountType("otherData"), ShouldEqual, 0)
 		So(hash.Equal(&c.Hashes[0]), ShouldBeTrue)
 	})
 }
@@ -161,6 +386,20 @@ func TestGet(t *testing.T) {
 		So(fmt.Sprintf("%v", &e2), ShouldEqual, fmt.Sprintf("%v", ed))
 	})
 
+	Convey("it should find header and entry by either hash", t, func() {
+		hd, ed, err := c.Find(hd1.EntryLink)
+		So(err, ShouldBeNil)
+		So(hd, ShouldEqual, c.Headers[0])
+		So(fmt.Sprintf("%v", &e1), ShouldEqual, fmt.Sprintf("%v", ed))
+		hd, ed, err = c.Find(h2)
+		So(err, ShouldBeNil)
+		So(hd, ShouldEqual, c.Headers[1])
+		So(fmt.Sprintf("%v", &e2), ShouldEqual, fmt.Sprintf("%v", ed))
+		hash, _ := NewHash("QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuUi")
+		_, _, err = c.Find(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
 	Convey("it should return nil for non existent hash", t, func() {
 		hash, _ := NewHash("QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuUi")
 		hd, err := c.Get(hash)
@@ -196,11 +435,103 @@ func TestMarshal(t *testing.T) {
 			So(c.Hashes[i].String(), ShouldEqual, c1.Hashes[i].String())
 		}
 		So(reflect.DeepEqual(c.TypeTops, c1.TypeTops), ShouldBeTrue)
+		So(reflect.DeepEqual(c.TypeCounts, c1.TypeCounts), ShouldBeTrue)
 		So(reflect.DeepEqual(c.Hmap, c1.Hmap), ShouldBeTrue)
 		So(reflect.DeepEqual(c.Emap, c1.Emap), ShouldBeTrue)
 	})
 }
 
+func TestDuplicateEntries(t *testing.T) {
+	d := setupTestDir()
+	defer cleanupTestDir(d)
+	h, key, now := chainTestSetup()
+
+	path := d + "/chain.dat"
+	c, err := NewChainFromFile(h, path)
+	if err != nil {
+		panic(err)
+	}
+
+	e := GobEntry{C: "some data"}
+	c.AddEntry(h, now, "myData1", &e, key)
+	e = GobEntry{C: "some other data"}
+	c.AddEntry(h, now, "myData1", &e, key)
+	e = GobEntry{C: "some data"}
+	c.AddEntry(h, now, "myData2", &e, key)
+
+	Convey("identical entries should be stored once with unique headers", t, func() {
+		So(c.Entries[2], ShouldEqual, c.Entries[0])
+		So(c.Headers[2].EntryLink.Equal(&c.Headers[0].EntryLink), ShouldBeTrue)
+		So(c.Hashes[2].Equal(&c.Hashes[0]), ShouldBeFalse)
+		So(c.Validate(h), ShouldBeNil)
+
+		entry, entryType, err := c.GetEntry(c.Headers[0].EntryLink)
+		So(err, ShouldBeNil)
+		So(entry, ShouldEqual, c.Entries[0])
+		So(entryType, ShouldEqual, "myData2")
+	})
+
+	Convey("it should reload a chain with a stored reference", t, func() {
+		dump := c.String()
+		c.s.Close()
+		c1, err := NewChainFromFile(h, path)
+		So(err, ShouldBeNil)
+		So(c1.String(), ShouldEqual, dump)
+		So(c1.Entries[2], ShouldEqual, c1.Entries[0])
+		c1.s.Close()
+	})
+
+	Convey("it should marshal and unmarshal a chain with a stored reference", t, func() {
+		var b bytes.Buffer
+		err := c.MarshalChain(&b)
+		So(err, ShouldBeNil)
+		c1, err := UnmarshalChain(&b)
+		So(err, ShouldBeNil)
+		So(c1.String(), ShouldEqual, c.String())
+		So(c1.Entries[2], ShouldEqual, c1.Entries[0])
+		So(reflect.DeepEqual(c.Emap, c1.Emap), ShouldBeTrue)
+	})
+
+	Convey("chain files should start with the format marker", t, func() {
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(b[:len(chainFormatMagic)]), ShouldEqual, chainFormatMagic)
+	})
+
+	Convey("a reference in a chain file without the format marker should be an error", t, func() {
+		b, _ := ioutil.ReadFile(path)
+		legacy := d + "/legacy.dat"
+		So(ioutil.WriteFile(legacy, b[len(chainFormatMagic):], 0600), ShouldBeNil)
+		_, err := NewChainFromFile(h, legacy)
+		So(err.Error(), ShouldContainSubstring, "entry reference in a chain file without a format marker")
+	})
+
+	Convey("chain files without the format marker should only be appended to with complete entries", t, func() {
+		p := d + "/old.dat"
+		c1, err := NewChainFromFile(h, p)
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "some data"}
+		c1.AddEntry(h, now, "myData1", &e, key)
+		c1.s.Close()
+		b, _ := ioutil.ReadFile(p)
+		So(ioutil.WriteFile(p, b[len(chainFormatMagic):], 0600), ShouldBeNil)
+
+		c1, err = NewChainFromFile(h, p)
+		So(err, ShouldBeNil)
+		So(c1.legacy, ShouldBeTrue)
+		e = GobEntry{C: "some data"}
+		_, err = c1.AddEntry(h, now, "myData2", &e, key)
+		So(err, ShouldBeNil)
+		dump := c1.String()
+		c1.s.Close()
+
+		c1, err = NewChainFromFile(h, p)
+		So(err, ShouldBeNil)
+		So(c1.String(), ShouldEqual, dump)
+		c1.s.Close()
+	})
+}
+
 func TestWalkChain(t *testing.T) {
 	c := NewChain()
 	h, key, now := chainTestSetup()
@@ -248,6 +579,89 @@ func TestValidateChain(t *testing.T) {
 		c.Entries[0].(*GobEntry).C = "some data"
 		c.Headers[1].TypeLink = NullHash()
 		So(c.Validate(h).Error(), ShouldEqual, "header hash mismatch at link 1")
+		c.Headers[1].TypeLink = c.Hashes[0]
+	})
+
+	Convey("it should check that type links point to the previous header of the same type", t, func() {
+		So(c.ValidateTypeLinks(), ShouldBeNil)
+		c.Headers[2].TypeLink = c.Hashes[0]
+		So(c.ValidateTypeLinks().Error(), ShouldEqual, "type link mismatch at link 2")
+		c.Headers[2].TypeLink = c.Hashes[1]
+	})
+
+	Convey("it should check that timestamps don't go backwards", t, func() {
+		So(c.ValidateTimestamps(), ShouldBeNil)
+		So(c.ValidateStrict(h), ShouldBeNil)
+		ts := c.Headers[1].Time
+		c.Headers[1].Time = ts.Add(time.Hour)
+		So(c.ValidateTimestamps().Error(), ShouldEqual, "timestamp earlier than previous header at link 2")
+		c.Headers[1].Time = ts
+	})
+
+	Convey("it should check the timestamps of backdated headers too", t, func() {
+		ts := c.Headers[1].Time
+		c.Headers[1].Backdated = true
+		c.Headers[1].Time = c.Headers[0].Time.Add(-time.Hour)
+		So(c.ValidateTimestamps().Error(), ShouldEqual, "timestamp earlier than previous header at link 1")
+		c.Headers[1].Time = ts.Add(time.Hour)
+		So(c.ValidateTimestamps().Error(), ShouldEqual, "timestamp earlier than previous header at link 2")
+		c.Headers[1].Time = ts
+		So(c.ValidateTimestamps(), ShouldBeNil)
+		c.Headers[1].Backdated = false
+	})
+
+	Convey("it should validate from a header to the top", t, func() {
+		So(c.ValidateFrom(h, c.Hashes[1], true), ShouldBeNil)
+		So(c.ValidateFrom(h, c.Hashes[2], true), ShouldBeNil)
+
+		// breaks before the start header are trusted
+		c.Entries[0].(*GobEntry).C = "fish"
+		So(c.ValidateFrom(h, c.Hashes[1], true), ShouldBeNil)
+		c.Entries[0].(*GobEntry).C = "some data"
+
+		c.Entries[1].(*GobEntry).C = "fish"
+		So(c.ValidateFrom(h, c.Hashes[1], true).Error(), ShouldEqual, "entry hash mismatch at link 1")
+		So(c.ValidateFrom(h, c.Hashes[1], false), ShouldBeNil)
+		c.Entries[1].(*GobEntry).C = "some other data"
+
+		c.Headers[2].TypeLink = NullHash()
+		So(c.ValidateFrom(h, c.Hashes[1], false).Error(), ShouldEqual, "header hash mismatch at link 2")
+		c.Headers[2].TypeLink = c.Hashes[1]
+	})
+
+	Convey("it should require the start header to be in the chain", t, func() {
+		err := c.ValidateFrom(h, NullHash(), true)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+	})
+}
+
+func TestWalkType(t *testing.T) {
+	c := NewChain()
+	h, key, now := chainTestSetup()
+	c.AddEntry(h, now, "myData1", &GobEntry{C: "a"}, key)
+	c.AddEntry(h, now, "myData2", &GobEntry{C: "b"}, key)
+	c.AddEntry(h, now, "myData1", &GobEntry{C: "c"}, key)
+	c.AddEntry(h, now, "myData2", &GobEntry{C: "d"}, key)
+	c.AddEntry(h, now, "myData1", &GobEntry{C: "e"}, key)
+
+	Convey("it should walk only the entries of the given type", t, func() {
+		var x string
+		err := c.WalkType("myData1", func(key *Hash, h *Header, entry Entry) error {
+			x += entry.(*GobEntry).C.(string)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(x, ShouldEqual, "eca")
+	})
+
+	Convey("it should walk nothing for a type not on the chain", t, func() {
+		var n int
+		err := c.WalkType("bogus", func(key *Hash, h *Header, entry Entry) error {
+			n++
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
 	})
 }
 
@@ -281,6 +695,76 @@ func TestPersistingChain(t *testing.T) {
 }
 */
 
+func TestVerifyChainSlice(t *testing.T) {
+	c := NewChain()
+	h, key, now := chainTestSetup()
+	for i := 0; i < 3; i++ {
+		e := GobEntry{C: fmt.Sprintf("some data%d", i)}
+		if _, err := c.AddEntry(h, now, "myData", &e, key); err != nil {
+			panic(err)
+		}
+	}
+	slice := func(from int) (headers []Header, entries []Entry) {
+		for i := from; i < len(c.Headers); i++ {
+			headers = append(headers, *c.Headers[i])
+			entries = append(entries, c.Entries[i])
+		}
+		return
+	}
+
+	Convey("it should accept a valid continuation", t, func() {
+		headers, entries := slice(1)
+		So(VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[0]), ShouldBeNil)
+		So(VerifyChainSlice(h, key.GetPublic(), headers, nil, c.Hashes[0]), ShouldBeNil)
+		So(VerifyChainSlice(h, key.GetPublic(), nil, nil, c.Hashes[0]), ShouldBeNil)
+		headers, entries = slice(0)
+		So(VerifyChainSlice(h, key.GetPublic(), headers, entries, NullHash()), ShouldBeNil)
+	})
+
+	Convey("it should reject a slice that doesn't follow the expected header", t, func() {
+		headers, entries := slice(1)
+		err := VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[1])
+		So(err.Error(), ShouldEqual, "header link mismatch at slice index 0")
+		headers[1].HeaderLink = c.Hashes[0]
+		err = VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[0])
+		So(err.Error(), ShouldEqual, "header link mismatch at slice index 1")
+	})
+
+	Convey("it should reject a header that isn't signed by the agent", t, func() {
+		headers, entries := slice(1)
+		other, _ := NewAgent(IPFS, "other agent")
+		err := VerifyChainSlice(h, other.PubKey(), headers, entries, c.Hashes[0])
+		So(errors.Is(err, ErrInvalidSignature), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "invalid signature at slice index 0")
+	})
+
+	Convey("it should reject entries that don't match their headers", t, func() {
+		headers, entries := slice(1)
+		entries[1] = &GobEntry{C: "fish"}
+		err := VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[0])
+		So(err.Error(), ShouldEqual, "entry hash mismatch at slice index 1")
+		err = VerifyChainSlice(h, key.GetPublic(), headers, entries[:1], c.Hashes[0])
+		So(err.Error(), ShouldEqual, "1 entries given for 2 headers")
+	})
+
+	Convey("it should switch keys at an AgentEntry", t, func() {
+		newAgent, _ := NewAgent(IPFS, "new agent")
+		pk, _ := ic.MarshalPublicKey(newAgent.PubKey())
+		e := GobEntry{C: AgentEntry{Name: "new agent", KeyType: IPFS, Key: pk}}
+		_, err := c.AddEntry(h, now, AgentEntryType, &e, key)
+		So(err, ShouldBeNil)
+		e = GobEntry{C: "after rotation"}
+		_, err = c.AddEntry(h, now, "myData", &e, newAgent.PrivKey())
+		So(err, ShouldBeNil)
+
+		headers, entries := slice(1)
+		So(VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[0]), ShouldBeNil)
+		entries[2] = nil
+		err = VerifyChainSlice(h, key.GetPublic(), headers, entries, c.Hashes[0])
+		So(err.Error(), ShouldEqual, "missing AgentEntry at slice index 2")
+	})
+}
+
 func chainTestSetup() (hs HashSpec, key ic.PrivKey, now time.Time) {
 	a, _ := NewAgent(IPFS, "agent id")
 	key = a.PrivKey()
@@ -7,8 +7,10 @@ import (
 	holo "github.com/metacurrency/holochain"
 	"github.com/urfave/cli"
 	"os"
+	"os/signal"
 	"os/user"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -26,6 +28,8 @@ func setupApp() (app *cli.App) {
 
 	var force bool
 	var root string
+	var template string
+	var zomes cli.StringSlice
 	var service *holo.Service
 
 	app.Flags = []cli.Flag{
@@ -139,6 +143,17 @@ func setupApp() (app *cli.App) {
 					Usage:       "overwrite existing holochain",
 					Destination: &force,
 				},
+				cli.StringFlag{
+					Name:        "template",
+					Value:       holo.BlankDevTemplate,
+					Usage:       "template to generate from: blank or demo",
+					Destination: &template,
+				},
+				cli.StringSliceFlag{
+					Name:  "zome",
+					Usage: "zome to scaffold in the blank template as name[:nucleus-type] (repeatable, nucleus-type defaults to zygo)",
+					Value: &zomes,
+				},
 			},
 			Aliases:   []string{"d"},
 			Usage:     "generate a default configuration files, suitable for editing",
@@ -161,7 +176,16 @@ func setupApp() (app *cli.App) {
 						return e
 					}
 				}
-				h, err := service.GenDev(root+"/"+name, format)
+				devZomes := make([]holo.DevZome, 0)
+				for _, z := range zomes {
+					d := holo.DevZome{Name: z, NucleusType: holo.ZygoNucleusType}
+					if i := strings.Index(z, ":"); i >= 0 {
+						d.Name = z[:i]
+						d.NucleusType = z[i+1:]
+					}
+					devZomes = append(devZomes, d)
+				}
+				h, err := service.GenDevTemplate(root+"/"+name, format, template, devZomes...)
 				if err == nil {
 					if verbose {
 						fmt.Printf("created %s with new id: %v\n", name, h.Id)
@@ -178,27 +202,27 @@ func setupApp() (app *cli.App) {
 				{
 					Name:      "keys",
 					Aliases:   []string{"k", "key"},
-					Usage:     "generate separate key pair for entry signing on a specific holochain",
+					Usage:     "generate a new key pair for entry signing on a specific holochain, rotating out the current key",
 					ArgsUsage: "holochain-name",
 					Action: func(c *cli.Context) error {
-						// need to implement this later when this would
-						// check to see if the chain is started, and if so
-						// actually add a new AgentEntry to a chain, otherwise
-						// it could just add chain specific files.
-						return errors.New("not yet implemented")
-						/*
-							name, err := checkForName(c, "gen keys")
-							if err != nil {
-								return err
-							}
-							h, err := service.Load(name)
-							if err != nil {
-								return err
+						h, err := getHolochain(c, service, "gen keys")
+						if err != nil {
+							return err
+						}
+						if !h.Started() {
+							return errors.New("can't rotate keys on an un-started chain")
+						}
+						agent, err := holo.NewAgent(holo.IPFS, h.Agent().Name())
+						if err != nil {
+							return err
+						}
+						err = h.RotateKey(agent)
+						if err == nil {
+							if verbose {
+								fmt.Printf("rotated key for %s, new agent entry: %v\n", h.Name, h.Agenthash())
 							}
-							h.agent.GenKeys()
-							err = holo.SaveAgent(h.path, h.agent)
-							return err*/
-
+						}
+						return err
 					},
 				},
 				{
@@ -302,9 +326,10 @@ func setupApp() (app *cli.App) {
 					Destination: &force,
 				},
 			},
-			Name:    "test",
-			Aliases: []string{"t"},
-			Usage:   "run validation against test data for a chain in development",
+			Name:      "test",
+			Aliases:   []string{"t"},
+			ArgsUsage: "holochain-name [test-file]",
+			Usage:     "run validation against test data for a chain in development, or just against the named test file",
 			Action: func(c *cli.Context) error {
 				h, err := getHolochain(c, service, "test")
 				if err != nil {
@@ -320,7 +345,12 @@ func setupApp() (app *cli.App) {
 				if err != nil {
 					return err
 				}
-				var errs = h.Test()
+				var errs []error
+				if file := c.Args().Get(1); file != "" {
+					errs = h.TestOneWithOptions(file, holo.TestOptions{Verbose: verbose})
+				} else {
+					errs = h.TestWithOptions(holo.TestOptions{Verbose: verbose})
+				}
 				var s string
 				for _, e := range errs {
 					s += e.Error()
@@ -329,14 +359,28 @@ func setupApp() (app *cli.App) {
 			},
 		},
 		{
-			Name:    "status",
-			Aliases: []string{"s"},
-			Usage:   "display information about installed chains",
+			Name:      "status",
+			Aliases:   []string{"s"},
+			Usage:     "display information about installed chains, or the status of a chain",
+			ArgsUsage: "[holochain-name]",
 			Action: func(c *cli.Context) error {
 				if !initialized {
 					return uninitialized
 				}
-				listChains(service)
+				if c.Args().First() == "" {
+					listChains(service)
+					return nil
+				}
+				h, err := getHolochain(c, service, "status")
+				if err != nil {
+					return err
+				}
+				s := h.Status()
+				fmt.Printf("Started: %v\n", s.Started)
+				fmt.Printf("Chain length: %d\n", s.ChainLength)
+				fmt.Printf("DNA hash: %s\n", s.DNAHash)
+				fmt.Printf("Agent hash: %s\n", s.AgentHash)
+				fmt.Printf("DHT entries: %d\n", s.DHTEntries)
 				return nil
 			},
 		},
@@ -406,6 +450,19 @@ func setupApp() (app *cli.App) {
 				}
 				go h.DHT().HandlePutReqs()
 				go h.DHT().Gossip(2 * time.Second)
+				go h.DHT().Sweep()
+				go h.DHT().Snapshots(holo.DefaultSnapshotInterval)
+
+				// shut down cleanly on interrupt so the stores don't get corrupted
+				sigs := make(chan os.Signal, 1)
+				signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+				go func() {
+					<-sigs
+					if e := h.Close(); e != nil {
+						fmt.Printf("error closing holochain: %v\n", e)
+					}
+					os.Exit(0)
+				}()
 				serve(h, port)
 				return err
 			},
@@ -424,6 +481,22 @@ func setupApp() (app *cli.App) {
 				return err
 			},
 		},
+		{
+			Name:      "uninstall",
+			Usage:     "remove an installed chain. Warning this destroys the chain's DNA and all its data!",
+			ArgsUsage: "holochain-name",
+			Action: func(c *cli.Context) error {
+				name, err := checkForName(c, "uninstall")
+				if err != nil {
+					return err
+				}
+				err = service.Remove(name)
+				if err == nil && verbose {
+					fmt.Printf("removed %s\n", name)
+				}
+				return err
+			},
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
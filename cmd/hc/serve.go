@@ -7,7 +7,9 @@
 package main
 
 import (
-	_ "encoding/json"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	websocket "github.com/gorilla/websocket"
 	holo "github.com/metacurrency/holochain"
@@ -53,7 +55,7 @@ func serve(h *holo.Holochain, port string) {
 			}
 			zome := v["zome"]
 			function := v["fn"]
-			result, err := call(w, h, zome, function, v["arg"])
+			result, _, err := call(w, h, zome, function, v["arg"], false)
 			switch t := result.(type) {
 			case string:
 				err = conn.WriteMessage(websocket.TextMessage, []byte(t))
@@ -71,7 +73,25 @@ func serve(h *holo.Holochain, port string) {
 		}
 	})
 
-	http.HandleFunc("/fn/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.Status()); err != nil {
+			errs.Log(err)
+		}
+	})
+
+	http.HandleFunc("/fn/", fnHandler(h))
+	fmt.Printf("starting server on localhost:%s\n", port)
+	err := http.ListenAndServe(":"+port, nil) // set listen port
+	if err != nil {
+		errs.Logf("Couldn't start server: %v", err)
+	}
+}
+
+// fnHandler returns the handler for /fn/<zome>/<function> requests, which call the zome
+// function with the request body (or, for GET, the args query value) as its arguments
+func fnHandler(h *holo.Holochain) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
 		var err error
 		var errCode int = 400
@@ -100,10 +120,21 @@ func serve(h *holo.Holochain, port string) {
 		zome := path[2]
 		function := path[3]
 		args := string(body)
-		result, err := call(w, h, zome, function, args)
+		// GET requests can only call read-only functions, and take their args from the query
+		readOnly := r.Method == "GET"
+		if readOnly {
+			args = r.URL.Query().Get("args")
+		}
+		result, code, err := call(w, h, zome, function, args, readOnly)
 		if err != nil {
 			log.Logf("HC Serve: call of %s:%s resulted in error: %v\n", zome, function, err)
-			http.Error(w, err.Error(), 500)
+			if code == 0 {
+				code = 500
+				if errors.Is(err, holo.ErrInvalidJSONArgument) {
+					code = 400
+				}
+			}
+			http.Error(w, err.Error(), code)
 
 			return
 		} else {
@@ -117,28 +148,35 @@ func serve(h *holo.Holochain, port string) {
 				err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
 			}
 		}
-	}) // set router
-	fmt.Printf("starting server on localhost:%s\n", port)
-	err := http.ListenAndServe(":"+port, nil) // set listen port
-	if err != nil {
-		errs.Logf("Couldn't start server: %v", err)
 	}
 }
 
-func call(w http.ResponseWriter, h *holo.Holochain, zome string, function string, args string) (result interface{}, err error) {
-	var n holo.Nucleus
-	n, err = h.MakeNucleus(zome)
+// call calls the zome function, returning the HTTP status code for the error if the
+// request itself was at fault, or 0 if the error came from the call
+func call(w http.ResponseWriter, h *holo.Holochain, zome string, function string, args string, readOnly bool) (result interface{}, code int, err error) {
+	var interfaces map[string][]holo.Interface
+	interfaces, err = h.Interfaces()
 	if err == nil {
-		i := n.Interfaces()
+		i, ok := interfaces[zome]
+		if !ok {
+			code, err = mkErr("unknown zome: "+zome, 400)
+			return
+		}
 
 		for _, f := range i {
 			if f.Name == function {
+				if readOnly && !f.ReadOnly {
+					code, err = mkErr("function "+function+" is not read-only", http.StatusMethodNotAllowed)
+					return
+				}
 				log.Logf("calling %s:%s(%s)\n", zome, function, args)
-				result, err = h.Call(zome, function, args)
+				ctx, cancel := context.WithTimeout(context.Background(), holo.DefaultCallTimeout)
+				defer cancel()
+				result, err = h.CallWithContext(ctx, zome, function, args)
 				return
 			}
 		}
-		_, err = mkErr("unknown function: "+function, 400)
+		code, err = mkErr("unknown function: "+function, 400)
 	}
 	return
 }
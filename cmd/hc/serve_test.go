@@ -0,0 +1,42 @@
+package main
+
+import (
+	holo "github.com/metacurrency/holochain"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFnHandler(t *testing.T) {
+	d, err := ioutil.TempDir("", "hc_serve_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	s, err := holo.Init(d+"/"+holo.DefaultDirectoryName, holo.AgentName("Herbert <h@bert.com>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := s.GenDev(s.Path+"/test", "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := fnHandler(h)
+
+	Convey("a GET of a function that isn't read-only should be refused with 405", t, func() {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/fn/jsZome/addOdd?args=7", nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		So(w.Body.String(), ShouldContainSubstring, "function addOdd is not read-only")
+	})
+
+	Convey("an unknown function should be refused with 400", t, func() {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/fn/jsZome/bogus", strings.NewReader("7")))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
@@ -7,13 +7,24 @@
 package holochain
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/tidwall/buntdb"
+	"io"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,15 +34,74 @@ var ErrDHTExpectedMetaReqInBody error = errors.New("expected meta request")
 var ErrDHTExpectedMetaQueryInBody error = errors.New("expected meta query")
 var ErrDHTExpectedGossipReqInBody error = errors.New("expected gossip request")
 var ErrDHTErrNoGossipersAvailable error = errors.New("no gossipers available")
+var ErrDHTExpired error = errors.New("expired")
+var ErrDHTSnapshotCorrupt error = errors.New("corrupt DHT snapshot")
+var ErrDHTSnapshotVersion error = errors.New("unsupported DHT snapshot version")
+var ErrDHTRateLimited error = errors.New("put rate limit exceeded")
+var ErrDHTClosed error = errors.New("DHT closed")
+
+// DefaultSweepInterval is the interval at which Sweep removes expired puts unless the
+// holochain's Config sets SweepInterval
+const DefaultSweepInterval = 10 * time.Second
+
+// Default per-peer limits on the puts handled by the DHT
+const (
+	DefaultMaxPutsPerSecond     = 100
+	DefaultMaxPutBytesPerSecond = 10 * 1024 * 1024
+)
+
+// Defaults for retrying gets of hashes that haven't reached the DHT node yet
+const (
+	DefaultGetMaxAttempts = 4
+	DefaultGetRetryDelay  = 250 // milliseconds, doubled after each round of a get
+)
+
+// DefaultReplicationFactor is how many nodes a put is sent to if the Config's
+// ReplicationFactor is 0
+const DefaultReplicationFactor = 3
+
+// DefaultSnapshotInterval is the suggested interval for saving DHT snapshots with Snapshots
+const DefaultSnapshotInterval = time.Minute
+
+const (
+	DHTStoreFileName    = "dht.db"
+	DHTSnapshotFileName = "dht.snapshot"
+	DHTSnapshotVersion  = 1
+)
 
 // DHT struct holds the data necessary to run the distributed hash table
+//
+// The DHT is safe for concurrent use.  All writes to the store go through db.Update, and
+// buntdb runs its write transactions one at a time, so any read-then-write logic happens
+// inside a single Update transaction.  Reads use buntdb View transactions which can
+// run concurrently with each other but not with a write.
 type DHT struct {
-	h         *Holochain // pointer to the holochain this DHT is part of
-	db        *buntdb.DB
-	puts      chan *Message
-	gossiping bool
-	glog      Logger // the gossip logger
-	dlog      Logger // the dht logger
+	h            *Holochain // pointer to the holochain this DHT is part of
+	db           *buntdb.DB
+	puts         chan *Message
+	inflight     *inflightPuts // counts the queued puts, and of each hash, that haven't been handled yet
+	done         chan struct{} // closed by Close to stop the put handling loop
+	closed       bool          // protected by flk
+	gossiping    bool          // keeps the Gossip loop running, protected by flk
+	sweeping     bool          // keeps the Sweep loop running, protected by flk
+	snapshotting bool          // keeps the Snapshots loop running, protected by flk
+	flk          sync.Mutex    // protects the flags that keep the background loops running
+	limiter      *putLimiter   // throttles puts and putmetas from each peer
+	sc           *storeCipher  // if not nil, entries, headers, meta-data and gossip are encrypted in db with it (but not keys, types, statuses or fork records)
+	slk          sync.Mutex    // protects stats
+	stats        GossipStats
+	glog         Logger // the gossip logger
+	dlog         Logger // the dht logger
+}
+
+// GossipStats holds counters accumulated by gossiping
+type GossipStats struct {
+	Exchanges     int64 // gossip requests we made that were answered
+	Failures      int64 // gossip requests we made that failed
+	Served        int64 // gossip requests from other nodes that we answered
+	BytesReceived int64 // encoded size of the gossip we received
+	BytesSent     int64 // encoded size of the gossip we sent
+	PutsLearned   int64 // puts received by gossiping
 }
 
 // Meta holds data that can be associated with a hash
@@ -54,13 +124,15 @@ const (
 	REJECTED
 	DELETED
 	UPDATED
+	EXPIRED
 )
 
 // PutReq holds the data of a put request
 type PutReq struct {
-	H Hash
-	S int
-	D interface{}
+	H   Hash
+	S   int
+	D   interface{}
+	TTL time.Duration // if not 0 the entry expires after this long, overriding the EntryDef's Expiry
 }
 
 // GetReq holds the data of a put request
@@ -75,10 +147,16 @@ type MetaReq struct {
 	T string // type of the meta-data
 }
 
+// DefaultMetaQueryLimit is the number of results returned by a meta data query that doesn't
+// set a Limit
+const DefaultMetaQueryLimit = 10000
+
 // MetaQuery holds a getMeta query
 type MetaQuery struct {
-	H Hash
-	T string
+	H      Hash
+	T      string
+	Offset int // number of results to skip
+	Limit  int // maximum number of results to return, DefaultMetaQueryLimit if 0
 	// order
 	// filter, etc
 }
@@ -92,6 +170,7 @@ type MetaEntry struct {
 // MetaQueryResp holds response to getMeta query
 type MetaQueryResp struct {
 	Entries []MetaEntry
+	More    bool // there are more results after these
 }
 
 // Put holds a put or putmeta for gossiping
@@ -122,23 +201,311 @@ func NewDHT(h *Holochain) *DHT {
 	dht := DHT{
 		h: h,
 	}
-	db, err := buntdb.Open(h.path + "/dht.db")
+	p := h.DataPath() + "/" + DHTStoreFileName
+	restore := !fileExists(p)
+	db, err := buntdb.Open(p)
+	if err != nil && fileExists(h.DataPath()+"/"+DHTSnapshotFileName) {
+		// a store that can't be opened is moved aside and rebuilt from the snapshot
+		if e := os.Rename(p, p+".corrupt"); e == nil {
+			db, err = buntdb.Open(p)
+			restore = true
+		}
+	}
 	if err != nil {
 		panic(err)
 	}
 	db.CreateIndex("meta", "meta:*", buntdb.IndexString)
 	db.CreateIndex("idx", "idx:*", buntdb.IndexInt)
 	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
+	db.CreateIndex("expires", "expires:*", buntdb.IndexInt)
 
 	dht.db = db
 	dht.puts = make(chan *Message, 10)
+	dht.inflight = newInflightPuts()
+	dht.done = make(chan struct{})
 
 	dht.glog = h.config.Loggers.Gossip
 	dht.dlog = h.config.Loggers.DHT
 
+	dht.limiter = newPutLimiter(h.config.MaxPutsPerSecond, h.config.MaxPutBytesPerSecond)
+
+	if h.storeKey != nil {
+		if dht.sc, err = newStoreCipher(h.storeKey); err != nil {
+			panic(err)
+		}
+	}
+
+	// the snapshot is only used to rebuild a missing or empty store, a store that was
+	// written to since the snapshot was taken is more up to date than it
+	if restore || dht.empty() {
+		if err = dht.loadSnapshot(); err != nil {
+			dht.dlog.Logf("snapshot error: %v", err)
+		}
+	}
+
 	return &dht
 }
 
+// empty returns true if the DHT's store holds nothing
+func (dht *DHT) empty() (empty bool) {
+	empty = true
+	dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			empty = false
+			return false
+		})
+	})
+	return
+}
+
+// shareWith returns a DHT for another holochain that uses this DHT's store and put queue.
+// Only this DHT should be closed.
+func (dht *DHT) shareWith(h *Holochain) *DHT {
+	return &DHT{
+		h:        h,
+		db:       dht.db,
+		puts:     dht.puts,
+		inflight: dht.inflight,
+		done:     dht.done,
+		limiter:  dht.limiter,
+		sc:       dht.sc,
+		glog:     dht.glog,
+		dlog:     dht.dlog,
+	}
+}
+
+// Close stops the gossip, sweep and put handling loops and closes the DHT's store.
+// It is safe to call more than once.
+func (dht *DHT) Close() (err error) {
+	dht.flk.Lock()
+	closed := dht.closed
+	dht.closed = true
+	dht.gossiping = false
+	dht.sweeping = false
+	dht.snapshotting = false
+	dht.flk.Unlock()
+	if closed {
+		return
+	}
+	close(dht.done)
+	// puts still queued won't be handled, so stop anything waiting for them
+	dht.inflight.close()
+	if err = dht.saveSnapshot(); err != nil {
+		dht.dlog.Logf("snapshot error: %v", err)
+	}
+	err = dht.db.Close()
+	return
+}
+
+// revoke records that a peer's key has been revoked so its puts are refused
+func (dht *DHT) revoke(id peer.ID) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("revoked:"+peer.IDB58Encode(id), "", nil)
+		return err
+	})
+	return
+}
+
+// isRevoked returns true if a peer's key has been revoked
+func (dht *DHT) isRevoked(id peer.ID) (revoked bool) {
+	dht.db.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get("revoked:" + peer.IDB58Encode(id))
+		revoked = err == nil
+		return nil
+	})
+	return
+}
+
+// removeDHTFiles deletes the DHT store and snapshot from a holochain's data directory
+func removeDHTFiles(path string) (err error) {
+	if err = os.RemoveAll(path + "/" + DHTStoreFileName); err != nil {
+		return
+	}
+	err = os.RemoveAll(path + "/" + DHTSnapshotFileName)
+	return
+}
+
+// snapshotItem holds a key/value pair of the DHT store in a snapshot
+type snapshotItem struct {
+	K string
+	V string
+}
+
+// Snapshot writes the contents of the DHT's put/meta store to w.  The snapshot starts
+// with a header line holding the snapshot version and a checksum of the data which follows.
+func (dht *DHT) Snapshot(w io.Writer) (err error) {
+	var items []snapshotItem
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			items = append(items, snapshotItem{K: key, V: value})
+			return true
+		})
+	})
+	if err != nil {
+		return
+	}
+	var data bytes.Buffer
+	if err = gob.NewEncoder(&data).Encode(items); err != nil {
+		return
+	}
+	sum := sha256.Sum256(data.Bytes())
+	if _, err = fmt.Fprintf(w, "holochain-dht-snapshot %d %x\n", DHTSnapshotVersion, sum); err != nil {
+		return
+	}
+	_, err = w.Write(data.Bytes())
+	return
+}
+
+// RestoreSnapshot replaces the contents of the DHT's put/meta store with a snapshot read
+// from r.  If the snapshot's checksum doesn't match its data ErrDHTSnapshotCorrupt is
+// returned and the store is left untouched, as it is if the snapshot was taken of a store
+// encrypted with a different key (ErrStoreKey) or encrypted differently to this one.
+func (dht *DHT) RestoreSnapshot(r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	var line string
+	if line, err = br.ReadString('\n'); err != nil {
+		err = ErrDHTSnapshotCorrupt
+		return
+	}
+	var version int
+	var sum []byte
+	if _, e := fmt.Sscanf(line, "holochain-dht-snapshot %d %x\n", &version, &sum); e != nil {
+		err = ErrDHTSnapshotCorrupt
+		return
+	}
+	if version != DHTSnapshotVersion {
+		err = ErrDHTSnapshotVersion
+		return
+	}
+	var data []byte
+	if data, err = ioutil.ReadAll(br); err != nil {
+		return
+	}
+	if s := sha256.Sum256(data); !bytes.Equal(s[:], sum) {
+		err = ErrDHTSnapshotCorrupt
+		return
+	}
+	var items []snapshotItem
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&items); err != nil {
+		err = ErrDHTSnapshotCorrupt
+		return
+	}
+	var sealed string
+	var found bool
+	for _, i := range items {
+		if i.K == sealedKey {
+			sealed, found = i.V, true
+		}
+	}
+	if err = dht.checkSealed(sealed, found, len(items) == 0); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		if err := tx.DeleteAll(); err != nil {
+			return err
+		}
+		for _, i := range items {
+			if _, _, err := tx.Set(i.K, i.V, nil); err != nil {
+				return err
+			}
+		}
+		if dht.sc != nil && !found {
+			// an empty snapshot still leaves the store marked as encrypted
+			v, err := dht.seal(sealedKey, []byte(sealedKey))
+			if err != nil {
+				return err
+			}
+			if _, _, err = tx.Set(sealedKey, v, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// saveSnapshot writes a snapshot to the holochain's data directory, replacing any previous one
+func (dht *DHT) saveSnapshot() (err error) {
+	p := dht.h.DataPath() + "/" + DHTSnapshotFileName
+	var f *os.File
+	if f, err = os.Create(p + ".tmp"); err != nil {
+		return
+	}
+	err = dht.Snapshot(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(p + ".tmp")
+		return
+	}
+	err = os.Rename(p+".tmp", p)
+	return
+}
+
+// loadSnapshot restores the snapshot in the holochain's data directory if there is one,
+// replacing the contents of the store.  A corrupt or unsupported snapshot is logged and
+// ignored.
+func (dht *DHT) loadSnapshot() (err error) {
+	var f *os.File
+	if f, err = os.Open(dht.h.DataPath() + "/" + DHTSnapshotFileName); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	err = dht.RestoreSnapshot(f)
+	if err == ErrDHTSnapshotCorrupt || err == ErrDHTSnapshotVersion {
+		dht.dlog.Logf("ignoring snapshot: %v", err)
+		err = nil
+	}
+	return
+}
+
+// Snapshots saves a snapshot of the DHT every interval until the DHT is closed
+func (dht *DHT) Snapshots(interval time.Duration) {
+	if !dht.start(&dht.snapshotting) {
+		return
+	}
+	for dht.flag(&dht.snapshotting) {
+		time.Sleep(interval)
+		if !dht.flag(&dht.snapshotting) {
+			break
+		}
+		err := dht.saveSnapshot()
+		if err != nil {
+			dht.dlog.Logf("snapshot error: %v", err)
+		}
+	}
+}
+
+// flag returns the value of one of the flags protected by flk
+func (dht *DHT) flag(f *bool) bool {
+	dht.flk.Lock()
+	defer dht.flk.Unlock()
+	return *f
+}
+
+// setFlag sets one of the flags protected by flk
+func (dht *DHT) setFlag(f *bool, v bool) {
+	dht.flk.Lock()
+	*f = v
+	dht.flk.Unlock()
+}
+
+// start sets the flag that keeps a background loop running, unless the DHT has been
+// closed, reporting whether it did
+func (dht *DHT) start(f *bool) bool {
+	dht.flk.Lock()
+	defer dht.flk.Unlock()
+	if dht.closed {
+		return false
+	}
+	*f = true
+	return true
+}
+
 // SetupDHT prepares a DHT for use by adding the holochain's ID
 func (dht *DHT) SetupDHT() (err error) {
 	x := ""
@@ -147,7 +514,12 @@ func (dht *DHT) SetupDHT() (err error) {
 	if err != nil {
 		return
 	}
-	// put the AgentEntry so it always exists for putmeta
+	err = dht.putAgent()
+	return
+}
+
+// putAgent puts the current AgentEntry and key to the DHT so they always exist for putmeta
+func (dht *DHT) putAgent() (err error) {
 	a := dht.h.Agenthash()
 	var e Entry
 	var t string
@@ -181,8 +553,87 @@ func (dht *DHT) SetupDHT() (err error) {
 	return
 }
 
+// sealedKey is the key of a sealed copy of itself in an encrypted DHT store, used to check
+// the store's key
+const sealedKey = "_sealed"
+
+// checkSealed checks that a store's contents are encrypted if and only if the DHT has a
+// key, and that the key is the right one.  sealed is the value of sealedKey in the
+// store if found.
+func (dht *DHT) checkSealed(sealed string, found bool, empty bool) (err error) {
+	switch {
+	case dht.sc == nil && found:
+		err = ErrStoreEncrypted
+	case dht.sc != nil && found:
+		var v []byte
+		if v, err = dht.open(sealedKey, sealed); err == nil && string(v) != sealedKey {
+			err = ErrStoreKey
+		}
+	case dht.sc != nil && !empty:
+		err = ErrStoreNotEncrypted
+	}
+	return
+}
+
+// checkStore checks the DHT's store with checkSealed, marking an empty store as encrypted
+// if the DHT has a key
+func (dht *DHT) checkStore() (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(sealedKey)
+		if err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		found := err == nil
+		empty := true
+		err = tx.Ascend("", func(key, value string) bool {
+			empty = false
+			return false
+		})
+		if err != nil {
+			return err
+		}
+		if err = dht.checkSealed(val, found, empty); err != nil {
+			return err
+		}
+		if dht.sc == nil || found {
+			return nil
+		}
+		if val, err = dht.seal(sealedKey, []byte(sealedKey)); err != nil {
+			return err
+		}
+		_, _, err = tx.Set(sealedKey, val, nil)
+		return err
+	})
+	return
+}
+
+// seal encrypts a value for storing at key if the DHT is encrypted.  The value is bound to
+// the key so that it can't be opened if moved to another one.
+func (dht *DHT) seal(key string, value []byte) (sealed string, err error) {
+	if dht.sc == nil {
+		sealed = string(value)
+		return
+	}
+	var b []byte
+	if b, err = dht.sc.seal(value, []byte(key)); err != nil {
+		return
+	}
+	sealed = string(b)
+	return
+}
+
+// open decrypts a value sealed by seal for the given key
+func (dht *DHT) open(key string, sealed string) (value []byte, err error) {
+	if dht.sc == nil {
+		value = []byte(sealed)
+		return
+	}
+	value, err = dht.sc.open([]byte(sealed), []byte(key))
+	return
+}
+
 // incIdx adds a new index record to dht for gossiping later
-func incIdx(tx *buntdb.Tx, m *Message) (err error) {
+func (dht *DHT) incIdx(tx *buntdb.Tx, m *Message) (err error) {
 	var idx int
 	idx, err = getIntVal("_idx", tx)
 	if err != nil {
@@ -203,7 +654,9 @@ func incIdx(tx *buntdb.Tx, m *Message) (err error) {
 		if err != nil {
 			return err
 		}
-		msg = string(b)
+		if msg, err = dht.seal("idx:"+idxs, b); err != nil {
+			return err
+		}
 	}
 	_, _, err = tx.Set("idx:"+idxs, msg, nil)
 	if err != nil {
@@ -242,6 +695,145 @@ func (dht *DHT) GetIdx() (idx int, err error) {
 	return
 }
 
+// Count returns the number of entries stored in the DHT
+func (dht *DHT) Count() (count int, err error) {
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("entry:*", func(key, value string) bool {
+			count++
+			return true
+		})
+	})
+	return
+}
+
+// Keys returns the hashes of all the puts stored in the local DHT store
+func (dht *DHT) Keys() (keys []Hash, err error) {
+	var ks []string
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("entry:*", func(key, value string) bool {
+			ks = append(ks, strings.TrimPrefix(key, "entry:"))
+			return true
+		})
+	})
+	if err != nil {
+		return
+	}
+	for _, k := range ks {
+		var hash Hash
+		if hash, err = NewHash(k); err != nil {
+			return
+		}
+		keys = append(keys, hash)
+	}
+	return
+}
+
+// Get returns a put's entry, type and status directly from the local DHT store
+func (dht *DHT) Get(key Hash) (entry Entry, entryType string, status int, err error) {
+	var b []byte
+	if b, entryType, status, err = dht.get(key); err != nil {
+		return
+	}
+	var e GobEntry
+	if err = e.Unmarshal(b); err != nil {
+		return
+	}
+	entry = &e
+	return
+}
+
+// header returns the recorded header of a put, or an empty header with just the Type
+// set if none was recorded
+func (dht *DHT) header(tx *buntdb.Tx, k string) (hd Header, err error) {
+	var val string
+	val, err = tx.Get("header:" + k)
+	if err == buntdb.ErrNotFound {
+		hd.Type, err = tx.Get("type:" + k)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	var b []byte
+	if b, err = dht.open("header:"+k, val); err != nil {
+		return
+	}
+	err = hd.Unmarshal(b, 34)
+	return
+}
+
+// Prune removes the puts (and their meta-data) from the local DHT store for which
+// predicate returns true, returning how many were removed.  Puts that were stored without
+// a header are passed a header with only its Type set.
+func (dht *DHT) Prune(predicate func(Hash, Header) bool) (pruned int, err error) {
+	type put struct {
+		k  string
+		hd Header
+	}
+	var puts []put
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var ks []string
+		err := tx.AscendKeys("entry:*", func(key, value string) bool {
+			ks = append(ks, strings.TrimPrefix(key, "entry:"))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range ks {
+			hd, err := dht.header(tx, k)
+			if err != nil {
+				return err
+			}
+			puts = append(puts, put{k: k, hd: hd})
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	var remove []string
+	for _, p := range puts {
+		var hash Hash
+		if hash, err = NewHash(p.k); err != nil {
+			return
+		}
+		if predicate(hash, p.hd) {
+			remove = append(remove, p.k)
+		}
+	}
+	if len(remove) == 0 {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		for _, k := range remove {
+			keys := []string{"entry:" + k, "type:" + k, "src:" + k, "status:" + k, "expires:" + k, "header:" + k, "replacedBy:" + k}
+			err := tx.AscendKeys("meta:"+k+":*", func(key, value string) bool {
+				keys = append(keys, key)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			for _, x := range keys {
+				_, err = tx.Delete(x)
+				if err != nil && err != buntdb.ErrNotFound {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		pruned = len(remove)
+		dht.dlog.Logf("pruned %d puts", pruned)
+	}
+	return
+}
+
 // GetPuts returns a list of puts after the given index
 func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
 	puts = make([]Put, 0)
@@ -252,7 +844,11 @@ func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
 			if idx >= since {
 				var p Put
 				if value != "" {
-					err := ByteDecoder([]byte(value), &p.M)
+					b, err := dht.open(key, value)
+					if err != nil {
+						return false
+					}
+					err = ByteDecoder(b, &p.M)
 					if err != nil {
 						return false
 					}
@@ -332,31 +928,409 @@ func (dht *DHT) put(m *Message, entryType string, key Hash, src peer.ID, value [
 	k := key.String()
 	dht.dlog.Logf("put %v=>%s", key, string(value))
 	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		err := incIdx(tx, m)
+		err := dht.incIdx(tx, m)
 		if err != nil {
 			return err
 		}
-		_, _, err = tx.Set("entry:"+k, string(value), nil)
+		sealed, err := dht.seal("entry:"+k, value)
 		if err != nil {
 			return err
 		}
-		_, _, err = tx.Set("type:"+k, entryType, nil)
+		_, _, err = tx.Set("entry:"+k, sealed, nil)
 		if err != nil {
 			return err
 		}
-		_, _, err = tx.Set("src:"+k, peer.IDB58Encode(src), nil)
+		_, _, err = tx.Set("type:"+k, entryType, nil)
 		if err != nil {
 			return err
 		}
-		_, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", status), nil)
+		_, _, err = tx.Set("src:"+k, peer.IDB58Encode(src), nil)
 		if err != nil {
 			return err
 		}
+		// putting an entry again mustn't undo a later change to it
+		changed := false
+		if status == LIVE {
+			val, e := tx.Get("status:" + k)
+			changed = e == nil && (val == fmt.Sprintf("%d", UPDATED) || val == fmt.Sprintf("%d", DELETED))
+		}
+		if !changed {
+			if _, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", status), nil); err != nil {
+				return err
+			}
+		}
+		return dht.applyPending(tx, k, peer.IDB58Encode(src))
+	})
+	return
+}
+
+// applyChange records that the entry changed by a put's header has been modified (by the
+// put's entry) or deleted.  Only the source of the changed entry may change it.  If the
+// changed entry hasn't been put yet the change is kept pending, and applied when it is.
+func (dht *DHT) applyChange(from peer.ID, key Hash, change StatusChange) (err error) {
+	k := change.Hash.String()
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		src, err := tx.Get("src:" + k)
+		if err == buntdb.ErrNotFound {
+			dht.dlog.Logf("%v not put yet, %s by %v pending", change.Hash, change.Action, key)
+			_, _, err = tx.Set("pending:"+k+":"+key.String(), change.Action+" "+peer.IDB58Encode(from), nil)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if src != peer.IDB58Encode(from) {
+			return fmt.Errorf("%v can only be changed by its source", change.Hash)
+		}
+		return setChanged(tx, k, change.Action, key.String())
+	})
+	if err == nil {
+		dht.dlog.Logf("%s of %v by %v", change.Action, change.Hash, key)
+	}
+	return
+}
+
+// applyPending applies the changes to the entry k that arrived before it was put, dropping
+// those that didn't come from its source src
+func (dht *DHT) applyPending(tx *buntdb.Tx, k string, src string) (err error) {
+	prefix := "pending:" + k + ":"
+	pending := make(map[string]string)
+	if err = tx.AscendKeys(prefix+"*", func(key, value string) bool {
+		pending[key] = value
+		return true
+	}); err != nil {
+		return
+	}
+	for key, value := range pending {
+		if _, err = tx.Delete(key); err != nil {
+			return
+		}
+		x := strings.SplitN(value, " ", 2)
+		if len(x) != 2 || x[1] != src {
+			dht.dlog.Logf("dropping change of %s not made by its source", k)
+			continue
+		}
+		if err = setChanged(tx, k, x[0], strings.TrimPrefix(key, prefix)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// setChanged sets the status of the entry k for a change by the entry by
+func setChanged(tx *buntdb.Tx, k string, action string, by string) (err error) {
+	status := DELETED
+	if action == ModAction {
+		status = UPDATED
+		if _, _, err = tx.Set("replacedBy:"+k, by, nil); err != nil {
+			return
+		}
+	}
+	_, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", status), nil)
+	return
+}
+
+// getLatest retrieves a value from the DHT store like get, except that if the value has been
+// modified the latest version of it is returned, and if it (or its latest version) has been
+// deleted ErrEntryDeleted is returned
+func (dht *DHT) getLatest(key Hash) (data []byte, entryType string, err error) {
+	seen := make(map[string]bool)
+	for {
+		var status int
+		if data, entryType, status, err = dht.get(key); err != nil {
+			return
+		}
+		switch status {
+		case DELETED:
+			err = ErrEntryDeleted
+			return
+		case UPDATED:
+			k := key.String()
+			if seen[k] {
+				err = fmt.Errorf("modifications of %v loop", key)
+				return
+			}
+			seen[k] = true
+			err = dht.db.View(func(tx *buntdb.Tx) error {
+				val, err := tx.Get("replacedBy:" + k)
+				if err == nil {
+					key, err = NewHash(val)
+				}
+				return err
+			})
+			if err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// setExpiry records the time after which a put should be dropped
+func (dht *DHT) setExpiry(key Hash, expires time.Time) (err error) {
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("expires:"+key.String(), fmt.Sprintf("%d", expires.UnixNano()), nil)
 		return err
 	})
 	return
 }
 
+// setHeader records the header of a put's entry so that it can be inspected later
+func (dht *DHT) setHeader(key Hash, hd *Header) (err error) {
+	var b []byte
+	if b, err = hd.Marshal(); err != nil {
+		return
+	}
+	k := "header:" + key.String()
+	var sealed string
+	if sealed, err = dht.seal(k, b); err != nil {
+		return
+	}
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(k, sealed, nil)
+		return err
+	})
+	return
+}
+
+// ForkWarrant is the evidence a DHT node keeps that an agent's chain has forked: two
+// different headers published by the agent that both follow the same header
+type ForkWarrant struct {
+	Agent   peer.ID
+	Prev    Hash      // the header both headers follow
+	Headers [2]Hash   // the header seen first, and the one that conflicts with it
+	Time    time.Time // when the fork was detected
+}
+
+// checkFork records a header published by an agent as the one that follows its previous
+// header.  If the agent already published a different header following the same header
+// its chain has forked, so a ForkWarrant is recorded against the agent (unless one already
+// was for that header) and a ForkError is returned.
+func (dht *DHT) checkFork(agent peer.ID, hd *Header) (err error) {
+	var hash Hash
+	if hash, _, err = hd.Sum(dht.h.hashSpec); err != nil {
+		return
+	}
+	k := forkKey(agent, hd.HeaderLink)
+	var warrant *ForkWarrant
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("next:" + k)
+		if err == buntdb.ErrNotFound {
+			_, _, err = tx.Set("next:"+k, hash.String(), nil)
+			return err
+		}
+		if err != nil || val == hash.String() {
+			return err
+		}
+		first, err := NewHash(val)
+		if err != nil {
+			return err
+		}
+		warrant = &ForkWarrant{Agent: agent, Prev: hd.HeaderLink, Headers: [2]Hash{first, hash}, Time: dht.h.Now()}
+		if _, err = tx.Get("warrant:" + k); err != buntdb.ErrNotFound {
+			return err
+		}
+		_, _, err = tx.Set("warrant:"+k, fmt.Sprintf("%v %v %d", first, hash, warrant.Time.UnixNano()), nil)
+		return err
+	})
+	if err == nil && warrant != nil {
+		err = &ForkError{Warrant: *warrant}
+		dht.dlog.Logf("%v", err)
+	}
+	return
+}
+
+// forkKey returns the part of the keys of the next header and fork warrant records that
+// identifies an agent's header
+func forkKey(agent peer.ID, prev Hash) string {
+	return peer.IDB58Encode(agent) + ":" + prev.String()
+}
+
+// parseWarrant rebuilds a ForkWarrant from its record in the store
+func parseWarrant(key string, val string) (w ForkWarrant, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, "warrant:"), ":", 2)
+	if len(parts) != 2 {
+		err = fmt.Errorf("bad warrant key: %s", key)
+		return
+	}
+	if w.Agent, err = peer.IDB58Decode(parts[0]); err != nil {
+		return
+	}
+	if w.Prev, err = NewHash(parts[1]); err != nil {
+		return
+	}
+	var h1, h2 string
+	var t int64
+	if _, err = fmt.Sscanf(val, "%s %s %d", &h1, &h2, &t); err != nil {
+		return
+	}
+	if w.Headers[0], err = NewHash(h1); err != nil {
+		return
+	}
+	if w.Headers[1], err = NewHash(h2); err != nil {
+		return
+	}
+	w.Time = time.Unix(0, t)
+	return
+}
+
+// Warrants returns the fork warrants the local DHT store holds against any agent
+func (dht *DHT) Warrants() (warrants []ForkWarrant, err error) {
+	var keys, vals []string
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("warrant:*", func(key, value string) bool {
+			keys = append(keys, key)
+			vals = append(vals, value)
+			return true
+		})
+	})
+	if err != nil {
+		return
+	}
+	for i := range keys {
+		var w ForkWarrant
+		if w, err = parseWarrant(keys[i], vals[i]); err != nil {
+			return
+		}
+		warrants = append(warrants, w)
+	}
+	return
+}
+
+// forkAt returns a ForkError if the local DHT store holds evidence that an agent's chain
+// forked after the header prev, where hash is the header following prev in the chain
+// being checked: either a warrant, or a different header recorded as following prev
+func (dht *DHT) forkAt(agent peer.ID, prev Hash, hash Hash) (err error) {
+	k := forkKey(agent, prev)
+	var warrant, next string
+	err = dht.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		if warrant, err = tx.Get("warrant:" + k); err != nil && err != buntdb.ErrNotFound {
+			return err
+		}
+		if next, err = tx.Get("next:" + k); err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if warrant != "" {
+		var w ForkWarrant
+		if w, err = parseWarrant("warrant:"+k, warrant); err == nil {
+			err = &ForkError{Warrant: w}
+		}
+		return
+	}
+	if next != "" && next != hash.String() {
+		var first Hash
+		if first, err = NewHash(next); err == nil {
+			err = &ForkError{Warrant: ForkWarrant{Agent: agent, Prev: prev, Headers: [2]Hash{first, hash}, Time: dht.h.Now()}}
+		}
+	}
+	return
+}
+
+// entryTTL returns how long entries of the given type should live in the DHT, 0 meaning forever
+func (dht *DHT) entryTTL(entryType string, t PutReq) (ttl time.Duration) {
+	ttl = t.TTL
+	if ttl == 0 {
+		_, d, err := dht.h.GetEntryDef(entryType)
+		if err == nil && d.Expiry > 0 {
+			ttl = time.Duration(d.Expiry) * time.Second
+		}
+	}
+	return
+}
+
+// isExpired checks the expiry status of a key in a transaction
+func isExpired(tx *buntdb.Tx, k string, now time.Time) (expired bool, err error) {
+	var val string
+	val, err = tx.Get("status:" + k)
+	if err == nil && val == fmt.Sprintf("%d", EXPIRED) {
+		expired = true
+		return
+	}
+	val, err = tx.Get("expires:" + k)
+	if err == buntdb.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	var expires int64
+	expires, err = strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return
+	}
+	expired = expires <= now.UnixNano()
+	return
+}
+
+// sweep removes the data and meta-data of any puts which have expired, leaving their
+// status as EXPIRED
+func (dht *DHT) sweep() (err error) {
+	// like isExpired a put expires at its expiry time, so sweep below the next nanosecond
+	limit := fmt.Sprintf("%d", dht.h.Now().UnixNano()+1)
+	keys := make([]string, 0)
+	err = dht.db.Update(func(tx *buntdb.Tx) error {
+		err := tx.AscendLessThan("expires", limit, func(key, value string) bool {
+			keys = append(keys, strings.TrimPrefix(key, "expires:"))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			metaKeys := make([]string, 0)
+			err := tx.AscendKeys("meta:"+k+":*", func(key, value string) bool {
+				metaKeys = append(metaKeys, key)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			for _, x := range append(metaKeys, "entry:"+k, "type:"+k, "src:"+k, "expires:"+k, "header:"+k, "replacedBy:"+k) {
+				_, err = tx.Delete(x)
+				if err != nil && err != buntdb.ErrNotFound {
+					return err
+				}
+			}
+			_, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", EXPIRED), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && len(keys) > 0 {
+		dht.dlog.Logf("swept %d expired puts", len(keys))
+	}
+	return
+}
+
+// Sweep removes expired puts every SweepInterval of the holochain's Config until the DHT
+// is closed
+func (dht *DHT) Sweep() {
+	if !dht.start(&dht.sweeping) {
+		return
+	}
+	interval := DefaultSweepInterval
+	if dht.h.config.SweepInterval > 0 {
+		interval = time.Duration(dht.h.config.SweepInterval) * time.Second
+	}
+	for dht.flag(&dht.sweeping) {
+		err := dht.sweep()
+		if err != nil {
+			dht.dlog.Logf("sweep error: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
 // exists checks for the existence of the hash in the store
 func (dht *DHT) exists(key Hash) (err error) {
 	err = dht.db.View(func(tx *buntdb.Tx) error {
@@ -385,9 +1359,17 @@ func (dht *DHT) source(key Hash) (id peer.ID, err error) {
 }
 
 // get retrieves a value from the DHT store
+// if the value has expired ErrDHTExpired is returned
 func (dht *DHT) get(key Hash) (data []byte, entryType string, status int, err error) {
 	err = dht.db.View(func(tx *buntdb.Tx) error {
 		k := key.String()
+		expired, err := isExpired(tx, k, dht.h.Now())
+		if err != nil {
+			return err
+		}
+		if expired {
+			return ErrDHTExpired
+		}
 		val, err := tx.Get("entry:" + k)
 		if err != nil {
 			if err == buntdb.ErrNotFound {
@@ -399,8 +1381,10 @@ func (dht *DHT) get(key Hash) (data []byte, entryType string, status int, err er
 		if err != nil {
 			return err
 		}
+		if data, err = dht.open("entry:"+k, val); err != nil {
+			return err
+		}
 		if err == nil {
-			data = []byte(val)
 			val, err = tx.Get("status:" + k)
 			status, err = strconv.Atoi(val)
 		}
@@ -412,6 +1396,7 @@ func (dht *DHT) get(key Hash) (data []byte, entryType string, status int, err er
 // putMeta associates a value with a stored hash
 // N.B. this function assumes that the data associated has been properly retrieved
 // and validated from the cource chain
+// Putting the same key, metaKey and metaTag more than once is a no-op
 func (dht *DHT) putMeta(m *Message, key Hash, metaKey Hash, metaTag string, entry Entry) (err error) {
 	dht.dlog.Logf("putmeta on %v %v=>%v as %s", key, metaKey, entry, metaTag)
 	k := key.String()
@@ -428,12 +1413,24 @@ func (dht *DHT) putMeta(m *Message, key Hash, metaKey Hash, metaTag string, entr
 		}
 
 		x := "meta:" + k + ":" + mk + ":" + metaTag
-		_, _, err = tx.Set(x, string(b), nil)
+		_, err = tx.Get(x)
+		if err == nil {
+			dht.dlog.Logf("putmeta: %s already exists", x)
+			return nil
+		}
+		if err != buntdb.ErrNotFound {
+			return err
+		}
+		sealed, err := dht.seal(x, b)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(x, sealed, nil)
 		if err != nil {
 			return err
 		}
 
-		err = incIdx(tx, m)
+		err = dht.incIdx(tx, m)
 		if err != nil {
 			return err
 		}
@@ -452,8 +1449,20 @@ func filter(ss []Meta, test func(*Meta) bool) (ret []Meta) {
 	return
 }
 
-// getMeta retrieves values associated with hashes
+// getMeta retrieves values associated with hashes, up to DefaultMetaQueryLimit of them
+// results are ordered by meta hash so that they don't depend on the order of storage
 func (dht *DHT) getMeta(key Hash, metaTag string) (results []MetaEntry, err error) {
+	results, _, err = dht.getMetaPage(key, metaTag, 0, DefaultMetaQueryLimit)
+	return
+}
+
+// getMetaPage retrieves a page of the values associated with a hash, skipping the first
+// offset of them and returning at most limit (DefaultMetaQueryLimit if 0).  Only the
+// returned page is held in memory, and more reports whether there are values after it.
+func (dht *DHT) getMetaPage(key Hash, metaTag string, offset int, limit int) (results []MetaEntry, more bool, err error) {
+	if limit <= 0 {
+		limit = DefaultMetaQueryLimit
+	}
 	k := key.String()
 	err = dht.db.View(func(tx *buntdb.Tx) error {
 		_, err := tx.Get("entry:" + k)
@@ -461,68 +1470,394 @@ func (dht *DHT) getMeta(key Hash, metaTag string) (results []MetaEntry, err erro
 			return ErrHashNotFound
 		}
 		results = make([]MetaEntry, 0)
-		err = tx.Ascend("meta", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			if string(x[1]) == k && string(x[3]) == metaTag {
-				var entry GobEntry
-				err := entry.Unmarshal([]byte(value))
-				if err != nil {
-					return false
-				}
-				metaEntry := MetaEntry{E: &entry, H: x[2]}
-				results = append(results, metaEntry)
+		skipped := 0
+		// keys are meta:<hash>:<meta hash>:<tag> so they ascend in meta hash order
+		e := tx.AscendKeys("meta:"+k+":*", func(key, value string) bool {
+			x := strings.SplitN(key, ":", 4)
+			if len(x) != 4 || x[1] != k || x[3] != metaTag {
+				return true
+			}
+			if skipped < offset {
+				skipped++
+				return true
+			}
+			if len(results) == limit {
+				more = true
+				return false
 			}
+			var b []byte
+			if b, err = dht.open(key, value); err != nil {
+				return false
+			}
+			var entry GobEntry
+			if err = entry.Unmarshal(b); err != nil {
+				return false
+			}
+			results = append(results, MetaEntry{E: &entry, H: x[2]})
 			return true
 		})
-		if len(results) == 0 {
-			err = fmt.Errorf("No values for %s", metaTag)
+		if e != nil {
+			return e
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 && offset == 0 {
+			return fmt.Errorf("No values for %s", metaTag)
+		}
+		return nil
 	})
+	if err != nil {
+		results = nil
+		more = false
+	}
 	return
 }
 
+// MetaCursor pages through the meta data on a hash with SendGetMeta so that only one page
+// of results is held in memory at a time
+type MetaCursor struct {
+	dht   *DHT
+	query MetaQuery
+	done  bool
+}
+
+// NewMetaCursor returns a cursor over the meta data with the given tag on key that fetches
+// pageSize results at a time (DefaultMetaQueryLimit if 0)
+func (dht *DHT) NewMetaCursor(key Hash, metaTag string, pageSize int) *MetaCursor {
+	return &MetaCursor{dht: dht, query: MetaQuery{H: key, T: metaTag, Limit: pageSize}}
+}
+
+// Next returns the next page of results, or no results once they have all been returned
+func (c *MetaCursor) Next() (entries []MetaEntry, err error) {
+	if c.done {
+		return
+	}
+	var r interface{}
+	if r, err = c.dht.SendGetMeta(c.query); err != nil {
+		return
+	}
+	resp, ok := r.(MetaQueryResp)
+	if !ok {
+		err = fmt.Errorf("unexpected response type from SendGetMeta: %v", r)
+		return
+	}
+	entries = resp.Entries
+	c.query.Offset += len(entries)
+	c.done = !resp.More || len(entries) == 0
+	return
+}
+
+// PutStatus reports which nodes a put was sent to and how many of them acknowledged it
+type PutStatus struct {
+	Result string   // "ok" if the put was sent
+	Peers  []string // B58 encoded IDs of the nodes responsible for the hash
+	Acks   int      // how many of the Peers acknowledged the put
+}
+
 // SendPut initiates publishing a particular Hash to the DHT.
 // This command only sends the hash, because the expectation is that DHT nodes will start to
 // communicate back to Source node (the node that makes this call) to get the data for validation
 func (dht *DHT) SendPut(key Hash) (err error) {
-	n, err := dht.FindNodeForHash(key)
+	_, err = dht.SendPutStatus(key)
+	return
+}
+
+// SendPutStatus does what SendPut does, returning the status of the put.  An acknowledgement
+// means a node has queued the put for validation, not that it has stored it.
+func (dht *DHT) SendPutStatus(key Hash) (status PutStatus, err error) {
+	if err = dht.checkShared(key); err != nil {
+		return
+	}
+	if dht.h.LocalOnly() {
+		// the entry is already on the local chain which is all there is to put it to
+		status = PutStatus{Result: "ok", Peers: []string{peer.IDB58Encode(dht.h.id)}, Acks: 1}
+		return
+	}
+	nodes, err := dht.FindNodesForHash(key, dht.replicationFactor())
 	if err != nil {
 		return
 	}
-	_, err = dht.send(n.HashAddr, PUT_REQUEST, PutReq{H: key})
+	var sendErr error
+	for _, id := range nodes {
+		status.Peers = append(status.Peers, peer.IDB58Encode(id))
+		if _, e := dht.send(id, PUT_REQUEST, PutReq{H: key}); e != nil {
+			dht.dlog.Logf("put of %v to %v failed: %v", key, id, e)
+			sendErr = e
+			continue
+		}
+		status.Acks++
+	}
+	if status.Acks == 0 {
+		err = sendErr
+		return
+	}
+	status.Result = "ok"
 	return
 }
 
-// SendGet initiates retrieving a value from the DHT
+// replicationFactor returns how many nodes a put is sent to
+func (dht *DHT) replicationFactor() int {
+	if dht.h.config.ReplicationFactor > 0 {
+		return dht.h.config.ReplicationFactor
+	}
+	return DefaultReplicationFactor
+}
+
+// checkShared returns ErrEntryPrivate if key is the hash of an entry on the local chain
+// whose type is private
+func (dht *DHT) checkShared(key Hash) (err error) {
+	_, entryType, e := dht.h.chain.GetEntry(key)
+	if e == nil && dht.h.isPrivate(entryType) {
+		err = ErrEntryPrivate
+	}
+	return
+}
+
+// SendGet initiates retrieving a value from the DHT.  The nodes responsible for the hash are
+// asked in turn, nearest first, until one has it: a node that can't be reached or doesn't
+// hold the hash falls back to the next.  Because a newly authored entry may not have reached
+// another node yet, if none of the nodes had the hash they're all asked again after a delay
+// (which doubles each round) up to the config's GetMaxAttempts rounds.  This node's miss
+// only calls for another round while a put of the hash is queued on it but not yet handled,
+// and then the delay ends early once the put has been handled.
 func (dht *DHT) SendGet(key Hash) (response interface{}, err error) {
-	n, err := dht.FindNodeForHash(key)
+	if dht.h.LocalOnly() {
+		response, err = dht.getLocal(key)
+		return
+	}
+	nodes, err := dht.FindNodesForHash(key, dht.replicationFactor())
 	if err != nil {
 		return
 	}
-	response, err = dht.send(n.HashAddr, GET_REQUEST, GetReq{H: key})
+	response, err = dht.getFrom(nodes, key)
 	return
 }
 
+// fallsBack reports whether a request that failed with err should be sent to the next node
+// responsible for its hash, which it should if the node didn't hold the hash or if it
+// couldn't be reached (i.e. the error isn't one of the node's answers)
+func fallsBack(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrHashNotFound) {
+		return true
+	}
+	var r *ResponseError
+	if errors.As(err, &r) {
+		return false
+	}
+	for _, e := range responseErrors {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
+
+// getFrom asks nodes for key in rounds as SendGet describes
+func (dht *DHT) getFrom(nodes []peer.ID, key Hash) (response interface{}, err error) {
+	attempts := dht.h.config.GetMaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultGetMaxAttempts
+	}
+	delay := time.Duration(dht.h.config.GetRetryDelay) * time.Millisecond
+	if delay <= 0 {
+		delay = DefaultGetRetryDelay * time.Millisecond
+	}
+	k := key.String()
+	for round := 1; ; round++ {
+		var retry, queued bool
+		for _, id := range nodes {
+			if response, err = dht.send(id, GET_REQUEST, GetReq{H: key}); !fallsBack(err) {
+				return
+			}
+			dht.dlog.Logf("get of %v from %v failed: %v", key, id, err)
+			if !errors.Is(err, ErrHashNotFound) {
+				continue
+			}
+			if !dht.isLocal(id) {
+				retry = true
+			} else if dht.inflight.busy(k) {
+				retry, queued = true, true
+			}
+		}
+		if !retry || round >= attempts {
+			return
+		}
+		dht.dlog.Logf("get of %v not found, retrying within %v", key, delay)
+		if queued {
+			dht.inflight.wait(k, delay)
+		} else {
+			time.Sleep(delay)
+		}
+		delay *= 2
+	}
+}
+
+// isLocal returns true if id is the node holding this DHT, i.e. this node or, for the
+// agents of a multi-agent test, the node whose DHT they share
+func (dht *DHT) isLocal(id peer.ID) bool {
+	if dht.h.sim != nil {
+		return id == dht.h.sim.dhtNode
+	}
+	return id == dht.h.id
+}
+
 // SendPutMeta initiates associating Meta data with particular Hash on the DHT.
 // This command assumes that the data has been committed to your local chain, and the hash of that
-// data is what get's sent in the MetaReq
+// data is what get's sent in the MetaReq.  Like a put it's sent to the nodes responsible for
+// the base hash, and succeeds if any of them acknowledge it.
 func (dht *DHT) SendPutMeta(req MetaReq) (err error) {
-	n, err := dht.FindNodeForHash(req.O)
+	if err = dht.checkShared(req.M); err != nil {
+		return
+	}
+	if dht.h.LocalOnly() {
+		return
+	}
+	nodes, err := dht.FindNodesForHash(req.O, dht.replicationFactor())
 	if err != nil {
 		return
 	}
-	_, err = dht.send(n.HashAddr, PUTMETA_REQUEST, req)
+	acked := false
+	for _, id := range nodes {
+		if _, e := dht.send(id, PUTMETA_REQUEST, req); e != nil {
+			dht.dlog.Logf("putmeta on %v to %v failed: %v", req.O, id, e)
+			err = e
+			continue
+		}
+		acked = true
+	}
+	if acked {
+		err = nil
+	}
 	return
 }
 
-// SendGetMeta initiates retrieving meta data from the DHT
+// SendGetMeta initiates retrieving meta data from the DHT, falling back to the next node
+// responsible for the hash as SendGet does
 func (dht *DHT) SendGetMeta(query MetaQuery) (response interface{}, err error) {
-	n, err := dht.FindNodeForHash(query.H)
+	if dht.h.LocalOnly() {
+		response, err = dht.getMetaLocal(query)
+		return
+	}
+	nodes, err := dht.FindNodesForHash(query.H, dht.replicationFactor())
+	if err != nil {
+		return
+	}
+	for _, id := range nodes {
+		if response, err = dht.send(id, GETMETA_REQUEST, query); !fallsBack(err) {
+			return
+		}
+		dht.dlog.Logf("getmeta of %v from %v failed: %v", query.H, id, err)
+	}
+	return
+}
+
+// getLocal answers a get from the local chain for a LocalOnly holochain, returning the
+// same response as a GET_REQUEST: the latest version of a modified entry, or ErrEntryDeleted
+// if it (or its latest version) has been deleted
+func (dht *DHT) getLocal(key Hash) (response interface{}, err error) {
+	deleted := make(map[string]bool)
+	replacedBy := make(map[string]Hash)
+	// the chain is walked from the top so the first modification seen is the latest
+	err = dht.h.chain.Walk(func(_ *Hash, header *Header, _ Entry) error {
+		k := header.Change.Hash.String()
+		switch header.Change.Action {
+		case DelAction:
+			deleted[k] = true
+		case ModAction:
+			if _, ok := replacedBy[k]; !ok {
+				replacedBy[k] = header.EntryLink
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for {
+		k := key.String()
+		if deleted[k] {
+			err = ErrEntryDeleted
+			return
+		}
+		next, ok := replacedBy[k]
+		if !ok {
+			break
+		}
+		if seen[k] {
+			err = fmt.Errorf("modifications of %v loop", key)
+			return
+		}
+		seen[k] = true
+		key = next
+	}
+	var e Entry
+	if e, _, err = dht.h.chain.GetEntry(key); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = e.Marshal(); err != nil {
+		return
+	}
+	var g GobEntry
+	if err = g.Unmarshal(b); err != nil {
+		return
+	}
+	response = &g
+	return
+}
+
+// getMetaLocal answers a meta data query from the links committed to the local chain for
+// a LocalOnly holochain, returning the same response as a GETMETA_REQUEST.  Meta data put
+// with putmeta isn't recorded on the chain so it can't be found this way.
+func (dht *DHT) getMetaLocal(query MetaQuery) (response interface{}, err error) {
+	var r MetaQueryResp
+	base := query.H.String()
+	err = dht.h.chain.Walk(func(key *Hash, header *Header, entry Entry) error {
+		if header.Type != LinkEntryType {
+			return nil
+		}
+		l, ok := entry.Content().(LinkEntry)
+		if !ok || l.Base != base || l.Tag != query.T {
+			return nil
+		}
+		e := entry
+		link, err := NewHash(l.Link)
+		if err != nil {
+			return err
+		}
+		if le, _, err := dht.h.chain.GetEntry(link); err == nil {
+			e = le
+		}
+		r.Entries = append(r.Entries, MetaEntry{E: e, H: l.Link})
+		return nil
+	})
 	if err != nil {
 		return
 	}
-	response, err = dht.send(n.HashAddr, GETMETA_REQUEST, query)
+	if len(r.Entries) == 0 {
+		err = fmt.Errorf("No values for %s", query.T)
+		return
+	}
+	sort.Slice(r.Entries, func(i, j int) bool { return r.Entries[i].H < r.Entries[j].H })
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultMetaQueryLimit
+	}
+	if query.Offset >= len(r.Entries) {
+		r.Entries = r.Entries[:0]
+	} else {
+		r.Entries = r.Entries[query.Offset:]
+	}
+	if len(r.Entries) > limit {
+		r.Entries = r.Entries[:limit]
+		r.More = true
+	}
+	response = r
 	return
 }
 
@@ -533,17 +1868,220 @@ func (dht *DHT) send(to peer.ID, t MsgType, body interface{}) (response interfac
 
 // FindNodeForHash gets the nearest node to the neighborhood of the hash
 func (dht *DHT) FindNodeForHash(key Hash) (n *Node, err error) {
+	var nodes []peer.ID
+	if nodes, err = dht.FindNodesForHash(key, 1); err != nil {
+		return
+	}
+	n = &Node{HashAddr: nodes[0]}
+	return
+}
 
-	// for now, the node it returns is self!
-	pid, err := peer.IDFromPrivateKey(dht.h.Agent().PrivKey())
-	if err != nil {
+// FindNodesForHash returns up to count of the nodes responsible for the neighborhood of the
+// hash, nearest first.  For now the known nodes are this one and the peers it is connected
+// to, and they are ordered by the XOR distance of their ids from the hash.
+func (dht *DHT) FindNodesForHash(key Hash, count int) (nodes []peer.ID, err error) {
+	// the agents of a multi-agent test share the DHT of the first agent
+	if dht.h.sim != nil {
+		nodes = []peer.ID{dht.h.sim.dhtNode}
 		return
 	}
-	var node Node
-	node.HashAddr = pid
 
-	n = &node
+	var self peer.ID
+	if self, err = peer.IDFromPrivateKey(dht.h.Agent().PrivKey()); err != nil {
+		return
+	}
+	nodes = []peer.ID{self}
+	if dht.h.node != nil {
+		for _, id := range dht.h.node.Peers() {
+			if id != self {
+				nodes = append(nodes, id)
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(xorDistance(key.H, []byte(nodes[i])), xorDistance(key.H, []byte(nodes[j]))) < 0
+	})
+	if count > 0 && len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return
+}
+
+// xorDistance returns the XOR of the sha256 digests of a and b, so that ids and hashes of
+// any length can be compared by their distance from each other
+func xorDistance(a []byte, b []byte) []byte {
+	da := sha256.Sum256(a)
+	db := sha256.Sum256(b)
+	d := make([]byte, len(da))
+	for i := range da {
+		d[i] = da[i] ^ db[i]
+	}
+	return d
+}
+
+// inflightPuts counts the queued puts that haven't been handled yet, so that waitPuts can
+// wait for them all, and those of each hash, so that a get of a hash that misses because its
+// put is still queued can wait for the put.  Once it's closed nothing waits.
+type inflightPuts struct {
+	lk     sync.Mutex
+	cond   *sync.Cond
+	queued int
+	counts map[string]int
+	closed bool
+}
+
+func newInflightPuts() *inflightPuts {
+	p := &inflightPuts{counts: make(map[string]int)}
+	p.cond = sync.NewCond(&p.lk)
+	return p
+}
+
+// add counts a queued put, of hash k if it's not ""
+func (p *inflightPuts) add(k string) {
+	p.lk.Lock()
+	p.queued++
+	if k != "" {
+		p.counts[k]++
+	}
+	p.lk.Unlock()
+}
+
+// done counts a queued put, of hash k if it's not "", as handled
+func (p *inflightPuts) done(k string) {
+	p.lk.Lock()
+	p.queued--
+	if k != "" {
+		if p.counts[k]--; p.counts[k] <= 0 {
+			delete(p.counts, k)
+		}
+	}
+	p.cond.Broadcast()
+	p.lk.Unlock()
+}
+
+// close stops waiting for the puts that are still queued
+func (p *inflightPuts) close() {
+	p.lk.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.lk.Unlock()
+}
+
+// waitAll waits until there are no unhandled puts
+func (p *inflightPuts) waitAll() {
+	p.lk.Lock()
+	for p.queued > 0 && !p.closed {
+		p.cond.Wait()
+	}
+	p.lk.Unlock()
+}
+
+// busy returns true if a put of k is queued but hasn't been handled yet
+func (p *inflightPuts) busy(k string) bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.counts[k] > 0
+}
+
+// wait waits until there are no unhandled puts of k or until d has passed
+func (p *inflightPuts) wait(k string, d time.Duration) {
+	expired := false
+	t := time.AfterFunc(d, func() {
+		p.lk.Lock()
+		expired = true
+		p.cond.Broadcast()
+		p.lk.Unlock()
+	})
+	defer t.Stop()
+	p.lk.Lock()
+	for p.counts[k] > 0 && !expired && !p.closed {
+		p.cond.Wait()
+	}
+	p.lk.Unlock()
+}
+
+// putLimiter is a per-peer token bucket limiting the rate of puts and of put bytes.
+// Each peer may burst up to one second's worth of either.
+type putLimiter struct {
+	lk    sync.Mutex
+	puts  float64   // allowed puts per second
+	bytes float64   // allowed bytes per second
+	swept time.Time // when idle buckets were last removed
+	peers map[peer.ID]*putBucket
+}
+
+type putBucket struct {
+	puts  float64
+	bytes float64
+	last  time.Time
+}
+
+// newPutLimiter creates a limiter, using the defaults for limits that are 0
+func newPutLimiter(puts int, bytes int) *putLimiter {
+	if puts <= 0 {
+		puts = DefaultMaxPutsPerSecond
+	}
+	if bytes <= 0 {
+		bytes = DefaultMaxPutBytesPerSecond
+	}
+	return &putLimiter{puts: float64(puts), bytes: float64(bytes), peers: make(map[peer.ID]*putBucket)}
+}
 
+// allow reports whether a put of size bytes from a peer is within its limits, and if so
+// takes it from the peer's bucket
+func (l *putLimiter) allow(from peer.ID, size int, now time.Time) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.sweep(now)
+	b, ok := l.peers[from]
+	if !ok {
+		b = &putBucket{puts: l.puts, bytes: l.bytes, last: now}
+		l.peers[from] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.puts = math.Min(l.puts, b.puts+elapsed*l.puts)
+		b.bytes = math.Min(l.bytes, b.bytes+elapsed*l.bytes)
+		b.last = now
+	}
+	if b.puts < 1 || b.bytes < float64(size) {
+		return false
+	}
+	b.puts--
+	b.bytes -= float64(size)
+	return true
+}
+
+// sweep removes the buckets of peers that haven't put anything for a second, at most once
+// a second.  Those buckets have refilled, so forgetting them doesn't change any limit.
+func (l *putLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Second {
+		return
+	}
+	for id, b := range l.peers {
+		if now.Sub(b.last) >= time.Second {
+			delete(l.peers, id)
+		}
+	}
+	l.swept = now
+}
+
+// throttle returns ErrKeyRevoked if the sending peer's key has been revoked, or
+// ErrDHTRateLimited if a put message exceeds the sending peer's limits.  The node only
+// passes on messages whose From is the peer that sent them, so m.From can't be spoofed
+// to spend another peer's limits or escape one's own.
+func (dht *DHT) throttle(m *Message) (err error) {
+	if dht.isRevoked(m.From) {
+		err = ErrKeyRevoked
+		return
+	}
+	var b []byte
+	if b, err = m.Encode(); err != nil {
+		return
+	}
+	if !dht.limiter.allow(m.From, len(b), dht.h.Now()) {
+		dht.dlog.Logf("throttling puts from %v", m.From)
+		err = ErrDHTRateLimited
+	}
 	return
 }
 
@@ -551,11 +2089,16 @@ func (dht *DHT) FindNodeForHash(key Hash) (n *Node, err error) {
 func (dht *DHT) HandlePutReqs() (err error) {
 	for {
 		dht.dlog.Log("HandlePutReq: waiting for put request")
-		m, ok := <-dht.puts
+		var m *Message
+		var ok bool
+		select {
+		case m, ok = <-dht.puts:
+		case <-dht.done:
+		}
 		if !ok {
 			break
 		}
-		err = dht.handlePutReq(m)
+		err = dht.handleQueuedPut(m)
 		if err != nil {
 			dht.dlog.Logf("HandlePutReq: got err: %v", err)
 		}
@@ -563,8 +2106,47 @@ func (dht *DHT) HandlePutReqs() (err error) {
 	return nil
 }
 
+// queuePut adds a put or putmeta request to the queue handled by HandlePutReqs, returning
+// ErrDHTClosed instead of waiting for room in the queue once the DHT has been closed
+func (dht *DHT) queuePut(m *Message) (err error) {
+	k := queuedPutKey(m)
+	dht.inflight.add(k)
+	select {
+	case dht.puts <- m:
+	case <-dht.done:
+		dht.inflight.done(k)
+		err = ErrDHTClosed
+	}
+	return
+}
+
+// handleQueuedPut handles a request taken from the put queue
+func (dht *DHT) handleQueuedPut(m *Message) (err error) {
+	defer dht.inflight.done(queuedPutKey(m))
+	err = dht.handlePutReq(m)
+	return
+}
+
+// queuedPutKey returns the hash a queued request puts, "" for a putmeta
+func queuedPutKey(m *Message) string {
+	if t, ok := m.Body.(PutReq); ok {
+		return t.H.String()
+	}
+	return ""
+}
+
+// waitPuts waits until all the requests queued so far have been handled, or until the DHT
+// is closed
+func (dht *DHT) waitPuts() {
+	dht.inflight.waitAll()
+}
+
 func (dht *DHT) handlePutReq(m *Message) (err error) {
 	from := m.From
+	if dht.isRevoked(from) {
+		err = ErrKeyRevoked
+		return
+	}
 	switch t := m.Body.(type) {
 	case PutReq:
 		dht.dlog.Logf("handling put: %v", m)
@@ -574,19 +2156,51 @@ func (dht *DHT) handlePutReq(m *Message) (err error) {
 			return
 		}
 		resp := r.(*ValidateResponse)
+		var author peer.ID
+		if author, err = dht.verifyPut(from, t.H, resp); err != nil {
+			return
+		}
+		if err = dht.h.checkEntrySize(resp.Type, resp.Entry); err != nil {
+			return
+		}
 		p := ValidationProps{
-			Sources: []string{peer.IDB58Encode(from)},
-			Hash:    t.H.String(),
+			Sources:      []string{peer.IDB58Encode(from)},
+			Hash:         t.H.String(),
+			AgentID:      peer.IDB58Encode(author),
+			Neighborhood: t.H.String(),
+			Role:         ValidatingAsDHTNode,
+		}
+		if resp.Header != nil {
+			p.Timestamp = resp.Header.Time.Unix()
 		}
 		err = dht.h.ValidateEntry(resp.Type, resp.Entry, &p)
+		if err == nil && resp.Header != nil {
+			// a header that forks the sender's chain is rejected, leaving a warrant
+			err = dht.checkFork(from, resp.Header)
+		}
 		if err != nil {
 			//@todo store as INVALID
 		} else {
 			entry := resp.Entry
-			b, err := entry.Marshal()
+			var b []byte
+			b, err = entry.Marshal()
 			if err == nil {
 				err = dht.put(m, resp.Type, t.H, from, b, LIVE)
 			}
+			if err == nil && resp.Header != nil {
+				err = dht.setHeader(t.H, resp.Header)
+			}
+			if err == nil && resp.Header != nil && resp.Header.Change.Action != "" {
+				err = dht.applyChange(from, t.H, resp.Header.Change)
+			}
+			if err == nil && resp.Type == RevocationEntryType {
+				err = dht.revoke(from)
+			}
+			if err == nil {
+				if ttl := dht.entryTTL(resp.Type, t); ttl > 0 {
+					err = dht.setExpiry(t.H, dht.h.Now().Add(ttl))
+				}
+			}
 		}
 	case MetaReq:
 		dht.dlog.Logf("handling putmeta: %v", m)
@@ -596,12 +2210,28 @@ func (dht *DHT) handlePutReq(m *Message) (err error) {
 			return
 		}
 		resp := r.(*ValidateResponse)
+		var author peer.ID
+		if author, err = dht.verifyPut(from, t.M, resp); err != nil {
+			return
+		}
+		if err = dht.h.checkEntrySize(resp.Type, resp.Entry); err != nil {
+			return
+		}
 		p := ValidationProps{
-			MetaTag:  t.T,
-			Sources:  []string{peer.IDB58Encode(from)},
-			MetaHash: t.M.String(),
+			MetaTag:      t.T,
+			Sources:      []string{peer.IDB58Encode(from)},
+			MetaHash:     t.M.String(),
+			AgentID:      peer.IDB58Encode(author),
+			Neighborhood: t.O.String(),
+			Role:         ValidatingAsDHTNode,
+		}
+		if resp.Header != nil {
+			p.Timestamp = resp.Header.Time.Unix()
 		}
 		err = dht.h.ValidateEntry(resp.Type, resp.Entry, &p)
+		if err == nil {
+			err = dht.h.ValidateLink(t.O, t.M, t.T, &p)
+		}
 		if err != nil {
 			//@todo store as INVALID
 		} else {
@@ -613,6 +2243,76 @@ func (dht *DHT) handlePutReq(m *Message) (err error) {
 	return
 }
 
+// verifyPut confirms that the header of a put of key was signed by its author, from, with
+// the key of the author's AgentEntry that came with it.  The AgentEntry must belong to from
+// (its key, or the key it replaced, must hash to from's peer ID), and a key rotation
+// AgentEntry is checked against the previous key that signed it.  Puts without a header
+// are rejected.  The author's agent ID, the peer ID of the AgentEntry's key, is returned.
+func (dht *DHT) verifyPut(from peer.ID, key Hash, resp *ValidateResponse) (author peer.ID, err error) {
+	hd := resp.Header
+	if hd == nil {
+		err = fmt.Errorf("%w: put of %v isn't signed", ErrInvalidSignature, key)
+		return
+	}
+	if !hd.EntryLink.Equal(&key) {
+		err = fmt.Errorf("%w: header of put of %v is for %v", ErrInvalidSignature, key, hd.EntryLink)
+		return
+	}
+	var a AgentEntry
+	if resp.Type == AgentEntryType {
+		var ok bool
+		if a, ok = resp.Entry.Content().(AgentEntry); !ok {
+			err = errors.New("expected AgentEntry")
+			return
+		}
+	} else if resp.Agent != nil {
+		a = *resp.Agent
+	} else {
+		err = fmt.Errorf("%w: no AgentEntry for author of %v", ErrInvalidSignature, key)
+		return
+	}
+	signer, err := ic.UnmarshalPublicKey(a.Key)
+	if err != nil {
+		return
+	}
+	id, err := peer.IDFromPublicKey(signer)
+	if err != nil {
+		return
+	}
+	owner := id == from
+	author = id
+	if len(a.PrevKey) > 0 {
+		var prev ic.PubKey
+		if prev, err = ic.UnmarshalPublicKey(a.PrevKey); err != nil {
+			return
+		}
+		// a node keeps the peer ID it was started with when its agent rotates keys
+		var prevID peer.ID
+		if prevID, err = peer.IDFromPublicKey(prev); err != nil {
+			return
+		}
+		owner = owner || prevID == from
+		if resp.Type == AgentEntryType {
+			signer = prev
+		}
+	}
+	if !owner {
+		err = fmt.Errorf("%w: AgentEntry of %v isn't the author's", ErrInvalidSignature, key)
+		return
+	}
+	valid, err := signer.Verify(hd.EntryLink.H, hd.Sig.S)
+	if err != nil {
+		return
+	}
+	if !valid {
+		err = fmt.Errorf("%w: header of %v wasn't signed by its author", ErrInvalidSignature, key)
+	}
+	if err != nil {
+		author = ""
+	}
+	return
+}
+
 // DHTReceiver handles messages on the dht protocol
 func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 	dht := h.dht
@@ -621,7 +2321,12 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		dht.dlog.Logf("DHTRecevier got PUT_REQUEST: %v", m)
 		switch m.Body.(type) {
 		case PutReq:
-			h.dht.puts <- m
+			if err = h.dht.throttle(m); err != nil {
+				return
+			}
+			if err = h.dht.queuePut(m); err != nil {
+				return
+			}
 			response = "queued"
 		default:
 			err = ErrDHTExpectedPutReqInBody
@@ -632,7 +2337,11 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		switch t := m.Body.(type) {
 		case GetReq:
 			var b []byte
-			b, _, _, err = h.dht.get(t.H)
+			var entryType string
+			b, entryType, err = h.dht.getLatest(t.H)
+			if err == nil && h.isPrivate(entryType) {
+				err = ErrEntryPrivate
+			}
 			if err == nil {
 				var e GobEntry
 				err = e.Unmarshal(b)
@@ -649,10 +2358,14 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		dht.dlog.Logf("DHTRecevier got PUTMETA_REQUEST: %v", m)
 		switch t := m.Body.(type) {
 		case MetaReq:
+			if err = h.dht.throttle(m); err != nil {
+				return
+			}
 			err = h.dht.exists(t.O)
 			if err == nil {
-				h.dht.puts <- m
-				response = "queued"
+				if err = h.dht.queuePut(m); err == nil {
+					response = "queued"
+				}
 			} else {
 				dht.dlog.Logf("DHTRecevier key %v doesn't exist, ignoring", t.O)
 			}
@@ -665,7 +2378,7 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 		switch t := m.Body.(type) {
 		case MetaQuery:
 			var r MetaQueryResp
-			r.Entries, err = h.dht.getMeta(t.H, t.T)
+			r.Entries, r.More, err = h.dht.getMetaPage(t.H, t.T, t.Offset, t.Limit)
 			response = r
 		default:
 			err = ErrDHTExpectedMetaQueryInBody
@@ -681,6 +2394,13 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 			puts, err = h.dht.GetPuts(t.YourIdx)
 			g := Gossip{Puts: puts}
 			response = g
+			if err == nil {
+				size := gossipSize(g)
+				h.dht.updateStats(func(s *GossipStats) {
+					s.Served++
+					s.BytesSent += size
+				})
+			}
 
 			// check to see what we know they said, and if our record is less
 			// that where they are currently at, gossip back
@@ -705,15 +2425,45 @@ func DHTReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 	return
 }
 
-// StartDHT initiates listening for DHT protocol messages on the node
+// StartDHT initiates listening for DHT protocol messages on the node.  Any saved snapshot has
+// already been restored by NewDHT, if the store was missing or empty, so the DHT is up to date
+// before it's gossiped.
 func (dht *DHT) StartDHT() (err error) {
 	err = dht.h.node.StartProtocol(dht.h, DHTProtocol, DHTReceiver)
 	return
 }
 
+// Stats returns a snapshot of the gossip statistics
+func (dht *DHT) Stats() GossipStats {
+	dht.slk.Lock()
+	defer dht.slk.Unlock()
+	return dht.stats
+}
+
+// updateStats applies f to the gossip statistics
+func (dht *DHT) updateStats(f func(s *GossipStats)) {
+	dht.slk.Lock()
+	f(&dht.stats)
+	dht.slk.Unlock()
+}
+
+// gossipSize returns the encoded size of a gossip message for the statistics
+func gossipSize(g Gossip) int64 {
+	b, err := ByteEncoder(&g)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
+
 // gossipWith gossips with an peer asking for everything after since
 func (dht *DHT) gossipWith(id peer.ID, after int) (err error) {
 	dht.glog.Logf("with %v", id)
+	defer func() {
+		if err != nil {
+			dht.updateStats(func(s *GossipStats) { s.Failures++ })
+		}
+	}()
 
 	var myIdx int
 	myIdx, err = dht.GetIdx()
@@ -730,6 +2480,12 @@ func (dht *DHT) gossipWith(id peer.ID, after int) (err error) {
 	gossip := r.(Gossip)
 	puts := gossip.Puts
 	dht.glog.Logf("received puts: %v", puts)
+	size := gossipSize(gossip)
+	dht.updateStats(func(s *GossipStats) {
+		s.Exchanges++
+		s.BytesReceived += size
+		s.PutsLearned += int64(len(puts))
+	})
 
 	// gossiper has more stuff that we new about before so update the gossipers status
 	// and also run their puts
@@ -745,6 +2501,9 @@ func (dht *DHT) gossipWith(id peer.ID, after int) (err error) {
 
 // gossip picks a random node in my neighborhood and sends gossips with it
 func (dht *DHT) gossip() (err error) {
+	if dht.h.node == nil {
+		return
+	}
 
 	var g *Gossiper
 	g, err = dht.FindGossiper()
@@ -758,8 +2517,10 @@ func (dht *DHT) gossip() (err error) {
 
 // Gossip gossips every interval
 func (dht *DHT) Gossip(interval time.Duration) {
-	dht.gossiping = true
-	for dht.gossiping {
+	if !dht.start(&dht.gossiping) {
+		return
+	}
+	for dht.flag(&dht.gossiping) {
 		err := dht.gossip()
 		if err != nil {
 			dht.glog.Logf("error: %v", err)
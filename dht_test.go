@@ -1,9 +1,17 @@
 package holochain
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
 	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -88,6 +96,142 @@ func TestPutGet(t *testing.T) {
 
 }
 
+func TestDHTConcurrency(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	dht := h.dht
+	var id peer.ID = h.id
+	base, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	err := dht.put(nil, "someType", base, id, []byte("base"), LIVE)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("concurrent puts and gets should all succeed", t, func() {
+		n := 50
+		var wg sync.WaitGroup
+		errs := make(chan error, n*3)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var hash Hash
+				value := fmt.Sprintf("value %d", i)
+				hash.Sum(h.hashSpec, []byte(value))
+				if err := dht.put(nil, "someType", hash, id, []byte(value), LIVE); err != nil {
+					errs <- err
+					return
+				}
+				data, _, _, err := dht.get(hash)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(data) != value {
+					errs <- fmt.Errorf("expected %s got %s", value, string(data))
+				}
+				e := GobEntry{C: value}
+				if err := dht.putMeta(nil, base, hash, "stress", &e); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+		data, err := dht.getMeta(base, "stress")
+		So(err, ShouldBeNil)
+		So(len(data), ShouldEqual, n)
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	dht := h.dht
+	var id peer.ID = h.id
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	metaHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	err := dht.put(nil, "someType", hash, id, []byte("some value"), LIVE)
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "meta value"}
+	err = dht.putMeta(nil, hash, metaHash, "someTag", &e)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("entry TTL should come from the put request or the entry definition", t, func() {
+		So(dht.entryTTL("myData", PutReq{}), ShouldEqual, 0)
+		So(dht.entryTTL("myData", PutReq{TTL: time.Minute}), ShouldEqual, time.Minute)
+		_, def, _ := h.GetEntryDef("myData")
+		def.Expiry = 5
+		h.Zomes["myZome"].Entries["myData"] = *def
+		So(dht.entryTTL("myData", PutReq{}), ShouldEqual, 5*time.Second)
+	})
+
+	Convey("an unexpired put should still be retrievable", t, func() {
+		err := dht.setExpiry(hash, time.Now().Add(time.Hour))
+		So(err, ShouldBeNil)
+		err = dht.sweep()
+		So(err, ShouldBeNil)
+		_, _, _, err = dht.get(hash)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("an expired put should not be retrievable even before it's swept", t, func() {
+		err := dht.setExpiry(hash, time.Now().Add(-time.Second))
+		So(err, ShouldBeNil)
+		_, _, _, err = dht.get(hash)
+		So(err, ShouldEqual, ErrDHTExpired)
+	})
+
+	Convey("sweep should remove expired puts and their meta data", t, func() {
+		err := dht.sweep()
+		So(err, ShouldBeNil)
+		_, _, _, err = dht.get(hash)
+		So(err, ShouldEqual, ErrDHTExpired)
+		So(dht.exists(hash), ShouldEqual, ErrHashNotFound)
+		_, err = dht.getMeta(hash, "someTag")
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("a put should be swept at exactly the time it expires", t, func() {
+		edge, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+		So(dht.put(nil, "someType", edge, id, []byte("edge value"), LIVE), ShouldBeNil)
+		now := time.Now()
+		h.SetClock(func() time.Time { return now })
+		defer h.SetClock(nil)
+		So(dht.setExpiry(edge, now), ShouldBeNil)
+		_, _, _, err := dht.get(edge)
+		So(err, ShouldEqual, ErrDHTExpired)
+		So(dht.sweep(), ShouldBeNil)
+		So(dht.exists(edge), ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("Sweep should stop when the DHT is closed", t, func() {
+		h.config.SweepInterval = 1
+		done := make(chan struct{})
+		go func() {
+			dht.Sweep()
+			close(done)
+		}()
+		So(dht.Close(), ShouldBeNil)
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("Sweep didn't stop")
+		}
+		// nor start once it is
+		dht.Sweep()
+	})
+}
+
 func TestPutGetMeta(t *testing.T) {
 	d := setupTestDir()
 	defer cleanupTestDir(d)
@@ -145,6 +289,87 @@ func TestPutGetMeta(t *testing.T) {
 		So(data[0].E.Content(), ShouldEqual, "value 3")
 		So(data[0].H, ShouldEqual, metaHash1.String())
 	})
+
+	Convey("It should not duplicate identical meta values", t, func() {
+		idx, _ := dht.GetIdx()
+		e1 := GobEntry{C: "value 1"}
+		err = dht.putMeta(nil, hash, metaHash1, "someType", &e1)
+		So(err, ShouldBeNil)
+		data, err := dht.getMeta(hash, "someType")
+		So(err, ShouldBeNil)
+		So(len(data), ShouldEqual, 2)
+		afterIdx, _ := dht.GetIdx()
+		So(afterIdx, ShouldEqual, idx)
+	})
+
+	Convey("It should return meta values ordered by meta hash", t, func() {
+		e4 := GobEntry{C: "a value 4"}
+		err = dht.putMeta(nil, hash, metaHash2, "thirdType", &e4)
+		So(err, ShouldBeNil)
+		e5 := GobEntry{C: "z value 5"}
+		err = dht.putMeta(nil, hash, metaHash1, "thirdType", &e5)
+		So(err, ShouldBeNil)
+		data, err := dht.getMeta(hash, "thirdType")
+		So(err, ShouldBeNil)
+		So(len(data), ShouldEqual, 2)
+		So(data[0].H, ShouldEqual, metaHash1.String())
+		So(data[1].H, ShouldEqual, metaHash2.String())
+	})
+
+	Convey("It should return pages of meta values", t, func() {
+		data, more, err := dht.getMetaPage(hash, "thirdType", 0, 1)
+		So(err, ShouldBeNil)
+		So(more, ShouldBeTrue)
+		So(len(data), ShouldEqual, 1)
+		So(data[0].H, ShouldEqual, metaHash1.String())
+
+		data, more, err = dht.getMetaPage(hash, "thirdType", 1, 1)
+		So(err, ShouldBeNil)
+		So(more, ShouldBeFalse)
+		So(len(data), ShouldEqual, 1)
+		So(data[0].H, ShouldEqual, metaHash2.String())
+
+		data, more, err = dht.getMetaPage(hash, "thirdType", 2, 1)
+		So(err, ShouldBeNil)
+		So(more, ShouldBeFalse)
+		So(len(data), ShouldEqual, 0)
+	})
+}
+
+func TestMetaCursor(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Unix(1, 1)
+	_, bhd, _ := h.NewEntry(now, "myOdds", &GobEntry{C: "7"})
+	base := bhd.EntryLink
+	if err := h.dht.SendPut(base); err != nil {
+		panic(err)
+	}
+	if err := h.dht.simHandlePutReqs(); err != nil {
+		panic(err)
+	}
+	for _, c := range []string{"1", "3", "5"} {
+		_, hd, _ := h.NewEntry(now, "myOdds", &GobEntry{C: c})
+		if err := h.dht.putMeta(nil, base, hd.EntryLink, "odds", &GobEntry{C: c}); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should page through all the meta values", t, func() {
+		c := h.dht.NewMetaCursor(base, "odds", 2)
+		page, err := c.Next()
+		So(err, ShouldBeNil)
+		So(len(page), ShouldEqual, 2)
+		seen := page[0].H + page[1].H
+		page, err = c.Next()
+		So(err, ShouldBeNil)
+		So(len(page), ShouldEqual, 1)
+		So(seen, ShouldNotContainSubstring, page[0].H)
+		page, err = c.Next()
+		So(err, ShouldBeNil)
+		So(len(page), ShouldEqual, 0)
+	})
 }
 
 func TestFindNodeForHash(t *testing.T) {
@@ -165,6 +390,69 @@ func TestFindNodeForHash(t *testing.T) {
 	})
 }
 
+func TestFindNodesForHash(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	hash, err := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("It should default the replication factor", t, func() {
+		So(h.dht.replicationFactor(), ShouldEqual, DefaultReplicationFactor)
+		h.config.ReplicationFactor = 5
+		So(h.dht.replicationFactor(), ShouldEqual, 5)
+		h.config.ReplicationFactor = 0
+	})
+
+	Convey("It should find only itself when it isn't connected to any peers", t, func() {
+		nodes, err := h.dht.FindNodesForHash(hash, 3)
+		So(err, ShouldBeNil)
+		So(nodes, ShouldResemble, []peer.ID{h.id})
+	})
+
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	h.node = node
+	var peers []*Node
+	for i, port := range []int{4321, 4322, 4323} {
+		p, err := makeNode(port, fmt.Sprintf("peer%d", i))
+		if err != nil {
+			panic(err)
+		}
+		defer p.Close()
+		if err = p.Host.Connect(context.Background(), pstore.PeerInfo{ID: node.HashAddr, Addrs: []ma.Multiaddr{node.NetAddr}}); err != nil {
+			panic(err)
+		}
+		peers = append(peers, p)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = h.WaitForPeers(ctx, len(peers)); err != nil {
+		panic(err)
+	}
+
+	Convey("It should find the connected nodes nearest the hash first", t, func() {
+		nodes, err := h.dht.FindNodesForHash(hash, 10)
+		So(err, ShouldBeNil)
+		So(len(nodes), ShouldEqual, 4)
+		for i := 1; i < len(nodes); i++ {
+			So(bytes.Compare(xorDistance(hash.H, []byte(nodes[i-1])), xorDistance(hash.H, []byte(nodes[i]))), ShouldBeLessThan, 0)
+		}
+
+		nearest, err := h.dht.FindNodesForHash(hash, 2)
+		So(err, ShouldBeNil)
+		So(nearest, ShouldResemble, nodes[:2])
+
+		n, err := h.dht.FindNodeForHash(hash)
+		So(err, ShouldBeNil)
+		So(n.HashAddr, ShouldEqual, nodes[0])
+	})
+}
+
 func TestSend(t *testing.T) {
 	d, _, h := prepareTestChain("test")
 	defer cleanupTestDir(d)
@@ -209,6 +497,313 @@ func TestSend(t *testing.T) {
 	})
 }
 
+func TestDHTInspectAndPrune(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	before, err := h.dht.Keys()
+	if err != nil {
+		panic(err)
+	}
+
+	old := GobEntry{C: "2"}
+	_, oldHd, _ := h.NewEntry(time.Unix(1, 1), "myData", &old)
+	recent := GobEntry{C: "4"}
+	_, recentHd, _ := h.NewEntry(time.Now(), "myData", &recent)
+	for _, hd := range []*Header{oldHd, recentHd} {
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hd.EntryLink})
+		if err := h.dht.handlePutReq(m); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("Keys should list the stored puts", t, func() {
+		keys, err := h.dht.Keys()
+		So(err, ShouldBeNil)
+		So(len(keys), ShouldEqual, len(before)+2)
+		var found int
+		for _, k := range keys {
+			if k.Equal(&oldHd.EntryLink) || k.Equal(&recentHd.EntryLink) {
+				found++
+			}
+		}
+		So(found, ShouldEqual, 2)
+	})
+
+	Convey("Get should return a stored put", t, func() {
+		entry, entryType, status, err := h.dht.Get(oldHd.EntryLink)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "2")
+		So(entryType, ShouldEqual, "myData")
+		So(status, ShouldEqual, LIVE)
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, _, _, err = h.dht.Get(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("Prune should remove the puts matching the predicate", t, func() {
+		cutoff := time.Unix(1000, 0)
+		pruned, err := h.dht.Prune(func(hash Hash, hd Header) bool {
+			return hd.Type == "myData" && hd.Time.Before(cutoff)
+		})
+		So(err, ShouldBeNil)
+		So(pruned, ShouldEqual, 1)
+		_, _, _, err = h.dht.Get(oldHd.EntryLink)
+		So(err, ShouldEqual, ErrHashNotFound)
+		_, _, _, err = h.dht.Get(recentHd.EntryLink)
+		So(err, ShouldBeNil)
+
+		pruned, err = h.dht.Prune(func(hash Hash, hd Header) bool { return false })
+		So(err, ShouldBeNil)
+		So(pruned, ShouldEqual, 0)
+	})
+}
+
+func TestSendGetRetry(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.GetMaxAttempts = 3
+	// long enough that a test waiting out a retry delay would never finish
+	h.config.GetRetryDelay = int(time.Hour / time.Millisecond)
+
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("it should not retry a hash that has no put queued", t, func() {
+		_, err := h.dht.SendGet(hash)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+	})
+
+	Convey("it should retry a get once the hash's queued put is handled", t, func() {
+		_, hd, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "2"})
+		So(err, ShouldBeNil)
+		So(h.dht.SendPut(hd.EntryLink), ShouldBeNil)
+		So(h.dht.inflight.busy(hd.EntryLink.String()), ShouldBeTrue)
+
+		// however the get and the put's handling interleave the get finds the entry
+		type result struct {
+			r   interface{}
+			err error
+		}
+		got := make(chan result)
+		go func() {
+			r, err := h.dht.SendGet(hd.EntryLink)
+			got <- result{r, err}
+		}()
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		res := <-got
+		So(res.err, ShouldBeNil)
+		So(res.r.(*GobEntry).C, ShouldEqual, "2")
+		So(h.dht.inflight.busy(hd.EntryLink.String()), ShouldBeFalse)
+	})
+}
+
+func TestSendGetRetryRemote(t *testing.T) {
+	mn := NewMockNetwork()
+	d1, h1 := prepareMockNetChain(mn, nil)
+	defer cleanupTestDir(d1)
+	defer h1.Close()
+	d2, h2 := prepareMockNetChain(mn, h1)
+	defer cleanupTestDir(d2)
+	defer h2.Close()
+	h2.config.GetMaxAttempts = 20
+	h2.config.GetRetryDelay = 5
+
+	Convey("it should give up on a hash another node never gets", t, func() {
+		h2.config.GetMaxAttempts = 3
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		start := time.Now()
+		_, err := h2.dht.getFrom([]peer.ID{h1.id}, hash)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+		// it waited out the delays of the two retries
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 15*time.Millisecond)
+		h2.config.GetMaxAttempts = 20
+	})
+
+	Convey("it should ask every node once per round rather than backing off on each", t, func() {
+		h2.config.GetMaxAttempts = 3
+		h2.config.GetRetryDelay = 50
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		start := time.Now()
+		_, err := h2.dht.getFrom([]peer.ID{h1.id, h1.id, h1.id}, hash)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+		// one set of delays for all three nodes, not one for each
+		elapsed := time.Since(start)
+		So(elapsed, ShouldBeGreaterThanOrEqualTo, 150*time.Millisecond)
+		So(elapsed, ShouldBeLessThan, 300*time.Millisecond)
+		h2.config.GetMaxAttempts = 20
+		h2.config.GetRetryDelay = 5
+	})
+
+	Convey("it should retry a get from another node until the entry reaches it", t, func() {
+		_, hd, err := h2.NewEntry(time.Now(), "myData", &GobEntry{C: "2"})
+		So(err, ShouldBeNil)
+		_, err = h2.dht.send(h1.id, GET_REQUEST, GetReq{H: hd.EntryLink})
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+
+		type result struct {
+			r   interface{}
+			err error
+		}
+		got := make(chan result)
+		go func() {
+			r, err := h2.dht.getFrom([]peer.ID{h1.id}, hd.EntryLink)
+			got <- result{r, err}
+		}()
+		time.Sleep(20 * time.Millisecond)
+		b, _ := (&GobEntry{C: "2"}).Marshal()
+		So(h1.dht.put(nil, "myData", hd.EntryLink, h2.id, b, LIVE), ShouldBeNil)
+		res := <-got
+		So(res.err, ShouldBeNil)
+		So(res.r.(GobEntry).C, ShouldEqual, "2")
+	})
+}
+
+func TestWaitPuts(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should wait for the queued puts to be handled", t, func() {
+		_, hd, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "2"})
+		So(err, ShouldBeNil)
+		So(h.dht.SendPut(hd.EntryLink), ShouldBeNil)
+		waited := make(chan struct{})
+		go func() {
+			h.dht.waitPuts()
+			close(waited)
+		}()
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+		<-waited
+		So(h.dht.inflight.queued, ShouldEqual, 0)
+	})
+
+	Convey("it should stop waiting for puts still queued when the DHT is closed", t, func() {
+		_, hd, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "4"})
+		So(err, ShouldBeNil)
+		So(h.dht.SendPut(hd.EntryLink), ShouldBeNil)
+		waited := make(chan struct{})
+		go func() {
+			h.dht.waitPuts()
+			close(waited)
+		}()
+		So(h.dht.Close(), ShouldBeNil)
+		<-waited
+		So(h.dht.inflight.queued, ShouldEqual, 1)
+	})
+}
+
+func TestSharing(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	hash, err := h.Commit("privateNote", "my secret")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("private entries should not be put to the DHT", t, func() {
+		err := h.dht.SendPut(hash)
+		So(err, ShouldEqual, ErrEntryPrivate)
+		err = h.dht.SendPutMeta(MetaReq{O: hash, M: hash, T: "someTag"})
+		So(err, ShouldEqual, ErrEntryPrivate)
+	})
+
+	Convey("private entries should not be given to nodes asking to validate them", t, func() {
+		m := h.node.NewMessage(SRC_VALIDATE, hash)
+		_, err := SrcReceiver(h, m)
+		So(err, ShouldEqual, ErrEntryPrivate)
+	})
+
+	Convey("DHT nodes should not validate private entries", t, func() {
+		e := GobEntry{C: "my secret"}
+		err := h.ValidateEntry("privateNote", &e, &ValidationProps{Role: ValidatingAsDHTNode})
+		So(errors.Is(err, ErrEntryPrivate), ShouldBeTrue)
+		err = h.ValidateEntry("privateNote", &e, &ValidationProps{Role: ValidatingAsAuthor})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("GET_REQUEST should not return private entries", t, func() {
+		e := GobEntry{C: "my secret"}
+		b, _ := e.Marshal()
+		err := h.dht.put(nil, "privateNote", hash, h.id, b, LIVE)
+		So(err, ShouldBeNil)
+		m := h.node.NewMessage(GET_REQUEST, GetReq{H: hash})
+		_, err = DHTReceiver(h, m)
+		So(err, ShouldEqual, ErrEntryPrivate)
+	})
+}
+
+func TestVerifyPut(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Unix(1, 1)
+	_, hd, _ := h.NewEntry(now, "myData", &GobEntry{C: "124"})
+	hash := hd.EntryLink
+	validate := func() *ValidateResponse {
+		r, err := SrcReceiver(h, h.node.NewMessage(SRC_VALIDATE, hash))
+		if err != nil {
+			panic(err)
+		}
+		return r.(*ValidateResponse)
+	}
+
+	Convey("it should accept a put signed by its author", t, func() {
+		resp := validate()
+		So(resp.Agent, ShouldNotBeNil)
+		author, err := h.dht.verifyPut(h.id, hash, resp)
+		So(err, ShouldBeNil)
+		So(author, ShouldEqual, h.id)
+
+		agentResp, err := SrcReceiver(h, h.node.NewMessage(SRC_VALIDATE, h.agentHash))
+		So(err, ShouldBeNil)
+		_, err = h.dht.verifyPut(h.id, h.agentHash, agentResp.(*ValidateResponse))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should reject unsigned and mis-signed puts", t, func() {
+		resp := validate()
+		resp.Header = nil
+		_, err := h.dht.verifyPut(h.id, hash, resp)
+		So(errors.Is(err, ErrInvalidSignature), ShouldBeTrue)
+
+		resp = validate()
+		hdr := *resp.Header
+		hdr.Sig.S = append([]byte{}, hdr.Sig.S...)
+		hdr.Sig.S[0] ^= 0xff
+		resp.Header = &hdr
+		_, err = h.dht.verifyPut(h.id, hash, resp)
+		So(errors.Is(err, ErrInvalidSignature), ShouldBeTrue)
+
+		resp = validate()
+		resp.Agent = nil
+		_, err = h.dht.verifyPut(h.id, hash, resp)
+		So(errors.Is(err, ErrInvalidSignature), ShouldBeTrue)
+	})
+
+	Convey("it should reject a put whose AgentEntry isn't the sender's", t, func() {
+		node, err := makeNode(1250, "node1")
+		So(err, ShouldBeNil)
+		defer node.Close()
+		_, err = h.dht.verifyPut(node.HashAddr, hash, validate())
+		So(errors.Is(err, ErrInvalidSignature), ShouldBeTrue)
+	})
+
+	Convey("it should return the author of the AgentEntry, not the sender, after a key rotation", t, func() {
+		sender := h.id
+		newAgent, err := NewAgent(IPFS, "Herbert <h@bert.com>")
+		So(err, ShouldBeNil)
+		So(h.RotateKey(newAgent), ShouldBeNil)
+		_, hd, err := h.NewEntry(h.Now(), "myData", &GobEntry{C: "126"})
+		So(err, ShouldBeNil)
+		r, err := SrcReceiver(h, h.node.NewMessage(SRC_VALIDATE, hd.EntryLink))
+		So(err, ShouldBeNil)
+		author, err := h.dht.verifyPut(sender, hd.EntryLink, r.(*ValidateResponse))
+		So(err, ShouldBeNil)
+		So(author, ShouldEqual, h.id)
+		So(author, ShouldNotEqual, sender)
+	})
+}
+
 func TestDHTReceiver(t *testing.T) {
 	d, _, h := prepareTestChain("test")
 	defer cleanupTestDir(d)
@@ -387,6 +982,16 @@ func TestGossip(t *testing.T) {
 		err = dht.gossip()
 		So(err, ShouldBeNil)
 	})
+
+	Convey("gossip should be counted in the stats", t, func() {
+		s := dht.Stats()
+		So(s.Exchanges, ShouldEqual, 1)
+		So(s.Served, ShouldEqual, 1)
+		So(s.Failures, ShouldEqual, 0)
+		So(s.BytesReceived, ShouldBeGreaterThan, 0)
+		So(s.BytesSent, ShouldEqual, s.BytesReceived)
+		So(s.PutsLearned, ShouldEqual, 0)
+	})
 }
 
 func TestHandlePutReqs(t *testing.T) {
@@ -401,7 +1006,9 @@ func TestHandlePutReqs(t *testing.T) {
 	}
 
 	m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hd.EntryLink})
-	h.dht.puts <- m
+	if err = h.dht.queuePut(m); err != nil {
+		panic(err)
+	}
 
 	Convey("handle put request should pull data from source and verify it", t, func() {
 		err := h.dht.simHandlePutReqs()
@@ -413,10 +1020,270 @@ func TestHandlePutReqs(t *testing.T) {
 		So(fmt.Sprintf("%v", data), ShouldEqual, fmt.Sprintf("%v", b))
 	})
 
+	Convey("queuePut should fail rather than block once the DHT is closed", t, func() {
+		for i := 0; i < cap(h.dht.puts); i++ {
+			So(h.dht.queuePut(m), ShouldBeNil)
+		}
+		So(h.dht.Close(), ShouldBeNil)
+		done := make(chan error, 1)
+		go func() { done <- h.dht.queuePut(m) }()
+		select {
+		case err := <-done:
+			So(err, ShouldEqual, ErrDHTClosed)
+		case <-time.After(3 * time.Second):
+			t.Fatal("queuePut blocked after Close")
+		}
+	})
+}
+
+func TestForkDetection(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Unix(1, 1)
+	prevHash := h.chain.Hashes[len(h.chain.Hashes)-1]
+	hash, hd, err := h.NewEntry(now, "myData", &GobEntry{C: "2"})
+	if err != nil {
+		panic(err)
+	}
+	// a header made by another process with the same agent keys after the same top
+	forged, forgedHd, err := newHeader(h.hashSpec, now, "myData", &GobEntry{C: "4"}, h.agent.PrivKey(), prevHash, hd.TypeLink)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should accept headers that follow the agent's chain", t, func() {
+		So(h.dht.checkFork(h.id, hd), ShouldBeNil)
+		So(h.dht.checkFork(h.id, hd), ShouldBeNil)
+		warrants, err := h.dht.Warrants()
+		So(err, ShouldBeNil)
+		So(warrants, ShouldBeNil)
+		valid, err := h.Validate(false)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+
+	Convey("it should reject a second header following the same header and record a warrant", t, func() {
+		err := h.dht.checkFork(h.id, forgedHd)
+		So(errors.Is(err, ErrChainFork), ShouldBeTrue)
+		var fe *ForkError
+		So(errors.As(err, &fe), ShouldBeTrue)
+		So(fe.Warrant.Agent, ShouldEqual, h.id)
+		So(fe.Warrant.Prev.String(), ShouldEqual, prevHash.String())
+		So(fe.Warrant.Headers[0].String(), ShouldEqual, hash.String())
+		So(fe.Warrant.Headers[1].String(), ShouldEqual, forged.String())
+
+		warrants, err := h.dht.Warrants()
+		So(err, ShouldBeNil)
+		So(len(warrants), ShouldEqual, 1)
+		So(warrants[0].Agent, ShouldEqual, h.id)
+		So(warrants[0].Headers[1].String(), ShouldEqual, forged.String())
+		So(warrants[0].Time.Equal(fe.Warrant.Time), ShouldBeTrue)
+	})
+
+	Convey("Validate should surface the fork", t, func() {
+		valid, err := h.Validate(false)
+		So(valid, ShouldBeFalse)
+		So(errors.Is(err, ErrChainFork), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, forged.String())
+	})
+
+	Convey("Validate should surface a fork whose other header was seen first", t, func() {
+		_, hd2, err := h.NewEntry(now, "myData", &GobEntry{C: "6"})
+		So(err, ShouldBeNil)
+		_, forgedHd2, err := newHeader(h.hashSpec, now, "myData", &GobEntry{C: "8"}, h.agent.PrivKey(), hash, hd.TypeLink)
+		So(err, ShouldBeNil)
+		So(h.dht.checkFork(h.id, forgedHd2), ShouldBeNil)
+
+		results, err := h.ValidateDetailed(false)
+		So(err, ShouldBeNil)
+		last := results[len(results)-1]
+		So(last.OK, ShouldBeFalse)
+		So(errors.Is(last.Err, ErrChainFork), ShouldBeTrue)
+		So(errors.Is(h.dht.checkFork(h.id, hd2), ErrChainFork), ShouldBeTrue)
+	})
+}
+
+func TestSendPutStatus(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	hash, err := h.Commit("myOdds", "7")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should report the peers the put was sent to and their acks", t, func() {
+		status, err := h.dht.SendPutStatus(hash)
+		So(err, ShouldBeNil)
+		So(status.Result, ShouldEqual, "ok")
+		So(status.Peers, ShouldResemble, []string{peer.IDB58Encode(h.id)})
+		So(status.Acks, ShouldEqual, 1)
+		So(h.dht.simHandlePutReqs(), ShouldBeNil)
+	})
+
+	Convey("it should report no acks for puts that aren't sent", t, func() {
+		hash, err := h.Commit("privateNote", "my secret")
+		So(err, ShouldBeNil)
+		status, err := h.dht.SendPutStatus(hash)
+		So(err, ShouldEqual, ErrEntryPrivate)
+		So(status.Acks, ShouldEqual, 0)
+	})
 }
 
 func (dht *DHT) simHandlePutReqs() (err error) {
 	m := <-dht.puts
-	err = dht.handlePutReq(m)
+	err = dht.handleQueuedPut(m)
 	return
 }
+
+func TestSnapshot(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	dht := h.dht
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	if err := dht.put(nil, "someType", hash, h.id, []byte("some value"), LIVE); err != nil {
+		panic(err)
+	}
+	var snap bytes.Buffer
+	if err := dht.Snapshot(&snap); err != nil {
+		panic(err)
+	}
+	if err := dht.put(nil, "someType", other, h.id, []byte("other value"), LIVE); err != nil {
+		panic(err)
+	}
+
+	Convey("it should restore the store to the state it was snapshotted in", t, func() {
+		err := dht.RestoreSnapshot(bytes.NewReader(snap.Bytes()))
+		So(err, ShouldBeNil)
+		data, _, _, err := dht.get(hash)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some value")
+		_, _, _, err = dht.get(other)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should detect and refuse a corrupt snapshot", t, func() {
+		b := append([]byte{}, snap.Bytes()...)
+		b[len(b)-1]++
+		err := dht.RestoreSnapshot(bytes.NewReader(b))
+		So(err, ShouldEqual, ErrDHTSnapshotCorrupt)
+		err = dht.RestoreSnapshot(bytes.NewReader([]byte("garbage")))
+		So(err, ShouldEqual, ErrDHTSnapshotCorrupt)
+		_, _, _, err = dht.get(hash)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should refuse a snapshot of another version", t, func() {
+		b := bytes.Replace(snap.Bytes(), []byte("snapshot 1 "), []byte("snapshot 2 "), 1)
+		err := dht.RestoreSnapshot(bytes.NewReader(b))
+		So(err, ShouldEqual, ErrDHTSnapshotVersion)
+	})
+
+	Convey("it should save a snapshot on close which is loaded by a new DHT", t, func() {
+		err := dht.Close()
+		So(err, ShouldBeNil)
+		So(fileExists(h.path+"/"+DHTSnapshotFileName), ShouldBeTrue)
+		os.Remove(h.path + "/" + DHTStoreFileName)
+		h.dht = NewDHT(h)
+		_, _, _, err = h.dht.get(hash)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should not replace a store that has data with an older snapshot", t, func() {
+		err := h.dht.put(nil, "someType", other, h.id, []byte("other value"), LIVE)
+		So(err, ShouldBeNil)
+		err = h.dht.Close()
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(h.path+"/"+DHTSnapshotFileName, snap.Bytes(), 0644)
+		So(err, ShouldBeNil)
+		h.dht = NewDHT(h)
+		data, _, _, err := h.dht.get(other)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "other value")
+	})
+
+	Convey("it should rebuild a store that can't be opened from the snapshot", t, func() {
+		err := h.dht.Close()
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(h.path+"/"+DHTSnapshotFileName, snap.Bytes(), 0644)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(h.path+"/"+DHTStoreFileName, []byte("garbage\n"), 0644)
+		So(err, ShouldBeNil)
+		h.dht = NewDHT(h)
+		So(fileExists(h.path+"/"+DHTStoreFileName+".corrupt"), ShouldBeTrue)
+		_, _, _, err = h.dht.get(hash)
+		So(err, ShouldBeNil)
+		_, _, _, err = h.dht.get(other)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should ignore a corrupt snapshot file", t, func() {
+		err := ioutil.WriteFile(h.path+"/"+DHTSnapshotFileName, []byte("garbage"), 0644)
+		So(err, ShouldBeNil)
+		err = h.dht.loadSnapshot()
+		So(err, ShouldBeNil)
+		_, _, _, err = h.dht.get(hash)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestPutRateLimit(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	h.node = node
+
+	now := time.Unix(1000, 0)
+	h.SetClock(func() time.Time { return now })
+	h.dht.limiter = newPutLimiter(3, 0)
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("a burst of puts beyond the limit should be throttled", t, func() {
+		for i := 0; i < 3; i++ {
+			r, err := DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}))
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, "queued")
+		}
+		_, err := DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}))
+		So(err, ShouldEqual, ErrDHTRateLimited)
+	})
+
+	Convey("puts should be allowed again once the limit has refilled", t, func() {
+		now = now.Add(time.Second)
+		r, err := DHTReceiver(h, h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}))
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, "queued")
+	})
+
+	Convey("the limits should be kept separately for each peer", t, func() {
+		l := newPutLimiter(1, 100)
+		So(l.allow(h.id, 10, now), ShouldBeTrue)
+		So(l.allow(h.id, 10, now), ShouldBeFalse)
+		So(l.allow(peer.ID("other"), 10, now), ShouldBeTrue)
+	})
+
+	Convey("puts larger than the byte limit should be throttled", t, func() {
+		l := newPutLimiter(10, 100)
+		So(l.allow(h.id, 60, now), ShouldBeTrue)
+		So(l.allow(h.id, 60, now), ShouldBeFalse)
+		So(l.allow(h.id, 60, now.Add(time.Second)), ShouldBeTrue)
+	})
+
+	Convey("the buckets of idle peers should be removed", t, func() {
+		l := newPutLimiter(1, 100)
+		So(l.allow(h.id, 10, now), ShouldBeTrue)
+		So(l.allow(peer.ID("other"), 10, now.Add(time.Second/2)), ShouldBeTrue)
+		So(len(l.peers), ShouldEqual, 2)
+		So(l.allow(peer.ID("other"), 10, now.Add(time.Second*3/2)), ShouldBeTrue)
+		So(len(l.peers), ShouldEqual, 1)
+		So(l.peers[h.id], ShouldBeNil)
+	})
+}
@@ -7,18 +7,29 @@
 package holochain
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"github.com/lestrrat/go-jsschema"
 	"github.com/lestrrat/go-jsval"
 	"github.com/lestrrat/go-jsval/builder"
+	mh "github.com/multiformats/go-multihash"
 	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 const (
-	DNAEntryType   = "%dna"
-	AgentEntryType = "%agent"
-	KeyEntryType   = "%%key" // virtual entry type, not actually on the chain
+	DNAEntryType        = "%dna"
+	AgentEntryType      = "%agent"
+	RevocationEntryType = "%revocation"
+	LinkEntryType       = "%link"
+	DelEntryType        = "%del"
+	KeyEntryType        = "%%key" // virtual entry type, not actually on the chain
 )
 
 const (
@@ -26,15 +37,87 @@ const (
 	DataFormatString  = "string"
 	DataFormatRawJS   = "js"
 	DataFormatRawZygo = "zygo"
+	DataFormatBinary  = "binary" // raw bytes, validated only against the maximum entry size
+	DataFormatURI     = "uri"    // a URIEntry as JSON, pointing to content stored off the chain
 )
 
+const (
+	SharingPublic  = "public"  // entries are put to the DHT and can be retrieved by any node
+	SharingPrivate = "private" // entries stay on the author's chain and are never published
+)
+
+// DefaultMaxEntrySize is the largest entry (in bytes) that can be committed unless the
+// holochain's Config sets MaxEntrySize or the entry's EntryDef sets MaxSize
+const DefaultMaxEntrySize = 1024 * 1024
+
 // EntryDef struct holds an entry definition
 type EntryDef struct {
 	Name       string
 	DataFormat string
 	Schema     string // file name of schema or language schema directive
 	SchemaHash Hash
+	Expiry     int    // number of seconds after which DHT nodes drop entries of this type, 0 means never
+	MaxSize    int    // largest entry of this type in bytes, 0 means the Config's MaxEntrySize
+	Sharing    string // SharingPublic or SharingPrivate, "" means public
 	validator  SchemaValidator
+	resolved   Hash // hash of the schema with its $refs resolved, which names its archived version
+}
+
+// isPrivate reports whether entries of this type must stay local to their author
+func (d *EntryDef) isPrivate() bool {
+	return d.Sharing == SharingPrivate
+}
+
+// URIEntry is the content of DataFormatURI entries.  The content itself is stored elsewhere
+// (i.e. on IPFS or an HTTP server) and the chain records where it is and the hash it must
+// have, so the chain remains the record of its provenance without holding its bytes.
+type URIEntry struct {
+	URI  string
+	Hash string // B58 encoded multihash of the content
+}
+
+// ParseURIEntry decodes the JSON content of a DataFormatURI entry, checking that the URI is
+// absolute and well formed and that the hash is a valid multihash
+func ParseURIEntry(content string) (u URIEntry, err error) {
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&u); err != nil {
+		err = fmt.Errorf("invalid URI entry: %v", err)
+		return
+	}
+	var p *url.URL
+	if p, err = url.Parse(u.URI); err != nil {
+		err = fmt.Errorf("invalid URI entry: %v", err)
+		return
+	}
+	if !p.IsAbs() || (p.Host == "" && p.Opaque == "" && p.Path == "") {
+		err = fmt.Errorf("invalid URI entry: %q is not an absolute URI", u.URI)
+		return
+	}
+	if _, err = NewHash(u.Hash); err != nil {
+		err = fmt.Errorf("invalid URI entry: bad content hash: %v", err)
+	}
+	return
+}
+
+// Verify checks that content retrieved from the entry's URI has the entry's hash
+func (u URIEntry) Verify(content []byte) (err error) {
+	var h Hash
+	if h, err = NewHash(u.Hash); err != nil {
+		return
+	}
+	var d *mh.DecodedMultihash
+	if d, err = mh.Decode(h.H); err != nil {
+		return
+	}
+	var sum Hash
+	if sum.H, err = mh.Sum(content, d.Code, d.Length); err != nil {
+		return
+	}
+	if !sum.Equal(&h) {
+		err = fmt.Errorf("content hash %v doesn't match %v", sum, h)
+	}
+	return
 }
 
 // Entry describes serialization and deserialziation of entry data
@@ -74,12 +157,16 @@ func MarshalEntry(writer io.Writer, e Entry) (err error) {
 }
 
 // UnmarshalEntry unserializes an entry from a reader
+// A zero length marks a reference to a copy stored earlier and returns a nil entry.
 func UnmarshalEntry(reader io.Reader) (e Entry, err error) {
 	var l uint64
 	err = binary.Read(reader, binary.LittleEndian, &l)
 	if err != nil {
 		return
 	}
+	if l == 0 {
+		return
+	}
 	var b = make([]byte, l)
 	err = binary.Read(reader, binary.LittleEndian, b)
 	if err != nil {
@@ -151,8 +238,46 @@ func (v *JSONSchemaValidator) Validate(entry interface{}) (err error) {
 
 // BuildJSONSchemaValidator builds a validator in an EntryDef
 func (d *EntryDef) BuildJSONSchemaValidator(path string) (err error) {
+	var v *JSONSchemaValidator
+	v, err = BuildJSONSchemaValidatorFromFile(path, d.Schema)
+	if err == nil {
+		d.validator = v
+	}
+	return
+}
+
+// BuildJSONSchemaValidatorFromFile builds a validator from the schema file in the given directory
+// $refs to other schema files (i.e. "defs.json#/definitions/name") are resolved relative to
+// the directory and inlined before the validator is built
+func BuildJSONSchemaValidatorFromFile(path string, file string) (validator *JSONSchemaValidator, err error) {
+	var j []byte
+	if j, err = resolveSchemaFile(path, file); err != nil {
+		return
+	}
+	validator, err = buildJSONSchemaValidator(file, j)
+	return
+}
+
+// resolveSchemaFile returns the JSON of a schema file with its $refs to other files inlined
+func resolveSchemaFile(path string, file string) (j []byte, err error) {
+	r := schemaResolver{path: path, docs: make(map[string]interface{})}
+	var doc interface{}
+	doc, err = r.load(file)
+	if err != nil {
+		return
+	}
+	doc, err = r.resolve(doc, file, true)
+	if err != nil {
+		return
+	}
+	j, err = json.Marshal(doc)
+	return
+}
+
+// buildJSONSchemaValidator builds a validator from a schema's JSON
+func buildJSONSchemaValidator(name string, j []byte) (validator *JSONSchemaValidator, err error) {
 	var s *schema.Schema
-	s, err = schema.ReadFile(path + "/" + d.Schema)
+	s, err = schema.Read(bytes.NewReader(j))
 	if err != nil {
 		return
 	}
@@ -161,8 +286,134 @@ func (d *EntryDef) BuildJSONSchemaValidator(path string) (err error) {
 	var v JSONSchemaValidator
 	v.v, err = b.Build(s)
 	if err == nil {
-		v.v.SetName(d.Schema)
-		d.validator = &v
+		v.v.SetName(name)
+		validator = &v
+	}
+	return
+}
+
+// schemaResolver inlines $refs to other schema files so that multi-file schemas can be built
+type schemaResolver struct {
+	path  string                 // directory the schema files are relative to
+	docs  map[string]interface{} // decoded schema files, by file name
+	stack []string               // the $refs being resolved, for cycle detection
+}
+
+// load returns the decoded contents of a schema file
+func (r *schemaResolver) load(file string) (doc interface{}, err error) {
+	doc, ok := r.docs[file]
+	if ok {
+		return
+	}
+	var b []byte
+	b, err = ioutil.ReadFile(filepath.Join(r.path, file))
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		err = fmt.Errorf("error reading schema %s: %v", file, err)
+		return
+	}
+	r.docs[file] = doc
+	return
+}
+
+// resolve returns a copy of v, which is from the given schema file, with its $refs inlined.
+// Local refs in the root schema file are left for the validator builder to handle.
+func (r *schemaResolver) resolve(v interface{}, file string, root bool) (result interface{}, err error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := t["$ref"].(string); ok && !strings.Contains(ref, "://") {
+			target := ref
+			pointer := ""
+			if i := strings.Index(ref, "#"); i >= 0 {
+				target = ref[:i]
+				pointer = ref[i+1:]
+			}
+			if target == "" {
+				if root {
+					result = t
+					return
+				}
+				target = file
+			}
+			result, err = r.resolveRef(target, pointer)
+			return
+		}
+		m := make(map[string]interface{})
+		for k, e := range t {
+			if m[k], err = r.resolve(e, file, root); err != nil {
+				return
+			}
+		}
+		result = m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			if a[i], err = r.resolve(e, file, root); err != nil {
+				return
+			}
+		}
+		result = a
+	default:
+		result = v
+	}
+	return
+}
+
+// resolveRef returns the resolved schema at the JSON pointer in the given file
+func (r *schemaResolver) resolveRef(file string, pointer string) (result interface{}, err error) {
+	key := file + "#" + pointer
+	for i, k := range r.stack {
+		if k == key {
+			err = fmt.Errorf("$ref cycle: %s -> %s", strings.Join(r.stack[i:], " -> "), key)
+			return
+		}
+	}
+	r.stack = append(r.stack, key)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	var doc interface{}
+	doc, err = r.load(file)
+	if err != nil {
+		return
+	}
+	var v interface{}
+	v, err = jsonPointer(doc, pointer)
+	if err != nil {
+		err = fmt.Errorf("bad $ref %s: %v", key, err)
+		return
+	}
+	result, err = r.resolve(v, file, false)
+	return
+}
+
+// jsonPointer returns the value in doc at the given JSON pointer (RFC 6901)
+func jsonPointer(doc interface{}, pointer string) (v interface{}, err error) {
+	v = doc
+	if pointer == "" || pointer == "/" {
+		return
+	}
+	for _, p := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		p = strings.Replace(strings.Replace(p, "~1", "/", -1), "~0", "~", -1)
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[p]; !ok {
+				err = fmt.Errorf("%s not found", p)
+				return
+			}
+		case []interface{}:
+			var i int
+			if i, err = strconv.Atoi(p); err != nil || i < 0 || i >= len(t) {
+				err = fmt.Errorf("bad index %s", p)
+				return
+			}
+			v = t[i]
+		default:
+			err = fmt.Errorf("%s not found", p)
+			return
+		}
 	}
 	return
 }
@@ -101,3 +101,85 @@ func TestMarshalEntry(t *testing.T) {
 		So(fmt.Sprintf("%v", ne), ShouldEqual, fmt.Sprintf("%v", &e))
 	})
 }
+
+func TestJSONSchemaRefs(t *testing.T) {
+	d, _ := setupTestService()
+	defer cleanupTestDir(d)
+
+	defs := `{
+	"definitions": {
+		"name": {"type": "string", "minLength": 1},
+		"person": {
+			"type": "object",
+			"properties": {"first": {"$ref": "#/definitions/name"}},
+			"required": ["first"]
+		}
+	}
+}`
+	profile := `{
+	"type": "object",
+	"properties": {"owner": {"$ref": "defs.json#/definitions/person"}},
+	"required": ["owner"]
+}`
+	cycle := `{"definitions": {"a": {"$ref": "cycle.json#/definitions/b"}, "b": {"$ref": "#/definitions/a"}}}`
+	for f, s := range map[string]string{"defs.json": defs, "profile.json": profile, "cycle.json": cycle} {
+		if err := writeFile(d, f, []byte(s)); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should resolve $refs to other schema files", t, func() {
+		v, err := BuildJSONSchemaValidatorFromFile(d, "profile.json")
+		So(err, ShouldBeNil)
+		var input interface{}
+		json.Unmarshal([]byte(`{"owner":{"first":"Eric"}}`), &input)
+		So(v.Validate(input), ShouldBeNil)
+		json.Unmarshal([]byte(`{"owner":{"first":""}}`), &input)
+		So(v.Validate(input), ShouldNotBeNil)
+		json.Unmarshal([]byte(`{"owner":{}}`), &input)
+		So(v.Validate(input), ShouldNotBeNil)
+	})
+
+	Convey("it should report $ref cycles", t, func() {
+		if err := writeFile(d, "uses_cycle.json", []byte(`{"$ref": "cycle.json#/definitions/a"}`)); err != nil {
+			panic(err)
+		}
+		_, err := BuildJSONSchemaValidatorFromFile(d, "uses_cycle.json")
+		So(err.Error(), ShouldEqual, "$ref cycle: cycle.json#/definitions/a -> cycle.json#/definitions/b -> cycle.json#/definitions/a")
+	})
+
+	Convey("it should report missing $ref targets", t, func() {
+		if err := writeFile(d, "bad_ref.json", []byte(`{"$ref": "defs.json#/definitions/bogus"}`)); err != nil {
+			panic(err)
+		}
+		_, err := BuildJSONSchemaValidatorFromFile(d, "bad_ref.json")
+		So(err.Error(), ShouldEqual, "bad $ref defs.json#/definitions/bogus: bogus not found")
+	})
+}
+
+func TestParseURIEntry(t *testing.T) {
+	var h Hash
+	h.Sum(HashSpec{Code: 0x12, Length: -1}, []byte("some media"))
+	Convey("it should accept absolute URIs with a multihash", t, func() {
+		u, err := ParseURIEntry(fmt.Sprintf(`{"URI":"https://example.com/media.mp4","Hash":"%s"}`, h.String()))
+		So(err, ShouldBeNil)
+		So(u.URI, ShouldEqual, "https://example.com/media.mp4")
+		_, err = ParseURIEntry(fmt.Sprintf(`{"uri":"ipfs://%s","hash":"%s"}`, h.String(), h.String()))
+		So(err, ShouldBeNil)
+	})
+	Convey("it should reject malformed entries", t, func() {
+		_, err := ParseURIEntry(fmt.Sprintf(`{"URI":"media.mp4","Hash":"%s"}`, h.String()))
+		So(err.Error(), ShouldEqual, `invalid URI entry: "media.mp4" is not an absolute URI`)
+		_, err = ParseURIEntry(`{"URI":"https://example.com/media.mp4","Hash":"fish"}`)
+		So(err.Error(), ShouldStartWith, "invalid URI entry: bad content hash")
+		_, err = ParseURIEntry(fmt.Sprintf(`{"URI":"https://example.com/media.mp4","Hash":"%s","Size":3}`, h.String()))
+		So(err, ShouldNotBeNil)
+		_, err = ParseURIEntry(`https://example.com/media.mp4`)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("it should verify content against the hash", t, func() {
+		u := URIEntry{URI: "https://example.com/media.mp4", Hash: h.String()}
+		So(u.Verify([]byte("some media")), ShouldBeNil)
+		So(u.Verify([]byte("other media")), ShouldNotBeNil)
+	})
+}
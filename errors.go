@@ -0,0 +1,263 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements structured error types so that errors can be handled programmatically
+// (with errors.Is and errors.As) rather than by matching their messages
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidEntry error = errors.New("invalid entry")
+var ErrValidationFailed error = errors.New("validation failed")
+var ErrKeyTypeNotAllowed error = errors.New("key type not allowed")
+var ErrEntryTooLarge error = errors.New("entry too large")
+var ErrKeyRevoked error = errors.New("key revoked")
+var ErrEntryPrivate error = errors.New("entry is private")
+var ErrInvalidLink error = errors.New("invalid link")
+var ErrInvalidJSONArgument error = errors.New("invalid JSON argument")
+var ErrDuplicateEntryType error = errors.New("duplicate entry type")
+var ErrInvalidSignature error = errors.New("invalid signature")
+var ErrEntryDeleted error = errors.New("entry deleted")
+var ErrStoreKey error = errors.New("wrong key for encrypted store or corrupt encrypted data")
+var ErrStoreEncrypted error = errors.New("store is encrypted")
+var ErrStoreNotEncrypted error = errors.New("store is not encrypted")
+var ErrUnknownFunction error = errors.New("unknown function")
+var ErrDNADownload error = errors.New("couldn't download DNA")
+var ErrDNAHashMismatch error = errors.New("DNA hash mismatch")
+var ErrChainFork error = errors.New("chain fork")
+var ErrReadOnlyCall error = errors.New("not allowed in a read-only function")
+var ErrNoDHT error = errors.New("holochain has no DHT, it must be activated first")
+var ErrAgentNameFixed error = errors.New("the agent name is recorded in the chain's genesis AgentEntry so it can't be changed once the chain is started, use RotateKey with a newly named agent instead")
+
+// InvalidEntryError is returned by a nucleus when the application's validation rules reject an entry
+type InvalidEntryError struct {
+	Content interface{}
+}
+
+func (e *InvalidEntryError) Error() string {
+	return fmt.Sprintf("Invalid entry: %v", e.Content)
+}
+
+// Is reports InvalidEntryErrors as ErrInvalidEntry
+func (e *InvalidEntryError) Is(target error) bool {
+	return target == ErrInvalidEntry
+}
+
+// InvalidLinkError is returned by a nucleus when the application's validateLink function
+// rejects a link
+type InvalidLinkError struct {
+	Base Hash
+	Link Hash
+	Tag  string
+}
+
+func (e *InvalidLinkError) Error() string {
+	return fmt.Sprintf("Invalid link: %v->%v (%s)", e.Base, e.Link, e.Tag)
+}
+
+// Is reports InvalidLinkErrors as ErrInvalidLink
+func (e *InvalidLinkError) Is(target error) bool {
+	return target == ErrInvalidLink
+}
+
+// ForkError is returned when an agent has published two different headers that follow the
+// same header of its chain, i.e. its chain has forked.  Warrant holds the evidence.
+type ForkError struct {
+	Warrant ForkWarrant
+}
+
+func (e *ForkError) Error() string {
+	w := e.Warrant
+	return fmt.Sprintf("chain fork: agent %s published headers %v and %v after %v", peer.IDB58Encode(w.Agent), w.Headers[0], w.Headers[1], w.Prev)
+}
+
+// Is reports ForkErrors as ErrChainFork
+func (e *ForkError) Is(target error) bool {
+	return target == ErrChainFork
+}
+
+// UnknownFunctionError is returned by Call when the zome doesn't expose the function called.
+// Available holds the names of the functions the zome does expose.
+type UnknownFunctionError struct {
+	Zome      string
+	Function  string
+	Available []string
+}
+
+func (e *UnknownFunctionError) Error() string {
+	s := "unknown function: " + e.Function
+	if m := e.Suggestion(); m != "" {
+		s += ", did you mean " + m + "?"
+	}
+	if len(e.Available) == 0 {
+		return fmt.Sprintf("%s (zome %s exposes no functions)", s, e.Zome)
+	}
+	return fmt.Sprintf("%s (zome %s exposes: %s)", s, e.Zome, strings.Join(e.Available, ", "))
+}
+
+// Suggestion returns the available function with the name closest to the one called, or ""
+// if none is close enough for the call to have likely been a typo of it
+func (e *UnknownFunctionError) Suggestion() (name string) {
+	best := len(e.Function)/3 + 1
+	for _, a := range e.Available {
+		if d := editDistance(strings.ToLower(e.Function), strings.ToLower(a)); d < best {
+			best = d
+			name = a
+		}
+	}
+	return
+}
+
+// Is reports UnknownFunctionErrors as ErrUnknownFunction
+func (e *UnknownFunctionError) Is(target error) bool {
+	return target == ErrUnknownFunction
+}
+
+// ValidationError is returned by ValidateEntry when an entry fails either the schema or the
+// application's validation.  Err holds the underlying schema validator or nucleus error.
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports ValidationErrors as ErrValidationFailed
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+// CallError is returned by a nucleus' Call when an exposed function fails because a holochain
+// library function (i.e. commit) returned an error.  Err is the error reported by the
+// scripting engine and Cause is the holochain error that gave rise to it.
+type CallError struct {
+	Function string
+	Err      error
+	Cause    error
+}
+
+func (e *CallError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Cause
+}
+
+// NucleusError is returned by Holochain.Call when an exposed function fails.  Its message is
+// the one reported by the scripting engine; Underlying returns the error without the engine's
+// decoration so that apps can tell validation rejections from scripting faults.
+type NucleusError struct {
+	Zome     string
+	Function string
+	Err      error
+}
+
+func (e *NucleusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NucleusError) Unwrap() error {
+	return e.Err
+}
+
+// nucleusErrorPrefix matches the prefixes scripting engines add to the errors of calls
+var nucleusErrorPrefix = regexp.MustCompile(`^(Zygomys exec error: |Error calling '[^']*': )`)
+
+// Underlying returns the holochain error that caused the call to fail if there was one (i.e.
+// a *ValidationError from commit), otherwise the engine's error with its prefixes removed
+func (e *NucleusError) Underlying() error {
+	var ce *CallError
+	if errors.As(e.Err, &ce) && ce.Cause != nil {
+		return ce.Cause
+	}
+	msg := e.Err.Error()
+	for {
+		m := nucleusErrorPrefix.ReplaceAllString(msg, "")
+		if m == msg {
+			break
+		}
+		msg = m
+	}
+	return errors.New(msg)
+}
+
+// IsValidation reports whether the call failed because an entry didn't validate
+func (e *NucleusError) IsValidation() bool {
+	return errors.Is(e.Err, ErrValidationFailed) || errors.Is(e.Err, ErrInvalidEntry) || errors.Is(e.Err, ErrInvalidLink)
+}
+
+// CallAbortedError is returned by CallWithContext when the context is done before the called
+// function returns.  Err is the context's error.
+type CallAbortedError struct {
+	Err error
+}
+
+func (e *CallAbortedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrCallAborted, e.Err)
+}
+
+func (e *CallAbortedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports CallAbortedErrors as ErrCallAborted
+func (e *CallAbortedError) Is(target error) bool {
+	return target == ErrCallAborted
+}
+
+// ResponseError is returned by Send when the receiving node responds with an error.  If the
+// error is one of the package's sentinel errors Err will be set to it.
+type ResponseError struct {
+	Message string
+	Err     error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("response error: %s", e.Message)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// responseErrors are the sentinel errors which are recovered from error responses
+var responseErrors = []error{
+	ErrHashNotFound,
+	ErrDHTExpectedGetReqInBody,
+	ErrDHTExpectedPutReqInBody,
+	ErrDHTExpectedMetaReqInBody,
+	ErrDHTExpectedMetaQueryInBody,
+	ErrDHTExpectedGossipReqInBody,
+	ErrDHTErrNoGossipersAvailable,
+	ErrDHTExpired,
+	ErrDHTRateLimited,
+	ErrKeyRevoked,
+	ErrEntryPrivate,
+	ErrEntryDeleted,
+}
+
+// newResponseError builds a ResponseError from the body of an error response
+func newResponseError(body interface{}) error {
+	e := ResponseError{Message: fmt.Sprintf("%v", body)}
+	for _, r := range responseErrors {
+		if r.Error() == e.Message {
+			e.Err = r
+			break
+		}
+	}
+	return &e
+}
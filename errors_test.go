@@ -0,0 +1,75 @@
+package holochain
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestInvalidEntryError(t *testing.T) {
+	Convey("it should keep the message format and match ErrInvalidEntry", t, func() {
+		var err error = &InvalidEntryError{Content: "cow"}
+		So(err.Error(), ShouldEqual, "Invalid entry: cow")
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeFalse)
+	})
+}
+
+func TestValidationError(t *testing.T) {
+	Convey("it should report the wrapped error's message and match both sentinels", t, func() {
+		var err error = &ValidationError{Err: &InvalidEntryError{Content: "cow"}}
+		So(err.Error(), ShouldEqual, "Invalid entry: cow")
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+	})
+}
+
+func TestUnknownFunctionError(t *testing.T) {
+	Convey("it should list the available functions and match ErrUnknownFunction", t, func() {
+		var err error = &UnknownFunctionError{Zome: "z", Function: "bogus", Available: []string{"addData", "getDNA"}}
+		So(err.Error(), ShouldEqual, "unknown function: bogus (zome z exposes: addData, getDNA)")
+		So(errors.Is(err, ErrUnknownFunction), ShouldBeTrue)
+		err = &UnknownFunctionError{Zome: "z", Function: "bogus"}
+		So(err.Error(), ShouldEqual, "unknown function: bogus (zome z exposes no functions)")
+	})
+	Convey("it should suggest the closest function for likely typos", t, func() {
+		e := UnknownFunctionError{Zome: "z", Function: "addDta", Available: []string{"addData", "addPrime", "getDNA"}}
+		So(e.Suggestion(), ShouldEqual, "addData")
+		So(e.Error(), ShouldEqual, "unknown function: addDta, did you mean addData? (zome z exposes: addData, addPrime, getDNA)")
+		e.Function = "getdna"
+		So(e.Suggestion(), ShouldEqual, "getDNA")
+		e.Function = "receive"
+		So(e.Suggestion(), ShouldEqual, "")
+	})
+}
+
+func TestResponseError(t *testing.T) {
+	Convey("it should recover known sentinel errors from the response body", t, func() {
+		err := newResponseError(ErrHashNotFound.Error())
+		So(err.Error(), ShouldEqual, "response error: hash not found")
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+	})
+	Convey("it should not match any sentinel for unknown errors", t, func() {
+		err := newResponseError("something bad")
+		So(err.Error(), ShouldEqual, "response error: something bad")
+		So(errors.Is(err, ErrHashNotFound), ShouldBeFalse)
+		var re *ResponseError
+		So(errors.As(err, &re), ShouldBeTrue)
+		So(re.Message, ShouldEqual, "something bad")
+	})
+}
+
+func TestNucleusError(t *testing.T) {
+	Convey("it should strip the scripting engine's decoration from the underlying error", t, func() {
+		ne := &NucleusError{Zome: "z", Function: "f", Err: errors.New("Zygomys exec error: Error calling 'get': hash not found")}
+		So(ne.Error(), ShouldEqual, "Zygomys exec error: Error calling 'get': hash not found")
+		So(ne.Underlying().Error(), ShouldEqual, "hash not found")
+		So(ne.IsValidation(), ShouldBeFalse)
+	})
+	Convey("it should return the cause of a CallError", t, func() {
+		cause := &InvalidEntryError{Content: "cow"}
+		ne := &NucleusError{Zome: "z", Function: "f", Err: &CallError{Function: "f", Err: errors.New("Error calling 'commit': Invalid entry: cow"), Cause: cause}}
+		So(ne.Underlying(), ShouldEqual, cause)
+		So(ne.IsValidation(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,319 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// fuzz implements generating random entries to exercise an application's validation rules
+
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// FuzzResult holds the outcome of validating one generated entry
+type FuzzResult struct {
+	Input      string // the generated entry content
+	Conforming bool   // whether the input was generated to conform to the entry's schema
+	Err        error  // the validation error, nil if the entry validated
+}
+
+// fuzzNonConformingRate is how often (one in) FuzzValidate generates a non-conforming input
+const fuzzNonConformingRate = 4
+
+// FuzzValidate generates n random entries of the given type and runs them through
+// ValidateEntry.  For JSON entries with a schema the schema drives the generation and some
+// of the inputs deliberately don't conform to it.  Other formats get random strings and ints.
+// A schema whose lower bound for a value is above its upper bound is an error.
+func (h *Holochain) FuzzValidate(entryType string, n int) (results []FuzzResult, err error) {
+	_, d, err := h.GetEntryDef(entryType)
+	if err != nil {
+		return
+	}
+	var doc interface{}
+	if d.DataFormat == DataFormatJSON && d.Schema != "" {
+		r := schemaResolver{path: h.path, docs: make(map[string]interface{})}
+		if doc, err = r.load(d.Schema); err != nil {
+			return
+		}
+		if doc, err = r.resolve(doc, d.Schema, true); err != nil {
+			return
+		}
+	}
+
+	p := ValidationProps{AgentID: peer.IDB58Encode(h.id), Role: ValidatingAsAuthor}
+	for i := 0; i < n; i++ {
+		r := FuzzResult{Conforming: true}
+		var content interface{}
+		switch {
+		case doc != nil:
+			g := fuzzGen{root: doc}
+			var v interface{}
+			if i%fuzzNonConformingRate == fuzzNonConformingRate-1 {
+				r.Conforming = false
+				v = g.nonConforming(doc)
+			} else {
+				v = g.value(doc, 0)
+			}
+			if g.err != nil {
+				err = g.err
+				return
+			}
+			var b []byte
+			if b, err = json.Marshal(v); err != nil {
+				return
+			}
+			r.Input = string(b)
+			content = r.Input
+		case d.DataFormat == DataFormatBinary:
+			b := make([]byte, rand.Intn(64))
+			rand.Read(b)
+			r.Input = string(b)
+			content = b
+		case d.DataFormat == DataFormatString:
+			r.Input = fuzzString(0, 12)
+			content = r.Input
+		case d.DataFormat == DataFormatJSON:
+			r.Input = strconv.Quote(fuzzString(0, 12))
+			content = r.Input
+		default:
+			// raw code formats get an int or a quoted string
+			if rand.Intn(2) == 0 {
+				r.Input = strconv.Itoa(rand.Intn(2000) - 1000)
+			} else {
+				r.Input = strconv.Quote(fuzzString(0, 12))
+			}
+			content = r.Input
+		}
+		r.Err = h.ValidateEntry(entryType, &GobEntry{C: content}, &p)
+		results = append(results, r)
+	}
+	return
+}
+
+// fuzzString returns a random string of letters with a length between min and max
+func fuzzString(min int, max int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	l := min
+	if max > min {
+		l += rand.Intn(max - min + 1)
+	}
+	b := make([]byte, l)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// fuzzGen generates values from a JSON schema document
+type fuzzGen struct {
+	root interface{} // the schema document, for resolving local $refs
+	err  error       // set if the schema can't be satisfied
+}
+
+// maxFuzzDepth limits how deeply nested objects and arrays are generated
+const maxFuzzDepth = 4
+
+// schemaNumber returns a numeric keyword of a schema, if it's set
+func schemaNumber(s map[string]interface{}, key string) (n float64, ok bool) {
+	n, ok = s[key].(float64)
+	return
+}
+
+// schemaType returns the type named by a schema, "" if it doesn't name one
+func schemaType(s map[string]interface{}) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		if len(t) > 0 {
+			if n, ok := t[0].(string); ok {
+				return n
+			}
+		}
+	}
+	if _, ok := s["properties"]; ok {
+		return "object"
+	}
+	return ""
+}
+
+// checkBounds records an error if a schema's lower bound is above its upper bound
+func (g *fuzzGen) checkBounds(minKey string, min float64, maxKey string, max float64) bool {
+	if max < min {
+		if g.err == nil {
+			g.err = fmt.Errorf("schema %s %v is greater than %s %v", minKey, min, maxKey, max)
+		}
+		return false
+	}
+	return true
+}
+
+// deref follows a local $ref in a schema
+func (g *fuzzGen) deref(s map[string]interface{}) map[string]interface{} {
+	for i := 0; i < maxFuzzDepth; i++ {
+		ref, ok := s["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			break
+		}
+		v, err := jsonPointer(g.root, ref[1:])
+		if err != nil {
+			break
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		s = m
+	}
+	return s
+}
+
+// value generates a random value conforming to a schema
+func (g *fuzzGen) value(schema interface{}, depth int) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return fuzzString(0, 12)
+	}
+	s = g.deref(s)
+	if enum, ok := s["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[rand.Intn(len(enum))]
+	}
+	switch schemaType(s) {
+	case "object":
+		o := make(map[string]interface{})
+		props, _ := s["properties"].(map[string]interface{})
+		required := make(map[string]bool)
+		if r, ok := s["required"].([]interface{}); ok {
+			for _, name := range r {
+				if n, ok := name.(string); ok {
+					required[n] = true
+				}
+			}
+		}
+		for name, p := range props {
+			if required[name] || (depth < maxFuzzDepth && rand.Intn(2) == 0) {
+				o[name] = g.value(p, depth+1)
+			}
+		}
+		return o
+	case "array":
+		min := 0
+		if n, ok := schemaNumber(s, "minItems"); ok {
+			min = int(n)
+		}
+		max := min + 3
+		if n, ok := schemaNumber(s, "maxItems"); ok {
+			max = int(n)
+		}
+		if !g.checkBounds("minItems", float64(min), "maxItems", float64(max)) {
+			return []interface{}{}
+		}
+		if depth >= maxFuzzDepth {
+			max = min
+		}
+		l := min
+		if max > min {
+			l += rand.Intn(max - min + 1)
+		}
+		a := make([]interface{}, l)
+		for i := range a {
+			a[i] = g.value(s["items"], depth+1)
+		}
+		return a
+	case "integer", "number":
+		min, max := 0.0, 1000.0
+		if n, ok := schemaNumber(s, "minimum"); ok {
+			min = n
+			if _, ok := schemaNumber(s, "maximum"); !ok {
+				max = min + 1000
+			}
+		}
+		if n, ok := schemaNumber(s, "maximum"); ok {
+			max = n
+			if _, ok := schemaNumber(s, "minimum"); !ok {
+				min = max - 1000
+			}
+		}
+		if !g.checkBounds("minimum", min, "maximum", max) {
+			return min
+		}
+		if schemaType(s) == "integer" {
+			return int(min) + rand.Intn(int(max-min)+1)
+		}
+		return min + rand.Float64()*(max-min)
+	case "boolean":
+		return rand.Intn(2) == 0
+	case "null":
+		return nil
+	default:
+		min, max := 0, 12
+		if n, ok := schemaNumber(s, "minLength"); ok {
+			min = int(n)
+			if max < min {
+				max = min + 12
+			}
+		}
+		if n, ok := schemaNumber(s, "maxLength"); ok {
+			max = int(n)
+		}
+		if !g.checkBounds("minLength", float64(min), "maxLength", float64(max)) {
+			return ""
+		}
+		return fuzzString(min, max)
+	}
+}
+
+// nonConforming generates a value that breaks a schema, either by leaving out a required
+// property or by using the wrong type
+func (g *fuzzGen) nonConforming(schema interface{}) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	s = g.deref(s)
+	if schemaType(s) == "object" {
+		o, ok := g.value(s, 0).(map[string]interface{})
+		if !ok {
+			// an enum of objects, so anything that isn't an object breaks it
+			return wrongType("object")
+		}
+		var required []string
+		if r, ok := s["required"].([]interface{}); ok {
+			for _, name := range r {
+				if n, ok := name.(string); ok {
+					required = append(required, n)
+				}
+			}
+		}
+		if len(required) > 0 && rand.Intn(2) == 0 {
+			delete(o, required[rand.Intn(len(required))])
+			return o
+		}
+		// give a property a value of the wrong type
+		props, _ := s["properties"].(map[string]interface{})
+		for name, p := range props {
+			if ps, ok := p.(map[string]interface{}); ok && schemaType(g.deref(ps)) != "" {
+				o[name] = wrongType(schemaType(g.deref(ps)))
+				return o
+			}
+		}
+		if len(required) > 0 {
+			delete(o, required[0])
+			return o
+		}
+	}
+	return wrongType(schemaType(s))
+}
+
+// wrongType returns a value that isn't of the given schema type
+func wrongType(t string) interface{} {
+	if t == "string" {
+		return rand.Intn(1000)
+	}
+	return fuzzString(1, 12)
+}
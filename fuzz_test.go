@@ -0,0 +1,84 @@
+package holochain
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestFuzzValidate(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should generate inputs from the schema of JSON entries", t, func() {
+		results, err := h.FuzzValidate("profile", 20)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 20)
+		var nonConforming int
+		for _, r := range results {
+			var v map[string]interface{}
+			So(json.Unmarshal([]byte(r.Input), &v), ShouldBeNil)
+			if r.Conforming {
+				So(r.Err, ShouldBeNil)
+			} else {
+				nonConforming++
+				So(r.Err, ShouldNotBeNil)
+			}
+		}
+		So(nonConforming, ShouldEqual, 5)
+	})
+
+	Convey("it should run random inputs of raw formats through the validate function", t, func() {
+		results, err := h.FuzzValidate("myData", 10)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 10)
+		for _, r := range results {
+			So(r.Conforming, ShouldBeTrue)
+			So(r.Input, ShouldNotEqual, "")
+		}
+	})
+
+	Convey("it should fail for unknown entry types", t, func() {
+		_, err := h.FuzzValidate("bogusType", 1)
+		So(err.Error(), ShouldEqual, "no definition for entry type: bogusType")
+	})
+
+	Convey("it should generate values for each schema type", t, func() {
+		g := fuzzGen{}
+		schema := map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "integer", "minimum": 5.0, "maximum": 7.0},
+		}
+		for i := 0; i < 10; i++ {
+			for _, v := range g.value(schema, 0).([]interface{}) {
+				So(v, ShouldBeBetweenOrEqual, 5, 7)
+			}
+		}
+		So(g.value(map[string]interface{}{"enum": []interface{}{"a"}}, 0), ShouldEqual, "a")
+		s := g.value(map[string]interface{}{"type": "string", "minLength": 3.0, "maxLength": 3.0}, 0)
+		So(len(s.(string)), ShouldEqual, 3)
+		So(g.err, ShouldBeNil)
+	})
+
+	Convey("it should report bounds that can't be satisfied rather than panic", t, func() {
+		g := fuzzGen{}
+		v := g.value(map[string]interface{}{"type": "integer", "minimum": 7.0, "maximum": 5.0}, 0)
+		So(v, ShouldEqual, 7.0)
+		So(g.err.Error(), ShouldEqual, "schema minimum 7 is greater than maximum 5")
+
+		g = fuzzGen{}
+		g.value(map[string]interface{}{"type": "array", "minItems": 3.0, "maxItems": 1.0}, 0)
+		So(g.err.Error(), ShouldEqual, "schema minItems 3 is greater than maxItems 1")
+	})
+
+	Convey("it should break object schemas that only allow enumerated values", t, func() {
+		g := fuzzGen{}
+		schema := map[string]interface{}{
+			"type": "object",
+			"enum": []interface{}{map[string]interface{}{"a": 1.0}},
+		}
+		v := g.nonConforming(schema)
+		_, isObject := v.(map[string]interface{})
+		So(isObject, ShouldBeFalse)
+	})
+}
@@ -9,6 +9,8 @@ package holochain
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	"io"
 	"time"
@@ -18,6 +20,29 @@ type Signature struct {
 	S []byte
 }
 
+// Actions of a header's Change
+const (
+	ModAction = "mod" // the header's entry supersedes the changed entry
+	DelAction = "del" // the header marks the changed entry as deleted
+)
+
+// StatusChange records that a header's entry modifies or deletes an earlier entry
+type StatusChange struct {
+	Action string // ModAction or DelAction, empty if the header changes nothing
+	Hash   Hash   // hash of the changed entry
+}
+
+// changeActions maps the actions to their codes in marshaled headers
+var changeActions = []string{"", ModAction, DelAction}
+
+// metaChangeFlag is set in the length of a marshaled header's meta section when the
+// schema link is followed by a change
+const metaChangeFlag = uint64(1) << 63
+
+// metaBackdatedFlag is set in the length of a marshaled header's meta section when the
+// header is backdated
+const metaBackdatedFlag = uint64(1) << 62
+
 // Header holds chain links, type, timestamp and signature
 type Header struct {
 	Type       string
@@ -26,6 +51,9 @@ type Header struct {
 	EntryLink  Hash // link to entry
 	TypeLink   Hash // link to header of previous header of this type
 	Sig        Signature
+	SchemaLink Hash         // hash of the schema the entry was validated against, if its type has one
+	Change     StatusChange // the earlier entry this header's entry modifies or deletes, if any
+	Backdated  bool         // the timestamp was supplied by the author (see CommitAt) rather than taken from the clock
 	//	Meta       interface{}
 }
 
@@ -117,12 +145,50 @@ func MarshalHeader(writer io.Writer, hd *Header) (err error) {
 		return
 	}
 
-	// write out 0 for future expansion (meta)
+	// the meta section is the length of the schema link followed by the link, the length
+	// being 0 when there is no link.  If the header has a change the length is flagged and
+	// the link is followed by the action's code and the changed hash, so headers without a
+	// change are marshaled as they were before changes existed.  Backdating is just a flag.
 	z := uint64(0)
-	err = binary.Write(writer, binary.LittleEndian, &z)
+	if hd.SchemaLink.H != nil && !hd.SchemaLink.IsNullHash() {
+		z = uint64(len(hd.SchemaLink.H))
+	}
+	var action uint8
+	if hd.Change.Action != "" {
+		for i, a := range changeActions {
+			if a == hd.Change.Action {
+				action = uint8(i)
+			}
+		}
+		if action == 0 {
+			err = fmt.Errorf("unknown change action: %s", hd.Change.Action)
+			return
+		}
+	}
+	flagged := z
+	if action != 0 {
+		flagged |= metaChangeFlag
+	}
+	if hd.Backdated {
+		flagged |= metaBackdatedFlag
+	}
+	err = binary.Write(writer, binary.LittleEndian, &flagged)
 	if err != nil {
 		return
 	}
+	if z > 0 {
+		err = binary.Write(writer, binary.LittleEndian, []byte(hd.SchemaLink.H))
+		if err != nil {
+			return
+		}
+	}
+	if action != 0 {
+		err = binary.Write(writer, binary.LittleEndian, action)
+		if err != nil {
+			return
+		}
+		err = hd.Change.Hash.MarshalHash(writer)
+	}
 	return
 }
 
@@ -180,6 +246,34 @@ func UnmarshalHeader(reader io.Reader, hd *Header, hashSize int) (err error) {
 	if err != nil {
 		return
 	}
+	changed := z&metaChangeFlag != 0
+	hd.Backdated = z&metaBackdatedFlag != 0
+	z &^= metaChangeFlag | metaBackdatedFlag
+	if z > 0 {
+		if z > uint64(hashSize)*2 {
+			err = errors.New("header meta too long")
+			return
+		}
+		b = make([]byte, z)
+		err = binary.Read(reader, binary.LittleEndian, b)
+		if err != nil {
+			return
+		}
+		hd.SchemaLink.H = b
+	}
+	if changed {
+		var action uint8
+		err = binary.Read(reader, binary.LittleEndian, &action)
+		if err != nil {
+			return
+		}
+		if action == 0 || int(action) >= len(changeActions) {
+			err = fmt.Errorf("unknown change action code: %d", action)
+			return
+		}
+		hd.Change.Action = changeActions[action]
+		err = hd.Change.Hash.UnmarshalHash(reader)
+	}
 	return
 }
 
@@ -41,6 +41,63 @@ func TestMarshalHeader(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(fmt.Sprintf("%v", nh), ShouldEqual, fmt.Sprintf("%v", *hd))
 	})
+
+	Convey("it should round-trip a schema link", t, func() {
+		var sh Hash
+		sh.Sum(h, []byte("some schema"))
+		hd.SchemaLink = sh
+		b, err := hd.Marshal()
+		So(err, ShouldBeNil)
+		var nh Header
+		err = (&nh).Unmarshal(b, 34)
+		So(err, ShouldBeNil)
+		So(nh.SchemaLink.String(), ShouldEqual, sh.String())
+		So(fmt.Sprintf("%v", nh), ShouldEqual, fmt.Sprintf("%v", *hd))
+	})
+
+	Convey("it should round-trip a change", t, func() {
+		var ch Hash
+		ch.Sum(h, []byte("some changed entry"))
+		for _, action := range []string{ModAction, DelAction} {
+			hd.Change = StatusChange{Action: action, Hash: ch}
+			b, err := hd.Marshal()
+			So(err, ShouldBeNil)
+			var nh Header
+			err = (&nh).Unmarshal(b, 34)
+			So(err, ShouldBeNil)
+			So(nh.Change.Action, ShouldEqual, action)
+			So(nh.Change.Hash.String(), ShouldEqual, ch.String())
+			So(fmt.Sprintf("%v", nh), ShouldEqual, fmt.Sprintf("%v", *hd))
+		}
+
+		hd.Change = StatusChange{Action: "bogus", Hash: ch}
+		_, err := hd.Marshal()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should round-trip the backdated flag", t, func() {
+		hd.Backdated = true
+		b, err := hd.Marshal()
+		So(err, ShouldBeNil)
+		var nh Header
+		err = (&nh).Unmarshal(b, 34)
+		So(err, ShouldBeNil)
+		So(nh.Backdated, ShouldBeTrue)
+		So(fmt.Sprintf("%v", nh), ShouldEqual, fmt.Sprintf("%v", *hd))
+		hd.Backdated = false
+	})
+
+	Convey("a header without a change should marshal as it did before changes", t, func() {
+		hd.Change = StatusChange{}
+		hd.SchemaLink = Hash{}
+		b, err := hd.Marshal()
+		So(err, ShouldBeNil)
+		So(b[len(b)-8:], ShouldResemble, make([]byte, 8))
+		var nh Header
+		err = (&nh).Unmarshal(b, 34)
+		So(err, ShouldBeNil)
+		So(nh.Change.Action, ShouldEqual, "")
+	})
 }
 
 func TestMarshalSignature(t *testing.T) {
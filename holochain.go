@@ -8,6 +8,9 @@ package holochain
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
@@ -20,10 +23,15 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,6 +43,26 @@ type AgentEntry struct {
 	Name    AgentName
 	KeyType KeytypeType
 	Key     []byte // marshaled public key
+	PrevKey []byte // marshaled public key this entry replaces (empty for the genesis agent entry)
+}
+
+// RevocationEntry structure for building RevocationEntryType entries
+type RevocationEntry struct {
+	Key    []byte // marshaled public key being revoked
+	Reason string
+}
+
+// LinkEntry structure for building LinkEntryType entries
+type LinkEntry struct {
+	Base string // hash of the entry being linked from
+	Link string // hash of the entry being linked to
+	Tag  string
+}
+
+// DelEntry structure for building DelEntryType entries
+type DelEntry struct {
+	Hash    string // hash of the entry being deleted
+	Message string
 }
 
 // Zome struct encapsulates logically related code, from "chromosome"
@@ -42,6 +70,7 @@ type Zome struct {
 	Name        string
 	Description string
 	Code        string // file name of DNA code
+	CodeSource  string // the code itself, if set it is used instead of the Code file
 	CodeHash    Hash
 	Entries     map[string]EntryDef
 	NucleusType string
@@ -59,11 +88,24 @@ type Loggers struct {
 
 // Config holds the non-DNA configuration for a holo-chain
 type Config struct {
-	Port            int
-	PeerModeAuthor  bool
-	PeerModeDHTNode bool
-	BootstrapServer string
-	Loggers         Loggers
+	Port                 int
+	PeerModeAuthor       bool
+	PeerModeDHTNode      bool
+	BootstrapServer      BootstrapServers // tried in order for finding peers, all are announced to
+	Loggers              Loggers
+	SkipHashCheck        bool     // don't verify code and schema files against the DNA hashes (for active development)
+	Persister            string   // name of the registered persister used for the local store (i.e. "bolt", "memory", or "sqlite" in builds with the sqlite tag), empty means DefaultPersisterName
+	MaxEntrySize         int      // largest entry in bytes, 0 means DefaultMaxEntrySize
+	MaxPutsPerSecond     int      // per-peer limit on puts handled by the DHT, 0 means DefaultMaxPutsPerSecond
+	MaxPutBytesPerSecond int      // per-peer limit on put bytes, 0 means DefaultMaxPutBytesPerSecond
+	GetMaxAttempts       int      // how many rounds of asking the nodes responsible for a hash a get that isn't found makes, 0 means DefaultGetMaxAttempts
+	GetRetryDelay        int      // milliseconds between a get's first and second rounds, doubled each round (or longest wait for a queued put on this node), 0 means DefaultGetRetryDelay
+	Transports           []string // transports to listen on (i.e. "tcp", "ws" or any added with RegisterTransport) from Port upwards, empty means DefaultTransports
+	DataPath             string   // directory for the chain store and DHT files, relative to the chain's path; empty means the chain's path
+	EncryptStores        bool     // encrypt the chain store and the DHT's entries, headers, meta-data and gossip at rest with a key derived from the agent's private key, DHT keys (i.e. hashes and link tags), entry types, statuses and fork records stay in the clear
+	ReplicationFactor    int      // how many of the nodes nearest to a put's hash it is sent to, 0 means DefaultReplicationFactor
+	SweepInterval        int      // seconds between removals of expired puts from the DHT, 0 means DefaultSweepInterval
+	KeyType              string   // key type the chain's agent must use (i.e. "IPFS"), empty means any the DNA allows
 }
 
 // Holochain struct holds the full "DNA" of the holochain
@@ -74,7 +116,8 @@ type Holochain struct {
 	Properties       map[string]string
 	PropertiesSchema string
 	HashType         string
-	BasedOn          Hash // holochain hash for base schemas and code
+	RequiredKeyType  string // if set, agents must use keys of this type (i.e. "IPFS")
+	BasedOn          Hash   // holochain hash for base schemas and code
 	Zomes            map[string]*Zome
 	//---- private values not serialized; initialized on Load
 	id             peer.ID // this is hash of the id, also used in the node
@@ -83,11 +126,63 @@ type Holochain struct {
 	path           string
 	agent          Agent
 	encodingFormat string
+	compressDNA    bool // if set the DNA file is saved gzip compressed
 	hashSpec       HashSpec
 	config         Config
+	envRestore     func(*Config) // puts back the config values overridden from the environment, so they aren't saved
+	storeKey       []byte        // key the chain store and DHT are encrypted with, nil if they aren't
 	dht            *DHT
 	node           *Node
-	chain          *Chain // the chain itself
+	chain          *Chain                 // the chain itself
+	now            func() time.Time       // clock used to timestamp entries, time.Now if nil
+	interfaces     map[string][]Interface // cache of the exposed functions of each zome
+	nuclei         map[*Zome]*nucleusPool // compiled nuclei of each zome that aren't in use, protected by nucleiLock
+	sim            *simNet                // routes messages between the holochains of a multi-agent test
+	commitHooks    *commitHooks           // Go functions called around each commit, protected by commitHooksLock
+}
+
+// nucleiLock protects the nuclei pools of all holochains.  It's not a field of Holochain
+// because Holochains are passed around by value while they are being built.
+var nucleiLock sync.Mutex
+
+// nucleusPool holds the compiled nuclei of a zome that aren't running so that they can be
+// reused rather than recompiled for each call
+type nucleusPool struct {
+	code   string // the code the nuclei were compiled from
+	idle   []Nucleus
+	pinned bool // set by ReloadZome: code is used instead of re-reading the zome's code
+}
+
+// interfacesLock protects the cached interfaces of all holochains, for the same reason as nucleiLock
+var interfacesLock sync.Mutex
+
+// commitHooksLock protects the commit hooks of all holochains, for the same reason as nucleiLock
+var commitHooksLock sync.Mutex
+
+// BeforeCommitFn is the type of function called before an entry is added to the chain.  If
+// it returns an error the entry isn't added and the commit fails with the error.
+type BeforeCommitFn func(entryType string, entry Entry) error
+
+// AfterCommitFn is the type of function called after an entry has been added to the chain,
+// with the hash of the entry's header and the header
+type AfterCommitFn func(hash Hash, header *Header)
+
+// commitHooks holds the functions registered with OnBeforeCommit and OnAfterCommit, in the
+// order they were registered
+type commitHooks struct {
+	next   int
+	before []beforeCommitHook
+	after  []afterCommitHook
+}
+
+type beforeCommitHook struct {
+	id int
+	fn BeforeCommitFn
+}
+
+type afterCommitHook struct {
+	id int
+	fn AfterCommitFn
 }
 
 var debugLog Logger
@@ -113,6 +208,9 @@ func Infof(m string, args ...interface{}) {
 func Register() {
 	gob.Register(Header{})
 	gob.Register(AgentEntry{})
+	gob.Register(RevocationEntry{})
+	gob.Register(LinkEntry{})
+	gob.Register(DelEntry{})
 	gob.Register(Hash{})
 	gob.Register(PutReq{})
 	gob.Register(GetReq{})
@@ -125,6 +223,8 @@ func Register() {
 	gob.Register(GobEntry{})
 	gob.Register(MetaQueryResp{})
 	gob.Register(MetaEntry{})
+	gob.Register(AppMsg{})
+	gob.Register(PingReq{})
 
 	RegisterBultinNucleii()
 	RegisterBultinPersisters()
@@ -135,6 +235,19 @@ func Register() {
 	rand.Seed(time.Now().Unix()) // initialize global pseudo random generator
 }
 
+// CompressedDNASuffix is appended to the DNA file name of gzip compressed DNA
+const CompressedDNASuffix = ".gz"
+
+// splitDNAFormat separates the encoding format from the compression suffix of a DNA format
+// as returned by findDNA, i.e. "json.gz"
+func splitDNAFormat(format string) (encoding string, compressed bool) {
+	encoding = strings.TrimSuffix(format, CompressedDNASuffix)
+	compressed = encoding != format
+	return
+}
+
+// findDNA returns the format of the DNA file in path, with the compression suffix if the DNA
+// is gzip compressed
 func findDNA(path string) (f string, err error) {
 	p := path + "/" + DNAFileName
 	matches, err := filepath.Glob(p + ".*")
@@ -142,9 +255,8 @@ func findDNA(path string) (f string, err error) {
 		return
 	}
 	for _, fn := range matches {
-		s := strings.Split(fn, ".")
-		f = s[len(s)-1]
-		if f == "json" || f == "yaml" || f == "toml" {
+		f = strings.TrimPrefix(fn, p+".")
+		if e, _ := splitDNAFormat(f); isEncodingFormat(e) {
 			break
 		}
 		f = ""
@@ -157,6 +269,16 @@ func findDNA(path string) (f string, err error) {
 	return
 }
 
+// NewPersister creates the persister selected by the holochain's config for its local store
+func (h *Holochain) NewPersister() (p Persister, err error) {
+	ptype := h.config.Persister
+	if ptype == "" {
+		ptype = DefaultPersisterName
+	}
+	p, err = CreatePersister(ptype, h.DataPath()+"/"+StoreFileName+".db")
+	return
+}
+
 // IsConfigured checks a directory for correctly set up holochain configuration files
 func (s *Service) IsConfigured(name string) (f string, err error) {
 	path := s.Path + "/" + name
@@ -183,6 +305,9 @@ func (s *Service) Load(name string) (h *Holochain, err error) {
 		return
 	}
 	h, err = s.load(name, f)
+	if err == nil {
+		s.track(name, h)
+	}
 	return
 }
 
@@ -217,14 +342,25 @@ func NewHolochain(agent Agent, path string, format string, zomes ...Zome) Holoch
 }
 
 // DecodeDNA decodes a Holochain structure from an io.Reader
+// If the format has the CompressedDNASuffix the reader is gunzipped first.
 func DecodeDNA(reader io.Reader, format string) (hP *Holochain, err error) {
 	var h Holochain
+	format, compressed := splitDNAFormat(format)
+	if compressed {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(reader); err != nil {
+			return
+		}
+		defer zr.Close()
+		reader = zr
+	}
 	err = Decode(reader, format, &h)
 	if err != nil {
 		return
 	}
 	hP = &h
 	hP.encodingFormat = format
+	hP.compressDNA = compressed
 
 	return
 }
@@ -244,7 +380,7 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 	h.path = path
-	h.encodingFormat = format
+	format = h.encodingFormat
 
 	// load the config
 	f, err = os.Open(path + "/" + ConfigFileName + "." + format)
@@ -256,9 +392,15 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	if err != nil {
 		return
 	}
+	if h.envRestore, err = h.config.applyEnvOverrides(name); err != nil {
+		return
+	}
 	if err = h.setupConfig(); err != nil {
 		return
 	}
+	if err = os.MkdirAll(h.DataPath(), os.ModePerm); err != nil {
+		return
+	}
 
 	// try and get the agent from the holochain instance
 	agent, err := LoadAgent(path)
@@ -291,7 +433,12 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, path+"/"+StoreFileName+".dat")
+	if h.config.EncryptStores {
+		if h.storeKey, err = StoreKey(agent); err != nil {
+			return
+		}
+	}
+	h.chain, err = NewEncryptedChainFromFile(h.hashSpec, h.DataPath()+"/"+StoreFileName+".dat", h.storeKey)
 	if err != nil {
 		return
 	}
@@ -299,7 +446,7 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	// if the chain has been started there should be a DNAHashFile which
 	// we can load to check against the actual hash of the DNA entry
 	var b []byte
-	b, err = readFile(h.path, DNAHashFileName)
+	b, err = readFile(h.DataPath(), DNAHashFileName)
 	if err == nil {
 		h.dnaHash, err = NewHash(string(b))
 		if err != nil {
@@ -308,8 +455,10 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		// @TODO compare value from file to actual hash
 	}
 
-	if h.chain.Length() > 0 {
-		h.agentHash = h.chain.Headers[1].EntryLink
+	// the agent entry is the most recent one, which may be later than genesis if
+	// the key has been rotated
+	if _, hd := h.chain.TopType(AgentEntryType); hd != nil {
+		h.agentHash = hd.EntryLink
 	}
 	if err = h.Prepare(); err != nil {
 		return
@@ -340,21 +489,47 @@ func (h *Holochain) PrepareHashType() (err error) {
 // Prepare sets up a holochain to run by:
 // validating the DNA, loading the schema validators, setting up a Network node and setting up the DHT
 func (h *Holochain) Prepare() (err error) {
+	if err = h.prepareZomes(); err != nil {
+		return
+	}
+
+	h.dht = NewDHT(h)
+	err = h.dht.checkStore()
+
+	return
+}
+
+// prepareZomes validates the DNA and loads the schema validators
+func (h *Holochain) prepareZomes() (err error) {
 
 	if err = h.PrepareHashType(); err != nil {
 		return
 	}
-	for zomeType, z := range h.Zomes {
+	if !h.config.SkipHashCheck {
+		if err = h.VerifyDNAHashes(); err != nil {
+			return
+		}
+	}
+	if err = h.validateProperties(h.Properties); err != nil {
+		return
+	}
+	if err = h.checkEntryTypes(); err != nil {
+		return
+	}
+	for _, z := range h.Zomes {
 		var n Nucleus
-		n, err = h.MakeNucleus(zomeType)
+		var release func(error)
+		n, release, err = h.acquireNucleus(z)
 		if err != nil {
 			return
 		}
-		if err = n.ChainRequires(); err != nil {
+		err = n.ChainRequires()
+		release(err)
+		if err != nil {
 			return
 		}
 
-		if !fileExists(h.path + "/" + z.Code) {
+		if z.CodeSource == "" && !fileExists(h.path+"/"+z.Code) {
 			return errors.New("DNA specified code file missing: " + z.Code)
 		}
 		for k := range z.Entries {
@@ -368,37 +543,156 @@ func (h *Holochain) Prepare() (err error) {
 						if err = e.BuildJSONSchemaValidator(h.path); err != nil {
 							return err
 						}
+						if err = h.archiveSchema(&e); err != nil {
+							return err
+						}
 						z.Entries[k] = e
 					}
 				}
 			}
 		}
 	}
+	return
+}
 
-	h.dht = NewDHT(h)
+// checkEntryTypes returns an ErrDuplicateEntryType error if an entry type is defined in more
+// than one zome, because entries are committed and validated by type name alone
+func (h *Holochain) checkEntryTypes() (err error) {
+	zomes := make([]string, 0, len(h.Zomes))
+	for name := range h.Zomes {
+		zomes = append(zomes, name)
+	}
+	sort.Strings(zomes)
+	defined := make(map[string]string)
+	for _, name := range zomes {
+		types := make([]string, 0, len(h.Zomes[name].Entries))
+		for t := range h.Zomes[name].Entries {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			if other, ok := defined[t]; ok {
+				err = fmt.Errorf("%w: %s is defined in zomes %s and %s", ErrDuplicateEntryType, t, other, name)
+				return
+			}
+			defined[t] = name
+		}
+	}
+	return
+}
 
+// VerifyDNAHashes checks that the code and schema files on disk match the hashes in the DNA
+// files for which the DNA has no hash are not checked
+func (h *Holochain) VerifyDNAHashes() (err error) {
+	mismatches := make([]string, 0)
+	check := func(name string, hash *Hash, read func() ([]byte, error)) error {
+		if hash.String() == "" || hash.IsNullHash() {
+			return nil
+		}
+		b, err := read()
+		if err != nil {
+			return err
+		}
+		var actual Hash
+		if err = actual.Sum(h.hashSpec, b); err != nil {
+			return err
+		}
+		if !actual.Equal(hash) {
+			mismatches = append(mismatches, name)
+		}
+		return nil
+	}
+	zomeNames := make([]string, 0, len(h.Zomes))
+	for zomeName := range h.Zomes {
+		zomeNames = append(zomeNames, zomeName)
+	}
+	sort.Strings(zomeNames)
+	for _, zomeName := range zomeNames {
+		z := h.Zomes[zomeName]
+		name := z.Code
+		if z.CodeSource != "" {
+			name = "inline code of zome " + zomeName
+		}
+		if err = check(name, &z.CodeHash, func() ([]byte, error) { return h.zomeCode(z) }); err != nil {
+			return
+		}
+		entryNames := make([]string, 0, len(z.Entries))
+		for entryName := range z.Entries {
+			entryNames = append(entryNames, entryName)
+		}
+		sort.Strings(entryNames)
+		for _, entryName := range entryNames {
+			e := z.Entries[entryName]
+			if e.Schema != "" {
+				if err = check(e.Schema, &e.SchemaHash, func() ([]byte, error) { return readFile(h.path, e.Schema) }); err != nil {
+					return
+				}
+			}
+		}
+	}
+	if len(mismatches) > 0 {
+		err = mkErr("DNA hash mismatch for: " + strings.Join(mismatches, ", "))
+	}
 	return
 }
 
-// Activate fires up the holochain node
+// LocalOnly returns true if the holochain is configured neither to author to nor to serve
+// the DHT.  Such a holochain runs without a node: puts are skipped, gets are answered from
+// the local chain, and meta data queries return the links committed to the local chain.
+func (h *Holochain) LocalOnly() bool {
+	return !h.config.PeerModeAuthor && !h.config.PeerModeDHTNode
+}
+
+// Activate fires up the holochain node, unless the holochain is LocalOnly
 func (h *Holochain) Activate() (err error) {
-	listenaddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", h.config.Port)
-	h.node, err = NewNode(listenaddr, h.id, h.Agent().PrivKey())
+	if h.LocalOnly() {
+		Debug("both peer modes are disabled so running local only without a node")
+		return
+	}
+	var addrs []string
+	if addrs, err = TransportListenAddrs(h.config.Transports, h.config.Port); err != nil {
+		return
+	}
+	h.node, err = NewNodeWithAddrs(addrs, h.id, h.Agent().PrivKey())
+	if err != nil {
+		return
+	}
+	err = h.startNode(true)
+	return
+}
+
+// ActivateOnMockNetwork fires up the holochain node on a MockNetwork rather than on the
+// configured transports, unless the holochain is LocalOnly.  The bootstrap server isn't used,
+// as the node is connected to all the other nodes on the MockNetwork.
+func (h *Holochain) ActivateOnMockNetwork(mn *MockNetwork) (err error) {
+	if h.LocalOnly() {
+		Debug("both peer modes are disabled so running local only without a node")
+		return
+	}
+	h.node, err = mn.NewNode(h.id, h.Agent().PrivKey())
 	if err != nil {
 		return
 	}
+	err = h.startNode(false)
+	return
+}
 
+// startNode starts the protocols of the holochain's node, registering with the bootstrap
+// server if bootstrap is true
+func (h *Holochain) startNode(bootstrap bool) (err error) {
 	if h.config.PeerModeDHTNode {
 		if err = h.dht.StartDHT(); err != nil {
 			return
 		}
-		e := h.BSpost()
-		if e != nil {
-			h.dht.dlog.Logf("error in BSpost: %s", e.Error())
-		}
-		e = h.BSget()
-		if e != nil {
-			h.dht.dlog.Logf("error in BSget: %s", e.Error())
+		if bootstrap {
+			e := h.BSpost()
+			if e != nil {
+				h.dht.dlog.Logf("error in BSpost: %s", e.Error())
+			}
+			e = h.BSget()
+			if e != nil {
+				h.dht.dlog.Logf("error in BSget: %s", e.Error())
+			}
 		}
 	}
 	if h.config.PeerModeAuthor {
@@ -406,6 +700,98 @@ func (h *Holochain) Activate() (err error) {
 			return
 		}
 	}
+	if err = h.node.StartApp(h); err != nil {
+		return
+	}
+	if err = h.node.StartPing(h); err != nil {
+		return
+	}
+	return
+}
+
+// Status summarizes the runtime state of a holochain
+type Status struct {
+	Started     bool
+	ChainLength int
+	DNAHash     string
+	AgentHash   string
+	DHTEntries  int
+	Peers       int    // number of connected peers, 0 if not activated
+	ListenAddr  string // the node's address, empty if not activated
+}
+
+// Status gathers the runtime state of the holochain from its chain, DHT and node.
+// It is safe to call before Activate.
+func (h *Holochain) Status() (s Status) {
+	s.Started = h.Started()
+	if h.chain != nil {
+		s.ChainLength = h.chain.Length()
+	}
+	s.DNAHash = h.dnaHash.String()
+	s.AgentHash = h.agentHash.String()
+	if h.dht != nil {
+		s.DHTEntries, _ = h.dht.Count()
+	}
+	if h.node != nil {
+		s.Peers = len(h.node.Peers())
+		if h.node.NetAddr != nil {
+			s.ListenAddr = h.node.NetAddr.String()
+		}
+	}
+	return
+}
+
+// OnPeerConnect registers a function to be called when a peer connects to this holochain's node
+// It must be called after Activate.  The returned function unregisters the callback.
+func (h *Holochain) OnPeerConnect(fn PeerFn) (unsubscribe func(), err error) {
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
+	unsubscribe = h.node.OnConnect(fn)
+	return
+}
+
+// OnPeerDisconnect registers a function to be called when a peer disconnects from this
+// holochain's node.  It must be called after Activate.  The returned function unregisters
+// the callback.
+func (h *Holochain) OnPeerDisconnect(fn PeerFn) (unsubscribe func(), err error) {
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
+	unsubscribe = h.node.OnDisconnect(fn)
+	return
+}
+
+// WaitForPeers blocks until this holochain's node has at least min connected peers, or
+// returns the context's error if it is done first.  It must be called after Activate.
+func (h *Holochain) WaitForPeers(ctx context.Context, min int) (err error) {
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
+	changed := make(chan struct{}, 1)
+	signal := func(id peer.ID) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	// subscribe before counting so that no connection can be missed in between
+	unsubscribeConnect := h.node.OnConnect(signal)
+	defer unsubscribeConnect()
+	unsubscribeDisconnect := h.node.OnDisconnect(signal)
+	defer unsubscribeDisconnect()
+
+	for len(h.node.Peers()) < min {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
 	return
 }
 
@@ -429,11 +815,49 @@ func (h *Holochain) Path() string {
 	return h.path
 }
 
+// DataPath returns the directory holding the chain store and DHT files, which is the
+// holochain's path unless the config sets another one so the DNA can be kept read-only
+func (h *Holochain) DataPath() string {
+	p := h.config.DataPath
+	if p == "" {
+		return h.path
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(h.path, p)
+	}
+	return p
+}
+
 // DNAHash returns the hash of the DNA entry which is also the holochain ID
 func (h *Holochain) DNAHash() (id Hash) {
 	return h.dnaHash.Clone()
 }
 
+// dnaEntry returns the entry that holds the encoded DNA in the genesis of the chain
+func (h *Holochain) dnaEntry() (e GobEntry, err error) {
+	var buf bytes.Buffer
+	if err = h.EncodeDNA(&buf); err != nil {
+		return
+	}
+	e = GobEntry{C: buf.Bytes()}
+	return
+}
+
+// DNAHashOf returns the hash that the DNA of h will have when its chain is generated.  It
+// doesn't need the chain to be started so it can be used to check that two installations of
+// an application have identical DNA.
+func DNAHashOf(h *Holochain) (hash Hash, err error) {
+	if err = h.PrepareHashType(); err != nil {
+		return
+	}
+	var e GobEntry
+	if e, err = h.dnaEntry(); err != nil {
+		return
+	}
+	hash, err = e.Sum(h.hashSpec)
+	return
+}
+
 // AgentHash returns the hash of the Agent entry
 func (h *Holochain) Agenthash() (id Hash) {
 	return h.agentHash.Clone()
@@ -446,14 +870,115 @@ func (h *Holochain) Top() (top Hash, err error) {
 	return
 }
 
+// Length returns the number of entries on the local chain
+func (h *Holochain) Length() int {
+	return h.chain.Length()
+}
+
+// CountType returns the number of entries of the given type on the local chain
+func (h *Holochain) CountType(entryType string) int {
+	return h.chain.CountType(entryType)
+}
+
+// QueryResult holds an entry found by Query
+type QueryResult struct {
+	Hash  string
+	Entry interface{}
+}
+
+// Query returns this agent's entries of the given type from the local chain, oldest first.
+// The content of JSON entries is included as JSON rather than as a string.
+func (h *Holochain) Query(entryType string) (results []QueryResult, err error) {
+	var isJSON bool
+	if _, d, e := h.GetEntryDef(entryType); e == nil {
+		isJSON = d.DataFormat == DataFormatJSON || d.DataFormat == DataFormatURI
+	}
+	err = h.chain.WalkType(entryType, func(key *Hash, header *Header, entry Entry) error {
+		r := QueryResult{Hash: header.EntryLink.String(), Entry: entry.Content()}
+		if s, ok := r.Entry.(string); ok && isJSON {
+			r.Entry = json.RawMessage(s)
+		}
+		results = append([]QueryResult{r}, results...)
+		return nil
+	})
+	return
+}
+
+// QueryJSON returns the results of Query as a JSON array
+func (h *Holochain) QueryJSON(entryType string) (j []byte, err error) {
+	var results []QueryResult
+	if results, err = h.Query(entryType); err != nil {
+		return
+	}
+	if results == nil {
+		results = []QueryResult{}
+	}
+	j, err = json.Marshal(results)
+	return
+}
+
+// Now returns the current time according to the holochain's clock
+func (h *Holochain) Now() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// SetClock replaces the clock used to timestamp entries (and expire them from the DHT), i.e.
+// so that tests can freeze time and get reproducible hashes.  A nil clock restores time.Now.
+func (h *Holochain) SetClock(now func() time.Time) {
+	h.now = now
+}
+
 // Started returns true if the chain has been gened
 func (h *Holochain) Started() bool {
 	return h.DNAHash().String() != ""
 }
 
+// Resume confirms that a chain started in a previous run and reloaded from its file is
+// consistent, so that new entries can be added to it: the first entry must be the DNA
+// entry matching the DNA hash file, the top header's hash must match, and the agent's key
+// must be the one in the latest AgentEntry.
+func (h *Holochain) Resume() (err error) {
+	if !h.Started() {
+		err = mkErr("chain not started")
+		return
+	}
+	c := h.chain
+	l := len(c.Headers)
+	if l == 0 || c.Headers[0].Type != DNAEntryType || !c.Headers[0].EntryLink.Equal(&h.dnaHash) {
+		err = mkErr("chain doesn't start with the DNA")
+		return
+	}
+	var hash Hash
+	if hash, _, err = c.Headers[l-1].Sum(h.hashSpec); err != nil {
+		return
+	}
+	if !hash.Equal(&c.Hashes[l-1]) {
+		err = mkErr("top header hash doesn't match")
+		return
+	}
+	i, ok := c.TypeTops[AgentEntryType]
+	if !ok {
+		err = mkErr("chain has no agent entry")
+		return
+	}
+	var key ic.PubKey
+	if key, err = agentEntryKey(c.Entries[i]); err != nil {
+		return
+	}
+	if !ic.KeyEqual(key, h.agent.PrivKey().GetPublic()) {
+		err = mkErr("agent key doesn't match the chain's agent entry")
+		return
+	}
+	return
+}
+
 // GenChain establishes a holochain instance by creating the initial genesis entries in the chain
 // It assumes a properly set up .holochain sub-directory with a config file and
 // keys for signing.  See GenDev()
+// If genesis fails the chain, DNA hash file and DHT are cleaned up so that GenChain can be re-run
 func (h *Holochain) GenChain() (headerHash Hash, err error) {
 
 	if h.Started() {
@@ -461,50 +986,28 @@ func (h *Holochain) GenChain() (headerHash Hash, err error) {
 		return
 	}
 
-	defer func() {
-		if err != nil {
-			panic("cleanup after failed gen not implemented!  Error was: " + err.Error())
-		}
-	}()
-
-	if err = h.Prepare(); err != nil {
+	if err = h.checkAgentKeyType(h.agent); err != nil {
 		return
 	}
 
-	var buf bytes.Buffer
-	err = h.EncodeDNA(&buf)
-
-	e := GobEntry{C: buf.Bytes()}
-
-	var dnaHeader *Header
-	_, dnaHeader, err = h.NewEntry(time.Now(), DNAEntryType, &e)
-	if err != nil {
+	if err = h.Prepare(); err != nil {
 		return
 	}
 
-	h.dnaHash = dnaHeader.EntryLink.Clone()
-
-	var k AgentEntry
-	k.Name = h.agent.Name()
-	k.KeyType = h.agent.KeyType()
-
-	pk := h.agent.PrivKey().GetPublic()
-
-	k.Key, err = ic.MarshalPublicKey(pk)
-	if err != nil {
-		return
-	}
+	defer func() {
+		if err != nil {
+			if e := h.cleanupGen(); e != nil {
+				err = fmt.Errorf("%s (cleanup after failed gen also failed: %s)", err.Error(), e.Error())
+			}
+		}
+	}()
 
-	e.C = k
-	var agentHeader *Header
-	headerHash, agentHeader, err = h.NewEntry(time.Now(), AgentEntryType, &e)
+	headerHash, err = h.addGenesisEntries()
 	if err != nil {
 		return
 	}
 
-	h.agentHash = agentHeader.EntryLink
-
-	if err = writeFile(h.path, DNAHashFileName, []byte(h.dnaHash.String())); err != nil {
+	if err = writeFile(h.DataPath(), DNAHashFileName, []byte(h.dnaHash.String())); err != nil {
 		return
 	}
 
@@ -521,36 +1024,371 @@ func (h *Holochain) GenChain() (headerHash Hash, err error) {
 
 	// run the init functions of each zome
 	for zomeName, z := range h.Zomes {
-		var n Nucleus
-		n, err = h.makeNucleus(z)
-		if err == nil {
-			err = n.ChainGenesis()
-			if err != nil {
-				err = fmt.Errorf("In '%s' zome: %s", zomeName, err.Error())
-				return
-			}
+		if err = h.zomeGenesis(zomeName, z); err != nil {
+			return
 		}
 	}
 
 	return
 }
 
-// Clone copies DNA files from a source
-func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, err error) {
-	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
+// ValidateGenesis runs the genesis process against a temporary in-memory chain without
+// writing the DNAHashFile, the chain store or the DHT, and returns all the errors encountered
+// from the schema checks and the genesis functions of every zome.
+// This lets a developer confirm that a chain will gen correctly before actually starting it.
+func (h *Holochain) ValidateGenesis() (errs []error) {
+	if h.Started() {
+		return []error{mkErr("chain already started")}
+	}
 
-		format, err := findDNA(srcPath)
-		if err != nil {
-			return
-		}
+	if err := h.prepareZomes(); err != nil {
+		return []error{err}
+	}
 
-		f, err := os.Open(srcPath + "/" + DNAFileName + "." + format)
-		if err != nil {
-			return
-		}
-		defer f.Close()
-		h, err := DecodeDNA(f, format)
-		if err != nil {
+	// swap in a chain with no backing file, and restore the real state when done
+	chain, dnaHash, agentHash := h.chain, h.dnaHash, h.agentHash
+	h.chain = NewChain()
+	defer func() {
+		h.chain = chain
+		h.dnaHash = dnaHash
+		h.agentHash = agentHash
+	}()
+
+	if _, err := h.addGenesisEntries(); err != nil {
+		return []error{err}
+	}
+
+	zomeNames := make([]string, 0, len(h.Zomes))
+	for zomeName := range h.Zomes {
+		zomeNames = append(zomeNames, zomeName)
+	}
+	sort.Strings(zomeNames)
+	for _, zomeName := range zomeNames {
+		if err := h.zomeGenesis(zomeName, h.Zomes[zomeName]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// RotateKey replaces the agent's key with the key of newAgent by committing a new AgentEntry
+// that links to the previous key.  The new entry is signed by the previous key, which proves
+// that the rotation was made by the holder of that key, and all subsequent entries are
+// signed by the new key.  The new agent is saved to the holochain's directory and the
+// new AgentEntry and key are put to the DHT.
+func (h *Holochain) RotateKey(newAgent Agent) (err error) {
+	if !h.Started() {
+		err = mkErr("chain not started")
+		return
+	}
+
+	if err = h.checkAgentKeyType(newAgent); err != nil {
+		return
+	}
+	if h.storeKey != nil {
+		// the stores are encrypted with a key derived from the current one
+		err = mkErr("can't rotate the key of a holochain with encrypted stores")
+		return
+	}
+
+	var k AgentEntry
+	k.Name = newAgent.Name()
+	k.KeyType = newAgent.KeyType()
+	k.Key, err = ic.MarshalPublicKey(newAgent.PubKey())
+	if err != nil {
+		return
+	}
+	k.PrevKey, err = ic.MarshalPublicKey(h.agent.PubKey())
+	if err != nil {
+		return
+	}
+
+	var id peer.ID
+	id, err = peer.IDFromPrivateKey(newAgent.PrivKey())
+	if err != nil {
+		return
+	}
+
+	e := GobEntry{C: k}
+	var agentHeader *Header
+	_, agentHeader, err = h.NewEntry(h.Now(), AgentEntryType, &e)
+	if err != nil {
+		return
+	}
+
+	h.agent = newAgent
+	h.id = id
+	h.agentHash = agentHeader.EntryLink
+
+	// replace any chain specific agent with the new one
+	if fileExists(h.path + "/" + PrivKeyFileName) {
+		if err = os.Remove(h.path + "/" + PrivKeyFileName); err != nil {
+			return
+		}
+	}
+	if fileExists(h.path + "/" + AgentFileName) {
+		if err = os.Remove(h.path + "/" + AgentFileName); err != nil {
+			return
+		}
+	}
+	if err = SaveAgent(h.path, newAgent); err != nil {
+		return
+	}
+
+	if h.dht != nil {
+		err = h.dht.putAgent()
+	}
+	return
+}
+
+// SetAgentName renames the agent and records the new name in the holochain's directory.  If
+// the agent was loaded from the service directory, whose agent other holochains share, the
+// renamed agent is saved to the holochain's directory as its own.  The name is part of the
+// genesis AgentEntry so it can only be changed before the chain is started; afterwards
+// ErrAgentNameFixed is returned.
+func (h *Holochain) SetAgentName(name AgentName) (err error) {
+	if h.Started() {
+		err = ErrAgentNameFixed
+		return
+	}
+	p := h.path + "/" + AgentFileName
+	if fileExists(p) {
+		if err = os.Remove(p); err != nil {
+			return
+		}
+	}
+	old := h.agent.Name()
+	h.agent.SetName(name)
+	if fileExists(h.path + "/" + PrivKeyFileName) {
+		err = writeFile(h.path, AgentFileName, []byte(name))
+	} else {
+		err = SaveAgent(h.path, h.agent)
+	}
+	if err != nil {
+		h.agent.SetName(old)
+	}
+	return
+}
+
+// Agents returns the AgentEntries known from the local chain and the local DHT store,
+// one for each key, local chain entries first.  An agent that has rotated its key appears
+// once per key; the entry for each new key links to the key it replaced in PrevKey.
+func (h *Holochain) Agents() (agents []AgentEntry, err error) {
+	seen := make(map[string]bool)
+	add := func(e Entry) error {
+		a, ok := e.Content().(AgentEntry)
+		if !ok {
+			return errors.New("expected AgentEntry content")
+		}
+		k, err := agentKeyHash(a.Key)
+		if err != nil {
+			return err
+		}
+		if !seen[k] {
+			seen[k] = true
+			agents = append(agents, a)
+		}
+		return nil
+	}
+
+	if h.chain != nil {
+		// walk back from the top and add in chain order
+		var local []Entry
+		err = h.chain.WalkType(AgentEntryType, func(key *Hash, header *Header, entry Entry) error {
+			local = append([]Entry{entry}, local...)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		for _, e := range local {
+			if err = add(e); err != nil {
+				return
+			}
+		}
+	}
+
+	if h.dht != nil {
+		var keys []Hash
+		if keys, err = h.dht.Keys(); err != nil {
+			return
+		}
+		for _, key := range keys {
+			var e Entry
+			var t string
+			var status int
+			if e, t, status, err = h.dht.Get(key); err != nil {
+				return
+			}
+			if t != AgentEntryType || status != LIVE {
+				continue
+			}
+			if err = add(e); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// RevokeKey burns the agent's identity by committing a RevocationEntry signed by the current
+// key, recording it in this node's DHT and publishing it to the nodes responsible for it.
+// After revocation no more entries can be committed, DHT nodes that have received the
+// revocation refuse puts from the revoked key, and Validate treats any header following the
+// revocation as invalid.
+func (h *Holochain) RevokeKey(reason string) (err error) {
+	if !h.Started() {
+		err = mkErr("chain not started")
+		return
+	}
+	if h.Revoked() {
+		err = ErrKeyRevoked
+		return
+	}
+
+	var r RevocationEntry
+	r.Reason = reason
+	r.Key, err = ic.MarshalPublicKey(h.agent.PubKey())
+	if err != nil {
+		return
+	}
+
+	e := GobEntry{C: r}
+	var header *Header
+	_, header, err = h.NewEntry(h.Now(), RevocationEntryType, &e)
+	if err != nil {
+		return
+	}
+
+	if h.dht != nil {
+		var b []byte
+		if b, err = e.Marshal(); err != nil {
+			return
+		}
+		if err = h.dht.put(nil, RevocationEntryType, header.EntryLink, h.id, b, LIVE); err != nil {
+			return
+		}
+		if err = h.dht.revoke(h.id); err != nil {
+			return
+		}
+		err = h.dht.SendPut(header.EntryLink)
+	}
+	return
+}
+
+// Revoked returns true if the agent's key has been revoked
+func (h *Holochain) Revoked() bool {
+	_, ok := h.chain.TypeTops[RevocationEntryType]
+	return ok
+}
+
+// addGenesisEntries adds the DNA and Agent entries to the chain and sets dnaHash and agentHash
+func (h *Holochain) addGenesisEntries() (headerHash Hash, err error) {
+	var e GobEntry
+	if e, err = h.dnaEntry(); err != nil {
+		return
+	}
+
+	var dnaHeader *Header
+	_, dnaHeader, err = h.NewEntry(h.Now(), DNAEntryType, &e)
+	if err != nil {
+		return
+	}
+
+	h.dnaHash = dnaHeader.EntryLink.Clone()
+
+	var k AgentEntry
+	k.Name = h.agent.Name()
+	k.KeyType = h.agent.KeyType()
+
+	pk := h.agent.PrivKey().GetPublic()
+
+	k.Key, err = ic.MarshalPublicKey(pk)
+	if err != nil {
+		return
+	}
+
+	e.C = k
+	var agentHeader *Header
+	headerHash, agentHeader, err = h.NewEntry(h.Now(), AgentEntryType, &e)
+	if err != nil {
+		return
+	}
+
+	h.agentHash = agentHeader.EntryLink
+	return
+}
+
+// zomeGenesis runs the genesis function of a zome
+func (h *Holochain) zomeGenesis(zomeName string, z *Zome) (err error) {
+	var n Nucleus
+	n, err = h.makeNucleus(z)
+	if err != nil {
+		return
+	}
+	err = n.ChainGenesis()
+	if err != nil {
+		err = fmt.Errorf("In '%s' zome: %s", zomeName, err.Error())
+	}
+	return
+}
+
+// cleanupGen undoes the side effects of a failed GenChain so that it can be re-run
+func (h *Holochain) cleanupGen() (err error) {
+	h.dnaHash = Hash{}
+	h.agentHash = Hash{}
+
+	if err = os.RemoveAll(h.DataPath() + "/" + DNAHashFileName); err != nil {
+		return
+	}
+
+	if h.chain.InMemory() {
+		h.chain = NewChain()
+	} else {
+		h.chain.Close()
+		p := h.DataPath() + "/" + StoreFileName + ".dat"
+		if err = os.RemoveAll(p); err != nil {
+			return
+		}
+		h.chain, err = NewEncryptedChainFromFile(h.hashSpec, p, h.storeKey)
+		if err != nil {
+			return
+		}
+	}
+
+	if h.dht != nil {
+		h.dht.Close()
+		if err = removeDHTFiles(h.DataPath()); err != nil {
+			return
+		}
+		h.dht = NewDHT(h)
+		err = h.dht.checkStore()
+	}
+	return
+}
+
+// readDNA decodes the DNA file in the directory srcPath
+func readDNA(srcPath string) (h *Holochain, err error) {
+	format, err := findDNA(srcPath)
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(srcPath + "/" + DNAFileName + "." + format)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h, err = DecodeDNA(f, format)
+	return
+}
+
+// Clone copies DNA files from a source
+func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, err error) {
+	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
+
+		h, err := readDNA(srcPath)
+		if err != nil {
 			return
 		}
 
@@ -589,107 +1427,423 @@ func (s *Service) Clone(srcPath string, path string, new bool) (hP *Holochain, e
 			return
 		}
 
-		if err = CopyFile(srcPath+"/schema_properties.json", path+"/schema_properties.json"); err != nil {
+		if err = h.copyDNAFiles(srcPath, path); err != nil {
 			return
 		}
 
-		if dirExists(srcPath + "/test") {
-			if err = CopyDir(srcPath+"/test", path+"/test"); err != nil {
-				return
-			}
-		}
-
-		for _, z := range h.Zomes {
-			var bs []byte
-			bs, err = readFile(srcPath, z.Code)
-			if err != nil {
-				return
-			}
-			if err = writeFile(path, z.Code, bs); err != nil {
-				return
-			}
-			for k := range z.Entries {
-				e := z.Entries[k]
-				sc := e.Schema
-				if sc != "" {
-					if err = CopyFile(srcPath+"/"+sc, path+"/"+sc); err != nil {
-						return
-					}
-				}
-			}
-		}
-
 		hP = h
 		return
 	})
 	return
 }
 
-// TestData holds a test entry for a chain
-type TestData struct {
-	Zome   string
-	FnName string
-	Input  string
-	Output string
-	Err    string
-	Regexp string
-}
-
-func (h *Holochain) setupConfig() (err error) {
-	if err = h.config.Loggers.App.New(nil); err != nil {
+// MaxDNAArchiveSize is the size of the largest archive that CloneFromURL will download
+var MaxDNAArchiveSize int64 = 64 << 20
+
+// MaxDNAArchiveExtractedSize is the most that CloneFromURL will write extracting an archive,
+// so that a small archive can't expand to fill the disk
+var MaxDNAArchiveExtractedSize int64 = 256 << 20
+
+// MaxDNAArchiveEntries is the most files and directories that CloneFromURL will extract
+var MaxDNAArchiveEntries = 10000
+
+// DNADownloadTimeout is how long CloneFromURL waits for an archive to download
+const DNADownloadTimeout = 60 * time.Second
+
+// CloneFromURL clones a holochain as Clone does, from a zip, tar or gzipped tar archive at
+// url of the DNA directory (the DNA file with its ui, schema and zome code files) rather
+// than from a local directory.  The DNA file may be at the top of the archive or in its
+// only directory.  So that the application installed is the one the caller meant, its DNA
+// must have expectedHash as its hash (as reported by DNAHash on its chains), otherwise
+// ErrDNAHashMismatch is returned.  Failures to download the archive return ErrDNADownload.
+// The hash only covers the DNA file itself, and through the code and schema hashes it
+// records (see GenDNAHashes) the zome code and schemas, which are checked when the
+// holochain is prepared.  The ui and test files in the archive are not verified.
+func (s *Service) CloneFromURL(url string, path string, expectedHash Hash, new bool) (hP *Holochain, err error) {
+	if len(expectedHash.H) == 0 {
+		err = mkErr("an expected DNA hash is required to clone from a URL")
 		return
 	}
-	if err = h.config.Loggers.DHT.New(nil); err != nil {
+	data, err := fetchArchive(url)
+	if err != nil {
 		return
 	}
-	if err = h.config.Loggers.Gossip.New(nil); err != nil {
+	tmp, err := ioutil.TempDir("", "holochain-clone")
+	if err != nil {
 		return
 	}
-	if err = h.config.Loggers.TestPassed.New(nil); err != nil {
+	defer os.RemoveAll(tmp)
+	if err = extractArchive(data, tmp); err != nil {
+		err = fmt.Errorf("couldn't extract the archive at %s: %w", url, err)
 		return
 	}
-	if err = h.config.Loggers.TestFailed.New(nil); err != nil {
+
+	srcPath := tmp
+	if _, e := findDNA(srcPath); e != nil {
+		var files []os.FileInfo
+		if files, err = ioutil.ReadDir(tmp); err != nil {
+			return
+		}
+		if len(files) != 1 || !files[0].IsDir() {
+			err = fmt.Errorf("DNA not found in the archive at %s", url)
+			return
+		}
+		srcPath = tmp + "/" + files[0].Name()
+	}
+	h, err := readDNA(srcPath)
+	if err != nil {
+		err = fmt.Errorf("couldn't read the DNA in the archive at %s: %w", url, err)
 		return
 	}
-	if err = h.config.Loggers.TestInfo.New(nil); err != nil {
+	hash, err := DNAHashOf(h)
+	if err != nil {
 		return
 	}
+	if !hash.Equal(&expectedHash) {
+		err = fmt.Errorf("%w: the DNA at %s has hash %s, expected %s", ErrDNAHashMismatch, url, hash.String(), expectedHash.String())
+		return
+	}
+
+	hP, err = s.Clone(srcPath, path, new)
 	return
 }
 
-func makeConfig(h *Holochain, s *Service) (err error) {
-	h.config = Config{
-		Port:            DefaultPort,
-		PeerModeDHTNode: s.Settings.DefaultPeerModeDHTNode,
-		PeerModeAuthor:  s.Settings.DefaultPeerModeAuthor,
-		BootstrapServer: s.Settings.DefaultBootstrapServer,
-		Loggers: Loggers{
-			App:        Logger{Format: "%{color:cyan}%{message}", Enabled: true},
-			DHT:        Logger{Format: "%{color:yellow}%{time} DHT: %{message}"},
-			Gossip:     Logger{Format: "%{color:blue}%{time} Gossip: %{message}"},
-			TestPassed: Logger{Format: "%{color:green}%{message}", Enabled: true},
-			TestFailed: Logger{Format: "%{color:red}%{message}", Enabled: true},
-			TestInfo:   Logger{Format: "%{message}", Enabled: true},
-		},
-	}
-
-	p := h.path + "/" + ConfigFileName + "." + h.encodingFormat
-	f, err := os.Create(p)
+// fetchArchive downloads the archive at url, returning an ErrDNADownload error if it can't
+func fetchArchive(url string) (data []byte, err error) {
+	client := http.Client{Timeout: DNADownloadTimeout}
+	resp, err := client.Get(url)
 	if err != nil {
-		return err
+		err = fmt.Errorf("%w: %v", ErrDNADownload, err)
+		return
 	}
-	defer f.Close()
-
-	if err = Encode(f, h.encodingFormat, &h.config); err != nil {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%w: %s returned status %s", ErrDNADownload, url, resp.Status)
 		return
 	}
-	if err = h.setupConfig(); err != nil {
+	if data, err = ioutil.ReadAll(io.LimitReader(resp.Body, MaxDNAArchiveSize+1)); err != nil {
+		err = fmt.Errorf("%w: %v", ErrDNADownload, err)
 		return
 	}
+	if int64(len(data)) > MaxDNAArchiveSize {
+		data = nil
+		err = fmt.Errorf("%w: %s is larger than %d bytes", ErrDNADownload, url, MaxDNAArchiveSize)
+	}
 	return
 }
 
-// GenDev generates starter holochain DNA files from which to develop a chain
+// copyDNAFiles copies the properties schema, tests, zome code and entry schema files
+// of the DNA from srcPath to path
+func (h *Holochain) copyDNAFiles(srcPath string, path string) (err error) {
+	if h.PropertiesSchema != "" {
+		if err = CopyFile(srcPath+"/"+h.PropertiesSchema, path+"/"+h.PropertiesSchema); err != nil {
+			return
+		}
+	}
+
+	if dirExists(srcPath + "/test") {
+		if err = CopyDir(srcPath+"/test", path+"/test"); err != nil {
+			return
+		}
+	}
+
+	for _, z := range h.Zomes {
+		if z.CodeSource == "" {
+			var bs []byte
+			bs, err = readFile(srcPath, z.Code)
+			if err != nil {
+				return
+			}
+			if err = writeFile(path, z.Code, bs); err != nil {
+				return
+			}
+		}
+		for k := range z.Entries {
+			e := z.Entries[k]
+			sc := e.Schema
+			if sc != "" {
+				if err = CopyFile(srcPath+"/"+sc, path+"/"+sc); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// Fork makes a new holochain at path from the DNA of this (possibly running) holochain.
+// The new holochain gets a new UUID and is named after its path, but otherwise has copies
+// of the zomes, properties and config of this one.  Its chain, DHT and node are not copied,
+// the agent is loaded for the new path, and the DNA files are copied from this holochain's
+// directory.
+func (h *Holochain) Fork(path string) (hP *Holochain, err error) {
+	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
+		f := Holochain{
+			Version:          h.Version,
+			Name:             filepath.Base(path),
+			Properties:       make(map[string]string),
+			PropertiesSchema: h.PropertiesSchema,
+			HashType:         h.HashType,
+			RequiredKeyType:  h.RequiredKeyType,
+			BasedOn:          h.BasedOn.Clone(),
+			Zomes:            make(map[string]*Zome),
+			path:             path,
+			encodingFormat:   h.encodingFormat,
+			compressDNA:      h.compressDNA,
+			config:           h.persistedConfig(),
+		}
+		for k, v := range h.Properties {
+			f.Properties[k] = v
+		}
+		for name, z := range h.Zomes {
+			zome := *z
+			zome.CodeHash = z.CodeHash.Clone()
+			zome.Entries = make(map[string]EntryDef)
+			for k, e := range z.Entries {
+				e.SchemaHash = e.SchemaHash.Clone()
+				zome.Entries[k] = e
+			}
+			f.Zomes[name] = &zome
+		}
+
+		f.Id, err = uuid.NewUUID()
+		if err != nil {
+			return
+		}
+
+		f.agent, err = LoadAgent(filepath.Dir(path))
+		if err != nil {
+			return
+		}
+		f.id, err = peer.IDFromPrivateKey(f.agent.PrivKey())
+		if err != nil {
+			return
+		}
+
+		if err = f.PrepareHashType(); err != nil {
+			return
+		}
+
+		if err = f.saveConfig(); err != nil {
+			return
+		}
+		// as if the fork had been loaded, i.e. with its own data directory
+		if f.envRestore, err = f.config.applyEnvOverrides(f.Name); err != nil {
+			return
+		}
+
+		if dirExists(h.path + "/ui") {
+			if err = CopyDir(h.path+"/ui", path+"/ui"); err != nil {
+				return
+			}
+		}
+
+		if err = h.copyDNAFiles(h.path, path); err != nil {
+			return
+		}
+
+		hP = &f
+		return
+	})
+	return
+}
+
+// TestData holds a test entry for a chain
+type TestData struct {
+	Agent     string // the agent that runs the step, the holochain's own agent if empty
+	Zome      string
+	FnName    string
+	Input     string
+	Output    string
+	Err       string
+	Regexp    string
+	JSONMatch bool             // compare Output and the result as JSON values rather than as strings
+	DHT       []DHTExpectation `json:",omitempty"` // checks of the DHT made once the step's puts have been handled
+	Encoding  string           `json:",omitempty"` // TestEncodingBase64 if Input and Output are encoded, so that they can hold binary data
+}
+
+// TestEncodingBase64 is the TestData Encoding for base64 encoded Inputs and Outputs.  The
+// Input is decoded before it's passed to the function, and the function's result is
+// encoded before it's compared with the Output (or Regexp).
+const TestEncodingBase64 = "base64"
+
+// DHTExpectation holds a check of the DHT made after a test step.  If Base is set the
+// Output is compared with the JSON of the entries linked to it with Tag (as getmeta
+// returns them), otherwise with the content of the entry stored at Hash.
+type DHTExpectation struct {
+	Base      string
+	Tag       string
+	Hash      string
+	Output    string
+	Err       string
+	JSONMatch bool
+}
+
+// Environment variables which, if set, override a loaded holochain's config.  They apply to
+// every holochain loaded by the process, so a port set with EnvPort can only be used by one
+// activated holochain at a time.  The overrides are never saved to the config file.
+const (
+	EnvPort            = "HOLOCHAIN_PORT"               // Port
+	EnvBootstrap       = "HOLOCHAIN_BOOTSTRAP"          // BootstrapServer, as a comma separated list
+	EnvPeerModeAuthor  = "HOLOCHAIN_PEER_MODE_AUTHOR"   // PeerModeAuthor, true or false
+	EnvPeerModeDHTNode = "HOLOCHAIN_PEER_MODE_DHT_NODE" // PeerModeDHTNode, true or false
+	EnvDataPath        = "HOLOCHAIN_DATA_PATH"          // DataPath, an absolute one has the holochain's name appended
+)
+
+// ApplyEnvOverrides overlays the values of any of the Env* environment variables that are
+// set onto the config of the holochain with the given name.  An absolute EnvDataPath is
+// joined with the name so that each holochain gets its own data directory.
+func (c *Config) ApplyEnvOverrides(name string) (err error) {
+	_, err = c.applyEnvOverrides(name)
+	return
+}
+
+// applyEnvOverrides implements ApplyEnvOverrides, also returning a function that puts the
+// values the overridden fields had back into a copy of the config
+func (c *Config) applyEnvOverrides(name string) (restore func(*Config), err error) {
+	orig := *c
+	var overridden []string
+	if v := os.Getenv(EnvPort); v != "" {
+		if c.Port, err = strconv.Atoi(v); err != nil {
+			err = fmt.Errorf("invalid %s: %v", EnvPort, err)
+			return
+		}
+		overridden = append(overridden, EnvPort)
+	}
+	if v := os.Getenv(EnvBootstrap); v != "" {
+		c.BootstrapServer = parseBootstrapServers(v)
+		overridden = append(overridden, EnvBootstrap)
+	}
+	if v := os.Getenv(EnvPeerModeAuthor); v != "" {
+		if c.PeerModeAuthor, err = strconv.ParseBool(v); err != nil {
+			err = fmt.Errorf("invalid %s: %v", EnvPeerModeAuthor, err)
+			return
+		}
+		overridden = append(overridden, EnvPeerModeAuthor)
+	}
+	if v := os.Getenv(EnvPeerModeDHTNode); v != "" {
+		if c.PeerModeDHTNode, err = strconv.ParseBool(v); err != nil {
+			err = fmt.Errorf("invalid %s: %v", EnvPeerModeDHTNode, err)
+			return
+		}
+		overridden = append(overridden, EnvPeerModeDHTNode)
+	}
+	if v := os.Getenv(EnvDataPath); v != "" {
+		if filepath.IsAbs(v) {
+			v = filepath.Join(v, name)
+		}
+		c.DataPath = v
+		overridden = append(overridden, EnvDataPath)
+	}
+	restore = func(t *Config) {
+		for _, v := range overridden {
+			switch v {
+			case EnvPort:
+				t.Port = orig.Port
+			case EnvBootstrap:
+				t.BootstrapServer = orig.BootstrapServer
+			case EnvPeerModeAuthor:
+				t.PeerModeAuthor = orig.PeerModeAuthor
+			case EnvPeerModeDHTNode:
+				t.PeerModeDHTNode = orig.PeerModeDHTNode
+			case EnvDataPath:
+				t.DataPath = orig.DataPath
+			}
+		}
+	}
+	return
+}
+
+// persistedConfig returns the config without the values overridden from the environment
+func (h *Holochain) persistedConfig() (c Config) {
+	c = h.config
+	if h.envRestore != nil {
+		h.envRestore(&c)
+	}
+	return
+}
+
+func (h *Holochain) setupConfig() (err error) {
+	if err = h.config.Loggers.App.New(nil); err != nil {
+		return
+	}
+	if err = h.config.Loggers.DHT.New(nil); err != nil {
+		return
+	}
+	if err = h.config.Loggers.Gossip.New(nil); err != nil {
+		return
+	}
+	if err = h.config.Loggers.TestPassed.New(nil); err != nil {
+		return
+	}
+	if err = h.config.Loggers.TestFailed.New(nil); err != nil {
+		return
+	}
+	if err = h.config.Loggers.TestInfo.New(nil); err != nil {
+		return
+	}
+	return
+}
+
+func makeConfig(h *Holochain, s *Service) (err error) {
+	persister := s.Settings.DefaultPersister
+	if persister == "" {
+		persister = DefaultPersisterName
+	}
+	h.config = Config{
+		Port:                 DefaultPort,
+		PeerModeDHTNode:      s.Settings.DefaultPeerModeDHTNode,
+		PeerModeAuthor:       s.Settings.DefaultPeerModeAuthor,
+		BootstrapServer:      parseBootstrapServers(s.Settings.DefaultBootstrapServer),
+		Persister:            persister,
+		MaxPutsPerSecond:     DefaultMaxPutsPerSecond,
+		MaxPutBytesPerSecond: DefaultMaxPutBytesPerSecond,
+		GetMaxAttempts:       DefaultGetMaxAttempts,
+		GetRetryDelay:        DefaultGetRetryDelay,
+		Loggers: Loggers{
+			App:        Logger{Format: "%{color:cyan}%{message}", Enabled: true},
+			DHT:        Logger{Format: "%{color:yellow}%{time} DHT: %{message}"},
+			Gossip:     Logger{Format: "%{color:blue}%{time} Gossip: %{message}"},
+			TestPassed: Logger{Format: "%{color:green}%{message}", Enabled: true},
+			TestFailed: Logger{Format: "%{color:red}%{message}", Enabled: true},
+			TestInfo:   Logger{Format: "%{message}", Enabled: true},
+		},
+	}
+	err = h.saveConfig()
+	return
+}
+
+// saveConfig writes the holochain's config to its config file and sets it up
+func (h *Holochain) saveConfig() (err error) {
+	p := h.path + "/" + ConfigFileName + "." + h.encodingFormat
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c := h.persistedConfig()
+	if err = Encode(f, h.encodingFormat, &c); err != nil {
+		return
+	}
+	if err = h.setupConfig(); err != nil {
+		return
+	}
+	return
+}
+
+const (
+	DemoDevTemplate  = "demo"  // the sample application with fixtures and ui generated by GenDev
+	BlankDevTemplate = "blank" // minimal zomes with no sample code, fixtures or ui
+)
+
+// DevZome names a zome, and the type of its nucleus, for GenDevTemplate to scaffold
+type DevZome struct {
+	Name        string
+	NucleusType string
+}
+
+// GenDev generates starter holochain DNA files from which to develop a chain
 func (s *Service) GenDev(path string, format string) (hP *Holochain, err error) {
 	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
 		agent, err := LoadAgent(filepath.Dir(path))
@@ -711,8 +1865,8 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 				Description: "this is a javascript test zome",
 				NucleusType: JSNucleusType,
 				Entries: map[string]EntryDef{
-					"myOdds":  {Name: "myOdds", DataFormat: DataFormatRawJS},
-					"profile": {Name: "profile", DataFormat: DataFormatJSON, Schema: "schema_profile.json"},
+					"myOdds":      {Name: "myOdds", DataFormat: DataFormatRawJS, Sharing: SharingPublic},
+					"privateNote": {Name: "privateNote", DataFormat: DataFormatString, Sharing: SharingPrivate},
 				},
 			},
 		}
@@ -794,7 +1948,7 @@ func (s *Service) GenDev(path string, format string) (hP *Holochain, err error)
 				Zome:   "myZome",
 				FnName: "addPrime",
 				Input:  "{\"prime\":4}",
-				Err:    `Error calling 'commit': Invalid entry: {"Atype":"hash", "prime":4, "zKeyOrder":["prime"]}`},
+				Err:    `Error calling 'commit': Invalid entry: {"prime":4}`},
 			{
 				Zome:   "jsZome",
 				FnName: "addProfile",
@@ -855,11 +2009,13 @@ expose("addOdd",HC.STRING);
 function addOdd(x) {return commit("myOdds",x);}
 expose("addProfile",HC.JSON);
 function addProfile(x) {return commit("profile",x);}
+expose("addPrivateNote",HC.STRING);
+function addPrivateNote(x) {return commit("privateNote",x);}
 function validate(entry_type,entry,props) {
 if (entry_type=="myOdds") {
   return entry%2 != 0
 }
-if (entry_type=="profile") {
+if (entry_type=="privateNote") {
   return true
 }
 return false
@@ -872,22 +2028,8 @@ function genesis() {return true}
 			return nil, err
 		}
 
-		for n := range h.Zomes {
-			z, _ := h.Zomes[n]
-			switch z.NucleusType {
-			case JSNucleusType:
-				z.Code = fmt.Sprintf("zome_%s.js", z.Name)
-			case ZygoNucleusType:
-				z.Code = fmt.Sprintf("zome_%s.zy", z.Name)
-			default:
-				err = fmt.Errorf("unknown nucleus type:%s", z.NucleusType)
-				return
-			}
-
-			c, _ := code[z.Name]
-			if err = writeFile(path, z.Code, []byte(c)); err != nil {
-				return
-			}
+		if err = writeZomeCode(path, h.Zomes, code); err != nil {
+			return
 		}
 
 		// write out the tests
@@ -920,6 +2062,106 @@ function genesis() {return true}
 	return
 }
 
+// writeZomeCode sets the code file name of each zome from its nucleus type and writes its code out
+func writeZomeCode(path string, zomes map[string]*Zome, code map[string]string) (err error) {
+	for n := range zomes {
+		z, _ := zomes[n]
+		switch z.NucleusType {
+		case JSNucleusType:
+			z.Code = fmt.Sprintf("zome_%s.js", z.Name)
+		case ZygoNucleusType:
+			z.Code = fmt.Sprintf("zome_%s.zy", z.Name)
+		default:
+			err = fmt.Errorf("unknown nucleus type:%s", z.NucleusType)
+			return
+		}
+
+		c, _ := code[z.Name]
+		if err = writeFile(path, z.Code, []byte(c)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// blankZomeCode holds the minimal valid code for a zome of each nucleus type
+var blankZomeCode = map[string]string{
+	ZygoNucleusType: `(defn requires [] (hash version:` + VersionStr + `))
+(defn genesis [] true)
+(defn validate [entryType entry props] true)
+`,
+	JSNucleusType: `function requires() {return {version:` + VersionStr + `}}
+function genesis() {return true}
+function validate(entry_type,entry,props) {return true}
+`,
+}
+
+// GenDevTemplate generates starter holochain DNA files from the named template.  The demo
+// template builds the sample application that GenDev builds, and takes no zomes.  The blank
+// template builds a minimal zome for each of the given zomes (or a single zygo zome if none are
+// given) without any sample code, tests or ui.
+func (s *Service) GenDevTemplate(path string, format string, template string, zomes ...DevZome) (hP *Holochain, err error) {
+	switch template {
+	case DemoDevTemplate:
+		if len(zomes) > 0 {
+			err = errors.New("the demo template doesn't take zomes")
+			return
+		}
+		hP, err = s.GenDev(path, format)
+	case BlankDevTemplate:
+		hP, err = s.genBlank(path, format, zomes)
+	default:
+		err = fmt.Errorf("unknown dev template: %s", template)
+	}
+	return
+}
+
+func (s *Service) genBlank(path string, format string, devZomes []DevZome) (hP *Holochain, err error) {
+	if len(devZomes) == 0 {
+		devZomes = []DevZome{{Name: "myZome", NucleusType: ZygoNucleusType}}
+	}
+	zomes := make([]Zome, 0)
+	code := make(map[string]string)
+	for _, d := range devZomes {
+		if d.Name == "" {
+			err = errors.New("zome name missing")
+			return
+		}
+		if _, ok := code[d.Name]; ok {
+			err = fmt.Errorf("duplicate zome name: %s", d.Name)
+			return
+		}
+		c, ok := blankZomeCode[d.NucleusType]
+		if !ok {
+			err = fmt.Errorf("unknown nucleus type:%s", d.NucleusType)
+			return
+		}
+		code[d.Name] = c
+		zomes = append(zomes, Zome{Name: d.Name, NucleusType: d.NucleusType, Entries: make(map[string]EntryDef)})
+	}
+
+	hP, err = gen(path, func(path string) (hP *Holochain, err error) {
+		agent, err := LoadAgent(filepath.Dir(path))
+		if err != nil {
+			return
+		}
+
+		h := NewHolochain(agent, path, format, zomes...)
+		h.Name = filepath.Base(path)
+		h.Properties = make(map[string]string)
+
+		if err = makeConfig(&h, s); err != nil {
+			return
+		}
+		if err = writeZomeCode(path, h.Zomes, code); err != nil {
+			return
+		}
+		hP = &h
+		return
+	})
+	return
+}
+
 // gen calls a make function which should build the holochain structure and supporting files
 func gen(path string, makeH func(path string) (hP *Holochain, err error)) (h *Holochain, err error) {
 	if dirExists(path) {
@@ -954,307 +2196,1886 @@ func gen(path string, makeH func(path string) (hP *Holochain, err error)) (h *Ho
 
 		err = h.store.Init()
 		if err != nil {
-			return
+			return
+		}
+	*/
+	err = h.SaveDNA(false)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// EncodeDNA encodes a holochain's DNA to an io.Writer
+func (h *Holochain) EncodeDNA(writer io.Writer) (err error) {
+	return Encode(writer, h.encodingFormat, &h)
+}
+
+// EncodingFormat returns the format (json, yaml or toml) in which the holochain's DNA and
+// config were loaded and are saved
+func (h *Holochain) EncodingFormat() string {
+	return h.encodingFormat
+}
+
+// SetEncodingFormat changes the format in which SaveDNA and the config are saved, so that a
+// DNA can be converted to another format.  SaveDNA replaces the files in the old format.
+// The DNA entry is encoded in this format too, so it can't be changed once the chain is
+// started.
+func (h *Holochain) SetEncodingFormat(format string) (err error) {
+	if !isEncodingFormat(format) {
+		err = errors.New("unknown encoding format: " + format)
+		return
+	}
+	if h.Started() && format != h.encodingFormat {
+		err = mkErr("can't change the encoding format of a started chain's DNA")
+		return
+	}
+	h.encodingFormat = format
+	return
+}
+
+// the kinds of DNAChange
+const (
+	DNAAdded   = "added"
+	DNARemoved = "removed"
+	DNAChanged = "changed"
+)
+
+// DNAChange describes an element of the DNA that differs between two DNAs
+type DNAChange struct {
+	Path string // the element, i.e. "Zomes.myZome.Entries.myData.Schema"
+	Kind string // DNAAdded, DNARemoved or DNAChanged
+	From string // the element's value in the first DNA, empty if it was added
+	To   string // the element's value in the second DNA, empty if it was removed
+}
+
+// String returns a one line description of the change
+func (c DNAChange) String() string {
+	switch c.Kind {
+	case DNAAdded:
+		return fmt.Sprintf("+ %s: %s", c.Path, c.To)
+	case DNARemoved:
+		return fmt.Sprintf("- %s: %s", c.Path, c.From)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.From, c.To)
+}
+
+// DNADiff holds the differences between two DNAs, the top level elements first, then the
+// properties and zomes in name order
+type DNADiff struct {
+	Changes []DNAChange
+}
+
+// Empty reports whether the DNAs were the same
+func (d *DNADiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String returns the changes one per line
+func (d *DNADiff) String() string {
+	lines := make([]string, len(d.Changes))
+	for i, c := range d.Changes {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// compare records a change at path if from and to differ
+func (d *DNADiff) compare(path string, from string, to string) {
+	if from != to {
+		d.Changes = append(d.Changes, DNAChange{Path: path, Kind: DNAChanged, From: from, To: to})
+	}
+}
+
+// diffKeys returns the union of the keys of a and b, sorted, with whether each is in a and b
+func diffKeys(a []string, b []string) (keys []string, inA map[string]bool, inB map[string]bool) {
+	inA = make(map[string]bool)
+	inB = make(map[string]bool)
+	for _, k := range a {
+		inA[k] = true
+		keys = append(keys, k)
+	}
+	for _, k := range b {
+		inB[k] = true
+		if !inA[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// DiffDNA compares the DNA of two holochains, i.e. an installed one and an upgrade, listing
+// the elements of its serialized form that were added, removed or changed in b.  Zomes and
+// entry definitions that were added or removed are reported as a whole, by name.  A zome's
+// code is compared by its CodeHash.
+func DiffDNA(a *Holochain, b *Holochain) (diff DNADiff, err error) {
+	if a == nil || b == nil {
+		err = errors.New("can't diff a nil DNA")
+		return
+	}
+	diff.compare("Version", strconv.Itoa(a.Version), strconv.Itoa(b.Version))
+	diff.compare("Id", a.Id.String(), b.Id.String())
+	diff.compare("Name", a.Name, b.Name)
+	diff.compare("HashType", a.HashType, b.HashType)
+	diff.compare("RequiredKeyType", a.RequiredKeyType, b.RequiredKeyType)
+	diff.compare("BasedOn", a.BasedOn.String(), b.BasedOn.String())
+	diff.compare("PropertiesSchema", a.PropertiesSchema, b.PropertiesSchema)
+
+	var aKeys, bKeys []string
+	for k := range a.Properties {
+		aKeys = append(aKeys, k)
+	}
+	for k := range b.Properties {
+		bKeys = append(bKeys, k)
+	}
+	keys, inA, inB := diffKeys(aKeys, bKeys)
+	for _, k := range keys {
+		path := "Properties." + k
+		switch {
+		case !inB[k]:
+			diff.Changes = append(diff.Changes, DNAChange{Path: path, Kind: DNARemoved, From: a.Properties[k]})
+		case !inA[k]:
+			diff.Changes = append(diff.Changes, DNAChange{Path: path, Kind: DNAAdded, To: b.Properties[k]})
+		default:
+			diff.compare(path, a.Properties[k], b.Properties[k])
+		}
+	}
+
+	aKeys, bKeys = nil, nil
+	for k := range a.Zomes {
+		aKeys = append(aKeys, k)
+	}
+	for k := range b.Zomes {
+		bKeys = append(bKeys, k)
+	}
+	keys, inA, inB = diffKeys(aKeys, bKeys)
+	for _, k := range keys {
+		path := "Zomes." + k
+		switch {
+		case !inB[k]:
+			diff.Changes = append(diff.Changes, DNAChange{Path: path, Kind: DNARemoved, From: k})
+		case !inA[k]:
+			diff.Changes = append(diff.Changes, DNAChange{Path: path, Kind: DNAAdded, To: k})
+		default:
+			diff.diffZome(path, a.Zomes[k], b.Zomes[k])
+		}
+	}
+	return
+}
+
+// diffZome records the differences between two versions of a zome
+func (d *DNADiff) diffZome(path string, a *Zome, b *Zome) {
+	d.compare(path+".Description", a.Description, b.Description)
+	d.compare(path+".NucleusType", a.NucleusType, b.NucleusType)
+	d.compare(path+".Code", a.Code, b.Code)
+	d.compare(path+".CodeHash", a.CodeHash.String(), b.CodeHash.String())
+
+	var aKeys, bKeys []string
+	for k := range a.Entries {
+		aKeys = append(aKeys, k)
+	}
+	for k := range b.Entries {
+		bKeys = append(bKeys, k)
+	}
+	keys, inA, inB := diffKeys(aKeys, bKeys)
+	for _, k := range keys {
+		p := path + ".Entries." + k
+		switch {
+		case !inB[k]:
+			d.Changes = append(d.Changes, DNAChange{Path: p, Kind: DNARemoved, From: k})
+		case !inA[k]:
+			d.Changes = append(d.Changes, DNAChange{Path: p, Kind: DNAAdded, To: k})
+		default:
+			ea, eb := a.Entries[k], b.Entries[k]
+			d.compare(p+".DataFormat", ea.DataFormat, eb.DataFormat)
+			d.compare(p+".Schema", ea.Schema, eb.Schema)
+			d.compare(p+".SchemaHash", ea.SchemaHash.String(), eb.SchemaHash.String())
+			d.compare(p+".Expiry", strconv.Itoa(ea.Expiry), strconv.Itoa(eb.Expiry))
+			d.compare(p+".MaxSize", strconv.Itoa(ea.MaxSize), strconv.Itoa(eb.MaxSize))
+			d.compare(p+".Sharing", ea.Sharing, eb.Sharing)
+		}
+	}
+}
+
+// SetDNACompression sets whether SaveDNA writes the DNA gzip compressed
+func (h *Holochain) SetDNACompression(compress bool) {
+	h.compressDNA = compress
+}
+
+// SaveDNA writes the holochain DNA to a file, gzip compressed with the CompressedDNASuffix
+// if DNA compression is set
+func (h *Holochain) SaveDNA(overwrite bool) (err error) {
+	p := h.path + "/" + DNAFileName + "." + h.encodingFormat
+	if h.compressDNA {
+		p += CompressedDNASuffix
+	}
+	if !overwrite && fileExists(p) {
+		return mkErr(p + " already exists")
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if !h.compressDNA {
+		err = h.EncodeDNA(f)
+	} else {
+		zw := gzip.NewWriter(f)
+		err = h.EncodeDNA(zw)
+		if e := zw.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+	err = h.removeOtherFormats(p)
+	return
+}
+
+// removeOtherFormats removes the DNA files other than the one at dnaPath, which findDNA
+// might find instead of it, and moves the config to the DNA's encoding format if it's in
+// another one
+func (h *Holochain) removeOtherFormats(dnaPath string) (err error) {
+	for _, format := range EncodingFormats {
+		for _, p := range []string{h.path + "/" + DNAFileName + "." + format, h.path + "/" + DNAFileName + "." + format + CompressedDNASuffix} {
+			if p != dnaPath && fileExists(p) {
+				if err = os.Remove(p); err != nil {
+					return
+				}
+			}
+		}
+		if format == h.encodingFormat {
+			continue
+		}
+		p := h.path + "/" + ConfigFileName + "." + format
+		if !fileExists(p) {
+			continue
+		}
+		if !fileExists(h.path + "/" + ConfigFileName + "." + h.encodingFormat) {
+			if err = h.saveConfig(); err != nil {
+				return
+			}
+		}
+		if err = os.Remove(p); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GenDNAHashes generates hashes for all the definition files in the DNA.
+// This function should only be called by developer tools at the end of the process
+// of finalizing DNA development or versioning
+func (h *Holochain) GenDNAHashes() (err error) {
+	var b []byte
+	for _, z := range h.Zomes {
+		b, err = h.zomeCode(z)
+		if err != nil {
+			return
+		}
+		err = z.CodeHash.Sum(h.hashSpec, b)
+		if err != nil {
+			return
+		}
+		for i, e := range z.Entries {
+			sc := e.Schema
+			if sc != "" {
+				b, err = readFile(h.path, sc)
+				if err != nil {
+					return
+				}
+				err = e.SchemaHash.Sum(h.hashSpec, b)
+				if err != nil {
+					return
+				}
+				z.Entries[i] = e
+			}
+		}
+
+	}
+	err = h.SaveDNA(true)
+	return
+}
+
+// OnBeforeCommit registers a function to be called before each entry is added to the chain,
+// whichever zome commits it (including the genesis entries).  The functions are called in the
+// order they were registered and the first to return an error aborts the commit.  The
+// returned function unregisters fn.
+func (h *Holochain) OnBeforeCommit(fn BeforeCommitFn) (unsubscribe func()) {
+	commitHooksLock.Lock()
+	defer commitHooksLock.Unlock()
+	c := h.hooks()
+	id := c.next
+	c.next++
+	c.before = append(c.before, beforeCommitHook{id: id, fn: fn})
+	unsubscribe = func() {
+		commitHooksLock.Lock()
+		defer commitHooksLock.Unlock()
+		for i, k := range c.before {
+			if k.id == id {
+				c.before = append(c.before[:i:i], c.before[i+1:]...)
+				break
+			}
+		}
+	}
+	return
+}
+
+// OnAfterCommit registers a function to be called after each entry is added to the chain,
+// in the order the functions were registered.  The returned function unregisters fn.
+func (h *Holochain) OnAfterCommit(fn AfterCommitFn) (unsubscribe func()) {
+	commitHooksLock.Lock()
+	defer commitHooksLock.Unlock()
+	c := h.hooks()
+	id := c.next
+	c.next++
+	c.after = append(c.after, afterCommitHook{id: id, fn: fn})
+	unsubscribe = func() {
+		commitHooksLock.Lock()
+		defer commitHooksLock.Unlock()
+		for i, k := range c.after {
+			if k.id == id {
+				c.after = append(c.after[:i:i], c.after[i+1:]...)
+				break
+			}
+		}
+	}
+	return
+}
+
+// hooks returns the holochain's commit hooks, creating them if need be.  The caller must
+// hold commitHooksLock.
+func (h *Holochain) hooks() *commitHooks {
+	if h.commitHooks == nil {
+		h.commitHooks = &commitHooks{}
+	}
+	return h.commitHooks
+}
+
+// beforeCommit calls the functions registered with OnBeforeCommit, stopping at the first
+// that returns an error
+func (h *Holochain) beforeCommit(entryType string, entry Entry) (err error) {
+	commitHooksLock.Lock()
+	var hooks []beforeCommitHook
+	if h.commitHooks != nil {
+		hooks = append(hooks, h.commitHooks.before...)
+	}
+	commitHooksLock.Unlock()
+	for _, k := range hooks {
+		if err = k.fn(entryType, entry); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// afterCommit calls the functions registered with OnAfterCommit
+func (h *Holochain) afterCommit(hash Hash, header *Header) {
+	commitHooksLock.Lock()
+	var hooks []afterCommitHook
+	if h.commitHooks != nil {
+		hooks = append(hooks, h.commitHooks.after...)
+	}
+	commitHooksLock.Unlock()
+	for _, k := range hooks {
+		k.fn(hash, header)
+	}
+}
+
+// NewEntry adds an entry and it's header to the chain and returns the header and it's hash
+func (h *Holochain) NewEntry(now time.Time, entryType string, entry Entry) (hash Hash, header *Header, err error) {
+
+	if h.Revoked() {
+		err = ErrKeyRevoked
+		return
+	}
+	if err = h.checkEntrySize(entryType, entry); err != nil {
+		return
+	}
+	var l int
+	l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, now, entryType, entry, h.agent.PrivKey())
+	if err == nil {
+		hash, err = h.stampSchema(header, hash)
+	}
+	if err == nil {
+		err = h.beforeCommit(entryType, entry)
+	}
+	if err == nil {
+		err = h.chain.addEntry(l, hash, header, entry)
+	}
+	if err == nil {
+		h.afterCommit(hash, header)
+	}
+	/*
+		// get the current top of the chain
+		ph, err := h.Top()
+		if err != nil {
+			ph = NullHash()
+		}
+
+		// and also the the top entry of this type
+		pth, err := h.TopType(t)
+		if err != nil {
+			pth = NullHash()
+		}
+
+		hash, header, err = newHeader(h.hashSpec, now, t, entry, h.agent.PrivKey(), ph, pth)
+		if err != nil {
+			return
+		}
+
+		// @TODO
+		// we have to do this stuff because currently we are persisting immediatly.
+		// instead we should be persisting from the Chain object.
+
+		// encode the header for saving
+		b, err := header.Marshal()
+		if err != nil {
+			return
+		}
+		// encode the entry into bytes
+		m, err := entry.Marshal()
+		if err != nil {
+			return
+		}
+
+		err = h.store.Put(t, hash, b, header.EntryLink, m)
+	*/
+	return
+}
+
+// get low level access to entries/headers (only works inside a bolt transaction)
+func get(hb *bolt.Bucket, eb *bolt.Bucket, key []byte, getEntry bool) (header Header, entry interface{}, err error) {
+	v := hb.Get(key)
+	if v == nil {
+		err = ErrHashNotFound
+		return
+	}
+
+	err = header.Unmarshal(v, 34)
+	if err != nil {
+		return
+	}
+	if getEntry {
+		v = eb.Get(header.EntryLink.H)
+		var g GobEntry
+		err = g.Unmarshal(v)
+		if err != nil {
+			return
+		}
+		entry = g.C
+	}
+	return
+}
+
+// GetLocal returns the header and entry for an entry or header hash from the local chain
+// without going to the network.  If the hash isn't on the local chain ErrHashNotFound is returned.
+func (h *Holochain) GetLocal(hash Hash) (header *Header, entry Entry, err error) {
+	header, entry, err = h.chain.Find(hash)
+	return
+}
+
+// func(key *Hash, h *Header, entry interface{}) error
+func (h *Holochain) Walk(fn WalkerFn, entriesToo bool) (err error) {
+	err = h.chain.Walk(fn)
+	return
+}
+
+// Validate scans back through a chain to the beginning confirming that the last header points to DNA
+// and that each header was signed by the agent key in effect at its position in the chain
+// This is actually kind of bogus on your own chain, because theoretically you put it there!  But
+// if the holochain file was copied from somewhere you can consider this a self-check
+func (h *Holochain) Validate(entriesToo bool) (valid bool, err error) {
+	valid, err = h.ValidateWithProgress(entriesToo, nil)
+	return
+}
+
+// ValidateStrict does the same checks as Validate and also confirms that each header's
+// timestamp is no earlier than the previous header's, catching clock rollbacks and forged
+// headers that the hash checks alone miss
+func (h *Holochain) ValidateStrict(entriesToo bool) (valid bool, err error) {
+	valid, err = h.validate(entriesToo, true, nil)
+	return
+}
+
+// ValidateWithProgress does the same checks as Validate, calling progress (if it's not nil)
+// as the checks go.  Validation makes several passes over the chain (the signatures, the
+// type links if entriesToo, the known forks if there's a DHT, and the header hashes) and
+// total counts every header of every pass, so done reaches total when validation finishes.
+func (h *Holochain) ValidateWithProgress(entriesToo bool, progress func(done, total int)) (valid bool, err error) {
+	valid, err = h.validate(entriesToo, false, progress)
+	return
+}
+
+// validate implements the Validate functions, checking timestamps if strict is set
+func (h *Holochain) validate(entriesToo bool, strict bool, progress func(done, total int)) (valid bool, err error) {
+	var results []HeaderValidation
+	if results, err = h.validateDetailed(entriesToo, strict, progress); err != nil {
+		return
+	}
+	for _, r := range results {
+		if !r.OK {
+			err = r.Err
+			return
+		}
+	}
+	valid = true
+	return
+}
+
+// HeaderValidation holds the result of validating one header of the chain
+type HeaderValidation struct {
+	Hash Hash // the header's hash
+	OK   bool
+	Err  error // why the header failed validation
+}
+
+// ValidateDetailed does the same checks as Validate but reports the result of each header,
+// in chain order, instead of stopping at the first failure.  A header's Err is the first of
+// its signature, header hash and (if entriesToo) type link checks that failed.  Headers
+// following a key revocation are invalid, as are headers that the local DHT knows to have
+// been forked (see ForkError).
+func (h *Holochain) ValidateDetailed(entriesToo bool) (results []HeaderValidation, err error) {
+	results, err = h.validateDetailed(entriesToo, false, nil)
+	return
+}
+
+// validateDetailed implements ValidateDetailed, checking timestamp order if strict is set
+// and reporting progress if it's not nil
+func (h *Holochain) validateDetailed(entriesToo bool, strict bool, progress func(done, total int)) (results []HeaderValidation, err error) {
+	c := h.chain
+	n := len(c.Headers)
+	passes := 2
+	if entriesToo {
+		passes++
+	}
+	if strict {
+		passes++
+	}
+	if h.dht != nil {
+		passes++
+	}
+	var done, pass int
+	total := passes * n
+	step := func() {
+		if progress != nil && done < pass*n {
+			done++
+			progress(done, total)
+		}
+	}
+	// nextPass also catches done up with a pass that stopped checking headers early
+	nextPass := func() {
+		for done < pass*n {
+			step()
+		}
+		pass++
+	}
+
+	nextPass()
+	sigErrs := c.signatureErrors(step)
+	var linkErrs []error
+	if entriesToo {
+		nextPass()
+		linkErrs = c.typeLinkErrors(step)
+	}
+	var timeErrs []error
+	if strict {
+		nextPass()
+		timeErrs = c.timestampErrors(step)
+	}
+	var forkErrs []error
+	if h.dht != nil {
+		nextPass()
+		forkErrs = h.forkErrors(step)
+	}
+	nextPass()
+	results = make([]HeaderValidation, len(c.Headers))
+	revokedAt := -1
+	for i, header := range c.Headers {
+		r := HeaderValidation{Hash: c.Hashes[i], Err: sigErrs[i]}
+		if r.Err == nil {
+			// confirm the correctness of the header hash
+			var bH Hash
+			if bH, _, err = header.Sum(h.hashSpec); err != nil {
+				return
+			}
+			if !bH.Equal(&c.Hashes[i]) {
+				r.Err = errors.New("header hash doesn't match")
+			}
+		}
+		if r.Err == nil && linkErrs != nil {
+			r.Err = linkErrs[i]
+		}
+		if r.Err == nil && timeErrs != nil {
+			r.Err = timeErrs[i]
+		}
+		if r.Err == nil && revokedAt >= 0 {
+			r.Err = fmt.Errorf("header after key revocation at link %d", revokedAt)
+		}
+		if r.Err == nil && forkErrs != nil {
+			r.Err = forkErrs[i]
+		}
+		if header.Type == RevocationEntryType && revokedAt < 0 {
+			revokedAt = i
+		}
+		r.OK = r.Err == nil
+		results[i] = r
+		step()
+	}
+	return
+}
+
+// forkErrors returns, for each header of the chain, the ForkError of any fork of the agent's
+// chain at that header that the local DHT knows of.  It returns nil if there's no DHT to
+// check, and headers whose records can't be read (i.e. the DHT is closed) aren't checked.
+// step (if it's not nil) is called as each header is checked.
+func (h *Holochain) forkErrors(step func()) (errs []error) {
+	if h.dht == nil {
+		return
+	}
+	c := h.chain
+	errs = make([]error, len(c.Headers))
+	for i, header := range c.Headers {
+		stepped(step)
+		if i == 0 {
+			continue
+		}
+		if e := h.dht.forkAt(h.id, header.HeaderLink, c.Hashes[i]); errors.Is(e, ErrChainFork) {
+			errs[i] = e
+		}
+	}
+	return
+}
+
+// GetEntryDef returns an EntryDef of the given name
+func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
+	for _, z := range h.Zomes {
+		e, ok := z.Entries[t]
+		if ok {
+			zome = z
+			d = &e
+			break
+		}
+	}
+	if d == nil {
+		err = errors.New("no definition for entry type: " + t)
+	}
+	return
+}
+
+// AddEntryDef adds an entry definition to a zome of a chain that hasn't been started,
+// building its schema validator and saving the DNA.  It's an error if the zome is unknown or
+// the entry type is already defined by any zome.
+func (h *Holochain) AddEntryDef(zome string, def EntryDef) (err error) {
+	if h.Started() {
+		err = mkErr("chain already started")
+		return
+	}
+	z, ok := h.Zomes[zome]
+	if !ok {
+		err = errors.New("unknown zome: " + zome)
+		return
+	}
+	if def.Name == "" {
+		err = errors.New("entry definition must have a name")
+		return
+	}
+	if other, _, e := h.GetEntryDef(def.Name); e == nil {
+		err = fmt.Errorf("%w: %s is already defined in zome %s", ErrDuplicateEntryType, def.Name, other.Name)
+		return
+	}
+	if sc := def.Schema; sc != "" {
+		if !fileExists(h.path + "/" + sc) {
+			err = errors.New("DNA specified schema file missing: " + sc)
+			return
+		}
+		if strings.HasSuffix(sc, ".json") {
+			if err = def.BuildJSONSchemaValidator(h.path); err != nil {
+				return
+			}
+			if err = h.archiveSchema(&def); err != nil {
+				return
+			}
+		}
+	}
+	if z.Entries == nil {
+		z.Entries = make(map[string]EntryDef)
+	}
+	z.Entries[def.Name] = def
+	if err = h.SaveDNA(true); err != nil {
+		delete(z.Entries, def.Name)
+	}
+	return
+}
+
+// CheckKeyType returns an error if keyType isn't the key type the DNA pins or, if the DNA
+// doesn't pin one, isn't a key type that holochain supports
+func (h *Holochain) CheckKeyType(keyType KeytypeType) (err error) {
+	if h.RequiredKeyType == "" {
+		if _, ok := keyTypeNames[keyType]; !ok {
+			err = &ValidationError{Err: fmt.Errorf("%w: agent key type %v isn't supported", ErrKeyTypeNotAllowed, keyType)}
+		}
+		return
+	}
+	var required KeytypeType
+	required, err = KeyTypeFromString(h.RequiredKeyType)
+	if err != nil {
+		return
+	}
+	if keyType != required {
+		err = &ValidationError{Err: fmt.Errorf("%w: agent key type %v, DNA requires %v", ErrKeyTypeNotAllowed, keyType, required)}
+	}
+	return
+}
+
+// checkKeyMatches returns an error if the key type an agent declares isn't the type of
+// its public key, so an agent can't claim an allowed key type while using another
+func checkKeyMatches(declared KeytypeType, pubKey ic.PubKey) (err error) {
+	actual, e := PubKeyType(pubKey)
+	if e != nil {
+		err = &ValidationError{Err: fmt.Errorf("%w: %v", ErrKeyTypeNotAllowed, e)}
+		return
+	}
+	if actual != declared {
+		err = &ValidationError{Err: fmt.Errorf("%w: agent declares key type %v but its key is %v", ErrKeyTypeNotAllowed, declared, actual)}
+	}
+	return
+}
+
+// checkAgentKeyType returns an error if the local agent's key doesn't match the key type
+// it declares, or that key type isn't the one the config asks for or the DNA allows
+func (h *Holochain) checkAgentKeyType(agent Agent) (err error) {
+	if err = checkKeyMatches(agent.KeyType(), agent.PubKey()); err != nil {
+		return
+	}
+	if h.config.KeyType != "" {
+		var want KeytypeType
+		if want, err = KeyTypeFromString(h.config.KeyType); err != nil {
+			return
+		}
+		if agent.KeyType() != want {
+			err = fmt.Errorf("%w: agent key type %v, config requires %v", ErrKeyTypeNotAllowed, agent.KeyType(), want)
+			return
+		}
+	}
+	err = h.CheckKeyType(agent.KeyType())
+	return
+}
+
+// validateAgentEntry checks an agent entry received from the network against the DNA's key
+// type requirements, and that its key is a public key of the type the entry declares
+func (h *Holochain) validateAgentEntry(entry Entry) (err error) {
+	a, ok := entry.Content().(AgentEntry)
+	if !ok {
+		err = &ValidationError{Err: errors.New("expected AgentEntry")}
+		return
+	}
+	if err = h.CheckKeyType(a.KeyType); err != nil {
+		return
+	}
+	pk, e := ic.UnmarshalPublicKey(a.Key)
+	if e != nil {
+		err = &ValidationError{Err: fmt.Errorf("%w: agent key: %v", ErrInvalidEntry, e)}
+		return
+	}
+	err = checkKeyMatches(a.KeyType, pk)
+	return
+}
+
+// validateRevocationEntry checks that a revocation entry received from the network revokes
+// the key of the agent that sent it
+func (h *Holochain) validateRevocationEntry(entry Entry, props *ValidationProps) (err error) {
+	r, ok := entry.Content().(RevocationEntry)
+	if !ok {
+		err = &ValidationError{Err: errors.New("expected RevocationEntry")}
+		return
+	}
+	var key ic.PubKey
+	if key, err = ic.UnmarshalPublicKey(r.Key); err != nil {
+		err = &ValidationError{Err: err}
+		return
+	}
+	var id peer.ID
+	if id, err = AgentAddress(key); err != nil {
+		return
+	}
+	if props != nil && props.AgentID != "" && props.AgentID != peer.IDB58Encode(id) {
+		err = &ValidationError{Err: errors.New("revocation must be sent by the revoked agent")}
+	}
+	return
+}
+
+// validateLinkEntry checks that a link entry holds valid hashes and that the link passes
+// the application's link validation
+func (h *Holochain) validateLinkEntry(entry Entry, props *ValidationProps) (err error) {
+	l, ok := entry.Content().(LinkEntry)
+	if !ok {
+		err = &ValidationError{Err: errors.New("expected LinkEntry")}
+		return
+	}
+	var base, link Hash
+	if base, err = NewHash(l.Base); err != nil {
+		err = &ValidationError{Err: err}
+		return
+	}
+	if link, err = NewHash(l.Link); err != nil {
+		err = &ValidationError{Err: err}
+		return
+	}
+	err = h.ValidateLink(base, link, l.Tag, props)
+	return
+}
+
+// validateDelEntry checks that a del entry holds a valid hash
+func validateDelEntry(entry Entry) (err error) {
+	d, ok := entry.Content().(DelEntry)
+	if !ok {
+		err = &ValidationError{Err: errors.New("expected DelEntry")}
+		return
+	}
+	if _, err = NewHash(d.Hash); err != nil {
+		err = &ValidationError{Err: err}
+	}
+	return
+}
+
+// ValidateLink runs the validateLink functions of all the zomes that define one against a
+// link from base to link with the given tag
+func (h *Holochain) ValidateLink(base Hash, link Hash, tag string, props *ValidationProps) (err error) {
+	names := make([]string, 0, len(h.Zomes))
+	for name := range h.Zomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var n Nucleus
+		var release func(error)
+		if n, release, err = h.acquireNucleus(h.Zomes[name]); err != nil {
+			return
+		}
+		err = n.ValidateLink(base, link, tag, props)
+		release(err)
+		if err != nil {
+			err = &ValidationError{Err: err}
+			return
+		}
+	}
+	return
+}
+
+// LinkEntries commits a link from base to link with the given tag to the chain and puts it
+// to the DHT as meta data on base so that it can be retrieved with GetLinks
+func (h *Holochain) LinkEntries(base Hash, link Hash, tag string) (err error) {
+	l := LinkEntry{Base: base.String(), Link: link.String(), Tag: tag}
+	if _, err = h.Commit(LinkEntryType, l); err != nil {
+		return
+	}
+	if h.dht != nil {
+		err = h.dht.SendPutMeta(MetaReq{O: base, M: link, T: tag})
+	}
+	return
+}
+
+// GetLinks returns the hashes of all the entries linked from base with the given tag,
+// fetching them a page at a time
+func (h *Holochain) GetLinks(base Hash, tag string) (links []Hash, err error) {
+	if h.dht == nil {
+		err = ErrNoDHT
+		return
+	}
+	links = make([]Hash, 0)
+	c := h.dht.NewMetaCursor(base, tag, 0)
+	for {
+		var page []MetaEntry
+		if page, err = c.Next(); err != nil {
+			links = nil
+			return
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, e := range page {
+			var link Hash
+			if link, err = NewHash(e.H); err != nil {
+				links = nil
+				return
+			}
+			links = append(links, link)
+		}
+	}
+	return
+}
+
+// maxEntrySize returns the largest entry size allowed for an entry type by its EntryDef,
+// or failing that by the config
+func (h *Holochain) maxEntrySize(entryType string) int {
+	if _, d, err := h.GetEntryDef(entryType); err == nil && d.MaxSize > 0 {
+		return d.MaxSize
+	}
+	if h.config.MaxEntrySize > 0 {
+		return h.config.MaxEntrySize
+	}
+	return DefaultMaxEntrySize
+}
+
+// SchemaArchiveDir is the directory, within a holochain's DataPath, in which each version
+// of the DNA's schemas is kept (resolved and named by the hash of the resolved schema, so
+// that a change to a file it $refs is a new version) so that entries can be re-validated
+// against the schema they were committed under
+const SchemaArchiveDir = "schemas"
+
+// archiveSchema saves the resolved schema of an entry definition to the schema archive
+// and sets the definition's resolved hash
+func (h *Holochain) archiveSchema(d *EntryDef) (err error) {
+	if d.Schema == "" {
+		return
+	}
+	var j []byte
+	if j, err = resolveSchemaFile(h.path, d.Schema); err != nil {
+		return
+	}
+	if err = d.resolved.Sum(h.hashSpec, j); err != nil {
+		return
+	}
+	dir := filepath.Join(h.DataPath(), SchemaArchiveDir)
+	file := d.resolved.String() + ".json"
+	if fileExists(filepath.Join(dir, file)) {
+		return
+	}
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return
+	}
+	err = writeFile(dir, file, j)
+	return
+}
+
+// archivedSchemaValidator builds a validator from the archived schema with the given hash
+func (h *Holochain) archivedSchemaValidator(schemaLink Hash) (validator SchemaValidator, err error) {
+	file := schemaLink.String() + ".json"
+	var j []byte
+	if j, err = readFile(filepath.Join(h.DataPath(), SchemaArchiveDir), file); err != nil {
+		err = fmt.Errorf("unknown schema version %s: %v", schemaLink.String(), err)
+		return
+	}
+	validator, err = buildJSONSchemaValidator(file, j)
+	return
+}
+
+// stampSchema records in a header the hash of the schema that its entry's type is
+// validated against, returning the header's new hash.  The header's signature is over the
+// entry so it's unaffected.
+func (h *Holochain) stampSchema(header *Header, hash Hash) (newHash Hash, err error) {
+	newHash = hash
+	_, d, e := h.GetEntryDef(header.Type)
+	if e != nil || d.validator == nil || d.resolved.H == nil {
+		return
+	}
+	header.SchemaLink = d.resolved
+	newHash, _, err = header.Sum(h.hashSpec)
+	return
+}
+
+// isPrivate reports whether entries of the given type are private to their author
+func (h *Holochain) isPrivate(entryType string) bool {
+	_, d, err := h.GetEntryDef(entryType)
+	return err == nil && d.isPrivate()
+}
+
+// entrySize returns the size in bytes of an entry's content
+func entrySize(entry Entry) (size int, err error) {
+	switch c := entry.Content().(type) {
+	case []byte:
+		size = len(c)
+	case string:
+		size = len(c)
+	default:
+		var b []byte
+		if b, err = entry.Marshal(); err == nil {
+			size = len(b)
+		}
+	}
+	return
+}
+
+// checkEntrySize returns an ErrEntryTooLarge validation error if an entry is larger than its
+// type allows.  System entries (i.e. the DNA) aren't limited.
+func (h *Holochain) checkEntrySize(entryType string, entry Entry) (err error) {
+	if strings.HasPrefix(entryType, "%") {
+		return
+	}
+	var size int
+	if size, err = entrySize(entry); err != nil {
+		return
+	}
+	if max := h.maxEntrySize(entryType); size > max {
+		err = &ValidationError{Err: fmt.Errorf("%w: %d bytes, maximum is %d", ErrEntryTooLarge, size, max)}
+	}
+	return
+}
+
+// validateBinaryEntry checks that an entry holds bytes within the maximum entry size
+func (h *Holochain) validateBinaryEntry(entryType string, entry Entry) (err error) {
+	if _, ok := entry.Content().([]byte); !ok {
+		err = &ValidationError{Err: errors.New("binary entry content must be bytes")}
+		return
+	}
+	err = h.checkEntrySize(entryType, entry)
+	return
+}
+
+// validateURIEntry checks that the content of a DataFormatURI entry is a valid URIEntry
+func validateURIEntry(entry Entry) (err error) {
+	c, ok := entry.Content().(string)
+	if !ok {
+		err = &ValidationError{Err: errors.New("URI entry content must be a string")}
+		return
+	}
+	if _, err = ParseURIEntry(c); err != nil {
+		err = &ValidationError{Err: err}
+	}
+	return
+}
+
+// Commit validates an entry and adds it to the local chain, returning the entry's hash.
+// Content must be a string except for entry types with the binary data format, whose
+// content is a []byte (strings are converted) stored as is.
+func (h *Holochain) Commit(entryType string, content interface{}) (entryHash Hash, err error) {
+	entryHash, err = h.commit(entryType, content, StatusChange{}, time.Time{})
+	return
+}
+
+// MaxClockSkew is how far in the future the timestamp given to CommitAt may be
+const MaxClockSkew = 5 * time.Minute
+
+// CommitAt commits an entry as Commit does but timestamps it with t rather than the current
+// time, i.e. for importing data with the times it was created.  The header is marked as
+// backdated to record that its author chose the timestamp.  Like any other header's, its
+// timestamp may not be earlier than the previous header's, and t may not be more than
+// MaxClockSkew in the future.
+func (h *Holochain) CommitAt(entryType string, content interface{}, t time.Time) (entryHash Hash, err error) {
+	if t.IsZero() {
+		err = errors.New("commit timestamp missing")
+		return
+	}
+	if t.After(h.Now().Add(MaxClockSkew)) {
+		err = fmt.Errorf("commit timestamp %v is in the future", t)
+		return
+	}
+	if top := h.chain.Top(); top != nil && t.Before(top.Time) {
+		err = fmt.Errorf("commit timestamp %v is earlier than the previous header's", t)
+		return
+	}
+	entryHash, err = h.commit(entryType, content, StatusChange{}, t)
+	return
+}
+
+// Update commits a new version of the entry replaces, which must be on the local chain and
+// of the same type, recording the modification in the new entry's header.  As with Commit
+// the new version must then be put for the DHT to return it in place of the old one.
+func (h *Holochain) Update(entryType string, content interface{}, replaces Hash) (entryHash Hash, err error) {
+	var t string
+	if _, t, err = h.chain.GetEntry(replaces); err != nil {
+		return
+	}
+	if t != entryType {
+		err = fmt.Errorf("can't update %v of type %s with an entry of type %s", replaces, t, entryType)
+		return
+	}
+	entryHash, err = h.commit(entryType, content, StatusChange{Action: ModAction, Hash: replaces}, time.Time{})
+	return
+}
+
+// Remove commits a DelEntry recording the deletion of an entry on the local chain.  The
+// DelEntry must then be put for the DHT to stop returning the deleted entry.
+func (h *Holochain) Remove(deleted Hash, message string) (entryHash Hash, err error) {
+	if _, _, err = h.chain.GetEntry(deleted); err != nil {
+		return
+	}
+	d := DelEntry{Hash: deleted.String(), Message: message}
+	entryHash, err = h.commit(DelEntryType, d, StatusChange{Action: DelAction, Hash: deleted}, time.Time{})
+	return
+}
+
+// commit implements Commit, recording the change in the entry's header if there is one and
+// timestamping it with at unless at is zero
+func (h *Holochain) commit(entryType string, content interface{}, change StatusChange, at time.Time) (entryHash Hash, err error) {
+	if _, d, e := h.GetEntryDef(entryType); e == nil && d.DataFormat == DataFormatBinary {
+		if s, ok := content.(string); ok {
+			content = []byte(s)
+		}
+	}
+	if h.Revoked() {
+		err = ErrKeyRevoked
+		return
+	}
+	e := GobEntry{C: content}
+	if err = h.checkEntrySize(entryType, &e); err != nil {
+		return
+	}
+	var l int
+	var hash Hash
+	var header *Header
+	now := at
+	if now.IsZero() {
+		now = h.Now()
+	}
+	l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, now, entryType, &e, h.agent.PrivKey())
+	if err != nil {
+		return
+	}
+	if hash, err = h.stampSchema(header, hash); err != nil {
+		return
+	}
+	if change.Action != "" {
+		if change.Hash.Equal(&header.EntryLink) {
+			err = errors.New("an entry can't change itself")
+			return
+		}
+		header.Change = change
+	}
+	header.Backdated = !at.IsZero()
+	if change.Action != "" || header.Backdated {
+		if hash, _, err = header.Sum(h.hashSpec); err != nil {
+			return
+		}
+	}
+
+	p := ValidationProps{
+		Sources:      []string{peer.IDB58Encode(h.id)},
+		Hash:         hash.String(),
+		AgentID:      peer.IDB58Encode(h.id),
+		Neighborhood: header.EntryLink.String(),
+		Role:         ValidatingAsAuthor,
+		Timestamp:    header.Time.Unix(),
+	}
+	if err = h.ValidateEntry(entryType, &e, &p); err != nil {
+		return
+	}
+	if err = h.beforeCommit(entryType, &e); err != nil {
+		return
+	}
+	if err = h.chain.addEntry(l, hash, header, &e); err != nil {
+		return
+	}
+	h.afterCommit(hash, header)
+	entryHash = header.EntryLink
+	return
+}
+
+// ValidateEntry passes an entry data to the chain's validation routine
+// If the entry is valid err will be nil, otherwise it will contain some information about why the validation failed (or, possibly, some other system error)
+func (h *Holochain) ValidateEntry(entryType string, entry Entry, props *ValidationProps) (err error) {
+	err = h.validateEntry(entryType, entry, props, Hash{})
+	return
+}
+
+// ValidateEntryAt validates an entry the way ValidateEntry does, except that it's checked
+// against the version of its type's schema recorded in its header, so that entries committed
+// under an earlier DNA can be re-validated against the schema they were committed under
+func (h *Holochain) ValidateEntryAt(header *Header, entry Entry, props *ValidationProps) (err error) {
+	err = h.validateEntry(header.Type, entry, props, header.SchemaLink)
+	return
+}
+
+// validateEntry implements ValidateEntry, validating against the schema with the given hash
+// if it's set, otherwise against the entry type's current schema
+func (h *Holochain) validateEntry(entryType string, entry Entry, props *ValidationProps, schemaLink Hash) (err error) {
+
+	if entry == nil {
+		return errors.New("nil entry invalid")
+	}
+
+	// agent and revocation entries are system entries which have no zome definition
+	if entryType == AgentEntryType {
+		return h.validateAgentEntry(entry)
+	}
+	if entryType == RevocationEntryType {
+		return h.validateRevocationEntry(entry, props)
+	}
+	if entryType == LinkEntryType {
+		return h.validateLinkEntry(entry, props)
+	}
+	if entryType == DelEntryType {
+		return validateDelEntry(entry)
+	}
+
+	z, d, err := h.GetEntryDef(entryType)
+	if err != nil {
+		return
+	}
+
+	// private entries are never published so DHT nodes must not accept them
+	if d.isPrivate() && props != nil && props.Role == ValidatingAsDHTNode {
+		err = &ValidationError{Err: ErrEntryPrivate}
+		return
+	}
+
+	// binary entries are opaque to the nucleus so they are only checked for size
+	if d.DataFormat == DataFormatBinary {
+		err = h.validateBinaryEntry(entryType, entry)
+		return
+	}
+
+	if d.DataFormat == DataFormatURI {
+		if err = validateURIEntry(entry); err != nil {
+			return
+		}
+	}
+
+	// see if there is a schema validator for the entry type and validate it if so
+	validator := d.validator
+	if validator != nil && schemaLink.H != nil && !schemaLink.Equal(&d.resolved) {
+		if validator, err = h.archivedSchemaValidator(schemaLink); err != nil {
+			return
+		}
+	}
+	if err = validateSchema(d, validator, entry); err != nil {
+		return
+	}
+
+	// then run the nucleus (ie. "app" specific) validation rules
+	n, release, err := h.acquireNucleus(z)
+	if err != nil {
+		return
+	}
+	err = n.ValidateEntry(d, entry, props)
+	release(err)
+	if err != nil {
+		err = &ValidationError{Err: err}
+	}
+	return
+}
+
+// validateSchema checks an entry against a schema validator, if there is one
+func validateSchema(d *EntryDef, validator SchemaValidator, entry Entry) (err error) {
+	if validator == nil {
+		return
+	}
+	var input interface{}
+	if d.DataFormat == DataFormatJSON || d.DataFormat == DataFormatURI {
+		c, ok := entry.Content().(string)
+		if !ok {
+			err = &ValidationError{Err: fmt.Errorf("%w: %s entry content must be a string, not %T", ErrInvalidEntry, d.DataFormat, entry.Content())}
+			return
+		}
+		if err = json.Unmarshal([]byte(c), &input); err != nil {
+			return
+		}
+	} else {
+		input = entry
+	}
+	Debugf("Validating %v against schema", input)
+	if err = validator.Validate(input); err != nil {
+		err = &ValidationError{Err: err}
+	}
+	return
+}
+
+// ValidateEntryWith validates an entry the way ValidateEntry does but against the given
+// zome code and entry definition rather than a holochain's, so that validation rules can be
+// tested without a holochain directory or chain.  If the definition has a JSON schema file
+// it is loaded relative to the current directory.  Because there is no holochain, the
+// validation code can't call holochain library functions that need one (i.e. get).
+func ValidateEntryWith(code string, nucleusType string, def *EntryDef, entry Entry, props *ValidationProps) (err error) {
+	if entry == nil {
+		return errors.New("nil entry invalid")
+	}
+	if def.DataFormat == DataFormatBinary {
+		if _, ok := entry.Content().([]byte); !ok {
+			err = &ValidationError{Err: errors.New("binary entry content must be bytes")}
+		}
+		return
+	}
+	if def.DataFormat == DataFormatURI {
+		if err = validateURIEntry(entry); err != nil {
+			return
+		}
+	}
+	if def.validator == nil && strings.HasSuffix(def.Schema, ".json") {
+		if err = def.BuildJSONSchemaValidator("."); err != nil {
+			return
+		}
+	}
+	if err = validateSchema(def, def.validator, entry); err != nil {
+		return
+	}
+	var n Nucleus
+	if n, err = CreateNucleus(nil, nucleusType, code); err != nil {
+		return
+	}
+	if err = n.ValidateEntry(def, entry, props); err != nil {
+		err = &ValidationError{Err: err}
+	}
+	return
+}
+
+// Call executes an exposed function
+func (h *Holochain) Call(zomeType string, function string, arguments interface{}) (result interface{}, err error) {
+	n, release, err := h.acquireZomeNucleus(zomeType)
+	if err != nil {
+		return
+	}
+	if _, err = exposedInterface(zomeType, n, function); err != nil {
+		release(nil)
+		return
+	}
+	result, err = n.Call(function, arguments)
+	release(err)
+	if err != nil {
+		err = &NucleusError{Zome: zomeType, Function: function, Err: err}
+	}
+	return
+}
+
+// exposedInterface returns the Interface of a function exposed by a zome's nucleus, or an
+// UnknownFunctionError listing the functions it does expose if it has none by that name
+func exposedInterface(zomeType string, n Nucleus, function string) (iface Interface, err error) {
+	var names []string
+	for _, i := range n.Interfaces() {
+		if i.Name == function {
+			iface = i
+			return
+		}
+		names = append(names, i.Name)
+	}
+	sort.Strings(names)
+	err = &UnknownFunctionError{Zome: zomeType, Function: function, Available: names}
+	return
+}
+
+// callZome executes an exposed function on behalf of code running in another zome's
+// nucleus, returning an UnknownFunctionError if the zome doesn't expose it.  depth is the
+// call depth of the calling nucleus and is used to stop runaway recursion between zomes,
+// and readOnly is set if the caller is running a read-only function, in which case the
+// called function can't write either.  Like Call, errors of the function are wrapped in a
+// NucleusError naming the zome and function.
+func (h *Holochain) callZome(depth int, readOnly bool, zomeType string, function string, arguments interface{}) (result interface{}, err error) {
+	if depth >= MaxCallDepth {
+		err = ErrCallDepthExceeded
+		return
+	}
+	n, release, err := h.acquireZomeNucleus(zomeType)
+	if err != nil {
+		return
+	}
+	if _, err = exposedInterface(zomeType, n, function); err != nil {
+		release(nil)
+		return
+	}
+	if d, ok := n.(callDepther); ok {
+		d.setCallDepth(depth + 1)
+	}
+	if r, ok := n.(readOnlyCaller); ok {
+		r.setReadOnlyCaller(readOnly)
+	}
+	result, err = n.Call(function, arguments)
+	release(err)
+	if err != nil {
+		err = &NucleusError{Zome: zomeType, Function: function, Err: err}
+	}
+	return
+}
+
+// CallWithContext executes an exposed function, returning a NucleusError wrapping a
+// CallAbortedError (so errors.Is finds both ErrCallAborted and the context's error) if the
+// context is cancelled or its deadline passes before the function returns, in which case the
+// function itself is interrupted: JS functions stop straight away and Zygo functions at their
+// next function call.
+func (h *Holochain) CallWithContext(ctx context.Context, zomeType string, function string, arguments interface{}) (result interface{}, err error) {
+	n, release, err := h.acquireZomeNucleus(zomeType)
+	if err != nil {
+		return
+	}
+	if _, err = exposedInterface(zomeType, n, function); err != nil {
+		release(nil)
+		return
+	}
+	result, err = callWithContext(ctx, n, function, arguments)
+	if ctx.Err() == nil {
+		// an interrupted nucleus may still be unwinding and is left mid-call, so it isn't reused
+		release(err)
+	}
+	if err != nil {
+		err = &NucleusError{Zome: zomeType, Function: function, Err: err}
+	}
+	return
+}
+
+// CallJSON calls an exposed function declared as taking JSON, marshaling v as its argument,
+// and returns the function's result as raw JSON that can be unmarshaled into a Go value
+func (h *Holochain) CallJSON(zomeType string, function string, v interface{}) (result json.RawMessage, err error) {
+	n, release, err := h.acquireZomeNucleus(zomeType)
+	if err != nil {
+		return
+	}
+	var iface Interface
+	if iface, err = exposedInterface(zomeType, n, function); err != nil {
+		release(nil)
+		return
+	}
+	defer func() { release(err) }()
+	if iface.Schema != JSON {
+		err = fmt.Errorf("exposed function %s doesn't take JSON", function)
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(v); err != nil {
+		return
+	}
+	var r interface{}
+	if r, err = n.Call(function, string(b)); err != nil {
+		err = &NucleusError{Zome: zomeType, Function: function, Err: err}
+		return
+	}
+	switch t := r.(type) {
+	case string:
+		result = json.RawMessage(t)
+	case []byte:
+		result = json.RawMessage(t)
+	default:
+		err = fmt.Errorf("unexpected result type from %s: %T", function, r)
+	}
+	return
+}
+
+// MakeNucleus creates a Nucleus object based on the zome type
+func (h *Holochain) MakeNucleus(t string) (n Nucleus, err error) {
+	z, ok := h.Zomes[t]
+	if !ok {
+		err = errors.New("unknown zome: " + t)
+		return
+	}
+	n, err = h.makeNucleus(z)
+	return
+}
+
+// Interfaces returns the functions exposed by each zome, by zome name.  Because building
+// nuclei is expensive the result is cached until the holochain is Reset.
+func (h *Holochain) Interfaces() (interfaces map[string][]Interface, err error) {
+	interfacesLock.Lock()
+	interfaces = h.interfaces
+	interfacesLock.Unlock()
+	if interfaces != nil {
+		return
+	}
+	interfaces = make(map[string][]Interface)
+	for name, z := range h.Zomes {
+		var n Nucleus
+		var release func(error)
+		n, release, err = h.acquireNucleus(z)
+		if err != nil {
+			err = fmt.Errorf("In '%s' zome: %v", name, err)
+			return nil, err
 		}
-	*/
-	err = h.SaveDNA(false)
-	if err != nil {
-		return
+		interfaces[name] = n.Interfaces()
+		release(nil)
 	}
-
+	interfacesLock.Lock()
+	h.interfaces = interfaces
+	interfacesLock.Unlock()
 	return
 }
 
-// EncodeDNA encodes a holochain's DNA to an io.Writer
-func (h *Holochain) EncodeDNA(writer io.Writer) (err error) {
-	return Encode(writer, h.encodingFormat, &h)
+// resetInterfaces clears the cached result of Interfaces
+func (h *Holochain) resetInterfaces() {
+	interfacesLock.Lock()
+	h.interfaces = nil
+	interfacesLock.Unlock()
 }
 
-// SaveDNA writes the holochain DNA to a file
-func (h *Holochain) SaveDNA(overwrite bool) (err error) {
-	p := h.path + "/" + DNAFileName + "." + h.encodingFormat
-	if !overwrite && fileExists(p) {
-		return mkErr(p + " already exists")
+func (h *Holochain) makeNucleus(z *Zome) (n Nucleus, err error) {
+	var code string
+	if code, err = h.nucleusCode(z); err != nil {
+		return
 	}
-	f, err := os.Create(p)
-	if err != nil {
-		return err
+	n, err = CreateNucleus(h, z.NucleusType, code)
+	if err == nil {
+		setZomeName(n, z)
+		h.recordCode(z, code)
 	}
-	defer f.Close()
-	err = h.EncodeDNA(f)
 	return
 }
 
-// GenDNAHashes generates hashes for all the definition files in the DNA.
-// This function should only be called by developer tools at the end of the process
-// of finalizing DNA development or versioning
-func (h *Holochain) GenDNAHashes() (err error) {
-	var b []byte
-	for _, z := range h.Zomes {
-		code := z.Code
-		b, err = readFile(h.path, code)
-		if err != nil {
-			return
-		}
-		err = z.CodeHash.Sum(h.hashSpec, b)
-		if err != nil {
+// nucleusCode returns the code a zome's nuclei are compiled from: the code it was last
+// reloaded with by ReloadZome, if any, otherwise its current code
+func (h *Holochain) nucleusCode(z *Zome) (code string, err error) {
+	code, ok := h.pinnedCode(z)
+	if !ok {
+		var b []byte
+		if b, err = h.zomeCode(z); err != nil {
 			return
 		}
-		for i, e := range z.Entries {
-			sc := e.Schema
-			if sc != "" {
-				b, err = readFile(h.path, sc)
-				if err != nil {
-					return
-				}
-				err = e.SchemaHash.Sum(h.hashSpec, b)
-				if err != nil {
-					return
-				}
-				z.Entries[i] = e
-			}
-		}
-
+		code = string(b)
 	}
-	err = h.SaveDNA(true)
 	return
 }
 
-// NewEntry adds an entry and it's header to the chain and returns the header and it's hash
-func (h *Holochain) NewEntry(now time.Time, entryType string, entry Entry) (hash Hash, header *Header, err error) {
-
-	var l int
-	l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, now, entryType, entry, h.agent.PrivKey())
-	if err == nil {
-		err = h.chain.addEntry(l, hash, header, entry)
+// recordCode records the code a zome's nucleus has been compiled from if none is recorded
+// yet, so that ReloadZome knows what the zome was running
+func (h *Holochain) recordCode(z *Zome, code string) {
+	nucleiLock.Lock()
+	defer nucleiLock.Unlock()
+	if h.nuclei == nil {
+		h.nuclei = make(map[*Zome]*nucleusPool)
 	}
-	/*
-		// get the current top of the chain
-		ph, err := h.Top()
-		if err != nil {
-			ph = NullHash()
-		}
+	if h.nuclei[z] == nil {
+		h.nuclei[z] = &nucleusPool{code: code}
+	}
+}
 
-		// and also the the top entry of this type
-		pth, err := h.TopType(t)
-		if err != nil {
-			pth = NullHash()
-		}
+// setZomeName tells a nucleus which zome it runs, if it tags its log output with it
+func setZomeName(n Nucleus, z *Zome) {
+	if zn, ok := n.(zomeNamer); ok {
+		zn.setZomeName(z.Name)
+	}
+}
 
-		hash, header, err = newHeader(h.hashSpec, now, t, entry, h.agent.PrivKey(), ph, pth)
-		if err != nil {
-			return
-		}
+// resetter is implemented by nucleii that can be reused: reset clears their per-call state
+// and restores the globals of their code to the state they were in once it was compiled.
+// Nucleii that can't restore their globals (i.e. Zygo) aren't reused, so every call and
+// validation starts from freshly compiled code just as it would without the pool.
+type resetter interface {
+	reset()
+}
 
-		// @TODO
-		// we have to do this stuff because currently we are persisting immediatly.
-		// instead we should be persisting from the Chain object.
+// acquireZomeNucleus is acquireNucleus for a zome given by name
+func (h *Holochain) acquireZomeNucleus(t string) (n Nucleus, release func(error), err error) {
+	z, ok := h.Zomes[t]
+	if !ok {
+		err = errors.New("unknown zome: " + t)
+		return
+	}
+	return h.acquireNucleus(z)
+}
 
-		// encode the header for saving
-		b, err := header.Marshal()
-		if err != nil {
+// acquireNucleus returns a compiled nucleus for a zome, reusing an idle one if the zome's
+// code hasn't changed since it was compiled.  A nucleus is only used by one caller at a
+// time so nested calls between zomes and concurrent calls each get their own.  The caller
+// must call release with the error (if any) of its use of the nucleus when it's done; a
+// nucleus whose use failed is discarded rather than reused in case it was left in a bad
+// state, as is one that isn't a resetter.
+func (h *Holochain) acquireNucleus(z *Zome) (n Nucleus, release func(error), err error) {
+	var code string
+	if code, err = h.nucleusCode(z); err != nil {
+		return
+	}
+	nucleiLock.Lock()
+	if p := h.nuclei[z]; p != nil && p.code == code && len(p.idle) > 0 {
+		n = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+	}
+	nucleiLock.Unlock()
+	if n == nil {
+		if n, err = CreateNucleus(h, z.NucleusType, code); err != nil {
 			return
 		}
-		// encode the entry into bytes
-		m, err := entry.Marshal()
-		if err != nil {
+		setZomeName(n, z)
+		h.recordCode(z, code)
+	}
+	release = func(e error) {
+		if e != nil {
 			return
 		}
-
-		err = h.store.Put(t, hash, b, header.EntryLink, m)
-	*/
-	return
-}
-
-// get low level access to entries/headers (only works inside a bolt transaction)
-func get(hb *bolt.Bucket, eb *bolt.Bucket, key []byte, getEntry bool) (header Header, entry interface{}, err error) {
-	v := hb.Get(key)
-
-	err = header.Unmarshal(v, 34)
-	if err != nil {
-		return
-	}
-	if getEntry {
-		v = eb.Get(header.EntryLink.H)
-		var g GobEntry
-		err = g.Unmarshal(v)
-		if err != nil {
+		r, ok := n.(resetter)
+		if !ok {
 			return
 		}
-		entry = g.C
+		r.reset()
+		nucleiLock.Lock()
+		defer nucleiLock.Unlock()
+		if h.nuclei == nil {
+			h.nuclei = make(map[*Zome]*nucleusPool)
+		}
+		p := h.nuclei[z]
+		if p != nil && p.code != code {
+			if p.pinned {
+				// the zome was reloaded while this nucleus was running
+				return
+			}
+			p = nil
+		}
+		if p == nil {
+			p = &nucleusPool{code: code}
+			h.nuclei[z] = p
+		}
+		p.idle = append(p.idle, n)
 	}
 	return
 }
 
-//func(key *Hash, h *Header, entry interface{}) error
-func (h *Holochain) Walk(fn WalkerFn, entriesToo bool) (err error) {
-	err = h.chain.Walk(fn)
+// pinnedCode returns the code a zome was last reloaded with by ReloadZome, if any
+func (h *Holochain) pinnedCode(z *Zome) (code string, ok bool) {
+	nucleiLock.Lock()
+	defer nucleiLock.Unlock()
+	if p := h.nuclei[z]; p != nil && p.pinned {
+		code, ok = p.code, true
+	}
 	return
 }
 
-// Validate scans back through a chain to the beginning confirming that the last header points to DNA
-// This is actually kind of bogus on your own chain, because theoretically you put it there!  But
-// if the holochain file was copied from somewhere you can consider this a self-check
-func (h *Holochain) Validate(entriesToo bool) (valid bool, err error) {
-
-	err = h.Walk(func(key *Hash, header *Header, entry Entry) (err error) {
-		// confirm the correctness of the header hash
-
-		var bH Hash
-		bH, _, err = header.Sum(h.hashSpec)
-		if err != nil {
-			return
-		}
+// ReloadZome re-reads a zome's code from disk, compiles it and runs its ChainRequires, and
+// then uses it for all further calls into the zome.  It's meant for a fast edit-test loop
+// during development.  If the new code doesn't compile, or its ChainRequires fails, the
+// error is returned and the zome keeps running the code it was running before (i.e. that
+// it was prepared with), even though the code on disk has changed.
+func (h *Holochain) ReloadZome(name string) (err error) {
+	z, ok := h.Zomes[name]
+	if !ok {
+		err = errors.New("unknown zome: " + name)
+		return
+	}
+	var b []byte
+	if b, err = h.zomeCode(z); err != nil {
+		return
+	}
+	code := string(b)
 
-		if !bH.Equal(key) {
-			return errors.New("header hash doesn't match")
-		}
+	// keep running the code the zome is running now if the new code is no good
+	nucleiLock.Lock()
+	if p := h.nuclei[z]; p != nil {
+		p.pinned = true
+	}
+	nucleiLock.Unlock()
 
-		// @TODO check entry hashes Etoo if entriesToo set
-		if entriesToo {
+	var n Nucleus
+	if n, err = CreateNucleus(h, z.NucleusType, code); err != nil {
+		err = fmt.Errorf("In '%s' zome: %w", name, err)
+		return
+	}
+	setZomeName(n, z)
+	if err = n.ChainRequires(); err != nil {
+		err = fmt.Errorf("In '%s' zome: %w", name, err)
+		return
+	}
 
-		}
-		return nil
-	}, entriesToo)
-	if err == nil {
-		valid = true
+	p := &nucleusPool{code: code, pinned: true}
+	if r, ok := n.(resetter); ok {
+		r.reset()
+		p.idle = []Nucleus{n}
+	}
+	nucleiLock.Lock()
+	if h.nuclei == nil {
+		h.nuclei = make(map[*Zome]*nucleusPool)
 	}
+	h.nuclei[z] = p
+	nucleiLock.Unlock()
+	h.resetInterfaces()
+	Debugf("reloaded zome %s", name)
 	return
 }
 
-// GetEntryDef returns an EntryDef of the given name
-func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
-	for _, z := range h.Zomes {
-		e, ok := z.Entries[t]
-		if ok {
-			zome = z
-			d = &e
-			break
-		}
-	}
-	if d == nil {
-		err = errors.New("no definition for entry type: " + t)
+// zomeCode returns a zome's code, either inline from the DNA or read from its code file
+func (h *Holochain) zomeCode(z *Zome) (code []byte, err error) {
+	if z.CodeSource != "" {
+		code = []byte(z.CodeSource)
+		return
 	}
+	code, err = readFile(h.path, z.Code)
 	return
 }
 
-// ValidateEntry passes an entry data to the chain's validation routine
-// If the entry is valid err will be nil, otherwise it will contain some information about why the validation failed (or, possibly, some other system error)
-func (h *Holochain) ValidateEntry(entryType string, entry Entry, props *ValidationProps) (err error) {
-
-	if entry == nil {
-		return errors.New("nil entry invalid")
+func LoadTestData(path string) (map[string][]TestData, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
 	}
 
-	z, d, err := h.GetEntryDef(entryType)
-	if err != nil {
-		return
+	if len(files) == 0 {
+		return nil, errors.New("no test data found in: " + path + "/test")
 	}
 
-	// see if there is a schema validator for the entry type and validate it if so
-	if d.validator != nil {
-		var input interface{}
-		if d.DataFormat == DataFormatJSON {
-			if err = json.Unmarshal([]byte(entry.Content().(string)), &input); err != nil {
-				return
+	re := regexp.MustCompile(`(.*)\.json`)
+	var tests = make(map[string][]TestData)
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			x := re.FindStringSubmatch(f.Name())
+			if len(x) > 0 {
+				name := x[1]
+
+				var v []byte
+				v, err = readFile(path, x[0])
+				if err != nil {
+					return nil, err
+				}
+				var t []TestData
+				err = json.Unmarshal(v, &t)
+				if err != nil {
+					return nil, err
+				}
+				tests[name] = t
 			}
-		} else {
-			input = entry
-		}
-		Debugf("Validating %v against schema", input)
-		if err = d.validator.Validate(input); err != nil {
-			return
 		}
 	}
+	return tests, err
+}
 
-	// then run the nucleus (ie. "app" specific) validation rules
-	n, err := h.makeNucleus(z)
-	if err != nil {
-		return
+func ToString(input interface{}) string {
+	// @TODO this should probably act according the function schema
+	// not just the return value
+	var output string
+	switch t := input.(type) {
+	case []byte:
+		output = string(t)
+	case string:
+		output = t
+	default:
+		output = fmt.Sprintf("%v", t)
 	}
-	err = n.ValidateEntry(d, entry, props)
-	return
+	return output
 }
 
-// Call executes an exposed function
-func (h *Holochain) Call(zomeType string, function string, arguments interface{}) (result interface{}, err error) {
-	n, err := h.MakeNucleus(zomeType)
-	if err != nil {
+// jsonMatch reports whether two strings hold equivalent JSON values, ignoring key order and whitespace
+func jsonMatch(expected string, actual string) (match bool, err error) {
+	var e, a interface{}
+	if err = json.Unmarshal([]byte(expected), &e); err != nil {
+		err = fmt.Errorf("expected value isn't JSON: %v", err)
 		return
 	}
-	result, err = n.Call(function, arguments)
-	return
-}
-
-// MakeNucleus creates a Nucleus object based on the zome type
-func (h *Holochain) MakeNucleus(t string) (n Nucleus, err error) {
-	z, ok := h.Zomes[t]
-	if !ok {
-		err = errors.New("unknown zome: " + t)
+	if err = json.Unmarshal([]byte(actual), &a); err != nil {
+		err = fmt.Errorf("result isn't JSON: %v", err)
 		return
 	}
-	n, err = h.makeNucleus(z)
+	match = reflect.DeepEqual(e, a)
 	return
 }
 
-func (h *Holochain) makeNucleus(z *Zome) (n Nucleus, err error) {
-	var code []byte
-	code, err = readFile(h.path, z.Code)
-	if err != nil {
+// jsonDiff returns a line for each place where two JSON strings differ, naming the key path
+// of the difference (i.e. $.profile.name or $.items[2])
+func jsonDiff(expected string, actual string) (diffs []string, err error) {
+	var e, a interface{}
+	if err = json.Unmarshal([]byte(expected), &e); err != nil {
+		return
+	}
+	if err = json.Unmarshal([]byte(actual), &a); err != nil {
 		return
 	}
-	n, err = CreateNucleus(h, z.NucleusType, string(code))
+	diffs = jsonValueDiff("$", e, a, nil)
 	return
 }
 
-func LoadTestData(path string) (map[string][]TestData, error) {
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		return nil, err
+func jsonValueDiff(path string, e interface{}, a interface{}, diffs []string) []string {
+	switch et := e.(type) {
+	case map[string]interface{}:
+		at, ok := a.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(et)+len(at))
+		for k := range et {
+			keys = append(keys, k)
+		}
+		for k := range at {
+			if _, ok := et[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ev, inE := et[k]
+			av, inA := at[k]
+			p := path + "." + k
+			switch {
+			case !inA:
+				diffs = append(diffs, fmt.Sprintf("%s: missing, expected %s", p, jsonString(ev)))
+			case !inE:
+				diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", p, jsonString(av)))
+			default:
+				diffs = jsonValueDiff(p, ev, av, diffs)
+			}
+		}
+		return diffs
+	case []interface{}:
+		at, ok := a.([]interface{})
+		if !ok {
+			break
+		}
+		if len(et) != len(at) {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %d elements, got %d", path, len(et), len(at)))
+		}
+		for i := 0; i < len(et) && i < len(at); i++ {
+			diffs = jsonValueDiff(fmt.Sprintf("%s[%d]", path, i), et[i], at[i], diffs)
+		}
+		return diffs
+	}
+	if !reflect.DeepEqual(e, a) {
+		diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", path, jsonString(e), jsonString(a)))
 	}
+	return diffs
+}
 
-	if len(files) == 0 {
-		return nil, errors.New("no test data found in: " + path + "/test")
+// jsonString returns the JSON encoding of a decoded JSON value for display
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
 	}
+	return string(b)
+}
 
-	re := regexp.MustCompile(`(.*)\.json`)
-	var tests = make(map[string][]TestData)
-	for _, f := range files {
-		if f.Mode().IsRegular() {
-			x := re.FindStringSubmatch(f.Name())
-			if len(x) > 0 {
-				name := x[1]
+// textDiff describes how two strings differ: the position of the first differing character
+// for single line strings, otherwise a line diff with "-" marking expected lines that are
+// missing and "+" marking unexpected lines
+func textDiff(expected string, actual string) string {
+	el := strings.Split(expected, "\n")
+	al := strings.Split(actual, "\n")
+	if len(el) == 1 && len(al) == 1 {
+		i := 0
+		for i < len(expected) && i < len(actual) && expected[i] == actual[i] {
+			i++
+		}
+		return fmt.Sprintf("first difference at character %d: expected %q, got %q", i, diffContext(expected, i), diffContext(actual, i))
+	}
 
-				var v []byte
-				v, err = readFile(path, x[0])
-				if err != nil {
-					return nil, err
-				}
-				var t []TestData
-				err = json.Unmarshal(v, &t)
-				if err != nil {
-					return nil, err
-				}
-				tests[name] = t
+	// longest common subsequence of lines
+	lcs := make([][]int, len(el)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(al)+1)
+	}
+	for i := len(el) - 1; i >= 0; i-- {
+		for j := len(al) - 1; j >= 0; j-- {
+			if el[i] == al[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
 			}
 		}
 	}
-	return tests, err
+	var lines []string
+	i, j := 0, 0
+	for i < len(el) || j < len(al) {
+		switch {
+		case i < len(el) && j < len(al) && el[i] == al[j]:
+			lines = append(lines, "  "+el[i])
+			i++
+			j++
+		case i < len(el) && (j == len(al) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+el[i])
+			i++
+		default:
+			lines = append(lines, "+ "+al[j])
+			j++
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
-func ToString(input interface{}) string {
-	// @TODO this should probably act according the function schema
-	// not just the return value
-	var output string
-	switch t := input.(type) {
-	case []byte:
-		output = string(t)
-	case string:
-		output = t
-	default:
-		output = fmt.Sprintf("%v", t)
+// diffContext returns up to 20 characters of s starting at i
+func diffContext(s string, i int) string {
+	if i >= len(s) {
+		return ""
 	}
-	return output
+	end := i + 20
+	if end > len(s) {
+		end = len(s)
+	}
+	return s[i:end]
 }
 
 func (h *Holochain) TestStringReplacements(input, r1, r2, r3 string) string {
@@ -1273,124 +4094,463 @@ func (h *Holochain) TestStringReplacements(input, r1, r2, r3 string) string {
 	return output
 }
 
+// simNet delivers the messages of the holochains of a multi-agent test directly to each
+// other's receivers, with the DHT held by one of them
+type simNet struct {
+	peers   map[peer.ID]*Holochain
+	dhtNode peer.ID // the peer holding the DHT
+}
+
+// newSimNet returns a simNet whose DHT is held by h
+func newSimNet(h *Holochain) *simNet {
+	return &simNet{peers: map[peer.ID]*Holochain{h.id: h}, dhtNode: h.id}
+}
+
+// send delivers a message from one holochain of the simNet to the receiver of another
+func (s *simNet) send(from *Holochain, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
+	target, ok := s.peers[to]
+	if !ok {
+		err = fmt.Errorf("unknown peer: %v", to)
+		return
+	}
+	message := &Message{Type: t, Time: time.Now(), From: from.id, Body: body}
+	response, err = receiver(target, message)
+	return
+}
+
+// newTestAgent starts a chain for a new agent of a multi-agent test with the DNA of h,
+// adding it to the simNet of h so that it shares h's DHT
+func (h *Holochain) newTestAgent(name string) (a *Holochain, err error) {
+	var agent Agent
+	if agent, err = NewAgent(IPFS, AgentName(name)); err != nil {
+		return
+	}
+	c := *h
+	a = &c
+	a.agent = agent
+	if a.id, err = peer.IDFromPrivateKey(agent.PrivKey()); err != nil {
+		return
+	}
+	a.node = nil
+	a.interfaces = nil
+	a.nuclei = nil
+	a.chain = NewChainInMemory()
+	a.dht = h.dht.shareWith(a)
+	if _, err = a.addGenesisEntries(); err != nil {
+		return
+	}
+	if err = a.dht.putAgent(); err != nil {
+		return
+	}
+	for zomeName, z := range a.Zomes {
+		if err = a.zomeGenesis(zomeName, z); err != nil {
+			return
+		}
+	}
+	h.sim.peers[a.id] = a
+	return
+}
+
+// TestOptions control how Test runs the test files
+type TestOptions struct {
+	Verbose bool // on failure also log how the result differs from what was expected
+}
+
+// TestResults holds the outcome of a test run in a form that tools can consume, i.e. to
+// produce JUnit-XML or TAP output
+type TestResults struct {
+	Err   error // set if the tests couldn't be run at all, i.e. the test files couldn't be loaded
+	Files []TestFileResult
+}
+
+// TestFileResult holds the outcome of running the steps of one test file
+type TestFileResult struct {
+	Name     string
+	Steps    []TestStepResult
+	Duration time.Duration
+}
+
+// TestStepResult holds the outcome of one step (line) of a test file.  Expected is the
+// expected output, error or regexp of the step with its replacements made, and Actual is
+// the result of the call, or its error if it failed.
+type TestStepResult struct {
+	Line     int
+	Agent    string
+	Zome     string
+	FnName   string
+	Passed   bool
+	Expected string
+	Actual   string
+	Errors   []error // why the step failed, including any failed DHT expectations
+	Duration time.Duration
+}
+
+// Errors returns the errors of all the failed steps of a test run, in order
+func (r *TestResults) Errors() (errs []error) {
+	if r.Err != nil {
+		return []error{r.Err}
+	}
+	for _, f := range r.Files {
+		errs = append(errs, f.Errors()...)
+	}
+	return
+}
+
+// Passed returns true if all the steps of a test run passed
+func (r *TestResults) Passed() bool {
+	return len(r.Errors()) == 0
+}
+
+// Errors returns the errors of the failed steps of a test file, in order
+func (r *TestFileResult) Errors() (errs []error) {
+	for _, s := range r.Steps {
+		errs = append(errs, s.Errors...)
+	}
+	return
+}
+
 // Test loops through each of the test files calling the functions specified
 // This function is useful only in the context of developing a holochain and will return
 // an error if the chain has already been started (i.e. has genesis entries)
+// Steps that name an Agent are run by a chain of that agent, started for the first step
+// naming it, and all the agents of a test file share a simulated DHT.
 func (h *Holochain) Test() []error {
-	info := h.config.Loggers.TestInfo
-	passed := h.config.Loggers.TestPassed
-	failed := h.config.Loggers.TestFailed
+	return h.TestWithOptions(TestOptions{})
+}
 
-	var err error
-	var errs []error
+// TestWithOptions does what Test does, as modified by the options
+func (h *Holochain) TestWithOptions(opts TestOptions) []error {
+	return h.RunTestsWithOptions(opts).Errors()
+}
+
+// RunTests does what Test does but returns the results of each step of each test file
+// rather than just the errors
+func (h *Holochain) RunTests() *TestResults {
+	return h.RunTestsWithOptions(TestOptions{})
+}
+
+// RunTestsWithOptions does what RunTests does, as modified by the options
+func (h *Holochain) RunTestsWithOptions(opts TestOptions) *TestResults {
+	return h.runTests("", opts)
+}
+
+// TestOne runs just the test file with the given name (as keyed by LoadTestData), resetting
+// and generating the chain for it the way Test does for each file
+func (h *Holochain) TestOne(name string) []error {
+	return h.TestOneWithOptions(name, TestOptions{})
+}
+
+// TestOneWithOptions does what TestOne does, as modified by the options
+func (h *Holochain) TestOneWithOptions(name string, opts TestOptions) []error {
+	return h.runTests(name, opts).Errors()
+}
+
+// runTests runs the test file with the given name, or all of them (in name order) if the
+// name is empty, and reports the outcome
+func (h *Holochain) runTests(name string, opts TestOptions) (results *TestResults) {
+	results = &TestResults{}
+	tests, err := h.loadTests()
+	if err != nil {
+		results.Err = err
+		return
+	}
+	var names []string
+	if name != "" {
+		if _, ok := tests[name]; !ok {
+			results.Err = fmt.Errorf("no test data named %s", name)
+			return
+		}
+		names = []string{name}
+	} else {
+		for n := range tests {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+	}
+	for _, n := range names {
+		results.Files = append(results.Files, h.testFile(n, tests[n], opts))
+	}
+	h.reportTests(results.Errors())
+	return
+}
+
+// loadTests loads the test files of a holochain that hasn't been started
+func (h *Holochain) loadTests() (tests map[string][]TestData, err error) {
 	if h.Started() {
 		err = errors.New("chain already started")
-		return []error{err}
+		return
 	}
+	tests, err = LoadTestData(h.path + "/test")
+	return
+}
 
-	// load up the test files into the tests array
-	var tests, errorLoad = LoadTestData(h.path + "/test")
-	if errorLoad != nil {
-		return []error{errorLoad}
+// reportTests logs the outcome of a test run
+func (h *Holochain) reportTests(errs []error) {
+	if len(errs) == 0 {
+		h.config.Loggers.TestPassed.p(fmt.Sprintf("\n==================================================================\n\t\t+++++ All tests passed :D +++++\n=================================================================="))
+	} else {
+		h.config.Loggers.TestFailed.pf(fmt.Sprintf("\n==================================================================\n\t\t+++++ %d test(s) failed :( +++++\n==================================================================", len(errs)))
 	}
+}
+
+// testFile runs the steps of a test file against a freshly generated chain, returning the
+// result of each step
+func (h *Holochain) testFile(name string, ts []TestData, opts TestOptions) (result TestFileResult) {
+	info := h.config.Loggers.TestInfo
+	passed := h.config.Loggers.TestPassed
+	failed := h.config.Loggers.TestFailed
 
+	result.Name = name
+	fileStart := time.Now()
+	var err error
 	var lastResults [3]interface{}
-	for name, ts := range tests {
-		info.p("========================================")
-		info.pf("Test: '%s' starting...", name)
-		info.p("========================================")
-		// setup the genesis entries
-		err = h.Reset()
-		_, err = h.GenChain()
-		if err != nil {
-			panic("gen err " + err.Error())
-		}
-		go h.dht.HandlePutReqs()
-		for i, t := range ts {
-			Debugf("------------------------------")
-			info.pf("Test '%s' line %d: %s", name, i, t)
-			time.Sleep(time.Millisecond * 10)
-			if err == nil {
-				testID := fmt.Sprintf("%s:%d", name, i)
-				input := t.Input
-				Debugf("Input before replacement: %s", input)
-				r1 := strings.Trim(fmt.Sprintf("%v", lastResults[0]), "\"")
-				r2 := strings.Trim(fmt.Sprintf("%v", lastResults[1]), "\"")
-				r3 := strings.Trim(fmt.Sprintf("%v", lastResults[2]), "\"")
-				input = h.TestStringReplacements(input, r1, r2, r3)
-				Debugf("Input after replacement: %s", input)
-				//====================
-				var actualResult, actualError = h.Call(t.Zome, t.FnName, input)
-				var expectedResult, expectedError = t.Output, t.Err
-				var expectedResultRegexp = t.Regexp
-				//====================
-				lastResults[2] = lastResults[1]
-				lastResults[1] = lastResults[0]
-				lastResults[0] = actualResult
-				if expectedError != "" {
-					comparisonString := fmt.Sprintf("\nTest: %s\n\tExpected error:\t%v\n\tGot error:\t\t%v", testID, expectedError, actualError)
-					if actualError == nil || (actualError.Error() != expectedError) {
-						failed.pf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString)
-						err = fmt.Errorf(expectedError)
-					} else {
-						// all fine
-						Debugf("%s\n\tpassed :D", comparisonString)
-						err = nil
+	info.p("========================================")
+	info.pf("Test: '%s' starting...", name)
+	info.p("========================================")
+	// setup the genesis entries
+	err = h.Reset()
+	_, err = h.GenChain()
+	if err != nil {
+		panic("gen err " + err.Error())
+	}
+	go h.dht.HandlePutReqs()
+	agents := make(map[string]*Holochain)
+	for _, t := range ts {
+		if t.Agent != "" {
+			h.sim = newSimNet(h)
+			break
+		}
+	}
+	for i, t := range ts {
+		Debugf("------------------------------")
+		info.pf("Test '%s' line %d: %s", name, i, t)
+		time.Sleep(time.Millisecond * 10)
+		start := time.Now()
+		step := TestStepResult{Line: i, Agent: t.Agent, Zome: t.Zome, FnName: t.FnName}
+		var dhtErrs []error
+		a := h
+		if t.Agent != "" {
+			if a = agents[t.Agent]; a == nil {
+				if a, err = h.newTestAgent(t.Agent); err != nil {
+					err = fmt.Errorf("\nTest: %s:%d\n\tcouldn't start agent %s: %v", name, i, t.Agent, err)
+				} else {
+					agents[t.Agent] = a
+				}
+			}
+		}
+		if err == nil {
+			testID := fmt.Sprintf("%s:%d", name, i)
+			input := t.Input
+			Debugf("Input before replacement: %s", input)
+			r1 := strings.Trim(fmt.Sprintf("%v", lastResults[0]), "\"")
+			r2 := strings.Trim(fmt.Sprintf("%v", lastResults[1]), "\"")
+			r3 := strings.Trim(fmt.Sprintf("%v", lastResults[2]), "\"")
+			input = a.TestStringReplacements(input, r1, r2, r3)
+			Debugf("Input after replacement: %s", input)
+			//====================
+			var actualResult interface{}
+			var actualError error
+			if input, actualError = decodeTestInput(t, input); actualError == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+				actualResult, actualError = a.CallWithContext(ctx, t.Zome, t.FnName, input)
+				cancel()
+			}
+			step.Expected = a.testExpectation(t, r1, r2, r3)
+			if actualError != nil {
+				step.Actual = actualError.Error()
+			} else {
+				step.Actual = testResultString(t, actualResult)
+			}
+			var expectedResult, expectedError = t.Output, t.Err
+			var expectedResultRegexp = t.Regexp
+			//====================
+			lastResults[2] = lastResults[1]
+			lastResults[1] = lastResults[0]
+			lastResults[0] = actualResult
+			if expectedError != "" {
+				comparisonString := fmt.Sprintf("\nTest: %s\n\tExpected error:\t%v\n\tGot error:\t\t%v", testID, expectedError, actualError)
+				if actualError == nil || (actualError.Error() != expectedError) {
+					if opts.Verbose && actualError != nil {
+						comparisonString += "\n\tDiff:\n" + textDiff(expectedError, actualError.Error())
 					}
+					failed.pf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString)
+					err = errors.New(expectedError)
+				} else {
+					// all fine
+					Debugf("%s\n\tpassed :D", comparisonString)
+					err = nil
+				}
+			} else {
+				if actualError != nil {
+					errorString := fmt.Sprintf("\nTest: %s\n\tExpected:\t%s\n\tGot Error:\t\t%s\n", testID, expectedResult, actualError)
+					err = errors.New(errorString)
+					failed.pf("\n=====================\n%s\n\tfailed! m(\n=====================", errorString)
 				} else {
-					if actualError != nil {
-						errorString := fmt.Sprintf("\nTest: %s\n\tExpected:\t%s\n\tGot Error:\t\t%s\n", testID, expectedResult, actualError)
-						err = fmt.Errorf(errorString)
-						failed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", errorString))
+					var resultString = testResultString(t, actualResult)
+					var match bool
+					var comparisonString string
+					if expectedResultRegexp != "" {
+						Debugf("Test %s matching against regexp...", testID)
+						expectedResultRegexp = a.TestStringReplacements(expectedResultRegexp, r1, r2, r3)
+						comparisonString = fmt.Sprintf("\nTest: %s\n\tExpected regexp:\t%v\n\tGot:\t\t%v", testID, expectedResultRegexp, resultString)
+						var matchError error
+						match, matchError = regexp.MatchString(expectedResultRegexp, resultString)
+						//match, matchError = regexp.MatchString("[0-9]", "7")
+						if matchError != nil {
+							Infof(err.Error())
+						}
 					} else {
-						var resultString = ToString(actualResult)
-						var match bool
-						var comparisonString string
-						if expectedResultRegexp != "" {
-							Debugf("Test %s matching against regexp...", testID)
-							expectedResultRegexp = h.TestStringReplacements(expectedResultRegexp, r1, r2, r3)
-							comparisonString = fmt.Sprintf("\nTest: %s\n\tExpected regexp:\t%v\n\tGot:\t\t%v", testID, expectedResultRegexp, resultString)
-							var matchError error
-							match, matchError = regexp.MatchString(expectedResultRegexp, resultString)
-							//match, matchError = regexp.MatchString("[0-9]", "7")
-							if matchError != nil {
-								Infof(err.Error())
-							}
+						if t.JSONMatch {
+							Debugf("Test %s matching against JSON...", testID)
 						} else {
 							Debugf("Test %s matching against string...", testID)
-							expectedResult = h.TestStringReplacements(expectedResult, r1, r2, r3)
-							comparisonString = fmt.Sprintf("\nTest: %s\n\tExpected:\t%v\n\tGot:\t\t%v", testID, expectedResult, resultString)
-							match = (resultString == expectedResult)
 						}
+						expectedResult = a.TestStringReplacements(expectedResult, r1, r2, r3)
+						match, comparisonString = compareTestOutput(testID, expectedResult, resultString, t.JSONMatch, opts.Verbose)
+					}
 
-						if match {
-							Debugf("%s\n\tpassed! :D", comparisonString)
-							passed.p("passed! ✔")
-						} else {
-							err = fmt.Errorf(comparisonString)
-							failed.pf(fmt.Sprintf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString))
-						}
+					if match {
+						Debugf("%s\n\tpassed! :D", comparisonString)
+						passed.p("passed! ✔")
+					} else {
+						err = errors.New(comparisonString)
+						failed.pf("\n=====================\n%s\n\tfailed! m(\n=====================", comparisonString)
 					}
 				}
 			}
-
-			if err != nil {
-				errs = append(errs, err)
-				err = nil
+			if len(t.DHT) > 0 {
+				a.dht.waitPuts()
+				for j, x := range t.DHT {
+					if e := a.checkDHTExpectation(fmt.Sprintf("%s dht:%d", testID, j), x, r1, r2, r3, opts); e != nil {
+						failed.pf("\n=====================\n%s\n\tfailed! m(\n=====================", e)
+						dhtErrs = append(dhtErrs, e)
+					}
+				}
 			}
 		}
-		// restore the state for the next test file
-		e := h.Reset()
-		if e != nil {
-			panic(e)
+
+		if err != nil {
+			step.Errors = append(step.Errors, err)
+			err = nil
 		}
+		step.Errors = append(step.Errors, dhtErrs...)
+		step.Passed = len(step.Errors) == 0
+		step.Duration = time.Since(start)
+		result.Steps = append(result.Steps, step)
 	}
-	if len(errs) == 0 {
-		passed.p(fmt.Sprintf("\n==================================================================\n\t\t+++++ All tests passed :D +++++\n=================================================================="))
+	// restore the state for the next test file
+	h.sim = nil
+	e := h.Reset()
+	if e != nil {
+		panic(e)
+	}
+	result.Duration = time.Since(fileStart)
+	return
+}
+
+// decodeTestInput decodes the Input of a test step according to its Encoding
+func decodeTestInput(t TestData, input string) (decoded string, err error) {
+	switch t.Encoding {
+	case "":
+		decoded = input
+	case TestEncodingBase64:
+		var b []byte
+		if b, err = base64.StdEncoding.DecodeString(input); err != nil {
+			err = fmt.Errorf("couldn't decode Input: %w", err)
+			return
+		}
+		decoded = string(b)
+	default:
+		err = fmt.Errorf("unknown test encoding: %s", t.Encoding)
+	}
+	return
+}
+
+// testResultString returns the result of a test step's call as the string that's compared
+// with the step's Output, encoded according to the step's Encoding
+func testResultString(t TestData, result interface{}) string {
+	s := ToString(result)
+	if t.Encoding == TestEncodingBase64 {
+		s = base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return s
+}
+
+// testExpectation returns what a test step expects: its error, regexp or output, with the
+// replacements made
+func (h *Holochain) testExpectation(t TestData, r1, r2, r3 string) string {
+	switch {
+	case t.Err != "":
+		return t.Err
+	case t.Regexp != "":
+		return h.TestStringReplacements(t.Regexp, r1, r2, r3)
+	}
+	return h.TestStringReplacements(t.Output, r1, r2, r3)
+}
+
+// checkDHTExpectation compares what the DHT holds with what a DHTExpectation of a test step
+// expects, returning an error that describes the difference if they don't match
+func (h *Holochain) checkDHTExpectation(testID string, x DHTExpectation, r1, r2, r3 string, opts TestOptions) (err error) {
+	var result string
+	var actualError error
+	if x.Base != "" {
+		var base Hash
+		if base, actualError = NewHash(h.TestStringReplacements(x.Base, r1, r2, r3)); actualError == nil {
+			var entries []MetaEntry
+			if entries, actualError = h.dht.getMeta(base, x.Tag); actualError == nil {
+				var j []byte
+				if j, actualError = CanonicalJSON(entries); actualError == nil {
+					result = string(j)
+				}
+			}
+		}
 	} else {
-		failed.pf(fmt.Sprintf("\n==================================================================\n\t\t+++++ %d test(s) failed :( +++++\n==================================================================", len(errs)))
+		var key Hash
+		if key, actualError = NewHash(h.TestStringReplacements(x.Hash, r1, r2, r3)); actualError == nil {
+			var entry Entry
+			if entry, _, _, actualError = h.dht.Get(key); actualError == nil {
+				result = ToString(entry.Content())
+			}
+		}
+	}
+
+	if x.Err != "" {
+		if actualError == nil || actualError.Error() != x.Err {
+			err = fmt.Errorf("\nTest: %s\n\tExpected error:\t%v\n\tGot error:\t\t%v", testID, x.Err, actualError)
+		}
+		return
+	}
+	if actualError != nil {
+		err = fmt.Errorf("\nTest: %s\n\tExpected:\t%s\n\tGot Error:\t\t%s\n", testID, x.Output, actualError)
+		return
+	}
+	expected := h.TestStringReplacements(x.Output, r1, r2, r3)
+	if match, comparisonString := compareTestOutput(testID, expected, result, x.JSONMatch, opts.Verbose); !match {
+		err = errors.New(comparisonString)
 	}
-	return errs
+	return
+}
+
+// compareTestOutput compares the result of a test step with what it expects, as JSON if
+// asJSON is set and otherwise as text, returning whether they match and a description of
+// the comparison, with a diff if they don't match and verbose is set
+func compareTestOutput(testID string, expected string, result string, asJSON bool, verbose bool) (match bool, comparisonString string) {
+	if asJSON {
+		comparisonString = fmt.Sprintf("\nTest: %s\n\tExpected JSON:\t%v\n\tGot:\t\t%v", testID, expected, result)
+		var matchError error
+		if match, matchError = jsonMatch(expected, result); matchError != nil {
+			comparisonString += fmt.Sprintf("\n\t%v", matchError)
+		} else if !match && verbose {
+			diffs, _ := jsonDiff(expected, result)
+			comparisonString += "\n\tDiff:\n" + strings.Join(diffs, "\n")
+		}
+		return
+	}
+	comparisonString = fmt.Sprintf("\nTest: %s\n\tExpected:\t%v\n\tGot:\t\t%v", testID, expected, result)
+	if match = (result == expected); !match && verbose {
+		comparisonString += "\n\tDiff:\n" + textDiff(expected, result)
+	}
+	return
 }
 
 // GetProperty returns the value of a DNA property
@@ -1403,14 +4563,69 @@ func (h *Holochain) GetProperty(prop string) (property string, err error) {
 	return
 }
 
+// validateProperties checks properties against the PropertiesSchema
+// if there is no schema, or the schema file is missing, any properties are valid
+func (h *Holochain) validateProperties(properties map[string]string) (err error) {
+	if h.PropertiesSchema == "" || !fileExists(h.path+"/"+h.PropertiesSchema) {
+		return
+	}
+	var v *JSONSchemaValidator
+	v, err = BuildJSONSchemaValidatorFromFile(h.path, h.PropertiesSchema)
+	if err != nil {
+		err = fmt.Errorf("error building validator for %s: %v", h.PropertiesSchema, err)
+		return
+	}
+	input := make(map[string]interface{})
+	for k, p := range properties {
+		input[k] = p
+	}
+	if err = v.Validate(input); err != nil {
+		err = &ValidationError{Err: fmt.Errorf("DNA properties invalid: %v", err)}
+	}
+	return
+}
+
+// SetProperty sets the value of a DNA property, validating the resulting properties against
+// the PropertiesSchema and saving the DNA.  Because properties are part of the DNA (and thus
+// its hash) they can't be changed once the chain has been started.
+func (h *Holochain) SetProperty(prop string, value string) (err error) {
+	if h.Started() {
+		err = mkErr("properties can't be changed after the chain has been started")
+		return
+	}
+	if prop == ID_PROPERTY || prop == AGENT_ID_PROPERTY || prop == AGENT_NAME_PROPERTY {
+		err = mkErr("can't set reserved property: " + prop)
+		return
+	}
+
+	properties := make(map[string]string)
+	for k, v := range h.Properties {
+		properties[k] = v
+	}
+	properties[prop] = value
+
+	if err = h.validateProperties(properties); err != nil {
+		return
+	}
+
+	h.Properties = properties
+	err = h.SaveDNA(true)
+	return
+}
+
 // Reset deletes all chain and dht data and resets data structures
 func (h *Holochain) Reset() (err error) {
 
 	h.dnaHash = Hash{}
 	h.agentHash = Hash{}
+	h.resetInterfaces()
+	nucleiLock.Lock()
+	h.nuclei = nil
+	nucleiLock.Unlock()
 
-	if h.chain.s != nil {
-		h.chain.s.Close()
+	// the node is left running so that an activated holochain can be reset (i.e. by Test)
+	if err = h.closeStores(); err != nil {
+		return
 	}
 
 	/*	err = h.store.Remove()
@@ -1418,16 +4633,16 @@ func (h *Holochain) Reset() (err error) {
 			panic(err)
 		}
 	*/
-	err = os.RemoveAll(h.path + "/" + DNAHashFileName)
+	err = os.RemoveAll(h.DataPath() + "/" + DNAHashFileName)
 	if err != nil {
 		panic(err)
 	}
 
-	err = os.RemoveAll(h.path + "/" + StoreFileName + ".db")
+	err = os.RemoveAll(h.DataPath() + "/" + StoreFileName + ".db")
 	if err != nil {
 		panic(err)
 	}
-	err = os.RemoveAll(h.path + "/dht.db")
+	err = removeDHTFiles(h.DataPath())
 	if err != nil {
 		panic(err)
 	}
@@ -1439,6 +4654,81 @@ func (h *Holochain) Reset() (err error) {
 	*/
 	h.chain = NewChain()
 	h.dht = NewDHT(h)
+	err = h.dht.checkStore()
+	return
+}
+
+// ReindexDHT replaces the DHT with a fresh one rebuilt from the local chain, replaying the puts
+// that genesis and the chain's commits would have made.  Like Reset it replaces the DHT, so any
+// put handling, gossip or sweep loops started on the old one must be restarted.
+func (h *Holochain) ReindexDHT() (err error) {
+	if !h.Started() {
+		err = mkErr("chain not started")
+		return
+	}
+	if h.dht != nil {
+		h.dht.Close()
+	}
+	if err = removeDHTFiles(h.DataPath()); err != nil {
+		return
+	}
+	h.dht = NewDHT(h)
+	if err = h.dht.checkStore(); err != nil {
+		return
+	}
+	if err = h.dht.SetupDHT(); err != nil {
+		return
+	}
+	for i, hd := range h.chain.Headers {
+		// private entries are never published so they don't belong in the DHT
+		if hd.Type == DNAEntryType || h.isPrivate(hd.Type) {
+			continue
+		}
+		var b []byte
+		if b, err = h.chain.Entries[i].Marshal(); err != nil {
+			return
+		}
+		if err = h.dht.put(nil, hd.Type, hd.EntryLink, h.id, b, LIVE); err != nil {
+			return
+		}
+		if err = h.dht.setHeader(hd.EntryLink, hd); err != nil {
+			return
+		}
+		if err = h.dht.checkFork(h.id, hd); err != nil {
+			return
+		}
+		if ttl := h.dht.entryTTL(hd.Type, PutReq{}); ttl > 0 {
+			if err = h.dht.setExpiry(hd.EntryLink, hd.Time.Add(ttl)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close shuts down the holochain's node and closes its DHT and chain stores, stopping the
+// DHT's background loops.  It is safe to call more than once.
+func (h *Holochain) Close() (err error) {
+	if h.node != nil {
+		err = h.node.Close()
+		h.node = nil
+	}
+	if e := h.closeStores(); err == nil {
+		err = e
+	}
+	return
+}
+
+// closeStores stops the DHT's background loops and closes the DHT and chain stores
+func (h *Holochain) closeStores() (err error) {
+	if h.dht != nil {
+		err = h.dht.Close()
+	}
+	if h.chain != nil {
+		if e := h.chain.Close(); err == nil {
+			err = e
+		}
+	}
 	return
 }
 
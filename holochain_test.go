@@ -1,14 +1,30 @@
 package holochain
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	gob "encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	toml "github.com/BurntSushi/toml"
 	"github.com/google/uuid"
 	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -40,8 +56,8 @@ func TestNew(t *testing.T) {
 		nz := h.Zomes["myZome"]
 		So(nz.Description, ShouldEqual, "zome desc")
 		So(nz.Code, ShouldEqual, "zome_myZome.zy")
-		So(fmt.Sprintf("%v", nz.Entries["myData1"]), ShouldEqual, "{myData1 string   <nil>}")
-		So(fmt.Sprintf("%v", nz.Entries["myData2"]), ShouldEqual, "{myData2 zygo   <nil>}")
+		So(nz.Entries["myData1"], ShouldResemble, EntryDef{Name: "myData1", DataFormat: DataFormatString})
+		So(nz.Entries["myData2"], ShouldResemble, EntryDef{Name: "myData2", DataFormat: DataFormatRawZygo})
 	})
 
 }
@@ -105,7 +121,8 @@ func TestGenDev(t *testing.T) {
 		So(lh.config.Port, ShouldEqual, DefaultPort)
 		So(h.config.PeerModeDHTNode, ShouldEqual, s.Settings.DefaultPeerModeDHTNode)
 		So(h.config.PeerModeAuthor, ShouldEqual, s.Settings.DefaultPeerModeAuthor)
-		So(h.config.BootstrapServer, ShouldEqual, s.Settings.DefaultBootstrapServer)
+		So(h.config.BootstrapServer, ShouldResemble, BootstrapServers{s.Settings.DefaultBootstrapServer})
+		So(lh.config.Persister, ShouldEqual, BoltPersisterName)
 		//		lh.store.Close()
 
 		So(fileExists(h.path+"/schema_profile.json"), ShouldBeTrue)
@@ -120,6 +137,55 @@ func TestGenDev(t *testing.T) {
 	})
 }
 
+func TestGenDevTemplate(t *testing.T) {
+	d, s := setupTestService()
+	defer cleanupTestDir(d)
+
+	Convey("it should generate the demo holochain from the demo template", t, func() {
+		h, err := s.GenDevTemplate(s.Path+"/demo", "toml", DemoDevTemplate)
+		So(err, ShouldBeNil)
+		So(len(h.Zomes), ShouldEqual, 2)
+		So(fileExists(h.path+"/test/grouped.json"), ShouldBeTrue)
+		_, err = s.GenDevTemplate(s.Path+"/demo2", "toml", DemoDevTemplate, DevZome{Name: "z", NucleusType: JSNucleusType})
+		So(err.Error(), ShouldEqual, "the demo template doesn't take zomes")
+	})
+
+	Convey("it should scaffold the given zomes from the blank template", t, func() {
+		h, err := s.GenDevTemplate(s.Path+"/blank", "toml", BlankDevTemplate,
+			DevZome{Name: "posts", NucleusType: JSNucleusType},
+			DevZome{Name: "users", NucleusType: ZygoNucleusType})
+		So(err, ShouldBeNil)
+		So(len(h.Zomes), ShouldEqual, 2)
+		So(h.Zomes["posts"].Code, ShouldEqual, "zome_posts.js")
+		So(h.Zomes["users"].Code, ShouldEqual, "zome_users.zy")
+		So(dirExists(h.path+"/test"), ShouldBeFalse)
+		So(dirExists(h.path+"/ui"), ShouldBeFalse)
+
+		h, err = s.Load("blank")
+		So(err, ShouldBeNil)
+		_, err = h.GenChain()
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should scaffold a single zygo zome by default", t, func() {
+		h, err := s.GenDevTemplate(s.Path+"/single", "json", BlankDevTemplate)
+		So(err, ShouldBeNil)
+		So(len(h.Zomes), ShouldEqual, 1)
+		So(h.Zomes["myZome"].NucleusType, ShouldEqual, ZygoNucleusType)
+	})
+
+	Convey("it should reject bad templates and zomes", t, func() {
+		_, err := s.GenDevTemplate(s.Path+"/bad", "json", "bogus")
+		So(err.Error(), ShouldEqual, "unknown dev template: bogus")
+		_, err = s.GenDevTemplate(s.Path+"/bad", "json", BlankDevTemplate, DevZome{Name: "z", NucleusType: "lua"})
+		So(err.Error(), ShouldEqual, "unknown nucleus type:lua")
+		_, err = s.GenDevTemplate(s.Path+"/bad", "json", BlankDevTemplate,
+			DevZome{Name: "z", NucleusType: JSNucleusType}, DevZome{Name: "z", NucleusType: JSNucleusType})
+		So(err.Error(), ShouldEqual, "duplicate zome name: z")
+		So(dirExists(s.Path+"/bad"), ShouldBeFalse)
+	})
+}
+
 func TestCloneNew(t *testing.T) {
 	d, s, h0 := setupTestChain("test")
 	defer cleanupTestDir(d)
@@ -174,6 +240,305 @@ func TestCloneJoin(t *testing.T) {
 	})
 }
 
+// archiveDir makes a gzipped tar (or a zip) archive of the files in dir, naming them with prefix
+func archiveDir(dir string, prefix string, zipped bool) []byte {
+	var buf bytes.Buffer
+	var zw *zip.Writer
+	var gw *gzip.Writer
+	var tw *tar.Writer
+	if zipped {
+		zw = zip.NewWriter(&buf)
+	} else {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if zipped {
+			w, err := zw.Create(prefix + rel)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}
+		if err = tw.WriteHeader(&tar.Header{Name: prefix + rel, Mode: 0600, Size: int64(len(b)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	if zipped {
+		zw.Close()
+	} else {
+		tw.Close()
+		gw.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestCloneFromURL(t *testing.T) {
+	d, s, h0 := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	orig := s.Path + "/test"
+	archives := map[string][]byte{
+		"/dna.tgz": archiveDir(orig, "test/", false),
+		"/dna.zip": archiveDir(orig, "", true),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a, ok := archives[r.URL.Path]; ok {
+			w.Write(a)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	hash, err := DNAHashOf(h0)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should install the DNA of a tar archive", t, func() {
+		h, err := s.CloneFromURL(srv.URL+"/dna.tgz", s.Path+"/test2", hash, true)
+		So(err, ShouldBeNil)
+		So(h.Name, ShouldEqual, "test2")
+		So(h.Id, ShouldNotEqual, h0.Id)
+		src, _ := readFile(orig, "zome_myZome.zy")
+		dst, _ := readFile(h.path, "zome_myZome.zy")
+		So(string(src), ShouldEqual, string(dst))
+		So(fileExists(h.path+"/ui/index.html"), ShouldBeTrue)
+		So(fileExists(h.path+"/schema_profile.json"), ShouldBeTrue)
+	})
+
+	Convey("it should install the DNA of a zip archive", t, func() {
+		h, err := s.CloneFromURL(srv.URL+"/dna.zip", s.Path+"/test3", hash, false)
+		So(err, ShouldBeNil)
+		So(h.Id, ShouldEqual, h0.Id)
+		So(fileExists(h.path+"/ui/index.html"), ShouldBeTrue)
+	})
+
+	Convey("it should refuse DNA that doesn't have the expected hash", t, func() {
+		other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err := s.CloneFromURL(srv.URL+"/dna.zip", s.Path+"/test4", other, false)
+		So(errors.Is(err, ErrDNAHashMismatch), ShouldBeTrue)
+		So(dirExists(s.Path+"/test4"), ShouldBeFalse)
+
+		_, err = s.CloneFromURL(srv.URL+"/dna.zip", s.Path+"/test4", Hash{}, false)
+		So(err.Error(), ShouldEqual, "holochain: an expected DNA hash is required to clone from a URL")
+	})
+
+	Convey("it should report failed downloads", t, func() {
+		_, err := s.CloneFromURL(srv.URL+"/bogus.zip", s.Path+"/test4", hash, false)
+		So(errors.Is(err, ErrDNADownload), ShouldBeTrue)
+		So(err.Error(), ShouldEndWith, "returned status 404 Not Found")
+
+		_, err = s.CloneFromURL("http://127.0.0.1:1/dna.zip", s.Path+"/test4", hash, false)
+		So(errors.Is(err, ErrDNADownload), ShouldBeTrue)
+	})
+
+	Convey("it should refuse archive entries outside of the archive", t, func() {
+		tmp, _ := ioutil.TempDir("", "holochain-test")
+		defer os.RemoveAll(tmp)
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0600, Size: 1, Typeflag: tar.TypeReg})
+		tw.Write([]byte("x"))
+		tw.Close()
+		err := extractArchive(buf.Bytes(), tmp)
+		So(err.Error(), ShouldEqual, "archive entry ../evil is outside of the archive")
+	})
+
+	Convey("it should extract into a destination that isn't a clean path", t, func() {
+		tmp, _ := ioutil.TempDir("", "holochain-test")
+		defer os.RemoveAll(tmp)
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "dna.json", Mode: 0600, Size: 2, Typeflag: tar.TypeReg})
+		tw.Write([]byte("{}"))
+		tw.Close()
+		So(extractArchive(buf.Bytes(), tmp+"/"), ShouldBeNil)
+		So(fileExists(tmp+"/dna.json"), ShouldBeTrue)
+		So(extractArchive(buf.Bytes(), tmp+"/./sub/../"), ShouldBeNil)
+	})
+
+	Convey("it should limit what is extracted from an archive", t, func() {
+		tmp, _ := ioutil.TempDir("", "holochain-test")
+		defer os.RemoveAll(tmp)
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range []string{"a", "b", "c"} {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: 4, Typeflag: tar.TypeReg})
+			tw.Write([]byte("abcd"))
+		}
+		tw.Close()
+
+		defer func(size int64, entries int) {
+			MaxDNAArchiveExtractedSize = size
+			MaxDNAArchiveEntries = entries
+		}(MaxDNAArchiveExtractedSize, MaxDNAArchiveEntries)
+		MaxDNAArchiveExtractedSize = 10
+		err := extractArchive(buf.Bytes(), tmp)
+		So(err.Error(), ShouldEqual, "archive expands to more than 10 bytes")
+
+		MaxDNAArchiveExtractedSize = 12
+		MaxDNAArchiveEntries = 2
+		err = extractArchive(buf.Bytes(), tmp)
+		So(err.Error(), ShouldEqual, "archive has more than 2 entries")
+
+		MaxDNAArchiveEntries = 3
+		So(extractArchive(buf.Bytes(), tmp), ShouldBeNil)
+	})
+}
+
+func TestEncodingFormat(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should report the format the DNA was loaded in", t, func() {
+		So(h.EncodingFormat(), ShouldEqual, "toml")
+	})
+
+	Convey("it should only accept supported formats", t, func() {
+		err := h.SetEncodingFormat("xml")
+		So(err.Error(), ShouldEqual, "unknown encoding format: xml")
+		So(h.EncodingFormat(), ShouldEqual, "toml")
+	})
+
+	Convey("it should convert the DNA to another format with SaveDNA", t, func() {
+		err := h.SetEncodingFormat("json")
+		So(err, ShouldBeNil)
+		So(h.EncodingFormat(), ShouldEqual, "json")
+		err = h.SaveDNA(false)
+		So(err, ShouldBeNil)
+		So(fileExists(h.path+"/"+DNAFileName+".json"), ShouldBeTrue)
+
+		f, err := os.Open(h.path + "/" + DNAFileName + ".json")
+		So(err, ShouldBeNil)
+		defer f.Close()
+		h2, err := DecodeDNA(f, "json")
+		So(err, ShouldBeNil)
+		So(h2.Name, ShouldEqual, h.Name)
+		So(h2.EncodingFormat(), ShouldEqual, "json")
+	})
+
+	Convey("it should replace the DNA and config in the old format so the chain loads in the new one", t, func() {
+		So(fileExists(h.path+"/"+DNAFileName+".toml"), ShouldBeFalse)
+		So(fileExists(h.path+"/"+ConfigFileName+".toml"), ShouldBeFalse)
+		So(fileExists(h.path+"/"+ConfigFileName+".json"), ShouldBeTrue)
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.EncodingFormat(), ShouldEqual, "json")
+		So(h2.Name, ShouldEqual, h.Name)
+		So(h2.config.Port, ShouldEqual, h.config.Port)
+
+		err = h.SetEncodingFormat("yaml")
+		So(err, ShouldBeNil)
+		err = h.SaveDNA(false)
+		So(err, ShouldBeNil)
+		So(fileExists(h.path+"/"+DNAFileName+".json"), ShouldBeFalse)
+		h2, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.EncodingFormat(), ShouldEqual, "yaml")
+	})
+
+	Convey("it should not change the format of a started chain", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		err = h.SetEncodingFormat("yaml")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCompressedDNA(t *testing.T) {
+	d, s, h0 := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	h0.SetDNACompression(true)
+	if err := h0.SaveDNA(false); err != nil {
+		panic(err)
+	}
+
+	Convey("it should find gzip compressed DNA", t, func() {
+		So(fileExists(h0.path+"/"+DNAFileName+".toml"+CompressedDNASuffix), ShouldBeTrue)
+		So(fileExists(h0.path+"/"+DNAFileName+".toml"), ShouldBeFalse)
+		f, err := findDNA(h0.path)
+		So(err, ShouldBeNil)
+		So(f, ShouldEqual, "toml.gz")
+	})
+
+	Convey("it should load gzip compressed DNA", t, func() {
+		h, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.Name, ShouldEqual, h0.Name)
+		So(h.Id, ShouldEqual, h0.Id)
+		So(h.encodingFormat, ShouldEqual, "toml")
+		So(h.compressDNA, ShouldBeTrue)
+		So(h.config.Port, ShouldEqual, h0.config.Port)
+	})
+
+	Convey("it should keep the DNA compressed when cloning", t, func() {
+		h, err := s.Clone(h0.path, s.Path+"/test2", true)
+		So(err, ShouldBeNil)
+		So(h.Name, ShouldEqual, "test2")
+		So(fileExists(h.path+"/"+DNAFileName+".toml"+CompressedDNASuffix), ShouldBeTrue)
+		So(fileExists(h.path+"/"+ConfigFileName+".toml"), ShouldBeTrue)
+	})
+}
+
+func TestFork(t *testing.T) {
+	d, s, h0 := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	root := s.Path + "/forked"
+	h, err := h0.Fork(root)
+	Convey("it should create a new holochain from a running one", t, func() {
+		So(err, ShouldBeNil)
+		So(h.Name, ShouldEqual, "forked")
+		So(h.Id, ShouldNotEqual, h0.Id)
+		So(h.Started(), ShouldBeFalse)
+		So(h.chain.Length(), ShouldEqual, 0)
+		So(h.node, ShouldBeNil)
+		So(h.hashSpec, ShouldResemble, h0.hashSpec)
+		So(h.config.Port, ShouldEqual, h0.config.Port)
+		So(h.Properties, ShouldResemble, h0.Properties)
+		agent, err := LoadAgent(s.Path)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(h.agent.PrivKey(), agent.PrivKey()), ShouldBeTrue)
+		src, _ := readFile(h0.path, "zome_myZome.zy")
+		dst, _ := readFile(root, "zome_myZome.zy")
+		So(string(src), ShouldEqual, string(dst))
+		So(fileExists(root+"/ui/index.html"), ShouldBeTrue)
+		So(fileExists(root+"/schema_profile.json"), ShouldBeTrue)
+		So(fileExists(root+"/"+ConfigFileName+".toml"), ShouldBeTrue)
+		So(fileExists(root+"/"+DNAFileName+".toml"), ShouldBeTrue)
+	})
+
+	Convey("its DNA should be a copy that doesn't share state with the original", t, func() {
+		h.Properties["language"] = "fr"
+		h.Zomes["myZome"].Description = "changed"
+		So(h0.Properties["language"], ShouldEqual, "en")
+		So(h0.Zomes["myZome"].Description, ShouldNotEqual, "changed")
+	})
+
+	Convey("it should be able to start its own chain", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		So(h.DNAHash().String(), ShouldNotEqual, h0.DNAHash().String())
+	})
+}
+
 func TestNewEntry(t *testing.T) {
 	d, s := setupTestService()
 	defer cleanupTestDir(d)
@@ -283,6 +648,58 @@ func TestHeader(t *testing.T) {
 	})
 }
 
+func TestInterfaces(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should return the exposed functions of each zome", t, func() {
+		i, err := h.Interfaces()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", i["myZome"]), ShouldEqual, "[{getDNA 0} {exposedfn 0} {addData 0} {addPrime 1}]")
+		So(fmt.Sprintf("%v", i["jsZome"]), ShouldEqual, "[{getProperty 0} {addOdd 0} {addProfile 1} {addPrivateNote 0}]")
+	})
+
+	Convey("it should cache the result until reset", t, func() {
+		h.Zomes["jsZome"].CodeSource = `expose("other",HC.STRING);function other(x) {return x}`
+		i, _ := h.Interfaces()
+		So(len(i["jsZome"]), ShouldEqual, 3)
+		err := h.Reset()
+		So(err, ShouldBeNil)
+		i, err = h.Interfaces()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", i["jsZome"]), ShouldEqual, "[{other 0}]")
+	})
+}
+
+func TestSetClock(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Unix(1, 1)
+	h.SetClock(func() time.Time { return now })
+
+	Convey("genesis entries should be timestamped by the holochain's clock", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		So(h.chain.Headers[0].Time.Equal(now), ShouldBeTrue)
+		So(h.chain.Headers[1].Time.Equal(now), ShouldBeTrue)
+	})
+
+	Convey("genesis hashes should be reproducible with a frozen clock", t, func() {
+		top := h.chain.Hashes[1].String()
+		err := h.Reset()
+		So(err, ShouldBeNil)
+		_, err = h.GenChain()
+		So(err, ShouldBeNil)
+		So(h.chain.Hashes[1].String(), ShouldEqual, top)
+	})
+
+	Convey("a nil clock should restore the system time", t, func() {
+		h.SetClock(nil)
+		So(h.Now().After(now), ShouldBeTrue)
+	})
+}
+
 func TestGenChain(t *testing.T) {
 	d, _, h := setupTestChain("test")
 	defer cleanupTestDir(d)
@@ -306,6 +723,13 @@ func TestGenChain(t *testing.T) {
 		So(h.String(), ShouldEqual, "")
 	})
 
+	var fingerprint Hash
+	Convey("DNAHashOf should compute the DNA hash before GenChain", t, func() {
+		fingerprint, err = DNAHashOf(h)
+		So(err, ShouldBeNil)
+		So(fingerprint.String(), ShouldNotEqual, "")
+	})
+
 	var headerHash Hash
 	Convey("GenChain call works", t, func() {
 		headerHash, err = h.GenChain()
@@ -343,179 +767,2500 @@ func TestGenChain(t *testing.T) {
 		id := h.DNAHash()
 		So(err, ShouldBeNil)
 		So(id.String(), ShouldEqual, dnaHash.String())
+		So(fingerprint.String(), ShouldEqual, dnaHash.String())
 		top, err := h.Top()
 		So(err, ShouldBeNil)
 		So(top.String(), ShouldEqual, headerHash.String())
 	})
 }
 
-func TestWalk(t *testing.T) {
-	d, _, h := prepareTestChain("test")
+func TestNewPersister(t *testing.T) {
+	d, _, h := setupTestChain("test")
 	defer cleanupTestDir(d)
 
-	// add an extra link onto the chain
-	myData := `(message (from "art") (to "eric") (contents "test"))`
-	now := time.Unix(1, 1) // pick a constant time so the test will always work
-	e := GobEntry{C: myData}
-	_, _, err := h.NewEntry(now, "myData", &e)
+	Convey("it should create the persister selected in the config", t, func() {
+		p, err := h.NewPersister()
+		So(err, ShouldBeNil)
+		So(p.Name(), ShouldEqual, BoltPersisterName)
+
+		h.config.Persister = MemoryPersisterName
+		p, err = h.NewPersister()
+		So(err, ShouldBeNil)
+		So(p.Name(), ShouldEqual, MemoryPersisterName)
+
+		h.config.Persister = "bogus"
+		_, err = h.NewPersister()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDataPath(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should default to the chain's path", t, func() {
+		So(h.DataPath(), ShouldEqual, h.path)
+	})
+
+	h.config.DataPath = "data"
+	if err := h.saveConfig(); err != nil {
+		panic(err)
+	}
+	if err := h.Close(); err != nil {
+		panic(err)
+	}
+	h, err := s.Load("test")
 	if err != nil {
 		panic(err)
 	}
+	dataPath := filepath.Join(h.path, "data")
 
-	Convey("walk should call a function on all the elements of a chain", t, func() {
+	Convey("it should keep the chain store and DHT in the data path", t, func() {
+		So(h.DataPath(), ShouldEqual, dataPath)
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		So(fileExists(dataPath+"/"+StoreFileName+".dat"), ShouldBeTrue)
+		So(fileExists(dataPath+"/"+DHTStoreFileName), ShouldBeTrue)
+		So(fileExists(dataPath+"/"+DNAHashFileName), ShouldBeTrue)
+		So(fileExists(h.path+"/"+DNAHashFileName), ShouldBeFalse)
+		So(fileExists(h.path+"/"+DNAFileName+".toml"), ShouldBeTrue)
+	})
 
-		c := make(map[int]string, 0)
-		//	c := make([]string,0)
-		idx := 0
-		err := h.Walk(func(key *Hash, header *Header, entry Entry) (err error) {
-			c[idx] = header.EntryLink.String()
-			idx++
-			//	c = append(c, header.HeaderLink.String())
-			return nil
-		}, false)
+	Convey("it should load a chain from the data path", t, func() {
+		hash, err := h.Commit("myData", "2")
 		So(err, ShouldBeNil)
-		id := h.DNAHash()
-		So(c[2], ShouldEqual, id.String())
-		//	So(c,ShouldEqual,"fish")
+		So(h.Close(), ShouldBeNil)
+		h, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.Resume(), ShouldBeNil)
+		So(h.Length(), ShouldEqual, 3)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash.String())
+		So(h.Close(), ShouldBeNil)
 	})
 }
 
-func TestValidate(t *testing.T) {
-	d, _, h := prepareTestChain("test")
+func TestEncryptStores(t *testing.T) {
+	d, s, h := setupTestChain("test")
 	defer cleanupTestDir(d)
 
-	// add an extra link onto the chain
-	myData := `(message (from "art") (to "eric") (contents "test"))`
-	now := time.Unix(1, 1) // pick a constant time so the test will always work
-	e := GobEntry{C: myData}
-	_, _, err := h.NewEntry(now, "myData", &e)
+	h.config.EncryptStores = true
+	if err := h.saveConfig(); err != nil {
+		panic(err)
+	}
+	if err := h.Close(); err != nil {
+		panic(err)
+	}
+	h, err := s.Load("test")
 	if err != nil {
 		panic(err)
 	}
-	Convey("validate should check the hashes of the headers, and optionally of the entries", t, func() {
-		//	Convey("This isn't yet fully implemented", nil)
-		valid, err := h.Validate(false)
+	var hash Hash
+
+	Convey("it should not store the chain or DHT data in the clear", t, func() {
+		So(h.storeKey, ShouldNotBeNil)
+		_, err := h.GenChain()
 		So(err, ShouldBeNil)
-		So(valid, ShouldEqual, true)
+		hash, err = h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		So(h.Close(), ShouldBeNil)
+		for _, f := range []string{StoreFileName + ".dat", DHTStoreFileName, DHTSnapshotFileName} {
+			b, err := ioutil.ReadFile(h.DataPath() + "/" + f)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(b, []byte("h@bert.com")), ShouldBeFalse)
+		}
 	})
-}
-
-func TestValidateEntry(t *testing.T) {
-	d, _, h := prepareTestChain("test")
-	defer cleanupTestDir(d)
-	var err error
 
-	p := ValidationProps{}
-	Convey("it should fail if a validator doesn't exist for the entry type", t, func() {
-		hdr := mkTestHeader("bogusType")
-		myData := "2"
-		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
-		So(err.Error(), ShouldEqual, "no definition for entry type: bogusType")
+	Convey("it should load the encrypted stores", t, func() {
+		h, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.Resume(), ShouldBeNil)
+		So(h.Length(), ShouldEqual, 3)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash.String())
+		entry, _, _, err := h.dht.Get(hash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "2")
 	})
 
-	Convey("a nil entry is invalid", t, func() {
-		hdr := mkTestHeader("myData")
-		err = h.ValidateEntry(hdr.Type, nil, &p)
-		So(err.Error(), ShouldEqual, "nil entry invalid")
+	Convey("it should not open values moved to another key", t, func() {
+		k := "entry:" + hash.String()
+		var v string
+		err := h.dht.db.View(func(tx *buntdb.Tx) (err error) {
+			v, err = tx.Get(k)
+			return
+		})
+		So(err, ShouldBeNil)
+		_, err = h.dht.open(k, v)
+		So(err, ShouldBeNil)
+		_, err = h.dht.open("entry:QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2", v)
+		So(err, ShouldEqual, ErrStoreKey)
 	})
-	Convey("a valid entry validates", t, func() {
-		hdr := mkTestHeader("myData")
-		myData := "2" //`(message (from "art") (to "eric") (contents "test"))`
-		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
+
+	Convey("it should not rotate the agent's key", t, func() {
+		agent, err := NewAgent(IPFS, "Joe")
 		So(err, ShouldBeNil)
+		err = h.RotateKey(agent)
+		So(err.Error(), ShouldEqual, "holochain: can't rotate the key of a holochain with encrypted stores")
+		So(h.Close(), ShouldBeNil)
 	})
-	Convey("an invalid entry doesn't validate", t, func() {
-		hdr := mkTestHeader("myData")
-		myData := "1" //`(message (from "art") (to "eric") (contents "test"))`
-		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
-		So(err.Error(), ShouldEqual, "Invalid entry: 1")
+
+	Convey("it should fail cleanly without the key", t, func() {
+		h.config.EncryptStores = false
+		So(h.saveConfig(), ShouldBeNil)
+		_, err = s.Load("test")
+		So(err, ShouldEqual, ErrStoreEncrypted)
 	})
-	Convey("validate on a schema based entry should check entry against the schema", t, func() {
-		hdr := mkTestHeader("profile")
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	if err := h.Close(); err != nil {
+		panic(err)
+	}
+	vars := []string{EnvPort, EnvBootstrap, EnvPeerModeAuthor, EnvPeerModeDHTNode, EnvDataPath}
+	defer func() {
+		for _, v := range vars {
+			os.Unsetenv(v)
+		}
+	}()
+
+	Convey("it should leave the config alone if no variables are set", t, func() {
+		c := h.config
+		So(c.ApplyEnvOverrides("test"), ShouldBeNil)
+		So(c.Port, ShouldEqual, h.config.Port)
+		So(c.BootstrapServer, ShouldResemble, h.config.BootstrapServer)
+	})
+
+	Convey("it should override the config when loading", t, func() {
+		os.Setenv(EnvPort, "7777")
+		os.Setenv(EnvBootstrap, "a.example.com:10000, b.example.com:10000")
+		os.Setenv(EnvPeerModeAuthor, "false")
+		os.Setenv(EnvPeerModeDHTNode, "true")
+		os.Setenv(EnvDataPath, "data")
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		defer h2.Close()
+		So(h2.config.Port, ShouldEqual, 7777)
+		So(h2.config.BootstrapServer, ShouldResemble, BootstrapServers{"a.example.com:10000", "b.example.com:10000"})
+		So(h2.config.PeerModeAuthor, ShouldBeFalse)
+		So(h2.config.PeerModeDHTNode, ShouldBeTrue)
+		So(h2.DataPath(), ShouldEqual, filepath.Join(h2.path, "data"))
+		So(h2.saveConfig(), ShouldBeNil)
+	})
+
+	Convey("it should not save the overridden values in the config file", t, func() {
+		for _, v := range vars {
+			os.Unsetenv(v)
+		}
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		defer h2.Close()
+		So(h2.config.Port, ShouldEqual, h.config.Port)
+		So(h2.config.BootstrapServer, ShouldResemble, h.config.BootstrapServer)
+		So(h2.config.PeerModeAuthor, ShouldEqual, h.config.PeerModeAuthor)
+		So(h2.config.DataPath, ShouldEqual, h.config.DataPath)
+	})
+
+	Convey("it should give each holochain its own directory under an absolute data path", t, func() {
+		c := h.config
+		os.Setenv(EnvDataPath, filepath.Join(d, "shared"))
+		So(c.ApplyEnvOverrides("test"), ShouldBeNil)
+		So(c.DataPath, ShouldEqual, filepath.Join(d, "shared", "test"))
+		os.Unsetenv(EnvDataPath)
+	})
+
+	Convey("it should reject invalid values", t, func() {
+		c := Config{}
+		os.Setenv(EnvPort, "not a port")
+		err := c.ApplyEnvOverrides("test")
+		So(err.Error(), ShouldStartWith, "invalid "+EnvPort)
+		os.Setenv(EnvPort, "7777")
+		os.Setenv(EnvPeerModeAuthor, "maybe")
+		err = c.ApplyEnvOverrides("test")
+		So(err.Error(), ShouldStartWith, "invalid "+EnvPeerModeAuthor)
+	})
+}
+
+func TestVerifyDNAHashes(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("files without DNA hashes should not be checked", t, func() {
+		So(h.VerifyDNAHashes(), ShouldBeNil)
+	})
+
+	if err := h.GenDNAHashes(); err != nil {
+		panic(err)
+	}
+
+	Convey("it should verify untouched files", t, func() {
+		So(h.VerifyDNAHashes(), ShouldBeNil)
+		So(h.Prepare(), ShouldBeNil)
+	})
+
+	Convey("it should list files that don't match their DNA hashes", t, func() {
+		code := h.Zomes["myZome"].Code
+		f, err := os.OpenFile(h.path+"/"+code, os.O_APPEND|os.O_WRONLY, 0600)
+		So(err, ShouldBeNil)
+		f.WriteString("\n; tampered\n")
+		f.Close()
+		err = h.VerifyDNAHashes()
+		So(err.Error(), ShouldEqual, "holochain: DNA hash mismatch for: "+code)
+		err = h.Prepare()
+		So(err.Error(), ShouldEqual, "holochain: DNA hash mismatch for: "+code)
+	})
+
+	Convey("Prepare should not check the hashes if the config says to skip them", t, func() {
+		h.config.SkipHashCheck = true
+		So(h.Prepare(), ShouldBeNil)
+	})
+}
+
+// weakAgent is an agent that reports a key type other than the one it actually uses
+type weakAgent struct {
+	IPFSAgent
+}
+
+func (a *weakAgent) KeyType() KeytypeType {
+	return 99
+}
+
+func TestRequiredKeyType(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("only supported key types should be allowed if the DNA doesn't pin one", t, func() {
+		So(h.CheckKeyType(IPFS), ShouldBeNil)
+		err := h.CheckKeyType(99)
+		So(err.Error(), ShouldEqual, "key type not allowed: agent key type unknown(99) isn't supported")
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+
+		err = h.ValidateEntry(AgentEntryType, &GobEntry{C: AgentEntry{Name: "weak", KeyType: 99}}, &ValidationProps{})
+		So(errors.Is(err, ErrKeyTypeNotAllowed), ShouldBeTrue)
+		err = h.ValidateEntry(AgentEntryType, &GobEntry{C: AgentEntry{Name: "keyless", KeyType: IPFS}}, &ValidationProps{})
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+	})
+
+	h.RequiredKeyType = "IPFS"
+	Convey("a pinned key type should be checked", t, func() {
+		So(h.CheckKeyType(IPFS), ShouldBeNil)
+		err := h.CheckKeyType(99)
+		So(err.Error(), ShouldEqual, "key type not allowed: agent key type unknown(99), DNA requires IPFS")
+		So(errors.Is(err, ErrKeyTypeNotAllowed), ShouldBeTrue)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+	})
+
+	Convey("GenChain should refuse an agent with the wrong key type", t, func() {
+		agent := h.agent
+		h.agent = &weakAgent{IPFSAgent: *agent.(*IPFSAgent)}
+		_, err := h.GenChain()
+		So(errors.Is(err, ErrKeyTypeNotAllowed), ShouldBeTrue)
+		So(h.Started(), ShouldBeFalse)
+		h.agent = agent
+	})
+
+	Convey("agent entries with the wrong key type should not validate", t, func() {
+		err := h.ValidateEntry(AgentEntryType, &GobEntry{C: AgentEntry{Name: "weak", KeyType: 99}}, &ValidationProps{})
+		So(errors.Is(err, ErrKeyTypeNotAllowed), ShouldBeTrue)
+		key, err := h.agent.PrivKey().GetPublic().Bytes()
+		So(err, ShouldBeNil)
+		err = h.ValidateEntry(AgentEntryType, &GobEntry{C: AgentEntry{Name: "strong", KeyType: IPFS, Key: key}}, &ValidationProps{})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("agent entries declaring an allowed key type for a different key should not validate", t, func() {
+		_, pub, err := ic.GenerateKeyPairWithReader(ic.RSA, 1024, rand.Reader)
+		So(err, ShouldBeNil)
+		key, err := ic.MarshalPublicKey(pub)
+		So(err, ShouldBeNil)
+		err = h.ValidateEntry(AgentEntryType, &GobEntry{C: AgentEntry{Name: "liar", KeyType: IPFS, Key: key}}, &ValidationProps{})
+		So(errors.Is(err, ErrKeyTypeNotAllowed), ShouldBeTrue)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+	})
+
+	Convey("GenChain should refuse an agent whose key type isn't the one the config asks for", t, func() {
+		h.config.KeyType = "RSA"
+		_, err := h.GenChain()
+		So(err.Error(), ShouldEqual, "unknown key type: RSA")
+		So(h.Started(), ShouldBeFalse)
+		h.config.KeyType = "IPFS"
+	})
+
+	Convey("GenChain should accept an agent with the pinned key type", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestValidateGenesis(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("ValidateGenesis should succeed without starting the chain", t, func() {
+		errs := h.ValidateGenesis()
+		So(errs, ShouldBeNil)
+		So(h.Started(), ShouldBeFalse)
+		So(h.chain.Length(), ShouldEqual, 0)
+		So(fileExists(h.path+"/"+DNAHashFileName), ShouldBeFalse)
+	})
+
+	os.Remove(h.path + "/zome_jsZome.js")
+	err := writeFile(h.path, "zome_jsZome.js", []byte(`function genesis() {return false}`))
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("ValidateGenesis should return the genesis errors", t, func() {
+		errs := h.ValidateGenesis()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldEqual, "In 'jsZome' zome: genesis failed")
+		So(h.Started(), ShouldBeFalse)
+	})
+
+	Convey("a failed GenChain should return the error and clean up", t, func() {
+		_, err := h.GenChain()
+		So(err.Error(), ShouldEqual, "In 'jsZome' zome: genesis failed")
+		So(h.Started(), ShouldBeFalse)
+		So(h.chain.Length(), ShouldEqual, 0)
+		So(fileExists(h.path+"/"+DNAHashFileName), ShouldBeFalse)
+	})
+}
+
+func TestWalk(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	// add an extra link onto the chain
+	myData := `(message (from "art") (to "eric") (contents "test"))`
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	e := GobEntry{C: myData}
+	_, _, err := h.NewEntry(now, "myData", &e)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("walk should call a function on all the elements of a chain", t, func() {
+
+		c := make(map[int]string, 0)
+		//	c := make([]string,0)
+		idx := 0
+		err := h.Walk(func(key *Hash, header *Header, entry Entry) (err error) {
+			c[idx] = header.EntryLink.String()
+			idx++
+			//	c = append(c, header.HeaderLink.String())
+			return nil
+		}, false)
+		So(err, ShouldBeNil)
+		id := h.DNAHash()
+		So(c[2], ShouldEqual, id.String())
+		//	So(c,ShouldEqual,"fish")
+	})
+}
+
+func TestClose(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	h.node = node
+
+	handled := make(chan bool)
+	go func() {
+		h.dht.HandlePutReqs()
+		handled <- true
+	}()
+
+	Convey("it should shut down the node and the DHT", t, func() {
+		err := h.Close()
+		So(err, ShouldBeNil)
+		So(h.node, ShouldBeNil)
+
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Error("HandlePutReqs didn't return")
+		}
+
+		_, err = h.dht.GetIdx()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should be safe to call more than once", t, func() {
+		err := h.Close()
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should be possible to reset after close", t, func() {
+		err := h.Reset()
+		So(err, ShouldBeNil)
+		_, err = h.GenChain()
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestCommitBinary(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	h.Zomes["myZome"].Entries["thumb"] = EntryDef{Name: "thumb", DataFormat: DataFormatBinary}
+
+	Convey("it should commit bytes without converting them", t, func() {
+		data := []byte{0, 1, 2, 255}
+		hash, err := h.Commit("thumb", data)
+		So(err, ShouldBeNil)
+		_, entry, err := h.GetLocal(hash)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(entry.Content().([]byte), data), ShouldBeTrue)
+	})
+
+	Convey("it should convert string content of binary entries to bytes", t, func() {
+		hash, err := h.Commit("thumb", "abc")
+		So(err, ShouldBeNil)
+		_, entry, err := h.GetLocal(hash)
+		So(err, ShouldBeNil)
+		So(string(entry.Content().([]byte)), ShouldEqual, "abc")
+	})
+
+	Convey("it should reject binary entries larger than the maximum entry size", t, func() {
+		h.config.MaxEntrySize = 3
+		_, err := h.Commit("thumb", []byte{0, 1, 2, 3})
+		So(errors.Is(err, ErrEntryTooLarge), ShouldBeTrue)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "entry too large: 4 bytes, maximum is 3")
+		h.config.MaxEntrySize = 0
+	})
+
+	Convey("it should still run the nucleus validation for other entry types", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("myData", "3")
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+	})
+
+	Convey("it should reject bytes committed to JSON entry types rather than panic", t, func() {
+		_, err := h.Commit("profile", []byte(`{"firstName":"Art","lastName":"Brock"}`))
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "invalid entry: json entry content must be a string, not []uint8")
+
+		h.Zomes["jsZome"].Entries["jsonData"] = EntryDef{Name: "jsonData", DataFormat: DataFormatJSON}
+		_, err = h.Commit("jsonData", []byte(`{"a":1}`))
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+	})
+}
+
+func TestEntrySizeLimit(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.MaxEntrySize = 3
+
+	Convey("it should reject entries larger than the maximum entry size", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		l := h.Length()
+		_, err = h.Commit("myData", "1234")
+		So(errors.Is(err, ErrEntryTooLarge), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "entry too large: 4 bytes, maximum is 3")
+		_, _, err = h.NewEntry(time.Now(), "myData", &GobEntry{C: "1234"})
+		So(errors.Is(err, ErrEntryTooLarge), ShouldBeTrue)
+		So(h.Length(), ShouldEqual, l)
+	})
+
+	Convey("the entry definition should be able to raise the limit", t, func() {
+		_, def, _ := h.GetEntryDef("myData")
+		def.MaxSize = 10
+		h.Zomes["myZome"].Entries["myData"] = *def
+		_, err := h.Commit("myData", "1234")
+		So(err, ShouldBeNil)
+		_, err = h.Commit("myData", "12345678912")
+		So(err.Error(), ShouldEqual, "entry too large: 11 bytes, maximum is 10")
+	})
+
+	Convey("system entries shouldn't be limited", t, func() {
+		So(h.checkEntrySize(DNAEntryType, &GobEntry{C: "a large DNA"}), ShouldBeNil)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	// add an extra link onto the chain
+	myData := `(message (from "art") (to "eric") (contents "test"))`
+	now := time.Unix(1, 1) // pick a constant time so the test will always work
+	e := GobEntry{C: myData}
+	_, _, err := h.NewEntry(now, "myData", &e)
+	if err != nil {
+		panic(err)
+	}
+	Convey("validate should check the hashes of the headers, and optionally of the entries", t, func() {
+		//	Convey("This isn't yet fully implemented", nil)
+		valid, err := h.Validate(false)
+		So(err, ShouldBeNil)
+		So(valid, ShouldEqual, true)
+		valid, err = h.Validate(true)
+		So(err, ShouldBeNil)
+		So(valid, ShouldEqual, true)
+	})
+}
+
+func TestValidateEntryAt(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	profile := `{"firstName":"Art","lastName":"Brock"}`
+	hash, err := h.Commit("profile", profile)
+	if err != nil {
+		panic(err)
+	}
+	hd, _ := h.chain.GetEntryHeader(hash)
+	entry, _, _ := h.chain.GetEntry(hash)
+	_, def, _ := h.GetEntryDef("profile")
+	oldSchema := def.resolved
+
+	Convey("commits should record the schema version in the header", t, func() {
+		So(hd.SchemaLink.String(), ShouldEqual, oldSchema.String())
+		So(fileExists(filepath.Join(h.DataPath(), SchemaArchiveDir, oldSchema.String()+".json")), ShouldBeTrue)
+	})
+
+	Convey("the schema archive should be kept in the data path", t, func() {
+		h.config.DataPath = "data"
+		defer func() { h.config.DataPath = "" }()
+		So(h.archiveSchema(def), ShouldBeNil)
+		So(fileExists(filepath.Join(h.path, "data", SchemaArchiveDir, oldSchema.String()+".json")), ShouldBeTrue)
+	})
+
+	Convey("a change to a $ref target should be a new schema version", t, func() {
+		if err := writeFile(h.path, "refs.json", []byte(`{"properties": {"name": {"$ref": "names.json#/definitions/name"}}}`)); err != nil {
+			panic(err)
+		}
+		if err := writeFile(h.path, "names.json", []byte(`{"definitions": {"name": {"type": "string"}}}`)); err != nil {
+			panic(err)
+		}
+		a := EntryDef{Name: "refs", Schema: "refs.json"}
+		So(h.archiveSchema(&a), ShouldBeNil)
+		if err := writeFile(h.path, "names.json", []byte(`{"definitions": {"name": {"type": "integer"}}}`)); err != nil {
+			panic(err)
+		}
+		b := EntryDef{Name: "refs", Schema: "refs.json"}
+		So(h.archiveSchema(&b), ShouldBeNil)
+		So(b.resolved.String(), ShouldNotEqual, a.resolved.String())
+		So(fileExists(filepath.Join(h.DataPath(), SchemaArchiveDir, a.resolved.String()+".json")), ShouldBeTrue)
+		So(fileExists(filepath.Join(h.DataPath(), SchemaArchiveDir, b.resolved.String()+".json")), ShouldBeTrue)
+	})
+
+	// evolve the schema so that profiles require an email
+	schema := `{"type":"object","properties":{"email":{"type":"string"}},"required":["email"]}`
+	if err := writeFile(h.path, "schema_profile.json", []byte(schema)); err != nil {
+		panic(err)
+	}
+	for _, z := range h.Zomes {
+		if e, ok := z.Entries["profile"]; ok {
+			e.SchemaHash.Sum(h.hashSpec, []byte(schema))
+			if err := e.BuildJSONSchemaValidator(h.path); err != nil {
+				panic(err)
+			}
+			if err := h.archiveSchema(&e); err != nil {
+				panic(err)
+			}
+			z.Entries["profile"] = e
+		}
+	}
+
+	Convey("it should validate against the schema recorded in the header", t, func() {
+		err := h.ValidateEntry("profile", entry, &ValidationProps{})
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		err = h.ValidateEntryAt(hd, entry, &ValidationProps{})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should fail on an unknown schema version", t, func() {
+		var bogus Hash
+		bogus.Sum(h.hashSpec, []byte("bogus"))
+		bad := *hd
+		bad.SchemaLink = bogus
+		err := h.ValidateEntryAt(&bad, entry, &ValidationProps{})
+		So(err.Error(), ShouldStartWith, "unknown schema version "+bogus.String())
+	})
+}
+
+func TestValidateWithProgress(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should report progress after each header of each pass", t, func() {
+		var done []int
+		// signatures, type links, forks and header hashes
+		passes := 4
+		valid, err := h.ValidateWithProgress(true, func(d, total int) {
+			So(total, ShouldEqual, passes*h.chain.Length())
+			done = append(done, d)
+		})
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		So(len(done), ShouldEqual, passes*h.chain.Length())
+		for i, d := range done {
+			So(d, ShouldEqual, i+1)
+		}
+	})
+
+	Convey("it should count the timestamp pass when it's strict", t, func() {
+		var last, total int
+		_, err := h.validate(false, true, func(d, t int) { last, total = d, t })
+		So(err, ShouldBeNil)
+		// signatures, timestamps, forks and header hashes
+		So(total, ShouldEqual, 4*h.chain.Length())
+		So(last, ShouldEqual, total)
+	})
+}
+
+func TestValidateStrict(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should accept a chain whose timestamps are in order", t, func() {
+		valid, err := h.ValidateStrict(true)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+
+	Convey("it should reject a header timestamped before the previous one", t, func() {
+		h.SetClock(func() time.Time { return h.chain.Top().Time.Add(-time.Hour) })
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		h.SetClock(nil)
+
+		valid, err := h.Validate(true)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		valid, err = h.ValidateStrict(true)
+		So(valid, ShouldBeFalse)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("timestamp earlier than previous header at link %d", h.chain.Length()-1))
+	})
+}
+
+func TestCommitHooks(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	var calls []string
+	unsubscribe1 := h.OnBeforeCommit(func(entryType string, entry Entry) error {
+		calls = append(calls, fmt.Sprintf("before1 %s %v", entryType, entry.Content()))
+		return nil
+	})
+	h.OnBeforeCommit(func(entryType string, entry Entry) error {
+		calls = append(calls, "before2")
+		if entry.Content() == "4" {
+			return errors.New("no fours")
+		}
+		return nil
+	})
+	var hooked Hash
+	h.OnAfterCommit(func(hash Hash, header *Header) {
+		calls = append(calls, "after "+header.Type)
+		hooked = hash
+	})
+
+	Convey("it should call the hooks in order around each commit", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldBeNil)
+		So(calls, ShouldResemble, []string{"before1 myData 2", "before2", "after myData"})
+		So(hooked.String(), ShouldEqual, h.chain.Hashes[len(h.chain.Hashes)-1].String())
+	})
+
+	Convey("it should abort commits for which a before hook returns an error", t, func() {
+		calls = nil
+		l := h.chain.Length()
+		_, err := h.Commit("myData", "4")
+		So(err.Error(), ShouldEqual, "no fours")
+		So(h.chain.Length(), ShouldEqual, l)
+		So(calls, ShouldResemble, []string{"before1 myData 4", "before2"})
+	})
+
+	Convey("it should call the hooks for entries added with NewEntry", t, func() {
+		calls = nil
+		unsubscribe1()
+		_, _, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "6"})
+		So(err, ShouldBeNil)
+		So(calls, ShouldResemble, []string{"before2", "after myData"})
+	})
+}
+
+func TestCommitAt(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	// fix the clock after the chain's top so that the timestamps given can fall between them
+	now := h.chain.Top().Time.Add(time.Minute)
+	h.SetClock(func() time.Time { return now })
+
+	Convey("it should timestamp the entry with the given time and mark it as backdated", t, func() {
+		then := h.chain.Top().Time.Add(time.Second).Round(time.Second)
+		hash, err := h.CommitAt("myData", "2", then)
+		So(err, ShouldBeNil)
+		hd := h.chain.Top()
+		So(hd.EntryLink.String(), ShouldEqual, hash.String())
+		So(hd.Time.Equal(then), ShouldBeTrue)
+		So(hd.Backdated, ShouldBeTrue)
+	})
+
+	Convey("strict validation should accept the backdated header", t, func() {
+		_, err := h.Commit("myData", "4")
+		So(err, ShouldBeNil)
+		So(h.chain.Top().Backdated, ShouldBeFalse)
+		valid, err := h.ValidateStrict(true)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+
+	Convey("it should reject timestamps earlier than the previous header's", t, func() {
+		_, err := h.CommitAt("myData", "6", h.chain.Top().Time.Add(-24*time.Hour))
+		So(err.Error(), ShouldContainSubstring, "is earlier than the previous header's")
+	})
+
+	Convey("it should reject timestamps too far in the future", t, func() {
+		_, err := h.CommitAt("myData", "6", h.Now().Add(MaxClockSkew+time.Minute))
+		So(err.Error(), ShouldContainSubstring, "is in the future")
+		_, err = h.CommitAt("myData", "6", h.Now().Add(MaxClockSkew/2))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should still validate the entry", t, func() {
+		_, err := h.CommitAt("myData", "1", h.chain.Top().Time)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+	})
+}
+
+func TestValidateDetailed(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	for _, c := range []string{"2", "4"} {
+		if _, err := h.Commit("myData", c); err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should report the result of each header in chain order", t, func() {
+		results, err := h.ValidateDetailed(true)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, h.chain.Length())
+		for i, r := range results {
+			So(r.OK, ShouldBeTrue)
+			So(r.Err, ShouldBeNil)
+			So(r.Hash.String(), ShouldEqual, h.chain.Hashes[i].String())
+		}
+	})
+
+	Convey("it should report which header failed and why", t, func() {
+		bad := h.chain.Length() - 2
+		h.chain.Headers[bad].Time = time.Unix(1, 1)
+		results, err := h.ValidateDetailed(true)
+		So(err, ShouldBeNil)
+		for i, r := range results {
+			if i == bad {
+				So(r.OK, ShouldBeFalse)
+				So(r.Err.Error(), ShouldEqual, "header hash doesn't match")
+			} else {
+				So(r.OK, ShouldBeTrue)
+			}
+		}
+		valid, err := h.Validate(true)
+		So(valid, ShouldBeFalse)
+		So(err.Error(), ShouldEqual, "header hash doesn't match")
+	})
+}
+
+func TestSetAgentName(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should rename the agent in the holochain's own agent file before genesis", t, func() {
+		service, err := LoadAgent(filepath.Dir(h.path))
+		So(err, ShouldBeNil)
+
+		err = h.SetAgentName("Fred Flintstone")
+		So(err, ShouldBeNil)
+		So(h.Agent().Name(), ShouldEqual, "Fred Flintstone")
+
+		a, err := LoadAgent(h.path)
+		So(err, ShouldBeNil)
+		So(a.Name(), ShouldEqual, "Fred Flintstone")
+		So(a.PrivKey().Equals(service.PrivKey()), ShouldBeTrue)
+
+		s, err := LoadAgent(filepath.Dir(h.path))
+		So(err, ShouldBeNil)
+		So(s.Name(), ShouldEqual, service.Name())
+
+		err = h.SetAgentName("Fred")
+		So(err, ShouldBeNil)
+		a, err = LoadAgent(h.path)
+		So(err, ShouldBeNil)
+		So(a.Name(), ShouldEqual, "Fred")
+		err = h.SetAgentName("Fred Flintstone")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("the new name should be recorded in the genesis AgentEntry", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		_, e, err := h.GetLocal(h.agentHash)
+		So(err, ShouldBeNil)
+		So(e.Content().(AgentEntry).Name, ShouldEqual, "Fred Flintstone")
+	})
+
+	Convey("it should refuse to rename the agent once the chain is started", t, func() {
+		err := h.SetAgentName("Barney")
+		So(err, ShouldEqual, ErrAgentNameFixed)
+		So(h.Agent().Name(), ShouldEqual, "Fred Flintstone")
+	})
+}
+
+func TestRotateKey(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	oldAgent := h.agent
+	oldID := h.id
+	newAgent, _ := NewAgent(IPFS, "Herbert <h@bert.com>")
+
+	Convey("it should commit a new agent entry linking to the previous key", t, func() {
+		err := h.RotateKey(newAgent)
+		So(err, ShouldBeNil)
+		So(h.agent, ShouldEqual, newAgent)
+		So(h.id, ShouldNotEqual, oldID)
+
+		hd := h.chain.Top()
+		So(hd.Type, ShouldEqual, AgentEntryType)
+		So(hd.EntryLink.String(), ShouldEqual, h.agentHash.String())
+
+		entry, _, err := h.chain.GetEntry(hd.EntryLink)
+		So(err, ShouldBeNil)
+		a := entry.Content().(AgentEntry)
+		prevKey, _ := ic.MarshalPublicKey(oldAgent.PubKey())
+		So(string(a.PrevKey), ShouldEqual, string(prevKey))
+
+		// the rotation is signed by the previous key
+		valid, err := oldAgent.PubKey().Verify(hd.EntryLink.H, hd.Sig.S)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+
+	Convey("it should put the new agent entry to the DHT", t, func() {
+		_, et, _, err := h.dht.get(h.agentHash)
+		So(err, ShouldBeNil)
+		So(et, ShouldEqual, AgentEntryType)
+	})
+
+	Convey("validate should accept headers signed by keys valid at their position", t, func() {
+		_, _, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "2"})
+		So(err, ShouldBeNil)
+		valid, err := h.Validate(false)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+
+	Convey("validate should reject a header signed by the wrong key", t, func() {
+		_, hd, err := h.NewEntry(time.Now(), "myData", &GobEntry{C: "4"})
+		So(err, ShouldBeNil)
+		hd.Sig.S, _ = oldAgent.PrivKey().Sign(hd.EntryLink.H)
+		valid, err := h.Validate(false)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("invalid signature at link %d", h.chain.Length()-1))
+		So(valid, ShouldBeFalse)
+	})
+}
+
+func TestAgents(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	firstKey, _ := ic.MarshalPublicKey(h.agent.PubKey())
+
+	Convey("it should return the chain's agent once", t, func() {
+		agents, err := h.Agents()
+		So(err, ShouldBeNil)
+		So(len(agents), ShouldEqual, 1)
+		So(agents[0].Name, ShouldEqual, h.agent.Name())
+		So(string(agents[0].Key), ShouldEqual, string(firstKey))
+	})
+
+	Convey("it should include agents learned from the DHT", t, func() {
+		other, _ := NewAgent(IPFS, "Joe <joe@bar.com>")
+		key, _ := ic.MarshalPublicKey(other.PubKey())
+		e := GobEntry{C: AgentEntry{Name: other.Name(), KeyType: other.KeyType(), Key: key}}
+		hash, _ := e.Sum(h.hashSpec)
+		b, _ := e.Marshal()
+		err := h.dht.put(nil, AgentEntryType, hash, h.id, b, LIVE)
+		So(err, ShouldBeNil)
+
+		agents, err := h.Agents()
+		So(err, ShouldBeNil)
+		So(len(agents), ShouldEqual, 2)
+		So(agents[1].Name, ShouldEqual, other.Name())
+	})
+
+	Convey("it should return both keys of a rotated agent linked by PrevKey", t, func() {
+		newAgent, _ := NewAgent(IPFS, "Herbert <h@bert.com>")
+		err := h.RotateKey(newAgent)
+		So(err, ShouldBeNil)
+
+		agents, err := h.Agents()
+		So(err, ShouldBeNil)
+		So(len(agents), ShouldEqual, 3)
+		So(agents[1].Name, ShouldEqual, newAgent.Name())
+		So(string(agents[1].PrevKey), ShouldEqual, string(firstKey))
+	})
+}
+
+func TestRevokeKey(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should commit a revocation entry signed by the current key", t, func() {
+		So(h.Revoked(), ShouldBeFalse)
+		err := h.RevokeKey("compromised")
+		So(err, ShouldBeNil)
+		So(h.Revoked(), ShouldBeTrue)
+
+		hd := h.chain.Top()
+		So(hd.Type, ShouldEqual, RevocationEntryType)
+		entry, _, err := h.chain.GetEntry(hd.EntryLink)
+		So(err, ShouldBeNil)
+		r := entry.Content().(RevocationEntry)
+		So(r.Reason, ShouldEqual, "compromised")
+		key, _ := ic.MarshalPublicKey(h.agent.PubKey())
+		So(string(r.Key), ShouldEqual, string(key))
+		valid, err := h.agent.PubKey().Verify(hd.EntryLink.H, hd.Sig.S)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		_, et, _, err := h.dht.get(hd.EntryLink)
+		So(err, ShouldBeNil)
+		So(et, ShouldEqual, RevocationEntryType)
+	})
+
+	Convey("it should refuse new entries after revocation", t, func() {
+		_, err := h.Commit("myData", "2")
+		So(err, ShouldEqual, ErrKeyRevoked)
+		_, _, err = h.NewEntry(time.Now(), "myData", &GobEntry{C: "2"})
+		So(err, ShouldEqual, ErrKeyRevoked)
+		err = h.RevokeKey("again")
+		So(err, ShouldEqual, ErrKeyRevoked)
+	})
+
+	Convey("the DHT should refuse puts from the revoked key", t, func() {
+		So(h.dht.isRevoked(h.id), ShouldBeTrue)
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: h.chain.Top().EntryLink})
+		_, err := DHTReceiver(h, m)
+		So(err, ShouldEqual, ErrKeyRevoked)
+	})
+
+	Convey("a revocation should only be accepted from the revoked agent", t, func() {
+		entry, _, _ := h.chain.GetEntry(h.chain.Top().EntryLink)
+		err := h.ValidateEntry(RevocationEntryType, entry, &ValidationProps{AgentID: peer.IDB58Encode(h.id)})
+		So(err, ShouldBeNil)
+		err = h.ValidateEntry(RevocationEntryType, entry, &ValidationProps{AgentID: "QmSomeoneElse"})
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+	})
+
+	Convey("validate should reject headers after the revocation", t, func() {
+		valid, err := h.Validate(true)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		revokedAt := h.chain.Length() - 1
+		_, err = h.chain.AddEntry(h.hashSpec, time.Now(), "myData", &GobEntry{C: "2"}, h.agent.PrivKey())
+		So(err, ShouldBeNil)
+		valid, err = h.Validate(true)
+		So(valid, ShouldBeFalse)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("header after key revocation at link %d", revokedAt))
+	})
+}
+
+func TestValidateEntry(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	var err error
+
+	p := ValidationProps{}
+	Convey("it should fail if a validator doesn't exist for the entry type", t, func() {
+		hdr := mkTestHeader("bogusType")
+		myData := "2"
+		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
+		So(err.Error(), ShouldEqual, "no definition for entry type: bogusType")
+	})
+
+	Convey("a nil entry is invalid", t, func() {
+		hdr := mkTestHeader("myData")
+		err = h.ValidateEntry(hdr.Type, nil, &p)
+		So(err.Error(), ShouldEqual, "nil entry invalid")
+	})
+	Convey("a valid entry validates", t, func() {
+		hdr := mkTestHeader("myData")
+		myData := "2" //`(message (from "art") (to "eric") (contents "test"))`
+		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
+		So(err, ShouldBeNil)
+	})
+	Convey("an invalid entry doesn't validate", t, func() {
+		hdr := mkTestHeader("myData")
+		myData := "1" //`(message (from "art") (to "eric") (contents "test"))`
+		err = h.ValidateEntry(hdr.Type, &GobEntry{C: myData}, &p)
+		So(err.Error(), ShouldEqual, "Invalid entry: 1")
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+		var ie *InvalidEntryError
+		So(errors.As(err, &ie), ShouldBeTrue)
+		So(ie.Content, ShouldEqual, "1")
+	})
+	Convey("validate on a schema based entry should check entry against the schema", t, func() {
+		hdr := mkTestHeader("profile")
 		profile := `{"firstName":"Eric","lastName":"H-B"}`
 		err = h.ValidateEntry(hdr.Type, &GobEntry{C: profile}, &p)
 		So(err, ShouldBeNil)
-		h.Prepare()
-		profile = `{"firstName":"Eric"}` // missing required lastName
-		err = h.ValidateEntry(hdr.Type, &GobEntry{C: profile}, &p)
+		h.Prepare()
+		profile = `{"firstName":"Eric"}` // missing required lastName
+		err = h.ValidateEntry(hdr.Type, &GobEntry{C: profile}, &p)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "validator schema_profile.json failed: object property 'lastName' is required")
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeFalse)
+	})
+}
+
+func TestMakeNucleus(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	Convey("it should fail if the type isn't defined in the DNA", t, func() {
+		_, err := h.MakeNucleus("bogusType")
+		So(err.Error(), ShouldEqual, "unknown zome: bogusType")
+
+	})
+	Convey("it should make a nucleus based on the type", t, func() {
+		v, err := h.MakeNucleus("myZome")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		_, err = z.env.Run()
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestValidateEntryWith(t *testing.T) {
+	p := ValidationProps{}
+	Convey("it should validate against inline code without a holochain", t, func() {
+		d := EntryDef{Name: "myData", DataFormat: DataFormatString}
+		code := `function validate(name,entry,meta) { return (entry=="fish")};`
+		err := ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: "fish"}, &p)
+		So(err, ShouldBeNil)
+		err = ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: "cow"}, &p)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "Invalid entry: cow")
+
+		err = ValidateEntryWith(`(defn validate [name entry meta] (== entry "fish"))`, ZygoNucleusType, &d, &GobEntry{C: "fish"}, &p)
+		So(err, ShouldBeNil)
+	})
+	Convey("it should validate against the schema before running the code", t, func() {
+		v, err := buildJSONSchemaValidator("profile", []byte(`{"type":"object","properties":{"name":{"type":"string"}},"required":["name"]}`))
+		So(err, ShouldBeNil)
+		d := EntryDef{Name: "profile", DataFormat: DataFormatJSON, validator: v}
+		code := `function validate(name,entry,meta) { return true };`
+		err = ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: `{"name":"Zippy"}`}, &p)
+		So(err, ShouldBeNil)
+		err = ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: `{"age":3}`}, &p)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+	})
+	Convey("it should check URI entries before running the code", t, func() {
+		var h Hash
+		h.Sum(HashSpec{Code: 0x12, Length: -1}, []byte("some media"))
+		d := EntryDef{Name: "media", DataFormat: DataFormatURI}
+		code := `function validate(name,entry,meta) { return entry.URI.indexOf("https:")==0 };`
+		err := ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: fmt.Sprintf(`{"URI":"https://example.com/a.mp4","Hash":"%s"}`, h.String())}, &p)
+		So(err, ShouldBeNil)
+		err = ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: fmt.Sprintf(`{"URI":"http://example.com/a.mp4","Hash":"%s"}`, h.String())}, &p)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+		err = ValidateEntryWith(code, JSNucleusType, &d, &GobEntry{C: `{"URI":"https://example.com/a.mp4","Hash":"bogus"}`}, &p)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(err.Error(), ShouldStartWith, "invalid URI entry: bad content hash")
+	})
+	Convey("it should report unknown nucleus types", t, func() {
+		d := EntryDef{Name: "myData", DataFormat: DataFormatString}
+		err := ValidateEntryWith("", "bogus", &d, &GobEntry{C: "fish"}, &p)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCall(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	Convey("it should call the exposed function", t, func() {
+		result, err := h.Call("myZome", "exposedfn", "arg1 arg2")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: arg1 arg2")
+
+		result, err = h.Call("myZome", "addData", "42")
+		So(err, ShouldBeNil)
+
+		ph := h.chain.Top().EntryLink
+		So(result.(string), ShouldEqual, ph.String())
+
+		//_, err = h.Call("myZome", "addData", "41")
+		//So(err.Error(), ShouldEqual, "Error calling 'commit': Invalid entry: 41")
+	})
+	Convey("it should return errors that can be checked by type", t, func() {
+		_, err := h.Call("myZome", "addData", "41")
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+		var ce *CallError
+		So(errors.As(err, &ce), ShouldBeTrue)
+		So(ce.Function, ShouldEqual, "addData")
+	})
+	Convey("it should wrap nucleus errors in a NucleusError", t, func() {
+		_, err := h.Call("myZome", "addData", "41")
+		var ne *NucleusError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Zome, ShouldEqual, "myZome")
+		So(ne.Function, ShouldEqual, "addData")
+		So(ne.IsValidation(), ShouldBeTrue)
+		So(ne.Underlying().Error(), ShouldEqual, "Invalid entry: 41")
+		So(errors.Is(ne.Underlying(), ErrInvalidEntry), ShouldBeTrue)
+	})
+	Convey("it should call the exposed function with a context", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+		defer cancel()
+		result, err := h.CallWithContext(ctx, "myZome", "exposedfn", "arg1 arg2")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: arg1 arg2")
+	})
+	Convey("it should reject functions the zome doesn't expose with suggestions", t, func() {
+		_, err := h.Call("myZome", "exposedFn", "arg1")
+		So(errors.Is(err, ErrUnknownFunction), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "unknown function: exposedFn, did you mean exposedfn? (zome myZome exposes: addData, addPrime, exposedfn, getDNA)")
+		var ue *UnknownFunctionError
+		So(errors.As(err, &ue), ShouldBeTrue)
+		So(ue.Zome, ShouldEqual, "myZome")
+
+		_, err = h.CallWithContext(context.Background(), "jsZome", "addodd", "3")
+		So(err.Error(), ShouldStartWith, "unknown function: addodd, did you mean addOdd?")
+	})
+}
+
+func TestCallWithContextAbort(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	z := h.Zomes["jsZome"]
+	z.CodeSource = `expose("loop",HC.STRING);function loop(x) {while(true){}}`
+
+	Convey("it should abort and interrupt a function that runs past the context's deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := h.CallWithContext(ctx, "jsZome", "loop", "")
+		So(errors.Is(err, ErrCallAborted), ShouldBeTrue)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		var ne *NucleusError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Function, ShouldEqual, "loop")
+		// the interrupted nucleus isn't put back for reuse
+		p := h.nuclei[z]
+		So(p == nil || len(p.idle) == 0, ShouldBeTrue)
+	})
+
+	Convey("it should report a cancelled context as aborted", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err := h.CallWithContext(ctx, "jsZome", "loop", "")
+		So(errors.Is(err, ErrCallAborted), ShouldBeTrue)
+		So(errors.Is(err, context.Canceled), ShouldBeTrue)
+	})
+}
+
+func TestNucleusCache(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	z := h.Zomes["jsZome"]
+
+	Convey("it should reuse compiled nuclei between calls", t, func() {
+		_, err := h.Call("jsZome", "getProperty", "description")
+		So(err, ShouldBeNil)
+		So(len(h.nuclei[z].idle), ShouldEqual, 1)
+		n := h.nuclei[z].idle[0]
+
+		_, err = h.Call("jsZome", "getProperty", "language")
+		So(err, ShouldBeNil)
+		So(len(h.nuclei[z].idle), ShouldEqual, 1)
+		So(h.nuclei[z].idle[0], ShouldEqual, n)
+	})
+
+	Convey("it should give nested calls their own nucleus", t, func() {
+		n, release, err := h.acquireNucleus(z)
+		So(err, ShouldBeNil)
+		So(len(h.nuclei[z].idle), ShouldEqual, 0)
+		m, release2, err := h.acquireNucleus(z)
+		So(err, ShouldBeNil)
+		So(m, ShouldNotEqual, n)
+		release2(nil)
+		release(nil)
+		So(len(h.nuclei[z].idle), ShouldEqual, 2)
+	})
+
+	Convey("it should reset per-call state before reuse", t, func() {
+		n, release, err := h.acquireNucleus(z)
+		So(err, ShouldBeNil)
+		n.(callDepther).setCallDepth(3)
+		release(nil)
+		So(n.(*JSNucleus).depth, ShouldEqual, 0)
+	})
+
+	Convey("it should not reuse nuclei whose call failed", t, func() {
+		before := len(h.nuclei[z].idle)
+		_, err := h.Call("jsZome", "addOdd", "2")
+		So(err, ShouldNotBeNil)
+		So(len(h.nuclei[z].idle), ShouldBeLessThan, before)
+	})
+
+	Convey("it should not reuse nuclei that can't restore their globals", t, func() {
+		_, err := h.Call("myZome", "exposedfn", "arg1")
+		So(err, ShouldBeNil)
+		So(len(h.nuclei[h.Zomes["myZome"]].idle), ShouldEqual, 0)
+	})
+
+	Convey("it should not let calls and validation see each other's globals", t, func() {
+		z.CodeSource = `
+var count = 0;
+expose("bump",HC.STRING);
+function bump(x) {count++; return ""+count}
+function validate(entry_type,entry,props) {var ok = count == 0; count++; return ok}
+function genesis() {return true}
+`
+		for i := 0; i < 2; i++ {
+			result, err := h.Call("jsZome", "bump", "")
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "1")
+			So(h.ValidateEntry("myOdds", &GobEntry{C: "3"}, &ValidationProps{}), ShouldBeNil)
+		}
+		So(len(h.nuclei[z].idle), ShouldEqual, 1)
+	})
+
+	Convey("it should recompile when the zome code changes", t, func() {
+		z.CodeSource = `expose("hi",HC.STRING);function hi(x) {return "hello"}`
+		result, err := h.Call("jsZome", "hi", "")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "hello")
+		So(len(h.nuclei[z].idle), ShouldEqual, 1)
+	})
+
+	Convey("it should drop the cache on Reset", t, func() {
+		err := h.Reset()
+		So(err, ShouldBeNil)
+		So(h.nuclei, ShouldBeNil)
+	})
+}
+
+func TestReloadZome(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	z := h.Zomes["myZome"]
+	codeFile := filepath.Join(h.path, z.Code)
+
+	Convey("it should fail on an unknown zome", t, func() {
+		err := h.ReloadZome("bogusZome")
+		So(err.Error(), ShouldEqual, "unknown zome: bogusZome")
+	})
+
+	Convey("it should use the code on disk after reloading", t, func() {
+		_, err := h.Call("myZome", "exposedfn", "arg1")
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(codeFile, []byte(`(expose "hi" STRING)(defn hi [x] "hello")`), 0644)
+		So(err, ShouldBeNil)
+		err = h.ReloadZome("myZome")
+		So(err, ShouldBeNil)
+		result, err := h.Call("myZome", "hi", "")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "hello")
+		So(h.nuclei[z].code, ShouldEqual, `(expose "hi" STRING)(defn hi [x] "hello")`)
+		interfaces, err := h.Interfaces()
+		So(err, ShouldBeNil)
+		So(len(interfaces["myZome"]), ShouldEqual, 1)
+	})
+
+	Convey("it should keep the old code if the new code doesn't compile", t, func() {
+		err := ioutil.WriteFile(codeFile, []byte(`(expose "hi" STRING)(defn hi [x] "hello"`), 0644)
+		So(err, ShouldBeNil)
+		err = h.ReloadZome("myZome")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "In 'myZome' zome: ")
+		result, err := h.Call("myZome", "hi", "")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "hello")
+	})
+
+	Convey("it should pick up fixed code on the next reload", t, func() {
+		err := ioutil.WriteFile(codeFile, []byte(`(expose "hi" STRING)(defn hi [x] "hello again")`), 0644)
+		So(err, ShouldBeNil)
+		err = h.ReloadZome("myZome")
+		So(err, ShouldBeNil)
+		result, err := h.Call("myZome", "hi", "")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "hello again")
+	})
+
+	Convey("it should keep the code a zome was prepared with if its first reload fails", t, func() {
+		jsFile := filepath.Join(h.path, h.Zomes["jsZome"].Code)
+		err := ioutil.WriteFile(jsFile, []byte(`function getProperty(x) {`), 0644)
+		So(err, ShouldBeNil)
+		err = h.ReloadZome("jsZome")
+		So(err.Error(), ShouldStartWith, "In 'jsZome' zome: ")
+		result, err := h.Call("jsZome", "getProperty", "description")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "a bogus test holochain")
+		_, err = h.MakeNucleus("jsZome")
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestCallZome(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should call functions in other zomes", t, func() {
+		h.Zomes["myZome"].CodeSource = `(expose "viaJS" STRING)(defn viaJS [x] (callZome "jsZome" "getProperty" x))`
+		result, err := h.Call("myZome", "viaJS", "description")
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "a bogus test holochain")
+	})
+
+	Convey("it should stop recursive calls at the maximum call depth", t, func() {
+		h.Zomes["myZome"].CodeSource = `(expose "loop" STRING)(defn loop [x] (callZome "jsZome" "loop" x))`
+		h.Zomes["jsZome"].CodeSource = `expose("loop",HC.STRING);function loop(x) {return callZome("myZome","loop",x)}`
+		_, err := h.Call("myZome", "loop", "x")
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrCallDepthExceeded), ShouldBeTrue)
+
+		_, err = h.callZome(MaxCallDepth, false, "jsZome", "loop", "x")
+		So(err, ShouldEqual, ErrCallDepthExceeded)
+	})
+
+	Convey("it should only call functions the other zome exposes", t, func() {
+		h.Zomes["jsZome"].CodeSource = `expose("shown",HC.STRING);function shown(x) {return x};function hidden(x) {return x}`
+		_, err := h.callZome(0, false, "jsZome", "hidden", "x")
+		var unknown *UnknownFunctionError
+		So(errors.As(err, &unknown), ShouldBeTrue)
+		So(unknown.Available, ShouldResemble, []string{"shown"})
+
+		h.Zomes["myZome"].CodeSource = `(expose "viaJS" STRING)(defn viaJS [x] (callZome "jsZome" "hidden" x))`
+		_, err = h.Call("myZome", "viaJS", "x")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should stop functions called by a read-only function from writing", t, func() {
+		h.Zomes["myZome"].CodeSource = `(expose "peek" STRING readonly: true)(defn peek [x] (callZome "jsZome" "addOdd" x))`
+		h.Zomes["jsZome"].CodeSource = `expose("addOdd",HC.STRING);function addOdd(x) {return commit("myOdds",x);}`
+		l := h.chain.Length()
+		_, err := h.Call("myZome", "peek", "3")
+		So(errors.Is(err, ErrReadOnlyCall), ShouldBeTrue)
+		So(h.chain.Length(), ShouldEqual, l)
+	})
+
+	Convey("it should report the zome and function of a failed call to another zome", t, func() {
+		h.Zomes["jsZome"].CodeSource = `expose("addOdd",HC.STRING);function addOdd(x) {return commit("myOdds",x);}
+function validate(entry_type,entry,props) {return entry%2 != 0}`
+		_, err := h.callZome(0, false, "jsZome", "addOdd", "2")
+		var ne *NucleusError
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Zome, ShouldEqual, "jsZome")
+		So(ne.Function, ShouldEqual, "addOdd")
+		So(errors.Is(err, ErrInvalidEntry), ShouldBeTrue)
+
+		h.Zomes["myZome"].CodeSource = `(expose "viaJS" STRING)(defn viaJS [x] (callZome "jsZome" "addOdd" x))`
+		_, err = h.Call("myZome", "viaJS", "2")
+		So(errors.As(err, &ne), ShouldBeTrue)
+		So(ne.Zome, ShouldEqual, "myZome")
+		var inner *NucleusError
+		So(errors.As(ne.Err, &inner), ShouldBeTrue)
+		So(inner.Zome, ShouldEqual, "jsZome")
+		So(inner.Function, ShouldEqual, "addOdd")
+	})
+}
+
+func TestUpdateAndRemove(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	put := func(hash Hash) {
+		if err := h.dht.SendPut(hash); err != nil {
+			panic(err)
+		}
+		if err := h.dht.simHandlePutReqs(); err != nil {
+			panic(err)
+		}
+	}
+	get := func(hash Hash) (content interface{}, err error) {
+		var r interface{}
+		if r, err = h.dht.SendGet(hash); err == nil {
+			content = r.(*GobEntry).C
+		}
+		return
+	}
+
+	hash1, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+	put(hash1)
+
+	var hash2, hash3 Hash
+	Convey("it should record an update in the new version's header", t, func() {
+		hash2, err = h.Update("myData", "4", hash1)
+		So(err, ShouldBeNil)
+		hd := h.chain.Top()
+		So(hd.EntryLink.String(), ShouldEqual, hash2.String())
+		So(hd.Change.Action, ShouldEqual, ModAction)
+		So(hd.Change.Hash.String(), ShouldEqual, hash1.String())
+		put(hash2)
+
+		content, err := get(hash1)
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, "4")
+	})
+
+	Convey("gets should return the latest of several updates", t, func() {
+		hash3, err = h.Update("myData", "6", hash2)
+		So(err, ShouldBeNil)
+		put(hash3)
+
+		content, err := get(hash1)
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, "6")
+		content, err = get(hash2)
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, "6")
+		_, _, status, err := h.dht.Get(hash2)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, UPDATED)
+	})
+
+	Convey("gets of a deleted entry or its earlier versions should fail", t, func() {
+		del, err := h.Remove(hash3, "no longer needed")
+		So(err, ShouldBeNil)
+		hd := h.chain.Top()
+		So(hd.Type, ShouldEqual, DelEntryType)
+		So(hd.Change.Action, ShouldEqual, DelAction)
+		So(hd.Change.Hash.String(), ShouldEqual, hash3.String())
+		put(del)
+
+		_, err = get(hash3)
+		So(errors.Is(err, ErrEntryDeleted), ShouldBeTrue)
+		_, err = get(hash1)
+		So(errors.Is(err, ErrEntryDeleted), ShouldBeTrue)
+		_, _, status, err := h.dht.Get(hash3)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, DELETED)
+	})
+
+	Convey("putting an entry again should not undo a change to it", t, func() {
+		put(hash1)
+		_, _, status, err := h.dht.Get(hash1)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, UPDATED)
+	})
+
+	Convey("a change put before the entry it changes should be applied when that is put", t, func() {
+		hash4, err := h.Commit("myData", "10")
+		So(err, ShouldBeNil)
+		hash5, err := h.Update("myData", "12", hash4)
+		So(err, ShouldBeNil)
+		put(hash5)
+		_, err = get(hash4)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+
+		put(hash4)
+		_, _, status, err := h.dht.Get(hash4)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, UPDATED)
+		content, err := get(hash4)
+		So(err, ShouldBeNil)
+		So(content, ShouldEqual, "12")
+	})
+
+	Convey("the chain with changes should still validate", t, func() {
+		So(h.chain.Validate(h.hashSpec), ShouldBeNil)
+	})
+
+	Convey("it should only update entries on the chain with the same type", t, func() {
+		_, err := h.Update("myOdds", "7", hash1)
+		So(err, ShouldNotBeNil)
+		missing, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err = h.Update("myData", "8", missing)
+		So(err, ShouldEqual, ErrHashNotFound)
+		_, err = h.Remove(missing, "")
+		So(err, ShouldEqual, ErrHashNotFound)
+		_, err = h.Update("myData", "6", hash3)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestLinks(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	now := time.Unix(1, 1)
+	_, bhd, err := h.NewEntry(now, "myOdds", &GobEntry{C: "7"})
+	if err != nil {
+		panic(err)
+	}
+	base := bhd.EntryLink
+	if err = h.dht.SendPut(base); err != nil {
+		panic(err)
+	}
+	if err = h.dht.simHandlePutReqs(); err != nil {
+		panic(err)
+	}
+	_, lhd, err := h.NewEntry(now, "profile", &GobEntry{C: `{"firstName":"Zippy","lastName":"Pinhead"}`})
+	if err != nil {
+		panic(err)
+	}
+	link := lhd.EntryLink
+
+	Convey("it should commit links and retrieve them by tag", t, func() {
+		err := h.LinkEntries(base, link, "friend")
+		So(err, ShouldBeNil)
+		So(h.chain.Top().Type, ShouldEqual, LinkEntryType)
+		err = h.dht.simHandlePutReqs()
+		So(err, ShouldBeNil)
+
+		links, err := h.GetLinks(base, "friend")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 1)
+		So(links[0].String(), ShouldEqual, link.String())
+	})
+
+	Convey("it should reject links that fail the validateLink hook", t, func() {
+		h.Zomes["jsZome"].CodeSource = `function validateLink(base,link,tag,props) {return tag!="enemy"}`
+		err := h.LinkEntries(base, link, "enemy")
+		So(errors.Is(err, ErrInvalidLink), ShouldBeTrue)
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(h.chain.Top().Type, ShouldEqual, LinkEntryType)
+
+		err = h.LinkEntries(base, link, "colleague")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should return an error when getting links without a DHT", t, func() {
+		h2 := *h
+		h2.dht = nil
+		_, err := h2.GetLinks(base, "friend")
+		So(err, ShouldEqual, ErrNoDHT)
+	})
+}
+
+func TestLocalOnly(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.PeerModeAuthor = false
+	h.config.PeerModeDHTNode = false
+	if err := h.chain.Close(); err != nil {
+		panic(err)
+	}
+	h.chain = NewChainInMemory()
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
+	}
+
+	Convey("activate should not create a node", t, func() {
+		So(h.LocalOnly(), ShouldBeTrue)
+		err := h.Activate()
+		So(err, ShouldBeNil)
+		So(h.node, ShouldBeNil)
+	})
+
+	now := time.Unix(1, 1)
+	_, bhd, err := h.NewEntry(now, "myOdds", &GobEntry{C: "7"})
+	if err != nil {
+		panic(err)
+	}
+	base := bhd.EntryLink
+
+	Convey("put should be skipped and get should use the local chain", t, func() {
+		err := h.dht.SendPut(base)
+		So(err, ShouldBeNil)
+		So(h.dht.exists(base), ShouldNotBeNil)
+		status, err := h.dht.SendPutStatus(base)
+		So(err, ShouldBeNil)
+		So(status.Acks, ShouldEqual, 1)
+
+		r, err := h.dht.SendGet(base)
+		So(err, ShouldBeNil)
+		So(r.(*GobEntry).C, ShouldEqual, "7")
+
+		_, err = h.dht.SendGet(NullHash())
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("get should follow modifications and deletions on the local chain", t, func() {
+		_, hd, err := h.NewEntry(now, "myOdds", &GobEntry{C: "11"})
+		So(err, ShouldBeNil)
+		mod, err := h.Update("myOdds", "13", hd.EntryLink)
+		So(err, ShouldBeNil)
+
+		r, err := h.dht.SendGet(hd.EntryLink)
+		So(err, ShouldBeNil)
+		So(r.(*GobEntry).C, ShouldEqual, "13")
+
+		_, err = h.Remove(mod, "gone")
+		So(err, ShouldBeNil)
+		_, err = h.dht.SendGet(hd.EntryLink)
+		So(err, ShouldEqual, ErrEntryDeleted)
+		_, err = h.dht.SendGet(mod)
+		So(err, ShouldEqual, ErrEntryDeleted)
+	})
+
+	Convey("links should be found from the local chain", t, func() {
+		_, lhd, err := h.NewEntry(now, "myOdds", &GobEntry{C: "9"})
+		So(err, ShouldBeNil)
+		err = h.LinkEntries(base, lhd.EntryLink, "next")
+		So(err, ShouldBeNil)
+
+		links, err := h.GetLinks(base, "next")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 1)
+		So(links[0].String(), ShouldEqual, lhd.EntryLink.String())
+
+		_, err = h.GetLinks(base, "prev")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCallJSON(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should marshal the argument and return the result as JSON", t, func() {
+		profile := struct {
+			Firstname string `json:"firstName"`
+			Lastname  string `json:"lastName"`
+		}{"Zippy", "Pinhead"}
+		result, err := h.CallJSON("jsZome", "addProfile", profile)
+		So(err, ShouldBeNil)
+		var hash string
+		err = json.Unmarshal(result, &hash)
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, h.chain.Top().EntryLink.String())
+
+		result, err = h.CallJSON("myZome", "addPrime", map[string]int{"prime": 7})
+		So(err, ShouldBeNil)
+		err = json.Unmarshal(result, &hash)
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, h.chain.Top().EntryLink.String())
+	})
+
+	Convey("it should only call functions that take JSON", t, func() {
+		_, err := h.CallJSON("myZome", "exposedfn", "x")
+		So(err.Error(), ShouldEqual, "exposed function exposedfn doesn't take JSON")
+		_, err = h.CallJSON("myZome", "bogus", "x")
+		So(err.Error(), ShouldEqual, "unknown function: bogus (zome myZome exposes: addData, addPrime, exposedfn, getDNA)")
+	})
+}
+
+func TestTest(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	cleanupTestDir(d + "/.holochain/test/test/") // delete the test data created by gen dev
+	if os.Getenv("DEBUG") != "1" {
+		h.config.Loggers.TestPassed.Enabled = false
+		h.config.Loggers.TestFailed.Enabled = false
+		h.config.Loggers.TestInfo.Enabled = false
+	}
+	Convey("it should fail if there's no test data", t, func() {
+		err := h.Test()
+		So(err[0].Error(), ShouldEqual, "open "+h.path+"/test: no such file or directory")
+	})
+	cleanupTestDir(d)
+
+	d, _, h = setupTestChain("test")
+	defer cleanupTestDir(d)
+	if os.Getenv("DEBUG") != "1" {
+		h.config.Loggers.TestPassed.Enabled = false
+		h.config.Loggers.TestFailed.Enabled = false
+		h.config.Loggers.TestInfo.Enabled = false
+	}
+	Convey("it should validate on test data", t, func() {
+		err := h.Test()
+		So(err, ShouldBeNil)
+	})
+	Convey("it should reset the database state and thus run correctly twice", t, func() {
+		err := h.Test()
+		So(err, ShouldBeNil)
+	})
+	Convey("it should fail the test on incorrect data", t, func() {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[{"Zome":"myZome","FnName":"addData","Input":"2","Output":"","Err":"bogus error"}]`))
+		So(err, ShouldBeNil)
+		err = h.Test()[0]
+		So(err, ShouldNotBeNil)
+		//So(err.Error(), ShouldEqual, "Test: test_0:0\n  Expected Error: bogus error\n  Got: nil\n")
+		So(err.Error(), ShouldEqual, "bogus error")
+	})
+	Convey("it should compare JSON outputs semantically when JSONMatch is set", t, func() {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[{"Zome":"jsZome","FnName":"addProfile","Input":"{\"firstName\":\"Art\",\"lastName\":\"Brock\"}","Output":" \"%h%\" ","JSONMatch":true}]`))
+		So(err, ShouldBeNil)
+		So(h.Test(), ShouldBeNil)
+	})
+}
+
+func TestTestVerbose(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+
+	Convey("it should include a diff of the results in failures", t, func() {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: fist"}]`))
+		So(err, ShouldBeNil)
+		errs := h.TestWithOptions(TestOptions{Verbose: true})
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldEndWith, "Diff:\nfirst difference at character 11: expected \"t\", got \"h\"")
+
+		errs = h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldNotContainSubstring, "Diff:")
+	})
+
+	Convey("it should report outputs containing % as they are", t, func() {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: 100%d"}]`))
+		So(err, ShouldBeNil)
+		errs := h.TestWithOptions(TestOptions{Verbose: true})
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "Expected:\tresult: 100%d\n")
+		So(errs[0].Error(), ShouldNotContainSubstring, "%!")
+	})
+}
+
+func TestTestOne(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+
+	err := writeFile(d+"/.holochain/test/test", "failing.json", []byte(`[{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: fist"}]`))
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should run just the named test file", t, func() {
+		So(h.TestOne("test_0"), ShouldBeNil)
+		errs := h.TestOne("failing")
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "failing:0")
+		So(h.Started(), ShouldBeFalse)
+		So(len(h.Test()), ShouldEqual, 1)
+	})
+
+	Convey("it should fail for unknown test files", t, func() {
+		errs := h.TestOne("bogus")
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldEqual, "no test data named bogus")
+	})
+}
+
+func TestRunTests(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+
+	err := writeFile(d+"/.holochain/test/test", "failing.json", []byte(`[{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: fish"},{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: fist"}]`))
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should return the result of each step of each test file", t, func() {
+		results := h.RunTests()
+		So(results.Err, ShouldBeNil)
+		So(results.Passed(), ShouldBeFalse)
+		So(len(results.Files), ShouldEqual, 2)
+		So(results.Files[0].Name, ShouldEqual, "failing")
+		So(results.Files[1].Name, ShouldEqual, "test_0")
+		So(results.Files[0].Duration, ShouldBeGreaterThan, 0)
+
+		steps := results.Files[0].Steps
+		So(len(steps), ShouldEqual, 2)
+		So(steps[0].Line, ShouldEqual, 0)
+		So(steps[0].Zome, ShouldEqual, "myZome")
+		So(steps[0].FnName, ShouldEqual, "exposedfn")
+		So(steps[0].Passed, ShouldBeTrue)
+		So(steps[0].Errors, ShouldBeNil)
+		So(steps[0].Duration, ShouldBeGreaterThan, 0)
+		So(steps[1].Line, ShouldEqual, 1)
+		So(steps[1].Passed, ShouldBeFalse)
+		So(steps[1].Expected, ShouldEqual, "result: fist")
+		So(steps[1].Actual, ShouldEqual, "result: fish")
+		So(len(steps[1].Errors), ShouldEqual, 1)
+
+		for _, s := range results.Files[1].Steps {
+			So(s.Passed, ShouldBeTrue)
+		}
+	})
+
+	Convey("Test should return the errors of the failed steps", t, func() {
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "failing:1")
+	})
+
+	Convey("it should hold the error if the tests can't be run", t, func() {
+		err := os.RemoveAll(h.path + "/test")
+		So(err, ShouldBeNil)
+		results := h.RunTests()
+		So(results.Err, ShouldNotBeNil)
+		So(results.Files, ShouldBeNil)
+		So(results.Errors(), ShouldResemble, []error{results.Err})
+	})
+}
+
+func TestTestBase64(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+
+	input := base64.StdEncoding.EncodeToString([]byte{1, 2, 127})
+	output := base64.StdEncoding.EncodeToString(append([]byte("result: "), 1, 2, 127))
+	write := func(steps string) {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(steps))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	Convey("it should decode the Input and encode the result of base64 steps", t, func() {
+		write(`[{"Zome":"myZome","FnName":"exposedfn","Input":"` + input + `","Output":"` + output + `","Encoding":"base64"}]`)
+		So(h.Test(), ShouldBeNil)
+	})
+
+	Convey("it should compare base64 results as encoded", t, func() {
+		write(`[{"Zome":"myZome","FnName":"exposedfn","Input":"` + input + `","Output":"` + input + `","Encoding":"base64"}]`)
+		results := h.RunTests()
+		So(results.Passed(), ShouldBeFalse)
+		step := results.Files[0].Steps[0]
+		So(step.Expected, ShouldEqual, input)
+		So(step.Actual, ShouldEqual, output)
+	})
+
+	Convey("it should fail steps whose Input isn't base64", t, func() {
+		write(`[{"Zome":"myZome","FnName":"exposedfn","Input":"not base64!","Output":"` + output + `","Encoding":"base64"}]`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "couldn't decode Input")
+	})
+
+	Convey("it should fail steps with an unknown encoding", t, func() {
+		write(`[{"Zome":"myZome","FnName":"exposedfn","Input":"fish","Output":"result: fish","Encoding":"rot13"}]`)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Error(), ShouldContainSubstring, "unknown test encoding: rot13")
+	})
+}
+
+func TestTestMultiAgent(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+	h.config.SkipHashCheck = true
+
+	z := h.Zomes["jsZome"]
+	code, err := h.zomeCode(z)
+	if err != nil {
+		panic(err)
+	}
+	z.CodeSource = string(code) + `
+expose("getOdd",HC.STRING);
+function getOdd(x) {return get(x).C;}
+expose("whoami",HC.STRING);
+function whoami(x) {return App.Agent.String;}
+`
+
+	Convey("it should run steps as the agent they name sharing one DHT", t, func() {
+		os.Remove(d + "/.holochain/test/test/test_0.json")
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[
+{"Zome":"jsZome","FnName":"addOdd","Input":"3","Regexp":"^Qm"},
+{"Agent":"bob","Zome":"jsZome","FnName":"getOdd","Input":"%r1%","Output":"3"},
+{"Agent":"bob","Zome":"jsZome","FnName":"whoami","Input":"","Output":"bob"},
+{"Zome":"jsZome","FnName":"whoami","Input":"","Output":"%agentstr%"},
+{"Agent":"bob","Zome":"jsZome","FnName":"whoami","Input":"","Output":"%agentstr%"}
+]`))
+		So(err, ShouldBeNil)
+		errs := h.Test()
+		So(len(errs), ShouldEqual, 0)
+		So(h.sim, ShouldBeNil)
+	})
+}
+
+func TestTestDHTExpectations(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+	h.config.Loggers.TestPassed.Enabled = false
+	h.config.Loggers.TestInfo.Enabled = false
+	h.config.Loggers.TestFailed.Enabled = false
+	h.config.SkipHashCheck = true
+
+	z := h.Zomes["jsZome"]
+	code, err := h.zomeCode(z)
+	if err != nil {
+		panic(err)
+	}
+	z.CodeSource = string(code) + `
+expose("linkOdds",HC.JSON);
+function linkOdds(x) {link(x.base,x.link,"next");return true;}
+`
+	os.Remove(d + "/.holochain/test/test/test_0.json")
+
+	Convey("it should check the DHT once the puts of a step have been handled", t, func() {
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[
+{"Zome":"jsZome","FnName":"addOdd","Input":"3","Regexp":"^Qm"},
+{"Zome":"jsZome","FnName":"addOdd","Input":"5","Regexp":"^Qm","DHT":[{"Hash":"%h%","Output":"5"},{"Hash":"%r1%","Output":"3"}]},
+{"Zome":"jsZome","FnName":"linkOdds","Input":"{\"base\":\"%r2%\",\"link\":\"%r1%\"}","Output":"true",
+ "DHT":[{"Base":"%r2%","Tag":"next","Output":"[{\"E\":{\"C\":\"5\"},\"H\":\"%r1%\"}]","JSONMatch":true},{"Base":"%r1%","Tag":"next","Err":"No values for next"}]}
+]`))
+		So(err, ShouldBeNil)
+		So(h.Test(), ShouldBeNil)
+	})
+
+	Convey("it should fail the step for each expectation that isn't met", t, func() {
+		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[
+{"Zome":"jsZome","FnName":"addOdd","Input":"3","Regexp":"^Qm","DHT":[{"Hash":"%h%","Output":"7"},{"Hash":"QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2","Err":"hash not found"},{"Hash":"QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2","Output":"3"}]}
+]`))
+		So(err, ShouldBeNil)
+		errs := h.TestWithOptions(TestOptions{Verbose: true})
+		So(len(errs), ShouldEqual, 2)
+		So(errs[0].Error(), ShouldContainSubstring, "test_0:0 dht:0")
+		So(errs[0].Error(), ShouldEndWith, `Diff:
+first difference at character 0: expected "7", got "3"`)
+		So(errs[1].Error(), ShouldContainSubstring, "test_0:0 dht:2")
+		So(errs[1].Error(), ShouldContainSubstring, "Got Error:\t\thash not found")
+	})
+}
+
+func TestTextDiff(t *testing.T) {
+	Convey("it should report the first differing character of single lines", t, func() {
+		So(textDiff("abcdef", "abcxef"), ShouldEqual, `first difference at character 3: expected "def", got "xef"`)
+		So(textDiff("abc", "abcd"), ShouldEqual, `first difference at character 3: expected "", got "d"`)
+	})
+	Convey("it should diff multiple lines", t, func() {
+		So(textDiff("a\nb\nc", "a\nx\nc\nd"), ShouldEqual, "  a\n- b\n+ x\n  c\n+ d")
+	})
+}
+
+func TestJSONDiff(t *testing.T) {
+	Convey("it should name the keys that differ", t, func() {
+		diffs, err := jsonDiff(`{"a":1,"b":{"c":[1,2],"d":"x"},"e":true}`, `{"a":1,"b":{"c":[1,3],"d":"x","f":null}}`)
+		So(err, ShouldBeNil)
+		So(diffs, ShouldResemble, []string{
+			"$.b.c[1]: expected 2, got 3",
+			"$.b.f: unexpected null",
+			"$.e: missing, expected true",
+		})
+	})
+	Convey("it should report arrays of different lengths and values of different types", t, func() {
+		diffs, err := jsonDiff(`[1,{"a":1}]`, `[1,"x",3]`)
+		So(err, ShouldBeNil)
+		So(diffs, ShouldResemble, []string{
+			"$: expected 2 elements, got 3",
+			`$[1]: expected {"a":1}, got "x"`,
+		})
+	})
+}
+
+func TestJSONMatch(t *testing.T) {
+	Convey("it should ignore key order and whitespace", t, func() {
+		match, err := jsonMatch(`{"a":1, "b":[1,2]}`, `{"b": [1, 2],"a":1}`)
+		So(err, ShouldBeNil)
+		So(match, ShouldBeTrue)
+		match, err = jsonMatch(`{"a":1}`, `{"a":2}`)
+		So(err, ShouldBeNil)
+		So(match, ShouldBeFalse)
+	})
+	Convey("it should fail on values that aren't JSON", t, func() {
+		_, err := jsonMatch(`{"a":1}`, `fish`)
+		So(err.Error(), ShouldStartWith, "result isn't JSON: ")
+	})
+}
+
+func TestSetProperty(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	schema := `{
+	"title": "Properties Schema",
+	"type": "object",
+	"properties": {
+		"language": {
+			"type": "string",
+			"enum": ["en", "fr"]
+		}
+	}
+}`
+	os.Remove(h.path + "/schema_properties.json")
+	if err := writeFile(h.path, "schema_properties.json", []byte(schema)); err != nil {
+		panic(err)
+	}
+
+	Convey("it should set a property and save the DNA", t, func() {
+		err := h.SetProperty("language", "fr")
+		So(err, ShouldBeNil)
+		p, _ := h.GetProperty("language")
+		So(p, ShouldEqual, "fr")
+		var h2 Holochain
+		_, err = toml.DecodeFile(h.path+"/"+DNAFileName+".toml", &h2)
+		So(err, ShouldBeNil)
+		So(h2.Properties["language"], ShouldEqual, "fr")
+	})
+
+	Convey("it should reject values that don't match the properties schema", t, func() {
+		err := h.SetProperty("language", "klingon")
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		p, _ := h.GetProperty("language")
+		So(p, ShouldEqual, "fr")
+	})
+
+	Convey("it should reject reserved properties", t, func() {
+		err := h.SetProperty(ID_PROPERTY, "foo")
+		So(err.Error(), ShouldEqual, "holochain: can't set reserved property: "+ID_PROPERTY)
+	})
+
+	Convey("it should reject changes after the chain has been started", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		err = h.SetProperty("language", "en")
+		So(err.Error(), ShouldEqual, "holochain: properties can't be changed after the chain has been started")
+	})
+}
+
+func TestPrepareDuplicateEntryTypes(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("Prepare should reject an entry type defined in two zomes", t, func() {
+		h.Zomes["jsZome"].Entries["myData"] = EntryDef{Name: "myData", DataFormat: DataFormatString}
+		err := h.Prepare()
+		So(errors.Is(err, ErrDuplicateEntryType), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "duplicate entry type: myData is defined in zomes jsZome and myZome")
+
+		delete(h.Zomes["jsZome"].Entries, "myData")
+		So(h.Prepare(), ShouldBeNil)
+	})
+}
+
+func TestAddEntryDef(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should add an entry definition and save the DNA", t, func() {
+		err := h.AddEntryDef("jsZome", EntryDef{Name: "note", DataFormat: DataFormatString})
+		So(err, ShouldBeNil)
+		z, def, err := h.GetEntryDef("note")
+		So(err, ShouldBeNil)
+		So(z.Name, ShouldEqual, "jsZome")
+		So(def.DataFormat, ShouldEqual, DataFormatString)
+
+		err = h.AddEntryDef("myZome", EntryDef{Name: "contact", DataFormat: DataFormatJSON, Schema: "schema_profile.json"})
+		So(err, ShouldBeNil)
+		_, def, err = h.GetEntryDef("contact")
+		So(err, ShouldBeNil)
+		So(def.validator, ShouldNotBeNil)
+
+		So(h.Close(), ShouldBeNil)
+		h, err = s.Load("test")
+		So(err, ShouldBeNil)
+		_, _, err = h.GetEntryDef("note")
+		So(err, ShouldBeNil)
+		_, def, err = h.GetEntryDef("contact")
+		So(err, ShouldBeNil)
+		So(def.validator, ShouldNotBeNil)
+	})
+
+	Convey("it should reject duplicate entry types and unknown zomes", t, func() {
+		err := h.AddEntryDef("jsZome", EntryDef{Name: "myData", DataFormat: DataFormatString})
+		So(errors.Is(err, ErrDuplicateEntryType), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "duplicate entry type: myData is already defined in zome myZome")
+		err = h.AddEntryDef("bogusZome", EntryDef{Name: "other", DataFormat: DataFormatString})
+		So(err.Error(), ShouldEqual, "unknown zome: bogusZome")
+		err = h.AddEntryDef("jsZome", EntryDef{Name: "other", DataFormat: DataFormatJSON, Schema: "bogus.json"})
+		So(err.Error(), ShouldEqual, "DNA specified schema file missing: bogus.json")
+		_, _, err = h.GetEntryDef("other")
 		So(err, ShouldNotBeNil)
-		So(err.Error(), ShouldEqual, "validator schema_profile.json failed: object property 'lastName' is required")
+	})
+
+	Convey("it should refuse once the chain is started", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		err = h.AddEntryDef("jsZome", EntryDef{Name: "other", DataFormat: DataFormatString})
+		So(err.Error(), ShouldEqual, "holochain: chain already started")
 	})
 }
 
-func TestMakeNucleus(t *testing.T) {
+func TestDiffDNA(t *testing.T) {
+	d, _, a := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	copyDNA := func() *Holochain {
+		var buf bytes.Buffer
+		if err := a.EncodeDNA(&buf); err != nil {
+			panic(err)
+		}
+		b, err := DecodeDNA(&buf, a.encodingFormat)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+
+	Convey("it should find no differences between copies of a DNA", t, func() {
+		diff, err := DiffDNA(a, copyDNA())
+		So(err, ShouldBeNil)
+		So(diff.Empty(), ShouldBeTrue)
+		So(diff.String(), ShouldEqual, "")
+	})
+
+	Convey("it should list the added, removed and changed elements", t, func() {
+		b := copyDNA()
+		b.Name = "upgraded"
+		b.Properties["language"] = "fr"
+		delete(b.Properties, "description")
+		b.Properties["license"] = "GPL"
+		b.Zomes["jsZome"].Description = "new description"
+		delete(b.Zomes["jsZome"].Entries, "privateNote")
+		b.Zomes["jsZome"].Entries["note"] = EntryDef{Name: "note", DataFormat: DataFormatString}
+		def := b.Zomes["myZome"].Entries["primes"]
+		def.Expiry = 60
+		b.Zomes["myZome"].Entries["primes"] = def
+		b.Zomes["newZome"] = &Zome{Name: "newZome"}
+
+		diff, err := DiffDNA(a, b)
+		So(err, ShouldBeNil)
+		So(diff.Empty(), ShouldBeFalse)
+		So(diff.String(), ShouldEqual, `~ Name: test -> upgraded
+- Properties.description: a bogus test holochain
+~ Properties.language: en -> fr
++ Properties.license: GPL
+~ Zomes.jsZome.Description: `+a.Zomes["jsZome"].Description+` -> new description
++ Zomes.jsZome.Entries.note: note
+- Zomes.jsZome.Entries.privateNote: privateNote
+~ Zomes.myZome.Entries.primes.Expiry: 0 -> 60
++ Zomes.newZome: newZome`)
+		So(diff.Changes[0], ShouldResemble, DNAChange{Path: "Name", Kind: DNAChanged, From: "test", To: "upgraded"})
+
+		diff, err = DiffDNA(b, a)
+		So(err, ShouldBeNil)
+		So(diff.Changes[len(diff.Changes)-1].Kind, ShouldEqual, DNARemoved)
+	})
+
+	Convey("it should not diff a nil DNA", t, func() {
+		_, err := DiffDNA(a, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPrepareValidatesProperties(t *testing.T) {
 	d, _, h := setupTestChain("test")
 	defer cleanupTestDir(d)
-	Convey("it should fail if the type isn't defined in the DNA", t, func() {
-		_, err := h.MakeNucleus("bogusType")
-		So(err.Error(), ShouldEqual, "unknown zome: bogusType")
 
+	Convey("Prepare should accept properties that match the schema", t, func() {
+		So(h.Prepare(), ShouldBeNil)
 	})
-	Convey("it should make a nucleus based on the type", t, func() {
-		v, err := h.MakeNucleus("myZome")
+
+	Convey("Prepare should reject properties that don't match the schema", t, func() {
+		schema := `{
+	"title": "Properties Schema",
+	"type": "object",
+	"properties": {
+		"language": {
+			"type": "string"
+		}
+	},
+	"required": ["language"]
+}`
+		os.Remove(h.path + "/schema_properties.json")
+		err := writeFile(h.path, "schema_properties.json", []byte(schema))
 		So(err, ShouldBeNil)
-		z := v.(*ZygoNucleus)
-		_, err = z.env.Run()
+		delete(h.Properties, "language")
+		err = h.Prepare()
+		So(errors.Is(err, ErrValidationFailed), ShouldBeTrue)
+		So(err.Error(), ShouldStartWith, "DNA properties invalid: ")
+	})
+
+	Convey("Prepare should error on a malformed schema", t, func() {
+		os.Remove(h.path + "/schema_properties.json")
+		err := writeFile(h.path, "schema_properties.json", []byte("{bogus"))
 		So(err, ShouldBeNil)
+		err = h.Prepare()
+		So(err.Error(), ShouldStartWith, "error building validator for schema_properties.json")
+	})
+
+	Convey("Prepare should tolerate a missing schema file", t, func() {
+		os.Remove(h.path + "/schema_properties.json")
+		So(h.Prepare(), ShouldBeNil)
 	})
 }
 
-func TestCall(t *testing.T) {
+func TestInlineZomeCode(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	z := h.Zomes["myZome"]
+	code, err := readFile(h.path, z.Code)
+	if err != nil {
+		panic(err)
+	}
+	z.CodeSource = string(code)
+	os.Remove(h.path + "/" + z.Code)
+
+	Convey("Prepare should not require the code file of an inline zome", t, func() {
+		So(h.Prepare(), ShouldBeNil)
+	})
+
+	Convey("the nucleus should be made from the inline code", t, func() {
+		_, err := h.GenChain()
+		So(err, ShouldBeNil)
+		result, err := h.Call("myZome", "exposedfn", "arg1")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: arg1")
+	})
+
+	Convey("the code hash should be of the inline code", t, func() {
+		err := h.GenDNAHashes()
+		So(err, ShouldBeNil)
+		var hash Hash
+		hash.Sum(h.hashSpec, code)
+		So(z.CodeHash.String(), ShouldEqual, hash.String())
+		So(h.VerifyDNAHashes(), ShouldBeNil)
+	})
+}
+
+func TestReindexDHT(t *testing.T) {
 	d, _, h := prepareTestChain("test")
 	defer cleanupTestDir(d)
-	Convey("it should call the exposed function", t, func() {
-		result, err := h.Call("myZome", "exposedfn", "arg1 arg2")
+
+	hash, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+	private, err := h.Commit("privateNote", "just for me")
+	if err != nil {
+		panic(err)
+	}
+	stray, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	if err = h.dht.put(nil, "myData", stray, h.id, []byte("3"), LIVE); err != nil {
+		panic(err)
+	}
+
+	Convey("it should put the chain's entries into a fresh DHT", t, func() {
+		_, _, _, err := h.dht.get(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+
+		err = h.ReindexDHT()
 		So(err, ShouldBeNil)
-		So(result.(string), ShouldEqual, "result: arg1 arg2")
 
-		result, err = h.Call("myZome", "addData", "42")
+		data, entryType, _, err := h.dht.get(hash)
 		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "myData")
+		e, _, _ := h.chain.GetEntry(hash)
+		b, _ := e.Marshal()
+		So(string(data), ShouldEqual, string(b))
 
-		ph := h.chain.Top().EntryLink
-		So(result.(string), ShouldEqual, ph.String())
+		_, _, _, err = h.dht.get(h.DNAHash())
+		So(err, ShouldBeNil)
+		_, _, _, err = h.dht.get(h.Agenthash())
+		So(err, ShouldBeNil)
 
-		//_, err = h.Call("myZome", "addData", "41")
-		//So(err.Error(), ShouldEqual, "Error calling 'commit': Invalid entry: 41")
+		_, _, _, err = h.dht.get(stray)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should not put private entries", t, func() {
+		_, _, _, err := h.dht.get(private)
+		So(err, ShouldEqual, ErrHashNotFound)
 	})
 }
 
-func TestTest(t *testing.T) {
+func TestStatus(t *testing.T) {
 	d, _, h := setupTestChain("test")
-	cleanupTestDir(d + "/.holochain/test/test/") // delete the test data created by gen dev
-	if os.Getenv("DEBUG") != "1" {
-		h.config.Loggers.TestPassed.Enabled = false
-		h.config.Loggers.TestFailed.Enabled = false
-		h.config.Loggers.TestInfo.Enabled = false
+	defer cleanupTestDir(d)
+
+	Convey("it should report an unstarted chain", t, func() {
+		st := h.Status()
+		So(st.Started, ShouldBeFalse)
+		So(st.ChainLength, ShouldEqual, 0)
+		So(st.DNAHash, ShouldEqual, "")
+		So(st.Peers, ShouldEqual, 0)
+		So(st.ListenAddr, ShouldEqual, "")
+	})
+
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
 	}
-	Convey("it should fail if there's no test data", t, func() {
-		err := h.Test()
-		So(err[0].Error(), ShouldEqual, "open "+h.path+"/test: no such file or directory")
+
+	Convey("it should report the chain and DHT before activation", t, func() {
+		st := h.Status()
+		So(st.Started, ShouldBeTrue)
+		So(st.ChainLength, ShouldEqual, 2)
+		So(st.DNAHash, ShouldEqual, h.DNAHash().String())
+		So(st.AgentHash, ShouldEqual, h.Agenthash().String())
+		So(st.DHTEntries, ShouldEqual, 3)
+		So(st.Peers, ShouldEqual, 0)
+		So(st.ListenAddr, ShouldEqual, "")
 	})
-	cleanupTestDir(d)
 
-	d, _, h = setupTestChain("test")
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	h.node = node
+
+	Convey("it should report the node's address once activated", t, func() {
+		st := h.Status()
+		So(st.ListenAddr, ShouldEqual, node.NetAddr.String())
+		So(st.Peers, ShouldEqual, 0)
+	})
+}
+
+func TestWaitForPeers(t *testing.T) {
+	d, _, h := setupTestChain("test")
 	defer cleanupTestDir(d)
-	if os.Getenv("DEBUG") != "1" {
-		h.config.Loggers.TestPassed.Enabled = false
-		h.config.Loggers.TestFailed.Enabled = false
-		h.config.Loggers.TestInfo.Enabled = false
+
+	Convey("it should fail if the holochain isn't activated", t, func() {
+		err := h.WaitForPeers(context.Background(), 1)
+		So(err.Error(), ShouldEqual, "holochain: not activated")
+	})
+
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
 	}
-	Convey("it should validate on test data", t, func() {
-		err := h.Test()
+	defer node.Close()
+	h.node = node
+	node2, err := makeNode(4321, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+
+	Convey("it should return immediately if enough peers are connected", t, func() {
+		So(h.WaitForPeers(context.Background(), 0), ShouldBeNil)
+	})
+
+	Convey("it should time out if too few peers connect", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		So(h.WaitForPeers(ctx, 1), ShouldEqual, context.DeadlineExceeded)
+	})
+
+	Convey("it should return once a peer connects", t, func() {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			node2.Host.Connect(context.Background(), pstore.PeerInfo{ID: node.HashAddr, Addrs: []ma.Multiaddr{node.NetAddr}})
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		So(h.WaitForPeers(ctx, 1), ShouldBeNil)
+		So(h.Status().Peers, ShouldEqual, 1)
+	})
+}
+
+func TestResume(t *testing.T) {
+	d, s, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should fail on a chain that hasn't been started", t, func() {
+		err := h.Resume()
+		So(err.Error(), ShouldEqual, "holochain: chain not started")
+	})
+
+	if _, err := h.GenChain(); err != nil {
+		panic(err)
+	}
+	hash1, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+	if err = h.Close(); err != nil {
+		panic(err)
+	}
+
+	h, err = s.Load("test")
+	if err != nil {
+		panic(err)
+	}
+	defer h.Close()
+
+	Convey("it should allow committing to a reloaded chain", t, func() {
+		err := h.Resume()
+		So(err, ShouldBeNil)
+		So(h.Length(), ShouldEqual, 3)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash1.String())
+
+		hash2, err := h.Commit("myData", "4")
+		So(err, ShouldBeNil)
+		So(h.Length(), ShouldEqual, 4)
+		So(h.chain.Top().EntryLink.String(), ShouldEqual, hash2.String())
+		So(h.chain.Top().HeaderLink.String(), ShouldEqual, h.chain.Hashes[2].String())
+		valid, err := h.Validate(true)
 		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
 	})
-	Convey("it should reset the database state and thus run correctly twice", t, func() {
-		err := h.Test()
+
+	Convey("it should fail on an inconsistent chain", t, func() {
+		h.chain.Headers[h.chain.Length()-1].Time = time.Unix(1, 1)
+		err := h.Resume()
+		So(err.Error(), ShouldEqual, "holochain: top header hash doesn't match")
+	})
+}
+
+func TestQuery(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	hash1, err := h.Commit("myData", "2")
+	if err != nil {
+		panic(err)
+	}
+	profile, err := h.Commit("profile", `{"firstName":"Zippy","lastName":"Pinhead"}`)
+	if err != nil {
+		panic(err)
+	}
+	hash2, err := h.Commit("myData", "4")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should return the local entries of a type oldest first", t, func() {
+		results, err := h.Query("myData")
 		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Hash, ShouldEqual, hash1.String())
+		So(results[0].Entry, ShouldEqual, "2")
+		So(results[1].Hash, ShouldEqual, hash2.String())
 	})
-	Convey("it should fail the test on incorrect data", t, func() {
-		os.Remove(d + "/.holochain/test/test/test_0.json")
-		err := writeFile(d+"/.holochain/test/test", "test_0.json", []byte(`[{"Zome":"myZome","FnName":"addData","Input":"2","Output":"","Err":"bogus error"}]`))
+
+	Convey("it should return JSON entries as JSON", t, func() {
+		j, err := h.QueryJSON("profile")
 		So(err, ShouldBeNil)
-		err = h.Test()[0]
-		So(err, ShouldNotBeNil)
-		//So(err.Error(), ShouldEqual, "Test: test_0:0\n  Expected Error: bogus error\n  Got: nil\n")
-		So(err.Error(), ShouldEqual, "bogus error")
+		So(string(j), ShouldEqual, `[{"Hash":"`+profile.String()+`","Entry":{"firstName":"Zippy","lastName":"Pinhead"}}]`)
 	})
 
+	Convey("it should return an empty array when there are no entries", t, func() {
+		j, err := h.QueryJSON("bogusType")
+		So(err, ShouldBeNil)
+		So(string(j), ShouldEqual, `[]`)
+	})
 }
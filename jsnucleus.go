@@ -20,10 +20,18 @@ const (
 	JSNucleusType = "js"
 )
 
+var errJSInterrupted = errors.New("javascript interrupted")
+
 type JSNucleus struct {
 	vm         *otto.Otto
+	pristine   *otto.Otto // copy of the vm as it was once the code had run, restored by reset
 	interfaces []Interface
 	lastResult *otto.Value
+	callErr    error
+	depth      int
+	readOnly   bool   // the running function is read-only, or was called from one, so can't write
+	roCaller   bool   // the nucleus was called from a read-only function in another zome
+	zome       string // name of the zome the nucleus runs, if any
 }
 
 // Name returns the string value under which this nucleus is registered
@@ -89,14 +97,18 @@ func (z *JSNucleus) ChainGenesis() (err error) {
 // ValidateEntry checks the contents of an entry against the validation rules
 // this is the zgo implementation
 func (z *JSNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationProps) (err error) {
-	c := entry.Content().(string)
+	c, ok := entry.Content().(string)
+	if !ok {
+		err = fmt.Errorf("%w: %s entry content must be a string, not %T", ErrInvalidEntry, d.DataFormat, entry.Content())
+		return
+	}
 	var e string
 	switch d.DataFormat {
 	case DataFormatRawJS:
 		e = c
 	case DataFormatString:
 		e = "\"" + jsSanitizeString(c) + "\""
-	case DataFormatJSON:
+	case DataFormatJSON, DataFormatURI:
 		e = fmt.Sprintf(`JSON.parse("%s")`, jsSanitizeString(c))
 	default:
 		err = errors.New("data format not implemented: " + d.DataFormat)
@@ -124,7 +136,7 @@ func (z *JSNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationPro
 				return
 			}
 			if !b {
-				err = fmt.Errorf("Invalid entry: %v", entry.Content())
+				err = &InvalidEntryError{Content: entry.Content()}
 			}
 		}
 	} else {
@@ -133,6 +145,34 @@ func (z *JSNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationPro
 	return
 }
 
+// ValidateLink checks a link against the application's validateLink function, accepting it
+// if the application doesn't define one
+func (z *JSNucleus) ValidateLink(base Hash, link Hash, tag string, props *ValidationProps) (err error) {
+	var b []byte
+	b, err = json.Marshal(props)
+	if err != nil {
+		return
+	}
+	if t, _ := z.vm.Run(`typeof validateLink`); t.String() != "function" {
+		return
+	}
+	code := fmt.Sprintf(`validateLink("%s","%s","%s",JSON.parse("%s"))`, base.String(), link.String(), jsSanitizeString(tag), jsSanitizeString(string(b)))
+	v, err := z.vm.Run(code)
+	if err != nil {
+		err = fmt.Errorf("Error executing validateLink: %w", err)
+		return
+	}
+	if v.IsBoolean() {
+		var ok bool
+		if ok, err = v.ToBoolean(); err == nil && !ok {
+			err = &InvalidLinkError{Base: base, Link: link, Tag: tag}
+		}
+	} else {
+		err = fmt.Errorf("validateLink should return boolean, got: %v", v)
+	}
+	return
+}
+
 // GetInterface returns an Interface of the given name
 func (z *JSNucleus) GetInterface(iface string) (i *Interface, err error) {
 	for _, x := range z.interfaces {
@@ -172,22 +212,101 @@ func jsSanitizeString(s string) string {
 	return s2
 }
 
+// jsToJSON serializes a javascript object to canonical JSON
+func jsToJSON(vm *otto.Otto, v otto.Value) (j string, err error) {
+	if v, err = vm.Call("JSON.stringify", nil, v); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = CanonicalizeJSON([]byte(v.String())); err != nil {
+		return
+	}
+	j = string(b)
+	return
+}
+
+// commitArguments returns the entry type and the entry given as the first two arguments of
+// the library function fn (commit, commitAt or update), serializing an object entry as JSON
+func (z *JSNucleus) commitArguments(fn string, call otto.FunctionCall) (entryType string, entry string, err error) {
+	entryType, _ = call.Argument(0).ToString()
+	v := call.Argument(1)
+	if v.IsString() {
+		entry, _ = v.ToString()
+	} else if v.IsObject() {
+		entry, err = jsToJSON(z.vm, v)
+	} else {
+		err = errors.New(fn + " expected string as second argument")
+	}
+	return
+}
+
+// setCallDepth records how deeply nested this nucleus's calls from other zomes are
+func (z *JSNucleus) setCallDepth(depth int) { z.depth = depth }
+
+// setZomeName records the name of the zome this nucleus runs for its log output
+func (z *JSNucleus) setZomeName(name string) { z.zome = name }
+
+// setReadOnlyCaller records whether the nucleus is being called from a read-only function
+func (z *JSNucleus) setReadOnlyCaller(readOnly bool) { z.roCaller = readOnly }
+
+// reset clears the per-call state, and puts back the vm the code had just been run in so
+// that no globals set by the last call are seen by the next, so the nucleus can be reused
+// for another call
+func (z *JSNucleus) reset() {
+	z.vm = z.pristine.Copy()
+	z.vm.Interrupt = make(chan func(), 1)
+	z.lastResult = nil
+	z.callErr = nil
+	z.depth = 0
+	z.readOnly = false
+	z.roCaller = false
+}
+
+// interrupt aborts any javascript currently running in the vm
+func (z *JSNucleus) interrupt() {
+	z.vm.Interrupt <- func() {
+		panic(errJSInterrupted)
+	}
+}
+
 // Call calls the zygo function that was registered with expose
 func (z *JSNucleus) Call(iface string, params interface{}) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errJSInterrupted {
+				err = ErrCallAborted
+				return
+			}
+			panic(r)
+		}
+		if err != nil && z.callErr != nil {
+			err = &CallError{Function: iface, Err: err, Cause: z.callErr}
+		}
+	}()
+	z.callErr = nil
 	var i *Interface
 	i, err = z.GetInterface(iface)
 	if err != nil {
 		return
 	}
+	z.readOnly = i.ReadOnly || z.roCaller
+	var arg string
+	if arg, err = callArgument(iface, params); err != nil {
+		return
+	}
 	var code string
 	switch i.Schema {
 	case STRING:
-		code = fmt.Sprintf(`%s("%s");`, iface, jsSanitizeString(params.(string)))
+		code = fmt.Sprintf(`%s("%s");`, iface, jsSanitizeString(arg))
 	case JSON:
-		if params.(string) == "" {
+		p := jsSanitizeString(arg)
+		// line breaks are stripped before parsing so check what JSON.parse will see
+		if err = checkJSONArgument(iface, strings.NewReplacer("\n", "", "\r", "").Replace(arg)); err != nil {
+			return
+		}
+		if p == "" {
 			code = fmt.Sprintf(`JSON.stringify(%s());`, iface)
 		} else {
-			p := jsSanitizeString(params.(string))
 			code = fmt.Sprintf(`JSON.stringify(%s(JSON.parse("%s")));`, iface, p)
 		}
 	default:
@@ -205,6 +324,11 @@ func (z *JSNucleus) Call(iface string, params interface{}) (result interface{},
 			if err == nil {
 				err = errors.New(message.String())
 			}
+		} else if i.Schema == JSON && v.IsString() {
+			var j []byte
+			if j, err = CanonicalizeJSON([]byte(v.String())); err == nil {
+				result = string(j)
+			}
 		} else {
 			result, err = v.ToString()
 		}
@@ -216,6 +340,7 @@ func (z *JSNucleus) Call(iface string, params interface{}) (result interface{},
 func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	var z JSNucleus
 	z.vm = otto.New()
+	z.vm.Interrupt = make(chan func(), 1)
 
 	err = z.vm.Set("property", func(call otto.FunctionCall) otto.Value {
 		prop, _ := call.Argument(0).ToString()
@@ -231,9 +356,101 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		return nil, err
 	}
 
+	// chainLength is length under a name that goes with chainTop
+	length := func(call otto.FunctionCall) otto.Value {
+		result, _ := z.vm.ToValue(h.Length())
+		return result
+	}
+	err = z.vm.Set("length", length)
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("chainLength", length)
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("chainTop", func(call otto.FunctionCall) otto.Value {
+		top, err := h.Top()
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, _ := z.vm.ToValue(top.String())
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("countType", func(call otto.FunctionCall) otto.Value {
+		entryType, _ := call.Argument(0).ToString()
+		result, _ := z.vm.ToValue(h.CountType(entryType))
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("query", func(call otto.FunctionCall) otto.Value {
+		entryType, _ := call.Argument(0).ToString()
+		j, err := h.QueryJSON(entryType)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, err := z.vm.Call("JSON.parse", nil, string(j))
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("keyHashFor", func(call otto.FunctionCall) otto.Value {
+		key, _ := call.Argument(0).ToString()
+		hash, err := agentKeyHash(key)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, _ := z.vm.ToValue(hash)
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("callZome", func(call otto.FunctionCall) otto.Value {
+		zomeType, _ := call.Argument(0).ToString()
+		function, _ := call.Argument(1).ToString()
+		var arg string
+		v := call.Argument(2)
+
+		if v.IsString() {
+			arg, _ = v.ToString()
+		} else if v.IsObject() {
+			v, _ = z.vm.Call("JSON.stringify", nil, v)
+			arg, _ = v.ToString()
+		} else if !v.IsUndefined() {
+			return z.vm.MakeCustomError("HolochainError", "callZome expected string or object as third argument")
+		}
+
+		result, err := h.callZome(z.depth, z.readOnly, zomeType, function, arg)
+		if err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		r, _ := z.vm.ToValue(callResultString(result))
+		return r
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	err = z.vm.Set("debug", func(call otto.FunctionCall) otto.Value {
 		msg, _ := call.Argument(0).ToString()
-		h.config.Loggers.App.p(msg)
+		logApp(h, z.zome, msg)
 		return otto.UndefinedValue()
 	})
 
@@ -241,6 +458,11 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		fnName, _ := call.Argument(0).ToString()
 		schema, _ := call.Argument(1).ToInteger()
 		i := Interface{Name: fnName, Schema: InterfaceSchemaType(schema)}
+		// optional capabilities, i.e. expose("getProperty",HC.STRING,{readonly:true})
+		if opts := call.Argument(2); opts.IsObject() {
+			v, _ := opts.Object().Get("readonly")
+			i.ReadOnly, _ = v.ToBoolean()
+		}
 		err = z.expose(i)
 		if err != nil {
 			return z.vm.MakeCustomError("HolochainError", err.Error())
@@ -252,43 +474,103 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	}
 
 	err = z.vm.Set("commit", func(call otto.FunctionCall) otto.Value {
-		entryType, _ := call.Argument(0).ToString()
-		var entry string
-		v := call.Argument(1)
+		if err := checkWritable(z.readOnly, "commit"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		entryType, entry, err := z.commitArguments("commit", call)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
 
-		if v.IsString() {
-			entry, _ = v.ToString()
-		} else if v.IsObject() {
-			v, _ = z.vm.Call("JSON.stringify", nil, v)
-			entry, _ = v.ToString()
-		} else {
-			return z.vm.MakeCustomError("HolochainError", "commit expected string as second argument")
+		entryHash, err := h.Commit(entryType, entry)
+		if err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		e := GobEntry{C: entry}
-		var l int
-		var hash Hash
-		var header *Header
-		l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, time.Now(), entryType, &e, h.agent.PrivKey())
+		result, _ := z.vm.ToValue(entryHash.String())
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("commitAt", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "commitAt"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		entryType, entry, err := z.commitArguments("commitAt", call)
 		if err != nil {
 			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		p := ValidationProps{
-			Sources: []string{peer.IDB58Encode(h.id)},
-			Hash:    hash.String(),
+		ts := call.Argument(2)
+		if !ts.IsNumber() {
+			return z.vm.MakeCustomError("HolochainError", "commitAt expected timestamp in seconds as third argument")
+		}
+		secs, _ := ts.ToInteger()
+		entryHash, err := h.CommitAt(entryType, entry, time.Unix(secs, 0))
+		if err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		err = h.ValidateEntry(entryType, &e, &p)
+		result, _ := z.vm.ToValue(entryHash.String())
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if err == nil {
-			err = h.chain.addEntry(l, hash, header, &e)
+	err = z.vm.Set("update", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "update"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		entryType, entry, err := z.commitArguments("update", call)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+
+		replaces, _ := call.Argument(2).ToString()
+		key, err := NewHash(replaces)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		entryHash, err := h.Update(entryType, entry, key)
+		if err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+
+		result, _ := z.vm.ToValue(entryHash.String())
+		return result
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("remove", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "remove"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		hashstr, _ := call.Argument(0).ToString()
+		message, _ := call.Argument(1).ToString()
+
+		key, err := NewHash(hashstr)
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
+		entryHash, err := h.Remove(key, message)
 		if err != nil {
+			z.callErr = err
 			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		result, _ := z.vm.ToValue(header.EntryLink.String())
+		result, _ := z.vm.ToValue(entryHash.String())
 		return result
 	})
 	if err != nil {
@@ -296,6 +578,10 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	}
 
 	err = z.vm.Set("put", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "put"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
 		v := call.Argument(0)
 		var hashstr string
 
@@ -306,16 +592,50 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 		}
 
 		var key Hash
+		var status PutStatus
 		key, err = NewHash(hashstr)
 		if err == nil {
-			err = h.dht.SendPut(key)
+			status, err = h.dht.SendPutStatus(key)
+		}
+		var result *otto.Object
+		if err == nil {
+			result, err = z.vm.Object(`({})`)
+		}
+		if err == nil {
+			err = result.Set("result", status.Result)
+		}
+		if err == nil {
+			err = result.Set("peers", status.Peers)
+		}
+		if err == nil {
+			err = result.Set("acks", status.Acks)
 		}
 
 		if err != nil {
 			return z.vm.MakeCustomError("HolochainError", err.Error())
 		}
 
-		return otto.UndefinedValue()
+		return result.Value()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("send", func(call otto.FunctionCall) otto.Value {
+		var args [3]string
+		for i := range args {
+			v := call.Argument(i)
+			if !v.IsString() {
+				return z.vm.MakeCustomError("HolochainError", "send expected string arguments")
+			}
+			args[i], _ = v.ToString()
+		}
+		response, err := sendFromNucleus(h, args[0], args[1], args[2])
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		result, _ := z.vm.ToValue(response)
+		return result
 	})
 	if err != nil {
 		return nil, err
@@ -360,6 +680,10 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	}
 
 	err = z.vm.Set("putmeta", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "putmeta"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
 		hashstr, _ := call.Argument(0).ToString()
 		metahashstr, _ := call.Argument(1).ToString()
 		typestr, _ := call.Argument(2).ToString()
@@ -387,12 +711,15 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	err = z.vm.Set("getmeta", func(call otto.FunctionCall) (result otto.Value) {
 		hashstr, _ := call.Argument(0).ToString()
 		typestr, _ := call.Argument(1).ToString()
+		// optional offset and limit for paging through the results
+		offset, _ := call.Argument(2).ToInteger()
+		limit, _ := call.Argument(3).ToInteger()
 
 		var key Hash
 		key, err = NewHash(hashstr)
 		var response interface{}
 		if err == nil {
-			response, err = h.dht.SendGetMeta(MetaQuery{H: key, T: typestr})
+			response, err = h.dht.SendGetMeta(MetaQuery{H: key, T: typestr, Offset: int(offset), Limit: int(limit)})
 			if err == nil {
 				result, err = z.vm.ToValue(response)
 			}
@@ -407,6 +734,63 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	if err != nil {
 		return nil, err
 	}
+	err = z.vm.Set("link", func(call otto.FunctionCall) otto.Value {
+		if err := checkWritable(z.readOnly, "link"); err != nil {
+			z.callErr = err
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+		basestr, _ := call.Argument(0).ToString()
+		linkstr, _ := call.Argument(1).ToString()
+		tag, _ := call.Argument(2).ToString()
+
+		var base Hash
+		base, err = NewHash(basestr)
+		if err == nil {
+			var link Hash
+			link, err = NewHash(linkstr)
+			if err == nil {
+				err = h.LinkEntries(base, link, tag)
+			}
+		}
+
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+
+		return otto.UndefinedValue()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = z.vm.Set("getLinks", func(call otto.FunctionCall) (result otto.Value) {
+		basestr, _ := call.Argument(0).ToString()
+		tag, _ := call.Argument(1).ToString()
+
+		var base Hash
+		base, err = NewHash(basestr)
+		if err == nil {
+			var links []Hash
+			links, err = h.GetLinks(base, tag)
+			if err == nil {
+				hashes := make([]string, len(links))
+				for i, l := range links {
+					hashes[i] = l.String()
+				}
+				result, err = z.vm.ToValue(hashes)
+			}
+		}
+
+		if err != nil {
+			return z.vm.MakeCustomError("HolochainError", err.Error())
+		}
+
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	l := JSLibrary
 	if h != nil {
 		l += fmt.Sprintf(`var App = {DNAHash:"%s",Agent:{Hash:"%s",String:"%s"},Key:{Hash:"%s"}};`, h.dnaHash, h.agentHash, h.Agent().Name(), peer.IDB58Encode(h.id))
@@ -415,6 +799,7 @@ func NewJSNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	if err != nil {
 		return
 	}
+	z.pristine = z.vm.Copy()
 	n = &z
 	return
 }
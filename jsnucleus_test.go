@@ -1,7 +1,11 @@
 package holochain
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/robertkrimen/otto"
 	. "github.com/smartystreets/goconvey/convey"
@@ -78,6 +82,106 @@ func TestNewJSNucleus(t *testing.T) {
 			})
 
 		})
+		Convey("length", func() {
+			_, err = z.Run(`length()`)
+			So(err, ShouldBeNil)
+			i, _ := z.lastResult.ToInteger()
+			So(i, ShouldEqual, 2)
+		})
+		Convey("update and remove", func() {
+			hash, err := h.Commit("myData", "2")
+			So(err, ShouldBeNil)
+			_, err = z.Run(`update("myData","4","` + hash.String() + `")`)
+			So(err, ShouldBeNil)
+			newHash, _ := z.lastResult.ToString()
+			So(h.chain.Top().EntryLink.String(), ShouldEqual, newHash)
+			So(h.chain.Top().Change.Action, ShouldEqual, ModAction)
+			So(h.chain.Top().Change.Hash.String(), ShouldEqual, hash.String())
+
+			_, err = z.Run(`remove("` + newHash + `","gone")`)
+			So(err, ShouldBeNil)
+			So(h.chain.Top().Type, ShouldEqual, DelEntryType)
+			So(h.chain.Top().Change.Action, ShouldEqual, DelAction)
+			So(h.chain.Top().Change.Hash.String(), ShouldEqual, newHash)
+		})
+		Convey("commitAt", func() {
+			ts := h.chain.Top().Time.Unix() + 1
+			_, err = z.Run(fmt.Sprintf(`commitAt("myData","2",%d)`, ts))
+			So(err, ShouldBeNil)
+			hash, _ := z.lastResult.ToString()
+			So(h.chain.Top().EntryLink.String(), ShouldEqual, hash)
+			So(h.chain.Top().Time.Unix(), ShouldEqual, ts)
+			So(h.chain.Top().Backdated, ShouldBeTrue)
+
+			_, err = z.Run(`commitAt("myData","2","yesterday")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.String(), ShouldEqual, "HolochainError: commitAt expected timestamp in seconds as third argument")
+		})
+		Convey("chainTop and chainLength", func() {
+			top, _ := h.Top()
+			_, err = z.Run(`chainTop()`)
+			So(err, ShouldBeNil)
+			s, _ := z.lastResult.ToString()
+			So(s, ShouldEqual, top.String())
+			_, err = z.Run(`chainLength()`)
+			So(err, ShouldBeNil)
+			i, _ := z.lastResult.ToInteger()
+			So(i, ShouldEqual, 2)
+
+			_, err = h.Commit("myData", "3")
+			So(err, ShouldBeNil)
+			top, _ = h.Top()
+			_, err = z.Run(`chainTop()`)
+			So(err, ShouldBeNil)
+			s, _ = z.lastResult.ToString()
+			So(s, ShouldEqual, top.String())
+			_, err = z.Run(`chainLength()`)
+			So(err, ShouldBeNil)
+			i, _ = z.lastResult.ToInteger()
+			So(i, ShouldEqual, 3)
+		})
+		Convey("countType", func() {
+			_, err = z.Run(`countType("%agent")`)
+			So(err, ShouldBeNil)
+			i, _ := z.lastResult.ToInteger()
+			So(i, ShouldEqual, 1)
+			_, err = z.Run(`countType("myData")`)
+			So(err, ShouldBeNil)
+			i, _ = z.lastResult.ToInteger()
+			So(i, ShouldEqual, 0)
+		})
+		Convey("query", func() {
+			_, err = z.Run(`JSON.stringify(query("myData"))`)
+			So(err, ShouldBeNil)
+			s, _ := z.lastResult.ToString()
+			So(s, ShouldEqual, `[]`)
+			hash, err := h.Commit("myData", "2")
+			So(err, ShouldBeNil)
+			_, err = z.Run(`JSON.stringify(query("myData"))`)
+			So(err, ShouldBeNil)
+			s, _ = z.lastResult.ToString()
+			So(s, ShouldEqual, `[{"Hash":"`+hash.String()+`","Entry":"2"}]`)
+		})
+		Convey("keyHashFor", func() {
+			key, _ := ic.MarshalPublicKey(h.Agent().PubKey())
+			_, err = z.Run(`keyHashFor("` + base64.StdEncoding.EncodeToString(key) + `")`)
+			So(err, ShouldBeNil)
+			s, _ := z.lastResult.ToString()
+			So(s, ShouldEqual, peer.IDB58Encode(h.id))
+		})
+		Convey("callZome", func() {
+			_, err = z.Run(`callZome("myZome","exposedfn","x")`)
+			So(err, ShouldBeNil)
+			s, _ := z.lastResult.ToString()
+			So(s, ShouldEqual, "result: x")
+			_, err = z.Run(`callZome("jsZome","addProfile",{firstName:"Art"})`)
+			So(err, ShouldBeNil)
+			s, _ = z.lastResult.ToString()
+			So(s, ShouldEqual, `"`+h.chain.Top().EntryLink.String()+`"`)
+			_, err = z.Run(`callZome("myZome","bogus","x")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.String(), ShouldEqual, "HolochainError: couldn't find exposed function: bogus")
+		})
 	})
 }
 
@@ -145,6 +249,14 @@ func TestJSValidateEntry(t *testing.T) {
 		err = v.ValidateEntry(&d, &GobEntry{C: `{"data":"fish"}`}, &p)
 		So(err, ShouldBeNil)
 	})
+	Convey("should pass the validation props to the validator", t, func() {
+		v, err := NewJSNucleus(nil, `function validate(name,entry,meta) { return (meta.Role=="author" && meta.AgentID=="QmAgent" && meta.Timestamp > 1000)};`)
+		d := EntryDef{Name: "myData", DataFormat: DataFormatString}
+		err = v.ValidateEntry(&d, &GobEntry{C: "fish"}, &ValidationProps{Role: ValidatingAsDHTNode, AgentID: "QmAgent", Timestamp: 2000})
+		So(err.Error(), ShouldEqual, "Invalid entry: fish")
+		err = v.ValidateEntry(&d, &GobEntry{C: "fish"}, &ValidationProps{Role: ValidatingAsAuthor, AgentID: "QmAgent", Timestamp: 2000})
+		So(err, ShouldBeNil)
+	})
 }
 
 func TestJSSanitize(t *testing.T) {
@@ -204,6 +316,49 @@ function emptyParametersJson(x){ return [{a:'b'}] };
 		So(err, ShouldBeNil)
 		So(result, ShouldEqual, "[{\"a\":\"b\"}]")
 	})
+	Convey("should reject malformed JSON before calling the function", t, func() {
+		_, err := z.Call("jtest", `{"input": `)
+		So(errors.Is(err, ErrInvalidJSONArgument), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "jtest")
+	})
+	Convey("should stop read-only functions from writing", t, func() {
+		v, err := NewJSNucleus(nil, `expose("peek",HC.STRING,{readonly:true});function peek(x) {return commit("myOdds",x)};expose("poke",HC.STRING);function poke(x) {return link(x,x,"tag")};`)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", v.Interfaces()), ShouldEqual, "[{peek 0 readonly} {poke 0}]")
+		_, err = v.Call("peek", "3")
+		So(errors.Is(err, ErrReadOnlyCall), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "commit: not allowed in a read-only function")
+		v.(*JSNucleus).setReadOnlyCaller(true)
+		_, err = v.Call("poke", "x")
+		So(errors.Is(err, ErrReadOnlyCall), ShouldBeTrue)
+	})
+}
+
+func TestJSCallWithContext(t *testing.T) {
+	v, err := NewJSNucleus(nil, `
+expose("loop",HC.STRING);
+function loop(x) {while(true){}};
+expose("cater",HC.STRING);
+function cater(x) {return "result: "+x};
+`)
+	if err != nil {
+		panic(err)
+	}
+	Convey("it should return the result of a call that finishes before the deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := callWithContext(ctx, v, "cater", "fish")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: fish")
+	})
+	Convey("it should abort a call that runs past the deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := callWithContext(ctx, v, "loop", "")
+		So(errors.Is(err, ErrCallAborted), ShouldBeTrue)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "call aborted: context deadline exceeded")
+	})
 }
 
 func TestJSDHT(t *testing.T) {
@@ -222,11 +377,10 @@ func TestJSDHT(t *testing.T) {
 
 	hash := hd.EntryLink
 	Convey("it should have a put function", t, func() {
-		v, err := NewJSNucleus(h, fmt.Sprintf(`put("%s");`, hash.String()))
+		v, err := NewJSNucleus(h, fmt.Sprintf(`var r = put("%s"); r.result+" "+r.acks+" "+r.peers.length+" "+r.peers[0];`, hash.String()))
 		So(err, ShouldBeNil)
 		z := v.(*JSNucleus)
-		So(err, ShouldBeNil)
-		So(z.lastResult.String(), ShouldEqual, otto.UndefinedValue().String())
+		So(z.lastResult.String(), ShouldEqual, "ok 1 1 "+peer.IDB58Encode(h.id))
 	})
 
 	Convey("it should have a get function", t, func() {
@@ -275,4 +429,50 @@ func TestJSDHT(t *testing.T) {
 		So(fmt.Sprintf("%v", mqr.Entries[0].E.Content()), ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
 	})
 
+	Convey("getmeta should take an offset and limit", t, func() {
+		v, err := NewJSNucleus(h, fmt.Sprintf(`getmeta("%s","myMetaTag",1,10);`, hash.String()))
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(len(x.(MetaQueryResp).Entries), ShouldEqual, 0)
+	})
+}
+
+func TestJSSend(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should have a send function", t, func() {
+		h.Zomes["jsZome"].CodeSource = `expose("receive",HC.STRING);function receive(x) {return "received: "+x}`
+		v, err := NewJSNucleus(h, fmt.Sprintf(`send("%s","jsZome","language");`, peer.IDB58Encode(h.id)))
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		So(z.lastResult.String(), ShouldEqual, "received: language")
+	})
+}
+
+func TestJSDebug(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("debug should log to the App logger", t, func() {
+		v, err := NewJSNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*JSNucleus)
+		ShouldLog(&h.config.Loggers.App, "hello\n", func() {
+			_, err = z.Run(`debug("hello")`)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("debug should prefix the log with the zome's name", t, func() {
+		n, err := h.makeNucleus(h.Zomes["jsZome"])
+		So(err, ShouldBeNil)
+		z := n.(*JSNucleus)
+		ShouldLog(&h.config.Loggers.App, "jsZome: hello\n", func() {
+			_, err = z.Run(`debug("hello")`)
+			So(err, ShouldBeNil)
+		})
+	})
 }
@@ -0,0 +1,161 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements an in-memory network for testing how the nodes of several holochains interact
+
+package holochain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	"io"
+	"sort"
+	"sync"
+)
+
+// MockNetwork is an in-memory network that connects the nodes made on it without any sockets,
+// so that several holochains running in one process can put, get and gossip with each other
+// in tests.  Every node on a MockNetwork is connected to every other one, and messages are
+// gob encoded and decoded on their way between nodes just as they are on the wire.
+type MockNetwork struct {
+	lk    sync.RWMutex
+	nodes map[peer.ID]*mockPeer
+}
+
+// mockPeer holds a node on a MockNetwork and the handlers of the protocols it has started
+type mockPeer struct {
+	node     *Node
+	handlers map[protocol.ID]mockHandler
+}
+
+// mockHandler handles the encoded message read from r that was sent by the node from,
+// returning the message to respond with
+type mockHandler func(from peer.ID, r io.Reader) *Message
+
+// NewMockNetwork creates a MockNetwork with no nodes on it
+func NewMockNetwork() *MockNetwork {
+	return &MockNetwork{nodes: make(map[peer.ID]*mockPeer)}
+}
+
+// NewNode creates a node with the given identity on the network, connecting it to the nodes
+// already on it
+func (mn *MockNetwork) NewNode(id peer.ID, priv ic.PrivKey) (node *Node, err error) {
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return
+	}
+	if pid != id {
+		err = errors.New("NewNode: Id doesn't match key")
+		return
+	}
+	n := Node{HashAddr: id, peers: newPeerNotifiee(), mock: mn}
+
+	mn.lk.Lock()
+	if _, exists := mn.nodes[id]; exists {
+		mn.lk.Unlock()
+		err = fmt.Errorf("mock network: node %v is already on the network", id)
+		return
+	}
+	others := mn.nodesExcept(id)
+	mn.nodes[id] = &mockPeer{node: &n, handlers: make(map[protocol.ID]mockHandler)}
+	mn.lk.Unlock()
+
+	for _, o := range others {
+		o.peers.notify(o.peers.onConnect, id)
+	}
+	node = &n
+	return
+}
+
+// nodesExcept returns the nodes on the network other than id.  The caller must hold the lock.
+func (mn *MockNetwork) nodesExcept(id peer.ID) (nodes []*Node) {
+	for pid, p := range mn.nodes {
+		if pid != id {
+			nodes = append(nodes, p.node)
+		}
+	}
+	return
+}
+
+// remove takes a node off the network, disconnecting it from the others
+func (mn *MockNetwork) remove(id peer.ID) {
+	mn.lk.Lock()
+	if _, exists := mn.nodes[id]; !exists {
+		mn.lk.Unlock()
+		return
+	}
+	delete(mn.nodes, id)
+	others := mn.nodesExcept(id)
+	mn.lk.Unlock()
+
+	for _, o := range others {
+		o.peers.notify(o.peers.onDisconnect, id)
+	}
+}
+
+// peers returns the ids of the nodes connected to id, in order
+func (mn *MockNetwork) peers(id peer.ID) (ids []peer.ID) {
+	mn.lk.RLock()
+	defer mn.lk.RUnlock()
+	if _, exists := mn.nodes[id]; !exists {
+		return
+	}
+	for pid := range mn.nodes {
+		if pid != id {
+			ids = append(ids, pid)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+// setHandler sets the handler of a protocol on a node of the network
+func (mn *MockNetwork) setHandler(id peer.ID, proto protocol.ID, handler mockHandler) {
+	mn.lk.Lock()
+	defer mn.lk.Unlock()
+	if p, exists := mn.nodes[id]; exists {
+		p.handlers[proto] = handler
+	}
+}
+
+// send delivers a message from one node of the network to the handler of a protocol on
+// another and returns the message it responds with
+func (mn *MockNetwork) send(from peer.ID, proto protocol.ID, to peer.ID, m *Message) (response Message, err error) {
+	mn.lk.RLock()
+	_, connected := mn.nodes[from]
+	p, exists := mn.nodes[to]
+	var handler mockHandler
+	if exists {
+		handler = p.handlers[proto]
+	}
+	mn.lk.RUnlock()
+
+	if !connected {
+		err = fmt.Errorf("mock network: node %v isn't on the network", from)
+		return
+	}
+	if !exists {
+		err = fmt.Errorf("mock network: no route to peer %v", to)
+		return
+	}
+	if handler == nil {
+		err = fmt.Errorf("mock network: peer %v doesn't handle protocol %s", to, proto)
+		return
+	}
+
+	data, err := m.Encode()
+	if err != nil {
+		return
+	}
+	r := handler(from, bytes.NewReader(data))
+	if data, err = r.Encode(); err != nil {
+		return
+	}
+	err = response.Decode(bytes.NewReader(data))
+	return
+}
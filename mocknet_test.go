@@ -0,0 +1,159 @@
+package holochain
+
+import (
+	"context"
+	"errors"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestMockNetwork(t *testing.T) {
+	mn := NewMockNetwork()
+	d1, h1 := prepareMockNetChain(mn, nil)
+	defer cleanupTestDir(d1)
+	defer h1.Close()
+	d2, h2 := prepareMockNetChain(mn, h1)
+	defer cleanupTestDir(d2)
+	defer h2.Close()
+
+	Convey("it should connect the nodes on it to each other", t, func() {
+		So(h1.node.Peers(), ShouldResemble, []peer.ID{h2.id})
+		So(h2.node.Peers(), ShouldResemble, []peer.ID{h1.id})
+		So(h1.Status().Peers, ShouldEqual, 1)
+		So(h1.Status().ListenAddr, ShouldEqual, "")
+		_, err := h1.Ping(h2.id)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should refuse messages whose source isn't the sending node", t, func() {
+		m := h2.node.NewMessage(PING_REQUEST, PingReq{})
+		m.From = h1.id
+		r, err := mn.send(h2.id, PingProtocol, h1.id, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body, ShouldStartWith, "message source")
+	})
+
+	var hash Hash
+	Convey("it should replicate puts to the other nodes", t, func() {
+		r, err := h1.Call("myZome", "addData", "2")
+		So(err, ShouldBeNil)
+		hash, err = NewHash(r.(string))
+		So(err, ShouldBeNil)
+		h2.dht.waitPuts()
+		entry, entryType, _, err := h2.dht.Get(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "myData")
+		So(entry.Content(), ShouldEqual, "2")
+	})
+
+	Convey("it should get entries from the other nodes", t, func() {
+		r, err := h2.Send(DHTProtocol, h1.id, GET_REQUEST, GetReq{H: hash}, DHTReceiver)
+		So(err, ShouldBeNil)
+		So(r.(GobEntry).C, ShouldEqual, "2")
+		other, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		_, err = h2.Send(DHTProtocol, h1.id, GET_REQUEST, GetReq{H: other}, DHTReceiver)
+		So(errors.Is(err, ErrHashNotFound), ShouldBeTrue)
+	})
+
+	Convey("it should put meta data to all the nodes responsible for the base", t, func() {
+		r, err := h1.Call("myZome", "addData", "4")
+		So(err, ShouldBeNil)
+		link, err := NewHash(r.(string))
+		So(err, ShouldBeNil)
+		h1.dht.waitPuts()
+		h2.dht.waitPuts()
+		So(h1.dht.SendPutMeta(MetaReq{O: hash, M: link, T: "myTag"}), ShouldBeNil)
+		h1.dht.waitPuts()
+		h2.dht.waitPuts()
+		for _, h := range []*Holochain{h1, h2} {
+			entries, err := h.dht.getMeta(hash, "myTag")
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+		}
+	})
+
+	Convey("it should fall back to the next node for gets the nearest can't answer", t, func() {
+		d3, h3 := prepareMockNetChain(mn, h1)
+		defer cleanupTestDir(d3)
+		defer h3.Close()
+		// h3 hasn't got the entry, and whichever node is nearest the get finds it
+		_, _, _, err := h3.dht.Get(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+		r, err := h3.dht.SendGet(hash)
+		So(err, ShouldBeNil)
+		So(r.(GobEntry).C, ShouldEqual, "2")
+		r, err = h3.dht.SendGetMeta(MetaQuery{H: hash, T: "myTag"})
+		So(err, ShouldBeNil)
+		So(len(r.(MetaQueryResp).Entries), ShouldEqual, 1)
+	})
+
+	Convey("it should let nodes that join later gossip for what they missed", t, func() {
+		d3, h3 := prepareMockNetChain(mn, h1)
+		defer cleanupTestDir(d3)
+		defer h3.Close()
+		_, _, _, err := h3.dht.Get(hash)
+		So(err, ShouldEqual, ErrHashNotFound)
+		err = h3.dht.gossipWith(h1.id, 0)
+		So(err, ShouldBeNil)
+		h3.dht.waitPuts()
+		entry, _, _, err := h3.dht.Get(hash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "2")
+	})
+
+	Convey("it should disconnect nodes that are closed", t, func() {
+		d3, h3 := prepareMockNetChain(mn, h1)
+		defer cleanupTestDir(d3)
+		disconnected := make(chan peer.ID, 2)
+		h1.OnPeerDisconnect(func(id peer.ID) { disconnected <- id })
+		So(len(h1.node.Peers()), ShouldEqual, 2)
+		id := h3.id
+		So(h3.Close(), ShouldBeNil)
+		So(<-disconnected, ShouldEqual, id)
+		So(h1.node.Peers(), ShouldResemble, []peer.ID{h2.id})
+		_, err := h1.Send(PingProtocol, id, PING_REQUEST, PingReq{}, PingReceiver)
+		So(err.Error(), ShouldStartWith, "mock network: no route to peer")
+	})
+
+	Convey("it should let WaitForPeers wait for nodes to join", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var h3 *Holochain
+		var d3 string
+		done := make(chan struct{})
+		go func() {
+			d3, h3 = prepareMockNetChain(mn, h1)
+			close(done)
+		}()
+		err := h1.WaitForPeers(ctx, 2)
+		So(err, ShouldBeNil)
+		<-done
+		h3.Close()
+		cleanupTestDir(d3)
+	})
+
+	Convey("it should send revocations to the other nodes so they refuse the revoked key", t, func() {
+		d3, h3 := prepareMockNetChain(mn, h1)
+		defer cleanupTestDir(d3)
+		defer h3.Close()
+		r, err := h3.Call("myZome", "addData", "6")
+		So(err, ShouldBeNil)
+		hash, err := NewHash(r.(string))
+		So(err, ShouldBeNil)
+		h1.dht.waitPuts()
+		h3.dht.waitPuts()
+
+		So(h3.RevokeKey("compromised"), ShouldBeNil)
+		h1.dht.waitPuts()
+		So(h1.dht.isRevoked(h3.id), ShouldBeTrue)
+		_, entryType, _, err := h1.dht.get(h3.chain.Top().EntryLink)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, RevocationEntryType)
+
+		_, err = h3.Send(DHTProtocol, h1.id, PUT_REQUEST, PutReq{H: hash}, DHTReceiver)
+		So(errors.Is(err, ErrKeyRevoked), ShouldBeTrue)
+	})
+}
@@ -22,6 +22,9 @@ import (
 	rhost "github.com/libp2p/go-libp2p/p2p/host/routed"
 	ma "github.com/multiformats/go-multiaddr"
 	"io"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -47,6 +50,14 @@ const (
 	// Source Messages
 
 	SRC_VALIDATE
+
+	// App Messages
+
+	APP_MESSAGE
+
+	// Ping Messages
+
+	PING_REQUEST
 )
 
 // Message represents data that can be sent to node in the network
@@ -60,15 +71,147 @@ type Message struct {
 // Node represents a node in the network
 type Node struct {
 	HashAddr peer.ID
-	NetAddr  ma.Multiaddr
+	NetAddr  ma.Multiaddr   // the first of the addresses the node listens on
+	NetAddrs []ma.Multiaddr // all the addresses the node listens on, one per transport
 	Host     *rhost.RoutedHost
+	peers    *peerNotifiee
+	mock     *MockNetwork // if not nil the node is on this MockNetwork and has no Host
 }
 
+const (
+	TCPTransport       = "tcp"
+	WebsocketTransport = "ws"
+)
+
+// DefaultTransports are the transports a node listens on if none are configured
+var DefaultTransports = []string{TCPTransport}
+
+// TransportAddrFn returns the listen address of a transport for a port
+type TransportAddrFn func(port int) string
+
+var transportAddrs = map[string]TransportAddrFn{
+	TCPTransport:       func(port int) string { return fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port) },
+	WebsocketTransport: func(port int) string { return fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/ws", port) },
+}
+
+// RegisterTransport adds a transport that can be named in the config's Transports.  The
+// libp2p swarm must be able to listen on the addresses it returns, so transports the swarm
+// doesn't support out of the box can only be added by builds that provide them.  QUIC is
+// one of these: the swarm we build with has no QUIC transport, so none is registered.
+func RegisterTransport(name string, addr TransportAddrFn) {
+	if addr == nil {
+		panic("Transport address function for " + name + " does not exist.")
+	}
+	if _, registered := transportAddrs[name]; registered {
+		panic("Transport " + name + " already registered.")
+	}
+	transportAddrs[name] = addr
+}
+
+// TransportListenAddrs returns the listen addresses for the given transports.  Each
+// transport gets its own port counting up from port, in the order given.
+func TransportListenAddrs(transports []string, port int) (addrs []string, err error) {
+	if len(transports) == 0 {
+		transports = DefaultTransports
+	}
+	for i, name := range transports {
+		addr, ok := transportAddrs[name]
+		if !ok {
+			available := make([]string, 0, len(transportAddrs))
+			for k := range transportAddrs {
+				available = append(available, k)
+			}
+			sort.Strings(available)
+			err = fmt.Errorf("unknown transport: %s (available transports: %s)", name, strings.Join(available, ", "))
+			return
+		}
+		addrs = append(addrs, addr(port+i))
+	}
+	return
+}
+
+// PeerFn is the type of function called when a peer connects or disconnects
+type PeerFn func(id peer.ID)
+
+// peerNotifiee implements the libp2p net.Notifiee interface, calling registered PeerFns
+// when peers connect and disconnect
+type peerNotifiee struct {
+	lk           sync.Mutex
+	next         int
+	onConnect    map[int]PeerFn
+	onDisconnect map[int]PeerFn
+}
+
+func newPeerNotifiee() *peerNotifiee {
+	return &peerNotifiee{
+		onConnect:    make(map[int]PeerFn),
+		onDisconnect: make(map[int]PeerFn),
+	}
+}
+
+// add registers a callback returning a function that unregisters it
+func (p *peerNotifiee) add(fns map[int]PeerFn, fn PeerFn) (unsubscribe func()) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	id := p.next
+	p.next++
+	fns[id] = fn
+	unsubscribe = func() {
+		p.lk.Lock()
+		defer p.lk.Unlock()
+		delete(fns, id)
+	}
+	return
+}
+
+// notify calls all the callbacks in fns with the id
+func (p *peerNotifiee) notify(fns map[int]PeerFn, id peer.ID) {
+	p.lk.Lock()
+	callbacks := make([]PeerFn, 0, len(fns))
+	for _, fn := range fns {
+		callbacks = append(callbacks, fn)
+	}
+	p.lk.Unlock()
+	for _, fn := range callbacks {
+		fn(id)
+	}
+}
+
+func (p *peerNotifiee) Connected(n net.Network, c net.Conn) {
+	p.notify(p.onConnect, c.RemotePeer())
+}
+
+func (p *peerNotifiee) Disconnected(n net.Network, c net.Conn) {
+	p.notify(p.onDisconnect, c.RemotePeer())
+}
+
+func (p *peerNotifiee) Listen(n net.Network, a ma.Multiaddr)      {}
+func (p *peerNotifiee) ListenClose(n net.Network, a ma.Multiaddr) {}
+func (p *peerNotifiee) OpenedStream(n net.Network, s net.Stream)  {}
+func (p *peerNotifiee) ClosedStream(n net.Network, s net.Stream)  {}
+
 const (
 	DHTProtocol    = protocol.ID("/holochain-dht/0.0.0")
 	SourceProtocol = protocol.ID("/holochain-src/0.0.0")
+	AppProtocol    = protocol.ID("/holochain-app/0.0.0")
+	PingProtocol   = protocol.ID("/holochain-ping/0.0.0")
 )
 
+// DefaultSendTimeout is how long SendTo waits for a response
+const DefaultSendTimeout = 10 * time.Second
+
+// ReceiveFunctionName is the zome function that receives the messages sent with SendTo.
+// It's the only function other nodes can call.
+const ReceiveFunctionName = "receive"
+
+var ErrSendTimeout error = errors.New("timeout waiting for response")
+
+// AppMsg holds a message sent by an app to the receive function of a zome on another node
+type AppMsg struct {
+	ZomeType string
+	Args     interface{}
+}
+
 type HolochainRouter struct {
 	dummy int
 }
@@ -80,11 +223,25 @@ func (r *HolochainRouter) FindPeer(context.Context, peer.ID) (peer pstore.PeerIn
 
 // NewNode creates a new ipfs basichost node with given identity
 func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err error) {
-	var n Node
-	n.NetAddr, err = ma.NewMultiaddr(listenAddr)
-	if err != nil {
+	return NewNodeWithAddrs([]string{listenAddr}, id, priv)
+}
+
+// NewNodeWithAddrs creates a new ipfs basichost node with given identity listening on
+// several addresses, i.e. one for each of the transports returned by TransportListenAddrs
+func NewNodeWithAddrs(listenAddrs []string, id peer.ID, priv ic.PrivKey) (node *Node, err error) {
+	if len(listenAddrs) == 0 {
+		err = errors.New("NewNode: no listen addresses")
 		return
 	}
+	var n Node
+	for _, a := range listenAddrs {
+		var addr ma.Multiaddr
+		if addr, err = ma.NewMultiaddr(a); err != nil {
+			return
+		}
+		n.NetAddrs = append(n.NetAddrs, addr)
+	}
+	n.NetAddr = n.NetAddrs[0]
 
 	ps := pstore.NewPeerstore()
 	pid, err := peer.IDFromPrivateKey(priv)
@@ -104,7 +261,7 @@ func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err er
 	ctx := context.Background()
 
 	// create a new swarm to be used by the service host
-	netw, err := swarm.NewNetwork(ctx, []ma.Multiaddr{n.NetAddr}, pid, ps, nil)
+	netw, err := swarm.NewNetwork(ctx, n.NetAddrs, pid, ps, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +274,9 @@ func NewNode(listenAddr string, id peer.ID, priv ic.PrivKey) (node *Node, err er
 	hr := HolochainRouter{}
 	n.Host = rhost.Wrap(bh, &hr)
 
+	n.peers = newPeerNotifiee()
+	netw.Notify(n.peers)
+
 	node = &n
 	return
 }
@@ -139,14 +299,19 @@ func (m *Message) Decode(r io.Reader) (err error) {
 	return
 }
 
-// respondWith writes a message either error or otherwise, to the stream
-func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
-	var m *Message
+// responseMessage builds the message with which a node responds, either error or otherwise
+func (node *Node) responseMessage(err error, body interface{}) (m *Message) {
 	if err != nil {
 		m = node.NewMessage(ERROR_RESPONSE, err.Error())
 	} else {
 		m = node.NewMessage(OK_RESPONSE, body)
 	}
+	return
+}
+
+// respondWith writes a message either error or otherwise, to the stream
+func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
+	m := node.responseMessage(err, body)
 
 	data, err := m.Encode()
 	if err != nil {
@@ -160,26 +325,43 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 
 // StartProtocol initiates listening for a protocol on the node
 func (node *Node) StartProtocol(h *Holochain, proto protocol.ID, receiver ReceiverFn) (err error) {
+	if node.mock != nil {
+		node.mock.setHandler(node.HashAddr, proto, func(from peer.ID, r io.Reader) *Message {
+			var m Message
+			response, err := receive(h, &m, from, m.Decode(r), receiver)
+			return node.responseMessage(err, response)
+		})
+		return
+	}
 	node.Host.SetStreamHandler(proto, func(s net.Stream) {
 		var m Message
-		err := m.Decode(s)
-		var response interface{}
-		if m.From == "" {
-			// @todo other sanity checks on From?
-			err = errors.New("message must have a source")
-		} else {
-			if err == nil {
-				response, err = receiver(h, &m)
-			}
-		}
+		response, err := receive(h, &m, s.Conn().RemotePeer(), m.Decode(s), receiver)
 		node.respondWith(s, err, response)
 	})
 	return
 }
 
+// receive passes a message to a receiver if decoding it didn't fail and its source is the
+// peer that sent it, so that receivers can rely on m.From being authenticated
+func receive(h *Holochain, m *Message, sender peer.ID, decodeErr error, receiver ReceiverFn) (response interface{}, err error) {
+	if err = decodeErr; err != nil {
+		return
+	}
+	if m.From == "" {
+		err = errors.New("message must have a source")
+	} else if m.From != sender {
+		err = fmt.Errorf("message source %v isn't the sending peer %v", m.From, sender)
+	} else {
+		response, err = receiver(h, m)
+	}
+	return
+}
+
 type ValidateResponse struct {
-	Entry Entry
-	Type  string
+	Entry  Entry
+	Type   string
+	Header *Header
+	Agent  *AgentEntry // the author's AgentEntry in effect when the header was signed
 }
 
 // SrcReceiver handles messages on the Source protocol
@@ -195,6 +377,18 @@ func SrcReceiver(h *Holochain, m *Message) (response interface{}, err error) {
 			if err == ErrHashNotFound {
 				// if that fails get it from the entries
 				r.Entry, r.Type, err = h.chain.GetEntry(t)
+				if err == nil && h.isPrivate(r.Type) {
+					err = ErrEntryPrivate
+				}
+				if err == nil {
+					r.Header, err = h.chain.GetEntryHeader(t)
+				}
+				if err == nil {
+					var a AgentEntry
+					if a, err = h.chain.signingAgent(h.chain.Emap[t.String()]); err == nil {
+						r.Agent = &a
+					}
+				}
 				response = &r
 			}
 		default:
@@ -211,13 +405,153 @@ func (node *Node) StartSrc(h *Holochain) (err error) {
 	return node.StartProtocol(h, SourceProtocol, SrcReceiver)
 }
 
+// OnConnect registers a function to be called whenever a peer connects to the node
+// the returned function unregisters it
+func (node *Node) OnConnect(fn PeerFn) (unsubscribe func()) {
+	return node.peers.add(node.peers.onConnect, fn)
+}
+
+// OnDisconnect registers a function to be called whenever a peer disconnects from the node
+// the returned function unregisters it
+func (node *Node) OnDisconnect(fn PeerFn) (unsubscribe func()) {
+	return node.peers.add(node.peers.onDisconnect, fn)
+}
+
+// AppReceiver handles messages on the App protocol by calling the receive function of the
+// zome they are addressed to.  Other nodes mustn't be able to run any other exposed function,
+// i.e. ones that commit to our chain, so messages can't name the function they call.
+func AppReceiver(h *Holochain, m *Message) (response interface{}, err error) {
+	switch m.Type {
+	case APP_MESSAGE:
+		switch t := m.Body.(type) {
+		case AppMsg:
+			args, ok := t.Args.(string)
+			if !ok {
+				err = fmt.Errorf("app message arguments must be a string, not %T", t.Args)
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+			defer cancel()
+			response, err = h.CallWithContext(ctx, t.ZomeType, ReceiveFunctionName, args)
+		default:
+			err = errors.New("expected app message")
+		}
+	default:
+		err = fmt.Errorf("message type %d not in holochain-app protocol", int(m.Type))
+	}
+	return
+}
+
+// StartApp initiates listening for App protocol messages on the node
+func (node *Node) StartApp(h *Holochain) (err error) {
+	return node.StartProtocol(h, AppProtocol, AppReceiver)
+}
+
+// PingReq is the body of a ping message, which the receiver echoes straight back
+type PingReq struct {
+	Nonce int64
+}
+
+// PingReceiver handles messages on the Ping protocol by replying with the ping it received
+func PingReceiver(h *Holochain, m *Message) (response interface{}, err error) {
+	switch m.Type {
+	case PING_REQUEST:
+		switch t := m.Body.(type) {
+		case PingReq:
+			response = t
+		default:
+			err = errors.New("expected ping request")
+		}
+	default:
+		err = fmt.Errorf("message type %d not in holochain-ping protocol", int(m.Type))
+	}
+	return
+}
+
+// StartPing initiates listening for Ping protocol messages on the node
+func (node *Node) StartPing(h *Holochain) (err error) {
+	return node.StartProtocol(h, PingProtocol, PingReceiver)
+}
+
+// Ping sends a ping to another node and returns the round trip time.  An ErrSendTimeout
+// error is returned if there is no reply within DefaultSendTimeout.
+func (h *Holochain) Ping(to peer.ID) (rtt time.Duration, err error) {
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
+	req := PingReq{Nonce: time.Now().UnixNano()}
+	start := time.Now()
+	var r interface{}
+	if r, err = h.sendWithTimeout(PingProtocol, to, PING_REQUEST, req, PingReceiver); err != nil {
+		return
+	}
+	rtt = time.Since(start)
+	if p, ok := r.(PingReq); !ok || p.Nonce != req.Nonce {
+		err = errors.New("unexpected ping response")
+	}
+	return
+}
+
 // Close shuts down the node
 func (node *Node) Close() error {
+	if node.mock != nil {
+		node.mock.remove(node.HashAddr)
+		return nil
+	}
 	return node.Host.Close()
 }
 
+// Peers returns the ids of the peers the node is connected to
+func (node *Node) Peers() []peer.ID {
+	if node.mock != nil {
+		return node.mock.peers(node.HashAddr)
+	}
+	return node.Host.Network().Peers()
+}
+
 // Send builds a message and either delivers it locally or via node.Send
 func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
+	response, err = h.send(context.Background(), proto, to, t, body, receiver)
+	return
+}
+
+// sendWithTimeout does what Send does but gives up with ErrSendTimeout if there's no
+// response within DefaultSendTimeout.  The stream of a network exchange that is given up on
+// is closed so that nothing is left waiting for the response.
+func (h *Holochain) sendWithTimeout(proto protocol.ID, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSendTimeout)
+	defer cancel()
+	type result struct {
+		response interface{}
+		err      error
+	}
+	// buffered so that the sending goroutine can always finish once we've given up on it
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.response, r.err = h.send(ctx, proto, to, t, body, receiver)
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		response, err = r.response, r.err
+	case <-ctx.Done():
+		err = ErrSendTimeout
+	}
+	return
+}
+
+// send implements Send, abandoning a network exchange when ctx is done
+func (h *Holochain) send(ctx context.Context, proto protocol.ID, to peer.ID, t MsgType, body interface{}, receiver ReceiverFn) (response interface{}, err error) {
+	if h.sim != nil {
+		response, err = h.sim.send(h, to, t, body, receiver)
+		return
+	}
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
 	message := h.node.NewMessage(t, body)
 	if err != nil {
 		return
@@ -228,12 +562,12 @@ func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interfac
 		response, err = receiver(h, message)
 	} else {
 		var r Message
-		r, err = h.node.Send(proto, to, message)
+		r, err = h.node.send(ctx, proto, to, message)
 		if err != nil {
 			return
 		}
 		if r.Type == ERROR_RESPONSE {
-			err = fmt.Errorf("response error: %v", r.Body)
+			err = newResponseError(r.Body)
 		} else {
 			response = r.Body
 		}
@@ -241,13 +575,73 @@ func (h *Holochain) Send(proto protocol.ID, to peer.ID, t MsgType, body interfac
 	return
 }
 
+// SendTo sends args to the ReceiveFunctionName function of a zome on the node of another agent
+// and returns the function's response.  args must be a string.  An ErrSendTimeout error is
+// returned if there is no response within DefaultSendTimeout.
+func (h *Holochain) SendTo(to peer.ID, zomeType string, args interface{}) (response interface{}, err error) {
+	if h.node == nil {
+		err = mkErr("not activated")
+		return
+	}
+	if _, ok := args.(string); !ok {
+		err = fmt.Errorf("app message arguments must be a string, not %T", args)
+		return
+	}
+	response, err = h.sendWithTimeout(AppProtocol, to, APP_MESSAGE, AppMsg{ZomeType: zomeType, Args: args}, AppReceiver)
+	return
+}
+
+// sendFromNucleus is the implementation of the nucleus send function, which takes the
+// recipient's peer id as a string and returns the response as a string
+func sendFromNucleus(h *Holochain, to string, zomeType string, args string) (response string, err error) {
+	var id peer.ID
+	id, err = peer.IDB58Decode(to)
+	if err != nil {
+		return
+	}
+	var r interface{}
+	r, err = h.SendTo(id, zomeType, args)
+	if err != nil {
+		return
+	}
+	switch t := r.(type) {
+	case string:
+		response = t
+	case []byte:
+		response = string(t)
+	default:
+		response = fmt.Sprintf("%v", t)
+	}
+	return
+}
+
 // Send delivers a message to a node via the given protocol
 func (node *Node) Send(proto protocol.ID, addr peer.ID, m *Message) (response Message, err error) {
-	s, err := node.Host.NewStream(context.Background(), addr, proto)
+	response, err = node.send(context.Background(), proto, addr, m)
+	return
+}
+
+// send implements Send, closing the stream to give up on the exchange when ctx is done
+func (node *Node) send(ctx context.Context, proto protocol.ID, addr peer.ID, m *Message) (response Message, err error) {
+	if node.mock != nil {
+		response, err = node.mock.send(node.HashAddr, proto, addr, m)
+		return
+	}
+	s, err := node.Host.NewStream(ctx, addr, proto)
 	if err != nil {
 		return
 	}
 	defer s.Close()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblocks the write or the read of the response
+			s.Close()
+		case <-finished:
+		}
+	}()
 
 	// encode the message and send it
 	data, err := m.Encode()
@@ -265,8 +659,8 @@ func (node *Node) Send(proto protocol.ID, addr peer.ID, m *Message) (response Me
 
 	// decode the response
 	err = response.Decode(s)
-	if err != nil {
-		return
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
 	}
 	return
 }
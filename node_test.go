@@ -8,6 +8,7 @@ import (
 	net "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
 	. "github.com/smartystreets/goconvey/convey"
 	"io/ioutil"
 	"strings"
@@ -15,6 +16,30 @@ import (
 	"time"
 )
 
+func TestTransportListenAddrs(t *testing.T) {
+	Convey("it should default to tcp", t, func() {
+		addrs, err := TransportListenAddrs(nil, 1234)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", addrs), ShouldEqual, "[/ip4/127.0.0.1/tcp/1234]")
+	})
+	Convey("it should give each transport its own port", t, func() {
+		addrs, err := TransportListenAddrs([]string{"tcp", "ws"}, 1234)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", addrs), ShouldEqual, "[/ip4/127.0.0.1/tcp/1234 /ip4/127.0.0.1/tcp/1235/ws]")
+	})
+	Convey("it should reject transports that aren't registered", t, func() {
+		_, err := TransportListenAddrs([]string{"tcp", "bogus"}, 1234)
+		So(err.Error(), ShouldEqual, "unknown transport: bogus (available transports: tcp, ws)")
+	})
+	Convey("it should listen on all the addresses", t, func() {
+		node, err := makeNodeWithAddrs([]string{"/ip4/127.0.0.1/tcp/1240", "/ip4/127.0.0.1/tcp/1241"}, "")
+		So(err, ShouldBeNil)
+		defer node.Close()
+		So(len(node.NetAddrs), ShouldEqual, 2)
+		So(node.NetAddr.String(), ShouldEqual, "/ip4/127.0.0.1/tcp/1240")
+	})
+}
+
 func TestNewNode(t *testing.T) {
 
 	node, err := makeNode(1234, "")
@@ -62,6 +87,127 @@ func TestNewNode(t *testing.T) {
 	})
 }
 
+func TestPeerCallbacks(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	node2, err := makeNode(4321, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+
+	Convey("It should call the callbacks when peers connect and disconnect", t, func() {
+		connected := make(chan peer.ID, 1)
+		disconnected := make(chan peer.ID, 1)
+		unsubscribeConnect := node2.OnConnect(func(id peer.ID) { connected <- id })
+		defer unsubscribeConnect()
+		unsubscribeDisconnect := node2.OnDisconnect(func(id peer.ID) { disconnected <- id })
+		defer unsubscribeDisconnect()
+
+		err := node.Host.Connect(context.Background(), pstore.PeerInfo{ID: node2.HashAddr, Addrs: []ma.Multiaddr{node2.NetAddr}})
+		So(err, ShouldBeNil)
+		select {
+		case id := <-connected:
+			So(id, ShouldEqual, node.HashAddr)
+		case <-time.After(time.Second):
+			So("timeout waiting for connect", ShouldBeNil)
+		}
+
+		err = node.Host.Network().ClosePeer(node2.HashAddr)
+		So(err, ShouldBeNil)
+		select {
+		case id := <-disconnected:
+			So(id, ShouldEqual, node.HashAddr)
+		case <-time.After(time.Second):
+			So("timeout waiting for disconnect", ShouldBeNil)
+		}
+	})
+
+	Convey("It should not call callbacks after they are unsubscribed", t, func() {
+		p := newPeerNotifiee()
+		count := 0
+		unsubscribe := p.add(p.onConnect, func(id peer.ID) { count++ })
+		p.notify(p.onConnect, node.HashAddr)
+		So(count, ShouldEqual, 1)
+		unsubscribe()
+		p.notify(p.onConnect, node.HashAddr)
+		So(count, ShouldEqual, 1)
+	})
+}
+
+func TestSendTo(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should call the zome's receive function", t, func() {
+		_, err := h.SendTo(h.node.HashAddr, "myZome", "arg1")
+		So(err.Error(), ShouldEqual, "unknown function: "+ReceiveFunctionName+" (zome myZome exposes: addData, addPrime, exposedfn, getDNA)")
+	})
+
+	Convey("it should reject arguments that aren't strings", t, func() {
+		m := h.node.NewMessage(APP_MESSAGE, AppMsg{ZomeType: "myZome", Args: 1})
+		_, err := AppReceiver(h, m)
+		So(err.Error(), ShouldEqual, "app message arguments must be a string, not int")
+		_, err = h.SendTo(h.node.HashAddr, "myZome", 1)
+		So(err.Error(), ShouldEqual, "app message arguments must be a string, not int")
+		_, err = h.Call("myZome", "exposedfn", 1)
+		So(err.Error(), ShouldContainSubstring, "exposedfn: argument must be a string, not int")
+		_, err = h.Call("jsZome", "getProperty", 1)
+		So(err.Error(), ShouldContainSubstring, "getProperty: argument must be a string, not int")
+	})
+
+	Convey("it should call the receive function on the receiving node", t, func() {
+		h.Zomes["myZome"].CodeSource = `(expose "receive" STRING)(defn receive [x] (concat "received: " x))`
+		r, err := h.SendTo(h.node.HashAddr, "myZome", "arg1 arg2")
+		So(err, ShouldBeNil)
+		So(r.(string), ShouldEqual, "received: arg1 arg2")
+	})
+
+	Convey("the app receiver should reject other message types", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, "fish")
+		_, err := AppReceiver(h, m)
+		So(err.Error(), ShouldEqual, "message type 2 not in holochain-app protocol")
+	})
+}
+
+func TestPing(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	node2, err := makeNode(1236, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	if err = node2.StartPing(h); err != nil {
+		panic(err)
+	}
+	h.node.Host.Peerstore().AddAddr(node2.HashAddr, node2.NetAddr, pstore.PermanentAddrTTL)
+
+	Convey("it should measure the round trip time to another node", t, func() {
+		rtt, err := h.Ping(node2.HashAddr)
+		So(err, ShouldBeNil)
+		So(rtt, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("it should be able to ping itself", t, func() {
+		_, err := h.Ping(h.node.HashAddr)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("the ping receiver should reject other message types and bodies", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, "fish")
+		_, err := PingReceiver(h, m)
+		So(err.Error(), ShouldEqual, "message type 2 not in holochain-ping protocol")
+		m = h.node.NewMessage(PING_REQUEST, "fish")
+		_, err = PingReceiver(h, m)
+		So(err.Error(), ShouldEqual, "expected ping request")
+	})
+}
+
 func TestNewMessage(t *testing.T) {
 	node, err := makeNode(1234, "node1")
 	if err != nil {
@@ -193,6 +339,7 @@ func TestSrcReceiver(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(r.(*ValidateResponse).Type, ShouldEqual, "myData")
 		So(fmt.Sprintf("%v", r.(*ValidateResponse).Entry), ShouldEqual, fmt.Sprintf("%v", &entry))
+		So(r.(*ValidateResponse).Header.Time.Unix(), ShouldEqual, hd.Time.Unix())
 
 	})
 }
@@ -228,6 +375,10 @@ func TestFindPeer(t *testing.T) {
 
 func makeNode(port int, id string) (*Node, error) {
 	listenaddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)
+	return makeNodeWithAddrs([]string{listenaddr}, id)
+}
+
+func makeNodeWithAddrs(listenaddrs []string, id string) (*Node, error) {
 	// use a constant reader so the key will be the same each time for the test...
 	r := strings.NewReader(id + "1234567890123456789012345678901234567890")
 	key, _, err := ic.GenerateEd25519Key(r)
@@ -236,5 +387,5 @@ func makeNode(port int, id string) (*Node, error) {
 	}
 	pid, _ := peer.IDFromPrivateKey(key)
 
-	return NewNode(listenaddr, pid, key)
+	return NewNodeWithAddrs(listenaddrs, pid, key)
 }
@@ -7,13 +7,71 @@
 package holochain
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	//peer "gx/ipfs/QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr/go-libp2p-peer"
 	"sort"
 	"strings"
+	"time"
 )
 
+// DefaultCallTimeout is the deadline used when calling exposed functions from the test harness
+// and the web server so that runaway app code can't hold up a response forever (though
+// runaway Zygo code keeps running, see CallWithContext)
+const DefaultCallTimeout = 10 * time.Second
+
+// MaxCallDepth limits how deeply zome functions can call each other with callZome
+const MaxCallDepth = 8
+
+var ErrCallAborted error = errors.New("call aborted")
+var ErrCallDepthExceeded error = errors.New("maximum zome call depth exceeded")
+
+// checkJSONArgument returns ErrInvalidJSONArgument if the argument for a JSON exposed function
+// doesn't parse.  An empty argument is allowed and calls the function with no parameter.
+func checkJSONArgument(function string, arg string) (err error) {
+	if arg == "" {
+		return
+	}
+	var v interface{}
+	if e := json.Unmarshal([]byte(arg), &v); e != nil {
+		err = fmt.Errorf("%w for %s: %v", ErrInvalidJSONArgument, function, e)
+	}
+	return
+}
+
+// CanonicalJSON marshals a value to the canonical JSON that the nuclei use for JSON entries
+// and function results: plain RFC 8259 JSON with object keys sorted, numbers as written and
+// no HTML escaping, so that data has one representation whichever scripting engine made it
+func CanonicalJSON(v interface{}) (j []byte, err error) {
+	var b []byte
+	if b, err = json.Marshal(v); err != nil {
+		return
+	}
+	j, err = CanonicalizeJSON(b)
+	return
+}
+
+// CanonicalizeJSON re-encodes JSON text as canonical JSON
+func CanonicalizeJSON(b []byte) (j []byte, err error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err = d.Decode(&v); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(v); err != nil {
+		return
+	}
+	j = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return
+}
+
 type NucleusFactory func(h *Holochain, code string) (Nucleus, error)
 
 type InterfaceSchemaType int
@@ -31,23 +89,43 @@ const (
 
 // Interface holds the name and schema of an DNA exposed function
 type Interface struct {
-	Name   string
-	Schema InterfaceSchemaType
+	Name     string
+	Schema   InterfaceSchemaType
+	ReadOnly bool // the function can't commit, put or link, which its nucleus enforces
+}
+
+// String returns the interface as {name schema}, with a readonly flag if it's set
+func (i Interface) String() string {
+	if i.ReadOnly {
+		return fmt.Sprintf("{%s %d readonly}", i.Name, i.Schema)
+	}
+	return fmt.Sprintf("{%s %d}", i.Name, i.Schema)
 }
 
+// Roles in which an entry may be validated
+const (
+	ValidatingAsAuthor  = "author" // the agent is committing the entry to its own chain
+	ValidatingAsDHTNode = "dht"    // the node is validating an entry put to the DHT
+)
+
 // ValidationProps holds the properties passed to the application validation routine
 // This includes the Headers and Sources
 type ValidationProps struct {
-	Sources  []string // B58 encoded peer
-	Hash     string
-	MetaTag  string // if validating a putMeta this will have the meta type set
-	MetaHash string
+	Sources      []string // B58 encoded peer
+	Hash         string
+	MetaTag      string // if validating a putMeta this will have the meta type set
+	MetaHash     string
+	AgentID      string // B58 encoded peer ID of the committing agent
+	Neighborhood string // the hash whose DHT neighborhood will hold the entry
+	Role         string // ValidatingAsAuthor or ValidatingAsDHTNode
+	Timestamp    int64  // the entry header's time in unix seconds, 0 if unknown
 }
 
 // Nucleus type abstracts the functions of code execution environments
 type Nucleus interface {
 	Type() string
 	ValidateEntry(def *EntryDef, entry Entry, props *ValidationProps) error
+	ValidateLink(base Hash, link Hash, tag string, props *ValidationProps) error
 	ChainGenesis() error
 	ChainRequires() error
 	expose(iface Interface) error
@@ -55,8 +133,99 @@ type Nucleus interface {
 	Call(iface string, params interface{}) (interface{}, error)
 }
 
+// interrupter is implemented by nucleii that can abort code that is currently running
+type interrupter interface {
+	interrupt()
+}
+
+// callDepther is implemented by nucleii that track how deeply nested their calls to
+// other zomes are
+type callDepther interface {
+	setCallDepth(depth int)
+}
+
+// readOnlyCaller is implemented by nucleii that refuse to write while running a function
+// called from a read-only function in another zome
+type readOnlyCaller interface {
+	setReadOnlyCaller(readOnly bool)
+}
+
+// checkWritable returns ErrReadOnlyCall if the library function fn, which commits to the
+// chain or puts to the DHT, is used while a nucleus is running a read-only function
+func checkWritable(readOnly bool, fn string) (err error) {
+	if readOnly {
+		err = fmt.Errorf("%s: %w", fn, ErrReadOnlyCall)
+	}
+	return
+}
+
+// zomeNamer is implemented by nucleii that tag their log output with the name of the zome
+// they run
+type zomeNamer interface {
+	setZomeName(name string)
+}
+
+// logApp writes a message logged by a nucleus's code to the App logger, prefixed with the
+// name of the nucleus's zome if it has one so the lines of multi-zome DNAs can be told apart
+func logApp(h *Holochain, zome string, msg string) {
+	if zome != "" {
+		msg = zome + ": " + msg
+	}
+	h.config.Loggers.App.p(msg)
+}
+
+// callResultString returns the result of an exposed function call as a string, the way
+// it's handed back to code calling it from another zome
+func callResultString(result interface{}) string {
+	switch t := result.(type) {
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	}
+	return fmt.Sprintf("%v", result)
+}
+
 var nucleusFactories = make(map[string]NucleusFactory)
 
+// callArgument returns the argument of a call to an exposed function as the string the
+// nuclei pass to it, or an error if it isn't of a type that can be passed
+func callArgument(fn string, params interface{}) (arg string, err error) {
+	switch t := params.(type) {
+	case string:
+		arg = t
+	default:
+		err = fmt.Errorf("%s: argument must be a string, not %T", fn, params)
+	}
+	return
+}
+
+// callWithContext calls an exposed function on a nucleus, returning a CallAbortedError (which
+// wraps the context's error) if the context is done before the call returns.  If the nucleus
+// can be interrupted (which both JS and Zygo nuclei can) the running code is aborted.
+func callWithContext(ctx context.Context, n Nucleus, function string, arguments interface{}) (result interface{}, err error) {
+	type callResult struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		r, e := n.Call(function, arguments)
+		done <- callResult{result: r, err: e}
+	}()
+
+	select {
+	case r := <-done:
+		result, err = r.result, r.err
+	case <-ctx.Done():
+		if i, ok := n.(interrupter); ok {
+			i.interrupt()
+		}
+		err = &CallAbortedError{Err: ctx.Err()}
+	}
+	return
+}
+
 // InterfaceSchema returns a functions schema type
 func InterfaceSchema(n Nucleus, name string) (InterfaceSchemaType, error) {
 	i := n.Interfaces()
@@ -18,3 +18,20 @@ func TestCreateNucleus(t *testing.T) {
 		So(fmt.Sprintf("%v", z.lastResult), ShouldEqual, "&{2 <nil>}")
 	})
 }
+
+func TestCanonicalJSON(t *testing.T) {
+	Convey("it should sort keys and not escape html", t, func() {
+		j, err := CanonicalJSON(map[string]interface{}{"b": "<x>", "a": []int{1, 2}})
+		So(err, ShouldBeNil)
+		So(string(j), ShouldEqual, `{"a":[1,2],"b":"<x>"}`)
+	})
+	Convey("it should canonicalize JSON text and keep numbers as written", t, func() {
+		j, err := CanonicalizeJSON([]byte(`{ "z": 1.50, "y": {"d":12345678901234567890, "c":null} }`))
+		So(err, ShouldBeNil)
+		So(string(j), ShouldEqual, `{"y":{"c":null,"d":12345678901234567890},"z":1.50}`)
+	})
+	Convey("it should reject invalid JSON", t, func() {
+		_, err := CanonicalizeJSON([]byte(`{"a":`))
+		So(err, ShouldNotBeNil)
+	})
+}
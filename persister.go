@@ -2,16 +2,17 @@
 // Use of this source code is governed by GPLv3 found in the LICENSE file
 //----------------------------------------------------------------------------------------
 // Persister implements a persistence engine interface for storing data
-// additionally it implements a bolt use of that interface
+// additionally it implements a bolt and an in-memory use of that interface (and, in builds
+// with the sqlite tag, a sqlite one: see persister_sqlite.go)
 
 package holochain
 
 import (
-	"errors"
 	_ "errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 )
@@ -26,7 +27,10 @@ const (
 )
 
 const (
-	BoltPersisterName = "bolt"
+	BoltPersisterName   = "bolt"
+	MemoryPersisterName = "memory"
+
+	DefaultPersisterName = BoltPersisterName
 )
 
 type Persister interface {
@@ -159,7 +163,7 @@ func (bp *BoltPersister) GetEntry(hash Hash) (entry interface{}, err error) {
 		eb := tx.Bucket([]byte(EntryBucket))
 		v := eb.Get(hash.H)
 		if v == nil {
-			e = errors.New("hash not found")
+			e = ErrHashNotFound
 			return
 		}
 		var g GobEntry
@@ -213,13 +217,123 @@ func (bp *BoltPersister) DB() *bolt.DB {
 	return bp.db
 }
 
+// MemoryPersister implements the Persister interface with maps, so nothing is written to disk
+type MemoryPersister struct {
+	headers map[string][]byte
+	entries map[string][]byte
+	meta    map[string][]byte
+}
+
+// Name returns the data store name
+func (mp *MemoryPersister) Name() string {
+	return MemoryPersisterName
+}
+
+// Open creates the maps (if they don't already exist)
+func (mp *MemoryPersister) Open() (err error) {
+	if mp.meta == nil {
+		mp.headers = make(map[string][]byte)
+		mp.entries = make(map[string][]byte)
+		mp.meta = make(map[string][]byte)
+	}
+	return
+}
+
+// Close does nothing as the data only lives as long as the persister
+func (mp *MemoryPersister) Close() {
+}
+
+// Init opens the store
+func (mp *MemoryPersister) Init() (err error) {
+	err = mp.Open()
+	return
+}
+
+// Put stores an entry and its header
+// N.B. this function does not confirm that the hashes match the values.  That must be done
+// external to the persister!
+func (mp *MemoryPersister) Put(entryType string, headerHash Hash, header []byte, entryHash Hash, entry []byte) (err error) {
+	mp.entries[string(entryHash.H)] = entry
+	mp.headers[string(headerHash.H)] = header
+	mp.meta[TopMetaKey] = headerHash.H
+	mp.meta[TopMetaKey+"_"+entryType] = headerHash.H
+	return
+}
+
+// Get returns a header, and (optionally) it's entry if getEntry is true
+func (mp *MemoryPersister) Get(hash Hash, getEntry bool) (header Header, entry interface{}, err error) {
+	v, ok := mp.headers[string(hash.H)]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	err = header.Unmarshal(v, 34)
+	if err != nil {
+		return
+	}
+	if getEntry {
+		entry, err = mp.GetEntry(header.EntryLink)
+	}
+	return
+}
+
+func (mp *MemoryPersister) GetEntry(hash Hash) (entry interface{}, err error) {
+	v, ok := mp.entries[string(hash.H)]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	var g GobEntry
+	if err = g.Unmarshal(v); err != nil {
+		return
+	}
+	entry = g.C
+	return
+}
+
+// GetMeta returns meta data
+func (mp *MemoryPersister) GetMeta(key string) (data []byte, err error) {
+	data = mp.meta[key]
+	return
+}
+
+// PutMeta sets meta data
+func (mp *MemoryPersister) PutMeta(key string, value []byte) (err error) {
+	mp.meta[key] = value
+	return
+}
+
+// Remove deletes all data in the datastore
+func (mp *MemoryPersister) Remove() (err error) {
+	mp.headers = nil
+	mp.entries = nil
+	mp.meta = nil
+	return nil
+}
+
+// NewMemoryPersister returns an in-memory implementation of the Persister type
+// the config string is ignored
+func NewMemoryPersister(config string) (p Persister, err error) {
+	var mp MemoryPersister
+	p = &mp
+	return
+}
+
 type PersisterFactory func(config string) (Persister, error)
 
 var persistorFactories = make(map[string]PersisterFactory)
 
+// taggedPersisters holds the built in persisters whose files are only compiled with a build
+// tag (i.e. sqlite), each file adding its persister in an init function
+var taggedPersisters = make(map[string]PersisterFactory)
+
 // RegisterBultinPersisters adds the built in persister types to the factory hash
 func RegisterBultinPersisters() {
 	RegisterPersister(BoltPersisterName, NewBoltPersister)
+	RegisterPersister(MemoryPersisterName, NewMemoryPersister)
+	for name, factory := range taggedPersisters {
+		RegisterPersister(name, factory)
+	}
 }
 
 // RegisterPersister sets up a Persister to be used by the CreatePersister function
@@ -245,6 +359,7 @@ func CreatePersister(ptype string, config string) (Persister, error) {
 		for k := range persistorFactories {
 			available = append(available, k)
 		}
+		sort.Strings(available)
 		return nil, fmt.Errorf("Invalid persister name. Must be one of: %s", strings.Join(available, ", "))
 	}
 
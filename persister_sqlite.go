@@ -0,0 +1,189 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+// SQLitePersister implements the Persister interface with a sqlite database.  It's only built
+// with the sqlite tag (i.e. go build -tags sqlite) because github.com/mattn/go-sqlite3 uses
+// cgo, so it needs a C compiler, and isn't one of the gx managed dependencies.
+
+//go:build sqlite
+// +build sqlite
+
+package holochain
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"os"
+)
+
+const SQLitePersisterName = "sqlite"
+
+func init() {
+	taggedPersisters[SQLitePersisterName] = NewSQLitePersister
+}
+
+// SQLitePersister implements the Persister interface with a sqlite database.  Headers, entries
+// and meta data are stored in their own tables, keyed by the base58 string of their hashes, so
+// that the chain data can be inspected with ordinary SQL, i.e. `select * from headers where type = "myData"`
+type SQLitePersister struct {
+	path string
+	db   *sql.DB
+}
+
+const sqliteSchema = `
+create table if not exists headers (hash text primary key, type text not null, entry text not null, header blob not null);
+create table if not exists entries (hash text primary key, type text not null, entry blob not null);
+create table if not exists meta (key text primary key, value blob);
+create index if not exists headers_type on headers (type);
+`
+
+// Name returns the data store name
+func (sp *SQLitePersister) Name() string {
+	return SQLitePersisterName
+}
+
+// Open opens the data store
+func (sp *SQLitePersister) Open() (err error) {
+	sp.db, err = sql.Open("sqlite3", sp.path)
+	if err != nil {
+		return
+	}
+	err = sp.db.Ping()
+	if err != nil {
+		sp.db.Close()
+		sp.db = nil
+	}
+	return
+}
+
+// Close closes the data store
+func (sp *SQLitePersister) Close() {
+	sp.db.Close()
+	sp.db = nil
+}
+
+// Init opens the store (if it isn't already open) and creates the tables
+func (sp *SQLitePersister) Init() (err error) {
+	if sp.db == nil {
+		err = sp.Open()
+	}
+	if err != nil {
+		return
+	}
+	_, err = sp.db.Exec(sqliteSchema)
+	if err != nil {
+		sp.db.Close()
+		sp.db = nil
+	}
+	return
+}
+
+// Put stores an entry and its header
+// N.B. this function does not confirm that the hashes match the values.  That must be done
+// external to the persister!
+func (sp *SQLitePersister) Put(entryType string, headerHash Hash, header []byte, entryHash Hash, entry []byte) (err error) {
+	tx, err := sp.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec("insert or replace into entries (hash, type, entry) values (?, ?, ?)", entryHash.String(), entryType, entry)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("insert or replace into headers (hash, type, entry, header) values (?, ?, ?, ?)", headerHash.String(), entryType, entryHash.String(), header)
+	if err != nil {
+		return
+	}
+
+	// don't use PutMeta because this has to be in the transaction
+	_, err = tx.Exec("insert or replace into meta (key, value) values (?, ?)", TopMetaKey, headerHash.H)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("insert or replace into meta (key, value) values (?, ?)", TopMetaKey+"_"+entryType, headerHash.H)
+	return
+}
+
+// Get returns a header, and (optionally) it's entry if getEntry is true
+func (sp *SQLitePersister) Get(hash Hash, getEntry bool) (header Header, entry interface{}, err error) {
+	var v []byte
+	err = sp.db.QueryRow("select header from headers where hash = ?", hash.String()).Scan(&v)
+	if err == sql.ErrNoRows {
+		err = ErrHashNotFound
+	}
+	if err != nil {
+		return
+	}
+	err = header.Unmarshal(v, 34)
+	if err != nil {
+		return
+	}
+	if getEntry {
+		entry, err = sp.GetEntry(header.EntryLink)
+	}
+	return
+}
+
+func (sp *SQLitePersister) GetEntry(hash Hash) (entry interface{}, err error) {
+	var v []byte
+	err = sp.db.QueryRow("select entry from entries where hash = ?", hash.String()).Scan(&v)
+	if err == sql.ErrNoRows {
+		err = ErrHashNotFound
+	}
+	if err != nil {
+		return
+	}
+	var g GobEntry
+	if err = g.Unmarshal(v); err != nil {
+		return
+	}
+	entry = g.C
+	return
+}
+
+// GetMeta returns meta data
+func (sp *SQLitePersister) GetMeta(key string) (data []byte, err error) {
+	err = sp.db.QueryRow("select value from meta where key = ?", key).Scan(&data)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// PutMeta sets meta data
+func (sp *SQLitePersister) PutMeta(key string, value []byte) (err error) {
+	_, err = sp.db.Exec("insert or replace into meta (key, value) values (?, ?)", key, value)
+	return
+}
+
+// Remove deletes all data in the datastore
+func (sp *SQLitePersister) Remove() (err error) {
+	if sp.db != nil {
+		sp.db.Close()
+	}
+	os.Remove(sp.path)
+	sp.db = nil
+	return nil
+}
+
+// NewSQLitePersister returns a SQLite implementation of the Persister type
+// always return no error because in this case any errors would happen at Init or Open time
+func NewSQLitePersister(path string) (p Persister, err error) {
+	var sp SQLitePersister
+	sp.path = path
+	p = &sp
+	return
+}
+
+// DB returns the sql db to give clients direct accesses to the sqlite store
+func (sp *SQLitePersister) DB() *sql.DB {
+	return sp.db
+}
@@ -0,0 +1,87 @@
+//go:build sqlite
+// +build sqlite
+
+package holochain
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSQLitePutGet(t *testing.T) {
+	p := "/tmp/sqlitedb"
+	v, err := CreatePersister(SQLitePersisterName, p)
+	if err != nil {
+		panic(err)
+	}
+	sp := v.(*SQLitePersister)
+	err = sp.Init()
+	if err != nil {
+		panic(err)
+	}
+	defer sp.Remove()
+
+	Convey("it should put & get entries", t, func() {
+		hhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		header := []byte("bogus header")
+		ehash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		entry := GobEntry{C: "bogus entry data"}
+		m, err := entry.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		err = sp.Put("myData", hhash, header, ehash, m)
+		So(err, ShouldBeNil)
+
+		data, err := sp.GetMeta(TopMetaKey + "_myData")
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", data), ShouldEqual, fmt.Sprintf("%v", hhash.H))
+
+		gentry, err := sp.GetEntry(ehash)
+		So(err, ShouldBeNil)
+		So(gentry.(string), ShouldEqual, "bogus entry data")
+
+		badhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+		gentry, err = sp.GetEntry(badhash)
+		So(err, ShouldEqual, ErrHashNotFound)
+		So(gentry, ShouldBeNil)
+
+		_, _, err = sp.Get(badhash, false)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should store headers in a queryable table", t, func() {
+		var hash, entry string
+		err := sp.DB().QueryRow("select hash, entry from headers where type = ?", "myData").Scan(&hash, &entry)
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		So(entry, ShouldEqual, "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	})
+
+	Convey("it should put & get meta data", t, func() {
+		err = sp.PutMeta("fish", []byte("cow"))
+		So(err, ShouldBeNil)
+		data, err := sp.GetMeta("fish")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "cow")
+		data, err = sp.GetMeta("bogus")
+		So(err, ShouldBeNil)
+		So(data, ShouldBeNil)
+	})
+}
+
+func TestNewSQLitePersister(t *testing.T) {
+	d, _, h := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should create a sqlite persister when the config selects it", t, func() {
+		h.config.Persister = SQLitePersisterName
+		p, err := h.NewPersister()
+		So(err, ShouldBeNil)
+		So(p.Name(), ShouldEqual, SQLitePersisterName)
+		So(p.Init(), ShouldBeNil)
+		defer p.Remove()
+		So(fileExists(h.DataPath()+"/"+StoreFileName+".db"), ShouldBeTrue)
+	})
+}
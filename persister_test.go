@@ -10,7 +10,8 @@ import (
 func TestCreatePersister(t *testing.T) {
 	Convey("should fail to create a persister based from bad type", t, func() {
 		_, err := CreatePersister("non-existent-type", "/some/path")
-		So(err.Error(), ShouldEqual, "Invalid persister name. Must be one of: bolt")
+		// sqlite is only listed in builds with the sqlite tag
+		So(err.Error(), ShouldStartWith, "Invalid persister name. Must be one of: bolt, memory")
 	})
 	Convey("should create a persister based from a good schema type", t, func() {
 		p := "/tmp/boltdb"
@@ -137,3 +138,55 @@ func TestBoltPutGet(t *testing.T) {
 		So(string(data), ShouldEqual, "cow")
 	})
 }
+
+func TestMemoryPutGet(t *testing.T) {
+	v, err := CreatePersister(MemoryPersisterName, "")
+	if err != nil {
+		panic(err)
+	}
+	mp := v.(*MemoryPersister)
+	err = mp.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should put & get entries", t, func() {
+		hhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		header := []byte("bogus header")
+		ehash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		entry := GobEntry{C: "bogus entry data"}
+		m, err := entry.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		err = mp.Put("myData", hhash, header, ehash, m)
+		So(err, ShouldBeNil)
+
+		data, err := mp.GetMeta(TopMetaKey + "_myData")
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", data), ShouldEqual, fmt.Sprintf("%v", hhash.H))
+
+		gentry, err := mp.GetEntry(ehash)
+		So(err, ShouldBeNil)
+		So(gentry.(string), ShouldEqual, "bogus entry data")
+
+		badhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+		gentry, err = mp.GetEntry(badhash)
+		So(err.Error(), ShouldEqual, "hash not found")
+		So(gentry, ShouldBeNil)
+
+		_, gentry, err = mp.Get(badhash, true)
+		So(err, ShouldEqual, ErrHashNotFound)
+		So(gentry, ShouldBeNil)
+	})
+
+	Convey("it should lose everything on Remove", t, func() {
+		err = mp.PutMeta("fish", []byte("cow"))
+		So(err, ShouldBeNil)
+		mp.Remove()
+		mp.Init()
+		data, err := mp.GetMeta("fish")
+		So(err, ShouldBeNil)
+		So(data, ShouldBeNil)
+	})
+}
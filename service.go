@@ -9,6 +9,8 @@ import (
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 )
 
 // System settings, directory, and file names
@@ -33,7 +35,8 @@ const (
 type ServiceConfig struct {
 	DefaultPeerModeAuthor  bool
 	DefaultPeerModeDHTNode bool
-	DefaultBootstrapServer string
+	DefaultBootstrapServer string // comma separated list of bootstrap servers
+	DefaultPersister       string // persister for the local store of new chains, empty means DefaultPersisterName
 }
 
 // Holochain service data structure
@@ -41,6 +44,9 @@ type Service struct {
 	Settings     ServiceConfig
 	DefaultAgent Agent
 	Path         string
+
+	lk     sync.Mutex
+	loaded map[string][]*Holochain // holochains loaded by the service, by name
 }
 
 // IsInitialized checks a path for a correctly set up .holochain directory
@@ -51,7 +57,12 @@ func IsInitialized(root string) bool {
 // Init initializes service defaults including a signing key pair for an agent
 // and writes them out to configuration files in the root path (making the
 // directory if necessary)
+// It is an error to Init a root that has already been initialized
 func Init(root string, agent AgentName) (service *Service, err error) {
+	if IsInitialized(root) {
+		err = mkErr(root + " already initialized")
+		return
+	}
 	err = os.MkdirAll(root, os.ModePerm)
 	if err != nil {
 		return
@@ -61,6 +72,7 @@ func Init(root string, agent AgentName) (service *Service, err error) {
 			DefaultPeerModeDHTNode: true,
 			DefaultPeerModeAuthor:  true,
 			DefaultBootstrapServer: DefaultBootstrapServer,
+			DefaultPersister:       DefaultPersisterName,
 		},
 		Path: root,
 	}
@@ -71,15 +83,16 @@ func Init(root string, agent AgentName) (service *Service, err error) {
 
 	Infof("Configured to connect to bootstrap server at: %s\n", s.Settings.DefaultBootstrapServer)
 
-	err = writeToml(root, SysFileName, s.Settings, false)
+	a, err := NewAgent(IPFS, agent)
 	if err != nil {
 		return
 	}
 
-	a, err := NewAgent(IPFS, agent)
+	err = writeToml(root, SysFileName, s.Settings, false)
 	if err != nil {
 		return
 	}
+
 	err = SaveAgent(root, a)
 	if err != nil {
 		return
@@ -128,3 +141,43 @@ func (s *Service) ConfiguredChains() (chains map[string]*Holochain, err error) {
 	}
 	return
 }
+
+// track records a holochain loaded by the service so that Remove can close it
+func (s *Service) track(name string, h *Holochain) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.loaded == nil {
+		s.loaded = make(map[string][]*Holochain)
+	}
+	s.loaded[name] = append(s.loaded[name], h)
+}
+
+// Remove closes the stores of any holochains the service has loaded with the given name
+// and deletes that holochain's directory.  It refuses to remove names that aren't configured
+// holochains in the service's directory, and holochains that are currently activated.
+func (s *Service) Remove(name string) (err error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		err = mkErr("invalid holochain name: " + name)
+		return
+	}
+	if _, err = s.IsConfigured(name); err != nil {
+		return
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, h := range s.loaded[name] {
+		if h.node != nil {
+			err = mkErr(name + " is active")
+			return
+		}
+	}
+	for _, h := range s.loaded[name] {
+		if err = h.Close(); err != nil {
+			return
+		}
+	}
+	delete(s.loaded, name)
+	err = os.RemoveAll(s.Path + "/" + name)
+	return
+}
@@ -41,6 +41,14 @@ func TestInit(t *testing.T) {
 			So(err, ShouldEqual, nil)
 			So(string(a), ShouldEqual, agent)
 		})
+
+		Convey("it should refuse to initialize it again", func() {
+			_, err := Init(p, AgentName("Barney Rubble"))
+			So(err.Error(), ShouldEqual, "holochain: "+p+" already initialized")
+			a, err := readFile(p, AgentFileName)
+			So(err, ShouldEqual, nil)
+			So(string(a), ShouldEqual, agent)
+		})
 	})
 }
 
@@ -54,6 +62,7 @@ func TestLoadService(t *testing.T) {
 		So(s.Path, ShouldEqual, root)
 		So(s.Settings.DefaultPeerModeDHTNode, ShouldEqual, true)
 		So(s.Settings.DefaultPeerModeAuthor, ShouldEqual, true)
+		So(s.Settings.DefaultPersister, ShouldEqual, BoltPersisterName)
 		So(s.DefaultAgent.Name(), ShouldEqual, AgentName("Herbert <h@bert.com>"))
 	})
 
@@ -71,3 +80,35 @@ func TestConfiguredChains(t *testing.T) {
 		So(chains["test"].Id, ShouldEqual, h.Id)
 	})
 }
+
+func TestRemove(t *testing.T) {
+	d, s, _ := setupTestChain("test")
+	defer cleanupTestDir(d)
+
+	h, err := s.Load("test")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should refuse names that aren't configured holochains", t, func() {
+		err := s.Remove("../" + DefaultDirectoryName)
+		So(err.Error(), ShouldEqual, "holochain: invalid holochain name: ../"+DefaultDirectoryName)
+		err = s.Remove("bogus")
+		So(err.Error(), ShouldEqual, "DNA not found")
+	})
+
+	Convey("it should refuse to remove an active holochain", t, func() {
+		h.node = &Node{}
+		err := s.Remove("test")
+		So(err.Error(), ShouldEqual, "holochain: test is active")
+		So(dirExists(s.Path+"/test"), ShouldBeTrue)
+		h.node = nil
+	})
+
+	Convey("it should close and delete the holochain", t, func() {
+		err := s.Remove("test")
+		So(err, ShouldBeNil)
+		So(dirExists(s.Path+"/test"), ShouldBeFalse)
+		So(dirExists(s.Path), ShouldBeTrue)
+	})
+}
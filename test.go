@@ -45,6 +45,11 @@ func setupTestChain(n string) (d string, s *Service, h *Holochain) {
 
 func prepareTestChain(n string) (d string, s *Service, h *Holochain) {
 	d, s, h = setupTestChain("test")
+	// use an in-memory chain so the tests don't pay for writing the chain file
+	if err := h.chain.Close(); err != nil {
+		panic(err)
+	}
+	h.chain = NewChainInMemory()
 	_, err := h.GenChain()
 	if err != nil {
 		panic(err)
@@ -56,6 +61,37 @@ func prepareTestChain(n string) (d string, s *Service, h *Holochain) {
 	return
 }
 
+// prepareMockNetChain starts a holochain with an in-memory chain on a MockNetwork, handling
+// its put requests in the background.  The holochain has a new agent and, if src is given,
+// src's DNA, otherwise the DNA generated by GenDev.
+func prepareMockNetChain(mn *MockNetwork, src *Holochain) (d string, h *Holochain) {
+	var err error
+	if src == nil {
+		d, _, h = setupTestChain("test")
+	} else {
+		var s *Service
+		d, s = setupTestService()
+		if _, err = s.Clone(src.path, s.Path+"/test", false); err != nil {
+			panic(err)
+		}
+		if h, err = s.Load("test"); err != nil {
+			panic(err)
+		}
+	}
+	if err = h.chain.Close(); err != nil {
+		panic(err)
+	}
+	h.chain = NewChainInMemory()
+	if _, err = h.GenChain(); err != nil {
+		panic(err)
+	}
+	if err = h.ActivateOnMockNetwork(mn); err != nil {
+		panic(err)
+	}
+	go h.dht.HandlePutReqs()
+	return
+}
+
 func setupTestDir() string {
 	d := mkTestDirName()
 	err := os.MkdirAll(d, os.ModePerm)
@@ -6,7 +6,15 @@
 package holochain
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
@@ -16,6 +24,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func writeToml(path string, file string, data interface{}, overwrite bool) error {
@@ -67,6 +77,65 @@ func mkErr(err string) error {
 	return errors.New("holochain: " + err)
 }
 
+// StoreKey derives the key used to encrypt the chain and DHT stores at rest from the
+// agent's private key
+func StoreKey(agent Agent) (key []byte, err error) {
+	var b []byte
+	if b, err = agent.PrivKey().Bytes(); err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, b)
+	mac.Write([]byte("holochain store key"))
+	key = mac.Sum(nil)
+	return
+}
+
+// storeCipher seals and opens the records of an encrypted store with AES-GCM, so that
+// data encrypted with a different key, or tampered with, fails to open.  Records can be
+// bound to where they are stored with additional data, e.g. their key, so that a sealed
+// value moved to another key fails to open too.
+type storeCipher struct {
+	aead cipher.AEAD
+}
+
+func newStoreCipher(key []byte) (sc *storeCipher, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return
+	}
+	sc = &storeCipher{aead: aead}
+	return
+}
+
+// seal encrypts data authenticating the additional data ad with it, returning it prefixed
+// with the random nonce used
+func (sc *storeCipher) seal(data []byte, ad []byte) (sealed []byte, err error) {
+	nonce := make([]byte, sc.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	sealed = sc.aead.Seal(nonce, nonce, data, ad)
+	return
+}
+
+// open decrypts data sealed by seal, returning ErrStoreKey if it wasn't sealed with this key
+// and additional data
+func (sc *storeCipher) open(sealed []byte, ad []byte) (data []byte, err error) {
+	n := sc.aead.NonceSize()
+	if len(sealed) < n {
+		err = ErrStoreKey
+		return
+	}
+	if data, err = sc.aead.Open(nil, sealed[:n], sealed[n:], ad); err != nil {
+		err = ErrStoreKey
+	}
+	return
+}
+
 func dirExists(name string) bool {
 	info, err := os.Stat(name)
 	return err == nil && info.Mode().IsDir()
@@ -154,6 +223,115 @@ func CopyFile(source string, dest string) (err error) {
 	return
 }
 
+// extractArchive unpacks a zip, tar or gzipped tar archive into the directory dest.  Only
+// directories and regular files are extracted, and entries whose paths would land outside
+// of dest are refused.
+func extractArchive(data []byte, dest string) (err error) {
+	// entry paths are cleaned by filepath.Join, so dest must be too for the prefix check
+	dest = filepath.Clean(dest)
+	x := archiveExtraction{dest: dest, bytes: MaxDNAArchiveExtractedSize, entries: MaxDNAArchiveEntries}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var zr *zip.Reader
+		if zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+			return
+		}
+		for _, f := range zr.File {
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err != nil {
+				return
+			}
+			err = x.entry(f.Name, f.FileInfo(), rc)
+			rc.Close()
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(r); err != nil {
+			return
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		var hdr *tar.Header
+		if hdr, err = tr.Next(); err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err = x.entry(hdr.Name, hdr.FileInfo(), tr); err != nil {
+			return
+		}
+	}
+}
+
+// archiveExtraction holds the destination of an archive being extracted and how many more
+// bytes and entries may be extracted from it
+type archiveExtraction struct {
+	dest    string
+	bytes   int64
+	entries int
+}
+
+// entry writes a directory or regular file of an archive under the destination
+func (x *archiveExtraction) entry(name string, fi os.FileInfo, r io.Reader) (err error) {
+	dest := x.dest
+	p := filepath.Join(dest, name)
+	if p != dest && !strings.HasPrefix(p, dest+string(os.PathSeparator)) {
+		err = fmt.Errorf("archive entry %s is outside of the archive", name)
+		return
+	}
+	if x.entries--; x.entries < 0 {
+		err = fmt.Errorf("archive has more than %d entries", MaxDNAArchiveEntries)
+		return
+	}
+	if fi.IsDir() {
+		err = os.MkdirAll(p, os.ModePerm)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.LimitReader(r, x.bytes+1))
+	if err != nil {
+		return
+	}
+	if x.bytes -= n; x.bytes < 0 {
+		err = fmt.Errorf("archive expands to more than %d bytes", MaxDNAArchiveExtractedSize)
+	}
+	return
+}
+
+// EncodingFormats are the formats supported by Encode and Decode
+var EncodingFormats = []string{"json", "toml", "yaml"}
+
+// isEncodingFormat returns true if format is one of the EncodingFormats
+func isEncodingFormat(format string) bool {
+	for _, f := range EncodingFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
 // Encode encodes data to the writer according to the given format
 func Encode(writer io.Writer, format string, data interface{}) (err error) {
 	switch format {
@@ -228,3 +406,31 @@ func ByteDecoder(b []byte, to interface{}) (err error) {
 	err = dec.Decode(to)
 	return
 }
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur := make([]int, len(t)+1)
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(t)]
+}
@@ -14,6 +14,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,11 +22,19 @@ const (
 	ZygoNucleusType = "zygo"
 )
 
+var errZygoInterrupted = errors.New("zygo interrupted")
+
 type ZygoNucleus struct {
 	env        *zygo.Glisp
 	interfaces []Interface
 	lastResult zygo.Sexp
 	library    string
+	callErr    error
+	depth      int
+	readOnly   bool   // the running function is read-only, or was called from one, so can't write
+	roCaller   bool   // the nucleus was called from a read-only function in another zome
+	zome       string // name of the zome the nucleus runs, if any
+	stop       int32  // set (atomically) by interrupt to abort the running code
 }
 
 // Name returns the string value under which this nucleus is registered
@@ -102,7 +111,10 @@ func (z *ZygoNucleus) ChainGenesis() (err error) {
 
 // ValidateEntry checks the contents of an entry against the validation rules
 func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationProps) (err error) {
-	c := entry.Content().(string)
+	var c string
+	if c, err = zygoContent(entry.Content()); err != nil {
+		return
+	}
 	// @todo handle JSON if schema type is different
 	var e string
 	switch d.DataFormat {
@@ -110,7 +122,7 @@ func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationP
 		e = c
 	case DataFormatString:
 		e = "\"" + sanitizeString(c) + "\""
-	case DataFormatJSON:
+	case DataFormatJSON, DataFormatURI:
 		e = fmt.Sprintf(`(unjson (raw "%s"))`, sanitizeString(c))
 	default:
 		err = errors.New("data format not implemented: " + d.DataFormat)
@@ -137,7 +149,7 @@ func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationP
 	case *zygo.SexpBool:
 		r := result.(*zygo.SexpBool).Val
 		if !r {
-			err = fmt.Errorf("Invalid entry: %v", entry.Content())
+			err = &InvalidEntryError{Content: entry.Content()}
 		}
 	case *zygo.SexpSentinel:
 		err = errors.New("validate should return boolean, got nil")
@@ -148,6 +160,87 @@ func (z *ZygoNucleus) ValidateEntry(d *EntryDef, entry Entry, props *ValidationP
 	return
 }
 
+// ValidateLink checks a link against the application's validateLink function, accepting it
+// if the application doesn't define one
+func (z *ZygoNucleus) ValidateLink(base Hash, link Hash, tag string, props *ValidationProps) (err error) {
+	var b []byte
+	b, err = json.Marshal(props)
+	if err != nil {
+		return
+	}
+	if _, ok := z.env.FindObject("validateLink"); !ok {
+		return
+	}
+	s := sanitizeString(string(b))
+	err = z.env.LoadString(fmt.Sprintf(`(validateLink "%s" "%s" "%s" (unjson (raw "%s")))`, base.String(), link.String(), sanitizeString(tag), s))
+	if err != nil {
+		return
+	}
+	result, err := z.env.Run()
+	if err != nil {
+		err = fmt.Errorf("Error executing validateLink: %w", err)
+		return
+	}
+	switch t := result.(type) {
+	case *zygo.SexpBool:
+		if !t.Val {
+			err = &InvalidLinkError{Base: base, Link: link, Tag: tag}
+		}
+	default:
+		err = errors.New("validateLink should return boolean, got: " + fmt.Sprintf("%v", result))
+	}
+	return
+}
+
+// zygoCommitArguments returns the entry type and the entry given as the first two arguments
+// of the library function fn (commit, commitAt or update), serializing a hash entry as JSON
+func zygoCommitArguments(fn string, args []zygo.Sexp) (entryType string, entry interface{}, err error) {
+	switch t := args[0].(type) {
+	case *zygo.SexpStr:
+		entryType = t.S
+	default:
+		err = fmt.Errorf("1st argument of %s should be string", fn)
+		return
+	}
+	switch t := args[1].(type) {
+	case *zygo.SexpStr:
+		entry = t.S
+	case *zygo.SexpHash:
+		var j []byte
+		if j, err = zygoToJSON(t); err == nil {
+			entry = string(j)
+		}
+	case *zygo.SexpRaw:
+		entry = t.Val
+	default:
+		err = fmt.Errorf("2nd argument of %s should be string, hash or raw", fn)
+	}
+	return
+}
+
+// setCallDepth records how deeply nested this nucleus's calls from other zomes are
+func (z *ZygoNucleus) setCallDepth(depth int) { z.depth = depth }
+
+// setZomeName records the name of the zome this nucleus runs for its log output
+func (z *ZygoNucleus) setZomeName(name string) { z.zome = name }
+
+// setReadOnlyCaller records whether the nucleus is being called from a read-only function
+func (z *ZygoNucleus) setReadOnlyCaller(readOnly bool) { z.roCaller = readOnly }
+
+// interrupt aborts any zygo currently running in the environment.  The environment checks
+// for it each time a function is called, so a running call stops at its next function call.
+func (z *ZygoNucleus) interrupt() {
+	atomic.StoreInt32(&z.stop, 1)
+}
+
+// checkInterrupt is the environment's pre-call hook, which aborts the running code by
+// panicking with errZygoInterrupted once interrupt has been called
+func (z *ZygoNucleus) checkInterrupt(env *zygo.Glisp, name string, args []zygo.Sexp) {
+	if atomic.LoadInt32(&z.stop) != 0 {
+		panic(errZygoInterrupted)
+	}
+}
+
 // GetInterface returns an Interface of the given name
 func (z *ZygoNucleus) GetInterface(iface string) (i *Interface, err error) {
 	for _, x := range z.interfaces {
@@ -176,19 +269,40 @@ func sanitizeString(s string) string {
 
 // Call calls the zygo function that was registered with expose
 func (z *ZygoNucleus) Call(iface string, params interface{}) (result interface{}, err error) {
+	z.callErr = nil
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errZygoInterrupted {
+				err = ErrCallAborted
+				return
+			}
+			panic(r)
+		}
+		if err != nil && z.callErr != nil {
+			err = &CallError{Function: iface, Err: err, Cause: z.callErr}
+		}
+	}()
 	i, err := z.GetInterface(iface)
 	if err != nil {
 		return
 	}
+	z.readOnly = i.ReadOnly || z.roCaller
+	var arg string
+	if arg, err = callArgument(iface, params); err != nil {
+		return
+	}
 	var code string
 	switch i.Schema {
 	case STRING:
-		code = fmt.Sprintf(`(%s "%s")`, iface, sanitizeString(params.(string)))
+		code = fmt.Sprintf(`(%s "%s")`, iface, sanitizeString(arg))
 	case JSON:
-		if params.(string) == "" {
-			code = fmt.Sprintf(`(json (%s (raw "%s")))`, iface, sanitizeString(params.(string)))
+		if err = checkJSONArgument(iface, arg); err != nil {
+			return
+		}
+		if arg == "" {
+			code = fmt.Sprintf(`(%s (raw "%s"))`, iface, sanitizeString(arg))
 		} else {
-			code = fmt.Sprintf(`(json (%s (unjson (raw "%s"))))`, iface, sanitizeString(params.(string)))
+			code = fmt.Sprintf(`(%s (unjson (raw "%s")))`, iface, sanitizeString(arg))
 		}
 	default:
 		err = errors.New("params type not implemented")
@@ -214,23 +328,49 @@ func (z *ZygoNucleus) Call(iface string, params interface{}) (result interface{}
 				result = fmt.Sprintf("%v", result)
 			}
 		case JSON:
-			// type should always be SexpRaw
-			switch t := result.(type) {
-			case *zygo.SexpRaw:
-				result = t.Val
-			default:
-				err = errors.New("expected SexpRaw return type!")
-			}
+			result, err = zygoToJSON(result.(zygo.Sexp))
 		}
 
 	}
 	return
 }
 
+// zygoToJSON serializes a zygo value to canonical JSON, i.e. without the Atype and zKeyOrder
+// annotations that zygo adds to the hashes it serializes
+func zygoToJSON(s zygo.Sexp) (j []byte, err error) {
+	d := json.NewDecoder(strings.NewReader(zygo.SexpToJson(s)))
+	d.UseNumber()
+	var v interface{}
+	if err = d.Decode(&v); err != nil {
+		return
+	}
+	j, err = CanonicalJSON(stripZygoJSON(v))
+	return
+}
+
+// stripZygoJSON removes zygo's hash annotations from decoded JSON
+func stripZygoJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if _, ok := t["zKeyOrder"]; ok {
+			delete(t, "zKeyOrder")
+			delete(t, "Atype")
+		}
+		for k, x := range t {
+			t[k] = stripZygoJSON(x)
+		}
+	case []interface{}:
+		for i, x := range t {
+			t[i] = stripZygoJSON(x)
+		}
+	}
+	return v
+}
+
 // These are the zygo implementations of the library functions that must available in
 // all Nucleii implementations.
 const (
-	ZygoLibrary = `(def STRING 0) (def JSON 1)`
+	ZygoLibrary = `(def STRING 0) (def JSON 1) (defn fromjson [s] (unjson (raw s)))`
 )
 
 // expose registers an interfaces defined in the DNA for calling by external clients
@@ -240,7 +380,8 @@ func (z *ZygoNucleus) expose(iface Interface) (err error) {
 	return
 }
 
-// put exposes DHTPut to zygo
+// put exposes DHTPut to zygo, the result hash also holding the peers the put was sent to
+// (as JSON) and how many of them acknowledged it
 func (z *ZygoNucleus) put(env *zygo.Glisp, h *Holochain, hash string) (result *zygo.SexpHash, err error) {
 	result, err = zygo.MakeHash(nil, "hash", env)
 	if err != nil {
@@ -251,12 +392,22 @@ func (z *ZygoNucleus) put(env *zygo.Glisp, h *Holochain, hash string) (result *z
 	if err != nil {
 		return
 	}
-	err = h.dht.SendPut(key)
+	status, err := h.dht.SendPutStatus(key)
 	if err != nil {
 		err = result.HashSet(env.MakeSymbol("error"), &zygo.SexpStr{S: err.Error()})
-	} else {
-		err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: "ok"})
+		return result, err
+	}
+	if err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: status.Result}); err != nil {
+		return
 	}
+	var peers []byte
+	if peers, err = json.Marshal(status.Peers); err != nil {
+		return
+	}
+	if err = result.HashSet(env.MakeSymbol("peers"), &zygo.SexpStr{S: string(peers)}); err != nil {
+		return
+	}
+	err = result.HashSet(env.MakeSymbol("acks"), &zygo.SexpInt{Val: int64(status.Acks)})
 	return result, err
 }
 
@@ -302,6 +453,11 @@ func (z *ZygoNucleus) get(env *zygo.Glisp, h *Holochain, hash string) (result *z
 	if err == nil {
 		switch t := response.(type) {
 		case *GobEntry:
+			// binary entries come back as raw bytes
+			if b, ok := t.C.([]byte); ok {
+				err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpRaw{Val: b})
+				break
+			}
 			// @TODO figure out encoding by entry type.
 			j, err := json.Marshal(t.C)
 			if err == nil {
@@ -317,8 +473,8 @@ func (z *ZygoNucleus) get(env *zygo.Glisp, h *Holochain, hash string) (result *z
 	return result, err
 }
 
-// getmeta exposes GetPutMeta to zygo
-func (z *ZygoNucleus) getmeta(env *zygo.Glisp, h *Holochain, metahash string, metaTag string) (result *zygo.SexpHash, err error) {
+// getmeta exposes GetPutMeta to zygo, returning at most limit results after the first offset
+func (z *ZygoNucleus) getmeta(env *zygo.Glisp, h *Holochain, metahash string, metaTag string, offset int, limit int) (result *zygo.SexpHash, err error) {
 	result, err = zygo.MakeHash(nil, "hash", env)
 	if err != nil {
 		return nil, err
@@ -330,15 +486,18 @@ func (z *ZygoNucleus) getmeta(env *zygo.Glisp, h *Holochain, metahash string, me
 		return
 	}
 
-	response, err := h.dht.SendGetMeta(MetaQuery{H: metakey, T: metaTag})
+	response, err := h.dht.SendGetMeta(MetaQuery{H: metakey, T: metaTag, Offset: offset, Limit: limit})
 	if err == nil {
 		switch t := response.(type) {
 		case MetaQueryResp:
 			// @TODO figure out encoding by entry type.
-			j, err := json.Marshal(t.Entries)
+			j, err := CanonicalJSON(t.Entries)
 			if err == nil {
 				err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: string(j)})
 			}
+			if err == nil {
+				err = result.HashSet(env.MakeSymbol("more"), &zygo.SexpBool{Val: t.More})
+			}
 		default:
 			err = fmt.Errorf("unexpected response type from SendGetMeta: %v", t)
 		}
@@ -348,10 +507,59 @@ func (z *ZygoNucleus) getmeta(env *zygo.Glisp, h *Holochain, metahash string, me
 	return result, err
 }
 
+// link exposes LinkEntries to zygo
+func (z *ZygoNucleus) link(env *zygo.Glisp, h *Holochain, base string, link string, tag string) (result *zygo.SexpHash, err error) {
+	result, err = zygo.MakeHash(nil, "hash", env)
+	if err != nil {
+		return nil, err
+	}
+	var baseKey, linkKey Hash
+	if baseKey, err = NewHash(base); err != nil {
+		return
+	}
+	if linkKey, err = NewHash(link); err != nil {
+		return
+	}
+	err = h.LinkEntries(baseKey, linkKey, tag)
+	if err != nil {
+		err = result.HashSet(env.MakeSymbol("error"), &zygo.SexpStr{S: err.Error()})
+	} else {
+		err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: "ok"})
+	}
+	return result, err
+}
+
+// getLinks exposes GetLinks to zygo, returning the linked hashes as a JSON array
+func (z *ZygoNucleus) getLinks(env *zygo.Glisp, h *Holochain, base string, tag string) (result *zygo.SexpHash, err error) {
+	result, err = zygo.MakeHash(nil, "hash", env)
+	if err != nil {
+		return nil, err
+	}
+	var baseKey Hash
+	if baseKey, err = NewHash(base); err != nil {
+		return
+	}
+	links, err := h.GetLinks(baseKey, tag)
+	if err == nil {
+		hashes := make([]string, len(links))
+		for i, l := range links {
+			hashes[i] = l.String()
+		}
+		var j []byte
+		if j, err = json.Marshal(hashes); err == nil {
+			err = result.HashSet(env.MakeSymbol("result"), &zygo.SexpStr{S: string(j)})
+		}
+	} else {
+		err = result.HashSet(env.MakeSymbol("error"), &zygo.SexpStr{S: err.Error()})
+	}
+	return result, err
+}
+
 // NewZygoNucleus builds an zygo execution environment with user specified code
 func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 	var z ZygoNucleus
 	z.env = zygo.NewGlispSandbox()
+	z.env.AddPreHook(z.checkInterrupt)
 	z.env.AddFunction("version",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
 			return &zygo.SexpStr{S: VersionStr}, nil
@@ -377,13 +585,13 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 					errors.New("argument of debug should be string")
 			}
 
-			h.config.Loggers.App.p(msg)
+			logApp(h, z.zome, msg)
 			return zygo.SexpNull, err
 		})
 
 	z.env.AddFunction("expose",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
-			if len(args) != 2 {
+			if len(args) != 2 && len(args) != 4 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
 
@@ -405,6 +613,28 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 					errors.New("2nd argument of expose should be integer")
 			}
 
+			// optional capability flag, i.e. (expose "getDNA" STRING readonly: true)
+			if len(args) == 4 {
+				var flag string
+				switch t := args[2].(type) {
+				case *zygo.SexpSymbol:
+					flag = strings.Trim(t.Name(), ":")
+				case *zygo.SexpStr:
+					flag = t.S
+				}
+				if flag != "readonly" {
+					return zygo.SexpNull,
+						errors.New("3rd argument of expose should be readonly:")
+				}
+				switch t := args[3].(type) {
+				case *zygo.SexpBool:
+					i.ReadOnly = t.Val
+				default:
+					return zygo.SexpNull,
+						errors.New("readonly flag of expose should be boolean")
+				}
+			}
+
 			err := z.expose(i)
 			return zygo.SexpNull, err
 		})
@@ -433,62 +663,280 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return &result, err
 		})
 
-	z.env.AddFunction("commit",
+	// chainLength is length under a name that goes with chainTop
+	length := func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+		if len(args) != 0 {
+			return zygo.SexpNull, zygo.WrongNargs
+		}
+		return &zygo.SexpInt{Val: int64(h.Length())}, nil
+	}
+	z.env.AddFunction("length", length)
+	z.env.AddFunction("chainLength", length)
+
+	z.env.AddFunction("chainTop",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
-			if len(args) != 2 {
+			if len(args) != 0 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			top, err := h.Top()
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: top.String()}, nil
+		})
+
+	z.env.AddFunction("countType",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
 
 			var entryType string
-			var entry string
 
 			switch t := args[0].(type) {
 			case *zygo.SexpStr:
 				entryType = t.S
 			default:
 				return zygo.SexpNull,
-					errors.New("1st argument of commit should be string")
+					errors.New("argument of countType should be string")
 			}
+			return &zygo.SexpInt{Val: int64(h.CountType(entryType))}, nil
+		})
 
-			switch t := args[1].(type) {
+	z.env.AddFunction("query",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			var entryType string
+
+			switch t := args[0].(type) {
 			case *zygo.SexpStr:
-				entry = t.S
-			case *zygo.SexpHash:
-				entry = zygo.SexpToJson(t)
+				entryType = t.S
 			default:
 				return zygo.SexpNull,
-					errors.New("2nd argument of commit should be string or hash")
+					errors.New("argument of query should be string")
 			}
+			j, err := h.QueryJSON(entryType)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
 
-			e := GobEntry{C: entry}
-			var l int
-			var hash Hash
-			var header *Header
-			l, hash, header, err = h.chain.PrepareHeader(h.hashSpec, time.Now(), entryType, &e, h.agent.PrivKey())
+	z.env.AddFunction("tojson",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			j, err := zygoToJSON(args[0])
 			if err != nil {
 				return zygo.SexpNull, err
 			}
+			return &zygo.SexpStr{S: string(j)}, nil
+		})
+
+	z.env.AddFunction("keyHashFor",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 1 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
 
-			p := ValidationProps{
-				Sources: []string{peer.IDB58Encode(h.id)},
-				Hash:    hash.String(),
+			var key interface{}
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				key = t.S
+			case *zygo.SexpRaw:
+				key = t.Val
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of keyHashFor should be string or raw")
 			}
+			hash, err := agentKeyHash(key)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: hash}, nil
+		})
 
-			err = h.ValidateEntry(entryType, &e, &p)
+	z.env.AddFunction("callZome",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
 
-			if err == nil {
-				err = h.chain.addEntry(l, hash, header, &e)
+			var names [2]string
+			for i := 0; i < 2; i++ {
+				switch t := args[i].(type) {
+				case *zygo.SexpStr:
+					names[i] = t.S
+				default:
+					return zygo.SexpNull,
+						errors.New("zome and function arguments of callZome should be strings")
+				}
+			}
+
+			var arg string
+			switch t := args[2].(type) {
+			case *zygo.SexpStr:
+				arg = t.S
+			case *zygo.SexpRaw:
+				arg = string(t.Val)
+			default:
+				return zygo.SexpNull,
+					errors.New("argument of callZome should be string or raw json")
+			}
+
+			result, err := h.callZome(z.depth, z.readOnly, names[0], names[1], arg)
+			if err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			return &zygo.SexpStr{S: callResultString(result)}, nil
+		})
+
+	z.env.AddFunction("commit",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
 			}
 
+			entryType, entry, err := zygoCommitArguments(name, args)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
-			var result = zygo.SexpStr{S: header.EntryLink.String()}
+
+			entryHash, err := h.Commit(entryType, entry)
+			if err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			var result = zygo.SexpStr{S: entryHash.String()}
+			return &result, nil
+		})
+
+	z.env.AddFunction("commitAt",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			entryType, entry, err := zygoCommitArguments(name, args)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+
+			var secs int64
+			switch t := args[2].(type) {
+			case *zygo.SexpInt:
+				secs = t.Val
+			default:
+				return zygo.SexpNull,
+					errors.New("3rd argument of commitAt should be integer timestamp in seconds")
+			}
+
+			entryHash, err := h.CommitAt(entryType, entry, time.Unix(secs, 0))
+			if err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			var result = zygo.SexpStr{S: entryHash.String()}
+			return &result, nil
+		})
+
+	z.env.AddFunction("update",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			entryType, entry, err := zygoCommitArguments(name, args)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+
+			var replaces string
+			switch t := args[2].(type) {
+			case *zygo.SexpStr:
+				replaces = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("3rd argument of update should be string")
+			}
+
+			key, err := NewHash(replaces)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			entryHash, err := h.Update(entryType, entry, key)
+			if err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			var result = zygo.SexpStr{S: entryHash.String()}
+			return &result, nil
+		})
+
+	z.env.AddFunction("remove",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			var hashstr, message string
+
+			switch t := args[0].(type) {
+			case *zygo.SexpStr:
+				hashstr = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("1st argument of remove should be string")
+			}
+
+			switch t := args[1].(type) {
+			case *zygo.SexpStr:
+				message = t.S
+			default:
+				return zygo.SexpNull,
+					errors.New("2nd argument of remove should be string")
+			}
+
+			key, err := NewHash(hashstr)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			entryHash, err := h.Remove(key, message)
+			if err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			var result = zygo.SexpStr{S: entryHash.String()}
 			return &result, nil
 		})
 
 	z.env.AddFunction("put",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
 			if len(args) != 1 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
@@ -525,6 +973,10 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 
 	z.env.AddFunction("putmeta",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
 			if len(args) != 3 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
@@ -557,9 +1009,33 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 			return result, err
 		})
 
+	z.env.AddFunction("send",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			var strs [3]string
+			for i, a := range args {
+				switch t := a.(type) {
+				case *zygo.SexpStr:
+					strs[i] = t.S
+				default:
+					return zygo.SexpNull,
+						fmt.Errorf("argument %d of send should be string", i+1)
+				}
+			}
+			response, err := sendFromNucleus(h, strs[0], strs[1], strs[2])
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			result := zygo.SexpStr{S: response}
+			return &result, nil
+		})
+
 	z.env.AddFunction("getmeta",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
-			if len(args) != 2 {
+			if len(args) != 2 && len(args) != 4 {
 				return zygo.SexpNull, zygo.WrongNargs
 			}
 
@@ -572,6 +1048,18 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 					errors.New("1st argument of gettmeta should be string")
 			}
 
+			// optional offset and limit for paging through the results
+			var page [2]int
+			for i := 2; i < len(args); i++ {
+				switch t := args[i].(type) {
+				case *zygo.SexpInt:
+					page[i-2] = int(t.Val)
+				default:
+					return zygo.SexpNull,
+						errors.New("offset and limit arguments of getmeta should be integers")
+				}
+			}
+
 			var typestr string
 			switch t := args[1].(type) {
 			case *zygo.SexpStr:
@@ -580,7 +1068,51 @@ func NewZygoNucleus(h *Holochain, code string) (n Nucleus, err error) {
 				return zygo.SexpNull,
 					errors.New("2nd argument of getmeta should be string")
 			}
-			result, err := z.getmeta(env, h, hashstr, typestr)
+			result, err := z.getmeta(env, h, hashstr, typestr, page[0], page[1])
+			return result, err
+		})
+
+	z.env.AddFunction("link",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if err := checkWritable(z.readOnly, name); err != nil {
+				z.callErr = err
+				return zygo.SexpNull, err
+			}
+			if len(args) != 3 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			var strs [3]string
+			for i, a := range args {
+				switch t := a.(type) {
+				case *zygo.SexpStr:
+					strs[i] = t.S
+				default:
+					return zygo.SexpNull,
+						fmt.Errorf("argument %d of link should be string", i+1)
+				}
+			}
+			result, err := z.link(env, h, strs[0], strs[1], strs[2])
+			return result, err
+		})
+
+	z.env.AddFunction("getLinks",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+
+			var strs [2]string
+			for i, a := range args {
+				switch t := a.(type) {
+				case *zygo.SexpStr:
+					strs[i] = t.S
+				default:
+					return zygo.SexpNull,
+						fmt.Errorf("argument %d of getLinks should be string", i+1)
+				}
+			}
+			result, err := z.getLinks(env, h, strs[0], strs[1])
 			return result, err
 		})
 
@@ -615,6 +1147,49 @@ func (z *ZygoNucleus) Run(code string) (result zygo.Sexp, err error) {
 	return
 }
 
+// zygoContent returns the content of an entry as the text validate is built from.  Numbers
+// committed as numbers rather than as text are written as literals of their zygo type, so
+// that a float is always a float (4.0) even if its value is integral and an int an int.
+func zygoContent(content interface{}) (c string, err error) {
+	switch t := content.(type) {
+	case string:
+		c = t
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		c = fmt.Sprintf("%d", t)
+	case float32:
+		c, err = zygoFloat(float64(t))
+	case float64:
+		c, err = zygoFloat(t)
+	default:
+		err = fmt.Errorf("can't validate entry content of type %T", content)
+	}
+	return
+}
+
+// zygoFloat returns the zygo literal for a float, with a decimal point even if it's integral
+func zygoFloat(f float64) (s string, err error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		err = fmt.Errorf("can't validate entry content %v", f)
+		return
+	}
+	s = strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return
+}
+
+// zygoNumber returns the value of a zygo int or float as a float, and whether it is one
+func zygoNumber(x zygo.Sexp) (f float64, ok bool) {
+	switch t := x.(type) {
+	case *zygo.SexpInt:
+		return float64(t.Val), true
+	case *zygo.SexpFloat:
+		return t.Val, true
+	}
+	return
+}
+
 // extra functions we want to have available for app developers in zygo
 
 func isPrime(t int64) bool {
@@ -650,10 +1225,39 @@ func addExtras(z *ZygoNucleus) {
 			switch t := args[0].(type) {
 			case *zygo.SexpInt:
 				return &zygo.SexpBool{Val: isPrime(t.Val)}, nil
+			case *zygo.SexpFloat:
+				// floats are prime if they have the integral value of a prime, so 7.0 is as
+				// prime as 7
+				integral := t.Val == math.Trunc(t.Val) && math.Abs(t.Val) < math.MaxInt64
+				return &zygo.SexpBool{Val: integral && isPrime(int64(t.Val))}, nil
 			default:
 				return zygo.SexpNull,
-					errors.New("argument to isprime should be int")
+					errors.New("argument to isprime should be a number")
+			}
+		})
+	// mod works on ints and floats alike, the result being an int only if both arguments are
+	z.env.AddFunction("mod",
+		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
+			if len(args) != 2 {
+				return zygo.SexpNull, zygo.WrongNargs
+			}
+			a, aInt := args[0].(*zygo.SexpInt)
+			b, bInt := args[1].(*zygo.SexpInt)
+			if aInt && bInt {
+				if b.Val == 0 {
+					return zygo.SexpNull, errors.New("mod by zero")
+				}
+				return &zygo.SexpInt{Val: a.Val % b.Val}, nil
+			}
+			x, xOk := zygoNumber(args[0])
+			y, yOk := zygoNumber(args[1])
+			if !xOk || !yOk {
+				return zygo.SexpNull, errors.New("arguments to mod should be numbers")
+			}
+			if y == 0 {
+				return zygo.SexpNull, errors.New("mod by zero")
 			}
+			return &zygo.SexpFloat{Val: math.Mod(x, y)}, nil
 		})
 	z.env.AddFunction("atoi",
 		func(env *zygo.Glisp, name string, args []zygo.Sexp) (zygo.Sexp, error) {
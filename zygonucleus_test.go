@@ -1,10 +1,15 @@
 package holochain
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	zygo "github.com/glycerine/zygomys/repl"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
+	"math"
 	"testing"
 	"time"
 )
@@ -77,8 +82,27 @@ func TestNewZygoNucleus(t *testing.T) {
 			_, err = z.Run(`(isprime 7)`)
 			So(err, ShouldBeNil)
 			So(z.lastResult.(*zygo.SexpBool).Val, ShouldEqual, true)
+			_, err = z.Run(`(isprime 7.0)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpBool).Val, ShouldEqual, true)
+			_, err = z.Run(`(isprime 7.5)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpBool).Val, ShouldEqual, false)
 			_, err = z.Run(`(isprime "fish")`)
-			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'isprime': argument to isprime should be int")
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'isprime': argument to isprime should be a number")
+		})
+		Convey("mod", func() {
+			_, err = z.Run(`(mod 7 2)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 1)
+			_, err = z.Run(`(mod 7.5 2)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpFloat).Val, ShouldEqual, 1.5)
+			_, err = z.Run(`(mod 4 2.0)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpFloat).Val, ShouldEqual, 0)
+			_, err = z.Run(`(mod 4 0)`)
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'mod': mod by zero")
 		})
 		Convey("property", func() {
 			_, err = z.Run(`(property "description")`)
@@ -91,6 +115,110 @@ func TestNewZygoNucleus(t *testing.T) {
 			})
 
 		})
+		Convey("length", func() {
+			_, err = z.Run(`(length)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 2)
+		})
+		Convey("update and remove", func() {
+			hash, err := h.Commit("myData", "2")
+			So(err, ShouldBeNil)
+			_, err = z.Run(`(update "myData" "4" "` + hash.String() + `")`)
+			So(err, ShouldBeNil)
+			newHash := z.lastResult.(*zygo.SexpStr).S
+			So(h.chain.Top().EntryLink.String(), ShouldEqual, newHash)
+			So(h.chain.Top().Change.Action, ShouldEqual, ModAction)
+			So(h.chain.Top().Change.Hash.String(), ShouldEqual, hash.String())
+
+			_, err = z.Run(`(remove "` + newHash + `" "gone")`)
+			So(err, ShouldBeNil)
+			So(h.chain.Top().Type, ShouldEqual, DelEntryType)
+			So(h.chain.Top().Change.Action, ShouldEqual, DelAction)
+			So(h.chain.Top().Change.Hash.String(), ShouldEqual, newHash)
+		})
+		Convey("commitAt", func() {
+			ts := h.chain.Top().Time.Unix() + 1
+			_, err = z.Run(fmt.Sprintf(`(commitAt "myData" "2" %d)`, ts))
+			So(err, ShouldBeNil)
+			So(h.chain.Top().EntryLink.String(), ShouldEqual, z.lastResult.(*zygo.SexpStr).S)
+			So(h.chain.Top().Time.Unix(), ShouldEqual, ts)
+			So(h.chain.Top().Backdated, ShouldBeTrue)
+
+			_, err = z.Run(`(commitAt "myData" "2" "yesterday")`)
+			So(err.Error(), ShouldContainSubstring, "3rd argument of commitAt should be integer timestamp in seconds")
+		})
+		Convey("chainTop and chainLength", func() {
+			top, _ := h.Top()
+			_, err = z.Run(`(chainTop)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, top.String())
+			_, err = z.Run(`(chainLength)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 2)
+
+			_, err = h.Commit("myData", "3")
+			So(err, ShouldBeNil)
+			top, _ = h.Top()
+			_, err = z.Run(`(chainTop)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, top.String())
+			_, err = z.Run(`(chainLength)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 3)
+		})
+		Convey("countType", func() {
+			_, err = z.Run(`(countType "%agent")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 1)
+			_, err = z.Run(`(countType "myData")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 0)
+		})
+		Convey("query", func() {
+			hash, err := h.Commit("myData", "2")
+			So(err, ShouldBeNil)
+			_, err = z.Run(`(query "myData")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `[{"Hash":"`+hash.String()+`","Entry":"2"}]`)
+			_, err = z.Run(`(query 1)`)
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'query': argument of query should be string")
+		})
+		Convey("tojson", func() {
+			_, err = z.Run(`(tojson (unjson (raw "{\"b\":{\"d\":1,\"c\":\"<x>\"},\"a\":[1,2]}")))`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `{"a":[1,2],"b":{"c":"<x>","d":1}}`)
+			_, err = z.Run(`(tojson "fish")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `"fish"`)
+		})
+		Convey("fromjson", func() {
+			_, err = z.Run(`(tojson (fromjson (tojson (hash a:1 b:"x"))))`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `{"a":1,"b":"x"}`)
+			_, err = z.Run(`(-> (fromjson "{\"a\":3}") a:)`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpInt).Val, ShouldEqual, 3)
+		})
+		Convey("keyHashFor", func() {
+			key, _ := ic.MarshalPublicKey(h.Agent().PubKey())
+			_, err = z.Run(`(keyHashFor "` + base64.StdEncoding.EncodeToString(key) + `")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, peer.IDB58Encode(h.id))
+			_, err = z.Run(`(keyHashFor 1)`)
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'keyHashFor': argument of keyHashFor should be string or raw")
+		})
+		Convey("callZome", func() {
+			_, err = z.Run(`(callZome "jsZome" "getProperty" "description")`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, "a bogus test holochain")
+			_, err = z.Run(`(callZome "jsZome" "addProfile" (raw "{\"firstName\":\"Art\"}"))`)
+			So(err, ShouldBeNil)
+			So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, `"`+h.chain.Top().EntryLink.String()+`"`)
+			_, err = z.Run(`(callZome "jsZome" "bogus" "x")`)
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'callZome': couldn't find exposed function: bogus")
+			_, err = z.Run(`(callZome "jsZome" 1 "x")`)
+			So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'callZome': zome and function arguments of callZome should be strings")
+		})
 	})
 }
 
@@ -157,6 +285,40 @@ func TestZygoValidateEntry(t *testing.T) {
 		err = v.ValidateEntry(&d, &GobEntry{C: `{"data":"fish"}`}, &p)
 		So(err, ShouldBeNil)
 	})
+	Convey("should pass numbers to the validator as zygo ints and floats", t, func() {
+		v, err := NewZygoNucleus(nil, `(defn validate [name entry meta] (cond (int? entry) (== (mod entry 2) 0) (float? entry) (== (mod entry 2) 0.5) false))`)
+		So(err, ShouldBeNil)
+		d := EntryDef{Name: "myData", DataFormat: DataFormatRawZygo}
+		for _, c := range []interface{}{"4", 4, int64(-6), "4.5", 4.5, float32(6.5)} {
+			So(v.ValidateEntry(&d, &GobEntry{C: c}, &p), ShouldBeNil)
+		}
+		for _, c := range []interface{}{"4.0", 4.0, "3", 3} {
+			So(errors.Is(v.ValidateEntry(&d, &GobEntry{C: c}, &p), ErrInvalidEntry), ShouldBeTrue)
+		}
+		err = v.ValidateEntry(&d, &GobEntry{C: math.NaN()}, &p)
+		So(err.Error(), ShouldEqual, "can't validate entry content NaN")
+		err = v.ValidateEntry(&d, &GobEntry{C: true}, &p)
+		So(err.Error(), ShouldEqual, "can't validate entry content of type bool")
+	})
+	Convey("should validate integral floats and ints alike with mod and isprime", t, func() {
+		v, err := NewZygoNucleus(nil, `(defn validate [name entry meta] (and (== (mod entry 2) 1) (isprime entry)))`)
+		So(err, ShouldBeNil)
+		d := EntryDef{Name: "myData", DataFormat: DataFormatRawZygo}
+		for _, c := range []interface{}{"7", 7, "7.0", 7.0} {
+			So(v.ValidateEntry(&d, &GobEntry{C: c}, &p), ShouldBeNil)
+		}
+		for _, c := range []interface{}{"9", 9, "9.0", 9.0, "4.0", 4.0} {
+			So(errors.Is(v.ValidateEntry(&d, &GobEntry{C: c}, &p), ErrInvalidEntry), ShouldBeTrue)
+		}
+	})
+	Convey("should pass the validation props to the validator", t, func() {
+		v, err := NewZygoNucleus(nil, `(defn validate [name entry meta] (cond (and (== (hget meta Role:) "author") (== (hget meta AgentID:) "QmAgent")) true false))`)
+		d := EntryDef{Name: "myData", DataFormat: DataFormatString}
+		err = v.ValidateEntry(&d, &GobEntry{C: "fish"}, &ValidationProps{Role: ValidatingAsDHTNode, AgentID: "QmAgent"})
+		So(err.Error(), ShouldEqual, "Invalid entry: fish")
+		err = v.ValidateEntry(&d, &GobEntry{C: "fish"}, &ValidationProps{Role: ValidatingAsAuthor, AgentID: "QmAgent"})
+		So(err, ShouldBeNil)
+	})
 }
 
 func TestZygoExposeCall(t *testing.T) {
@@ -183,6 +345,10 @@ func TestZygoExposeCall(t *testing.T) {
 		i := z.Interfaces()
 		So(fmt.Sprintf("%v", i), ShouldEqual, "[{cater 0} {adder 0} {jtest 1} {emptyParametersJson 1}]")
 	})
+	Convey("should reject a bad capability flag", t, func() {
+		_, err := NewZygoNucleus(nil, `(expose "bad" STRING writeonly: true)`)
+		So(err.Error(), ShouldEqual, "Zygomys exec error: Error calling 'expose': 3rd argument of expose should be readonly:")
+	})
 	Convey("should allow calling exposed STRING based functions", t, func() {
 		result, err := z.Call("cater", "fish \"zippy\"")
 		So(err, ShouldBeNil)
@@ -195,12 +361,89 @@ func TestZygoExposeCall(t *testing.T) {
 	Convey("should allow calling exposed JSON based functions", t, func() {
 		result, err := z.Call("jtest", `{"input": 2}`)
 		So(err, ShouldBeNil)
-		So(string(result.([]byte)), ShouldEqual, `{"Atype":"hash", "input":2, "output":4, "zKeyOrder":["input", "output"]}`)
+		So(string(result.([]byte)), ShouldEqual, `{"input":2,"output":4}`)
 	})
 	Convey("should allow a function declared with JSON parameter to be called with no parameter", t, func() {
 		result, err := z.Call("emptyParametersJson", "")
 		So(err, ShouldBeNil)
-		So(string(result.([]byte)), ShouldEqual, "[{\"Atype\":\"hash\", \"a\":\"b\", \"zKeyOrder\":[\"a\"]}]")
+		So(string(result.([]byte)), ShouldEqual, `[{"a":"b"}]`)
+	})
+	Convey("should reject malformed JSON before calling the function", t, func() {
+		_, err := z.Call("jtest", `{"input": `)
+		So(errors.Is(err, ErrInvalidJSONArgument), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "jtest")
+	})
+	Convey("should stop read-only functions from writing", t, func() {
+		v, err := NewZygoNucleus(nil, `(expose "peek" STRING readonly: true)(defn peek [x] (commit "myData" x))(expose "poke" STRING)(defn poke [x] (put x))`)
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", v.Interfaces()), ShouldEqual, "[{peek 0 readonly} {poke 0}]")
+		_, err = v.Call("peek", "2")
+		So(errors.Is(err, ErrReadOnlyCall), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "commit: not allowed in a read-only function")
+		v.(*ZygoNucleus).setReadOnlyCaller(true)
+		_, err = v.Call("poke", "x")
+		So(errors.Is(err, ErrReadOnlyCall), ShouldBeTrue)
+	})
+}
+
+func TestZygoCallWithContext(t *testing.T) {
+	v, err := NewZygoNucleus(nil, `
+(expose "loop" STRING)
+(defn loop [x] (loop (concat x "")))
+(expose "cater" STRING)
+(defn cater [x] (concat "result: " x))
+`)
+	if err != nil {
+		panic(err)
+	}
+	Convey("it should return the result of a call that finishes before the deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := callWithContext(ctx, v, "cater", "fish")
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: fish")
+	})
+	Convey("it should abort a call that runs past the deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := callWithContext(ctx, v, "loop", "")
+		So(errors.Is(err, ErrCallAborted), ShouldBeTrue)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "call aborted: context deadline exceeded")
+	})
+	Convey("it should stop an interrupted infinite loop", t, func() {
+		z, _ := NewZygoNucleus(nil, `(expose "loop" STRING) (defn loop [x] (loop (concat x "")))`)
+		done := make(chan error, 1)
+		go func() {
+			_, err := z.Call("loop", "")
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		z.(*ZygoNucleus).interrupt()
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(5 * time.Second):
+			err = errors.New("loop still running")
+		}
+		So(err, ShouldEqual, ErrCallAborted)
+	})
+}
+
+func TestZygoCommitBinary(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+	h.Zomes["myZome"].Entries["thumb"] = EntryDef{Name: "thumb", DataFormat: DataFormatBinary}
+
+	Convey("it should commit raw values as bytes", t, func() {
+		v, err := NewZygoNucleus(h, `(commit "thumb" (raw "abc"))`)
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		hash, err := NewHash(z.lastResult.(*zygo.SexpStr).S)
+		So(err, ShouldBeNil)
+		_, entry, err := h.GetLocal(hash)
+		So(err, ShouldBeNil)
+		So(string(entry.Content().([]byte)), ShouldEqual, "abc")
 	})
 }
 
@@ -226,6 +469,12 @@ func TestZygoDHT(t *testing.T) {
 		r, err := z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("result"))
 		So(err, ShouldBeNil)
 		So(r.(*zygo.SexpStr).S, ShouldEqual, "ok")
+		r, err = z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("peers"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpStr).S, ShouldEqual, `["`+peer.IDB58Encode(h.id)+`"]`)
+		r, err = z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("acks"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpInt).Val, ShouldEqual, 1)
 	})
 
 	Convey("it should have a get function", t, func() {
@@ -276,5 +525,78 @@ func TestZygoDHT(t *testing.T) {
 		r, err := sh.HashGet(z.env, z.env.MakeSymbol("result"))
 		So(err, ShouldBeNil)
 		So(r.(*zygo.SexpStr).S, ShouldEqual, `[{"E":{"C":"{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}"},"H":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt"}]`)
+		r, err = sh.HashGet(z.env, z.env.MakeSymbol("more"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpBool).Val, ShouldBeFalse)
+	})
+
+	Convey("getmeta should take an offset and limit", t, func() {
+		v, err := NewZygoNucleus(h, fmt.Sprintf(`(getmeta "%s" "myMetaTag" 1 10)`, hash.String()))
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		sh := z.lastResult.(*zygo.SexpHash)
+
+		r, err := sh.HashGet(z.env, z.env.MakeSymbol("result"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpStr).S, ShouldEqual, `[]`)
+
+		_, err = NewZygoNucleus(h, fmt.Sprintf(`(getmeta "%s" "myMetaTag" "1" 10)`, hash.String()))
+		So(err.Error(), ShouldContainSubstring, "offset and limit arguments of getmeta should be integers")
+	})
+
+	Convey("getmeta should report whether there are more values after the page", t, func() {
+		_, hd, err := h.NewEntry(now, "profile", &GobEntry{C: `{"firstName":"Griffy","lastName":"Pinhead"}`})
+		So(err, ShouldBeNil)
+		err = h.dht.putMeta(nil, hash, hd.EntryLink, "myMetaTag", &GobEntry{C: `{"firstName":"Griffy","lastName":"Pinhead"}`})
+		So(err, ShouldBeNil)
+
+		v, err := NewZygoNucleus(h, fmt.Sprintf(`(getmeta "%s" "myMetaTag" 0 1)`, hash.String()))
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		r, err := z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("more"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpBool).Val, ShouldBeTrue)
+
+		links, err := h.GetLinks(hash, "myMetaTag")
+		So(err, ShouldBeNil)
+		So(len(links), ShouldEqual, 2)
+	})
+}
+
+func TestZygoSend(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("it should have a send function", t, func() {
+		h.Zomes["myZome"].CodeSource = `(expose "receive" STRING)(defn receive [x] (concat "received: " x))`
+		v, err := NewZygoNucleus(h, fmt.Sprintf(`(send "%s" "myZome" "hello")`, peer.IDB58Encode(h.id)))
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		So(z.lastResult.(*zygo.SexpStr).S, ShouldEqual, "received: hello")
+	})
+}
+
+func TestZygoDebug(t *testing.T) {
+	d, _, h := prepareTestChain("test")
+	defer cleanupTestDir(d)
+
+	Convey("debug should log to the App logger", t, func() {
+		v, err := NewZygoNucleus(h, "")
+		So(err, ShouldBeNil)
+		z := v.(*ZygoNucleus)
+		ShouldLog(&h.config.Loggers.App, "hello\n", func() {
+			_, err = z.Run(`(debug "hello")`)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("debug should prefix the log with the zome's name", t, func() {
+		n, err := h.makeNucleus(h.Zomes["myZome"])
+		So(err, ShouldBeNil)
+		z := n.(*ZygoNucleus)
+		ShouldLog(&h.config.Loggers.App, "myZome: hello\n", func() {
+			_, err = z.Run(`(debug "hello")`)
+			So(err, ShouldBeNil)
+		})
 	})
 }